@@ -0,0 +1,468 @@
+// Package binding serves the fields of a Go struct as the coils, discrete
+// inputs, holding registers and input registers of a Modbus device, so that
+// an application appears as a device to SCADA systems.
+//
+// Fields are bound with tags of the form
+//
+//	modbus:"<table>,<address>[,<order>][,ro|rw]"
+//
+// where table is one of coil, discrete, holding and input, address is the
+// address of the first register or bit of the field, in decimal or 0x
+// prefixed hexadecimal, and order is the byte and word order of the field
+// (abcd, the default, cdab, badc or dcba, as named by mbpoll). Fields of bit
+// tables are bool; fields of register tables are uint16, int16, uint32,
+// int32, float32, uint64, int64 or float64, and span 1, 2 or 4 registers.
+// Coils and holding registers are writable unless tagged ro; discrete inputs
+// and input registers are read-only.
+//
+//	type Status struct {
+//		sync.Mutex
+//		Temp     float32 `modbus:"input,0,abcd"`
+//		Running  bool    `modbus:"discrete,0"`
+//		Setpoint float32 `modbus:"holding,0,cdab"`
+//		Serial   uint32  `modbus:"holding,10,ro"`
+//	}
+//
+//	b, err := binding.Bind(&status)
+//	srv, err := tcp.NewServer(":502", b)
+//
+// Reads encode the values of the fields when the request is handled; writes
+// decode into the fields. Requests for addresses no field is bound to, and
+// writes that cover part of a field or a read-only field, are answered with
+// ExceptionCodeIllegalDataAddress.
+//
+// Requests are handled concurrently with the application, which shares the
+// struct with the Binding through Config.Locker alone: the Binding holds it
+// while it reads or writes fields, and the application must hold it while
+// it does. There are no accessor methods; fields are read and written
+// directly.
+package binding
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/shasderias/modbus"
+	"github.com/shasderias/modbus/datamodel"
+	"github.com/shasderias/modbus/internal/tableserve"
+	"github.com/shasderias/modbus/internal/wordorder"
+)
+
+// Config configures a Binding. It is passed to the functions given to Bind.
+type Config struct {
+	// Locker, if set, is locked while fields are read or written, such as
+	// the mutex the application guards the struct with. If it has RLock and
+	// RUnlock methods, as a *sync.RWMutex does, reads lock it for reading
+	// only. Defaults to the struct itself, if it implements sync.Locker,
+	// such as by embedding a sync.Mutex.
+	Locker sync.Locker
+
+	// OnWrite, if set, is called with the names of the fields a client
+	// wrote, after they are written and the Locker is unlocked.
+	OnWrite func(fields []string)
+}
+
+// Binding is a modbus.Handler serving the fields of a struct.
+type Binding struct {
+	v       reflect.Value
+	locker  sync.Locker
+	onWrite func(fields []string)
+
+	// tables map every address of each table to the field bound to it
+	tables [4]map[int]*field
+}
+
+type field struct {
+	name     string
+	index    int
+	table    datamodel.Table
+	address  int
+	size     int
+	kind     reflect.Kind
+	order    wordorder.Order
+	readOnly bool
+}
+
+// Bind returns a Binding serving the tagged fields of the struct v points
+// to.
+func Bind(v any, fns ...func(c *Config)) (*Binding, error) {
+	conf := Config{}
+	for _, fn := range fns {
+		fn(&conf)
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("binding: want pointer to struct, got %T", v)
+	}
+
+	b := &Binding{
+		v:       rv.Elem(),
+		locker:  conf.Locker,
+		onWrite: conf.OnWrite,
+	}
+	if b.locker == nil {
+		b.locker, _ = v.(sync.Locker)
+	}
+	for i := range b.tables {
+		b.tables[i] = make(map[int]*field)
+	}
+
+	typ := b.v.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		tag, ok := sf.Tag.Lookup("modbus")
+		if !ok || tag == "-" {
+			continue
+		}
+
+		f, err := parseField(sf, tag)
+		if err != nil {
+			return nil, err
+		}
+		f.index = i
+
+		if f.address+f.size > 0x10000 {
+			return nil, fmt.Errorf("binding: field %s: addresses [%d, %d) out of range", f.name, f.address, f.address+f.size)
+		}
+		for a := f.address; a < f.address+f.size; a++ {
+			if other, ok := b.tables[f.table][a]; ok {
+				return nil, fmt.Errorf("binding: fields %s and %s overlap at %v address %d", other.name, f.name, f.table, a)
+			}
+			b.tables[f.table][a] = f
+		}
+	}
+
+	return b, nil
+}
+
+func parseField(sf reflect.StructField, tag string) (*field, error) {
+	if !sf.IsExported() {
+		return nil, fmt.Errorf("binding: field %s is not exported", sf.Name)
+	}
+
+	parts := strings.Split(tag, ",")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("binding: field %s: want tag \"<table>,<address>[,<order>][,ro|rw]\", got %q", sf.Name, tag)
+	}
+
+	f := &field{name: sf.Name, kind: sf.Type.Kind()}
+
+	switch strings.TrimSpace(parts[0]) {
+	case "coil", "coils":
+		f.table = datamodel.Coils
+	case "discrete":
+		f.table = datamodel.DiscreteInputs
+	case "holding":
+		f.table = datamodel.HoldingRegisters
+	case "input":
+		f.table = datamodel.InputRegisters
+	default:
+		return nil, fmt.Errorf("binding: field %s: unknown table %q, want one of coil, discrete, holding, input", sf.Name, parts[0])
+	}
+
+	address, err := strconv.ParseUint(strings.TrimSpace(parts[1]), 0, 16)
+	if err != nil {
+		return nil, fmt.Errorf("binding: field %s: invalid address %q", sf.Name, parts[1])
+	}
+	f.address = int(address)
+
+	f.readOnly = f.table == datamodel.DiscreteInputs || f.table == datamodel.InputRegisters
+	for _, opt := range parts[2:] {
+		switch opt = strings.TrimSpace(opt); opt {
+		case "ro":
+			f.readOnly = true
+		case "rw":
+			if f.table == datamodel.DiscreteInputs || f.table == datamodel.InputRegisters {
+				return nil, fmt.Errorf("binding: field %s: %v are read-only", sf.Name, f.table)
+			}
+		default:
+			order, err := wordorder.Parse(opt)
+			if err != nil {
+				return nil, fmt.Errorf("binding: field %s: unknown option %q, want an order or one of ro, rw", sf.Name, opt)
+			}
+			f.order = order
+		}
+	}
+
+	if f.table.IsBits() {
+		if f.kind != reflect.Bool {
+			return nil, fmt.Errorf("binding: field %s: %v fields must be bool, got %v", sf.Name, f.table, sf.Type)
+		}
+		f.size = 1
+		return f, nil
+	}
+
+	switch f.kind {
+	case reflect.Uint16, reflect.Int16:
+		f.size = 1
+	case reflect.Uint32, reflect.Int32, reflect.Float32:
+		f.size = 2
+	case reflect.Uint64, reflect.Int64, reflect.Float64:
+		f.size = 4
+	default:
+		return nil, fmt.Errorf("binding: field %s: unsupported type %v", sf.Name, sf.Type)
+	}
+	return f, nil
+}
+
+// encode returns the registers of f holding v.
+func (f *field) encode(v reflect.Value) []uint16 {
+	b := make([]byte, 2*f.size)
+	switch f.kind {
+	case reflect.Uint16:
+		binary.BigEndian.PutUint16(b, uint16(v.Uint()))
+	case reflect.Int16:
+		binary.BigEndian.PutUint16(b, uint16(v.Int()))
+	case reflect.Uint32:
+		binary.BigEndian.PutUint32(b, uint32(v.Uint()))
+	case reflect.Int32:
+		binary.BigEndian.PutUint32(b, uint32(v.Int()))
+	case reflect.Float32:
+		binary.BigEndian.PutUint32(b, math.Float32bits(float32(v.Float())))
+	case reflect.Uint64:
+		binary.BigEndian.PutUint64(b, v.Uint())
+	case reflect.Int64:
+		binary.BigEndian.PutUint64(b, uint64(v.Int()))
+	case reflect.Float64:
+		binary.BigEndian.PutUint64(b, math.Float64bits(v.Float()))
+	}
+	f.order.Apply(b)
+
+	registers := make([]uint16, f.size)
+	for i := range registers {
+		registers[i] = binary.BigEndian.Uint16(b[2*i:])
+	}
+	return registers
+}
+
+// decode sets v to the value registers of f hold.
+func (f *field) decode(v reflect.Value, registers []uint16) {
+	b := make([]byte, 2*f.size)
+	for i, r := range registers {
+		binary.BigEndian.PutUint16(b[2*i:], r)
+	}
+	f.order.Apply(b)
+
+	switch f.kind {
+	case reflect.Uint16:
+		v.SetUint(uint64(binary.BigEndian.Uint16(b)))
+	case reflect.Int16:
+		v.SetInt(int64(int16(binary.BigEndian.Uint16(b))))
+	case reflect.Uint32:
+		v.SetUint(uint64(binary.BigEndian.Uint32(b)))
+	case reflect.Int32:
+		v.SetInt(int64(int32(binary.BigEndian.Uint32(b))))
+	case reflect.Float32:
+		v.SetFloat(float64(math.Float32frombits(binary.BigEndian.Uint32(b))))
+	case reflect.Uint64:
+		v.SetUint(binary.BigEndian.Uint64(b))
+	case reflect.Int64:
+		v.SetInt(int64(binary.BigEndian.Uint64(b)))
+	case reflect.Float64:
+		v.SetFloat(math.Float64frombits(binary.BigEndian.Uint64(b)))
+	}
+}
+
+type rlocker interface {
+	RLock()
+	RUnlock()
+}
+
+func (b *Binding) rlock() {
+	if l, ok := b.locker.(rlocker); ok {
+		l.RLock()
+	} else if b.locker != nil {
+		b.locker.Lock()
+	}
+}
+
+func (b *Binding) runlock() {
+	if l, ok := b.locker.(rlocker); ok {
+		l.RUnlock()
+	} else if b.locker != nil {
+		b.locker.Unlock()
+	}
+}
+
+func (b *Binding) lock() {
+	if b.locker != nil {
+		b.locker.Lock()
+	}
+}
+
+func (b *Binding) unlock() {
+	if b.locker != nil {
+		b.locker.Unlock()
+	}
+}
+
+// bits returns count bits of t starting at address, or false if an address
+// is not bound.
+func (b *Binding) bits(t datamodel.Table, address, count int) ([]bool, bool) {
+	b.rlock()
+	defer b.runlock()
+
+	bits := make([]bool, count)
+	for i := range bits {
+		f, ok := b.tables[t][address+i]
+		if !ok {
+			return nil, false
+		}
+		bits[i] = b.v.Field(f.index).Bool()
+	}
+	return bits, true
+}
+
+// registers returns count registers of t starting at address, or false if an
+// address is not bound.
+func (b *Binding) registers(t datamodel.Table, address, count int) ([]uint16, bool) {
+	b.rlock()
+	defer b.runlock()
+
+	return b.registersLocked(t, address, count)
+}
+
+// registersLocked is registers with the Locker held.
+func (b *Binding) registersLocked(t datamodel.Table, address, count int) ([]uint16, bool) {
+	registers := make([]uint16, 0, count)
+	for a := address; a < address+count; {
+		f, ok := b.tables[t][a]
+		if !ok {
+			return nil, false
+		}
+		encoded := f.encode(b.v.Field(f.index))[a-f.address:]
+		if n := address + count - a; len(encoded) > n {
+			encoded = encoded[:n]
+		}
+		registers = append(registers, encoded...)
+		a += len(encoded)
+	}
+	return registers, true
+}
+
+// writableFields returns the fields bound to the count addresses of t
+// starting at address, or false if an address is not bound, or the
+// addresses cover part of a field or a read-only field.
+func (b *Binding) writableFields(t datamodel.Table, address, count int) ([]*field, bool) {
+	var fields []*field
+	for a := address; a < address+count; {
+		f, ok := b.tables[t][a]
+		if !ok || f.readOnly || f.address != a || a+f.size > address+count {
+			return nil, false
+		}
+		fields = append(fields, f)
+		a += f.size
+	}
+	return fields, true
+}
+
+func (b *Binding) writeBits(address int, values []bool) bool {
+	fields, ok := b.writableFields(datamodel.Coils, address, len(values))
+	if !ok {
+		return false
+	}
+
+	b.lock()
+	for i, f := range fields {
+		b.v.Field(f.index).SetBool(values[i])
+	}
+	b.unlock()
+
+	b.wrote(fields)
+	return true
+}
+
+// writeRegisters sets the holding registers of w, and reads those w asks
+// for, as one operation.
+func (b *Binding) writeRegisters(w tableserve.RegisterWrite) ([]uint16, bool) {
+	fields, ok := b.writableFields(datamodel.HoldingRegisters, w.Address, w.Count)
+	if !ok {
+		return nil, false
+	}
+
+	b.lock()
+	if w.ReadCount > 0 {
+		if _, ok := b.registersLocked(datamodel.HoldingRegisters, w.ReadAddress, w.ReadCount); !ok {
+			b.unlock()
+			return nil, false
+		}
+	}
+	// writable fields are bound
+	old, _ := b.registersLocked(datamodel.HoldingRegisters, w.Address, w.Count)
+	values := w.Update(old)
+	for _, f := range fields {
+		f.decode(b.v.Field(f.index), values[f.address-w.Address:][:f.size])
+	}
+	var registers []uint16
+	if w.ReadCount > 0 {
+		registers, _ = b.registersLocked(datamodel.HoldingRegisters, w.ReadAddress, w.ReadCount)
+	}
+	b.unlock()
+
+	b.wrote(fields)
+	return registers, true
+}
+
+func (b *Binding) wrote(fields []*field) {
+	if b.onWrite == nil {
+		return
+	}
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = f.name
+	}
+	b.onWrite(names)
+}
+
+// ServeModbus implements modbus.Handler for the read and write coil, discrete
+// input, holding register and input register function codes, and the mask
+// write register and read/write multiple registers function codes. unitID is
+// ignored. Other function codes are answered with
+// ExceptionCodeIllegalFunction.
+func (b *Binding) ServeModbus(ctx context.Context, unitID byte, req modbus.PDU) (modbus.PDU, error) {
+	return tableserve.Serve(bindingTables{b}, unitID, req)
+}
+
+// bindingTables serves the tables of a Binding, answering requests for
+// addresses it cannot serve with ExceptionCodeIllegalDataAddress.
+type bindingTables struct {
+	b *Binding
+}
+
+func (bt bindingTables) ReadBits(t tableserve.Table, address, count int) ([]bool, byte) {
+	bits, ok := bt.b.bits(datamodel.Table(t), address, count)
+	if !ok {
+		return nil, modbus.ExceptionCodeIllegalDataAddress
+	}
+	return bits, 0
+}
+
+func (bt bindingTables) ReadRegisters(t tableserve.Table, address, count int) ([]uint16, byte) {
+	registers, ok := bt.b.registers(datamodel.Table(t), address, count)
+	if !ok {
+		return nil, modbus.ExceptionCodeIllegalDataAddress
+	}
+	return registers, 0
+}
+
+func (bt bindingTables) WriteCoils(w tableserve.CoilWrite) byte {
+	if !bt.b.writeBits(w.Address, w.Values) {
+		return modbus.ExceptionCodeIllegalDataAddress
+	}
+	return 0
+}
+
+func (bt bindingTables) WriteHoldingRegisters(w tableserve.RegisterWrite) ([]uint16, byte) {
+	registers, ok := bt.b.writeRegisters(w)
+	if !ok {
+		return nil, modbus.ExceptionCodeIllegalDataAddress
+	}
+	return registers, 0
+}
@@ -0,0 +1,222 @@
+package binding_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/shasderias/modbus"
+	"github.com/shasderias/modbus/binding"
+)
+
+func serve(t *testing.T, h modbus.Handler, pdu ...byte) []byte {
+	t.Helper()
+
+	req, err := modbus.NewRawPDU(pdu)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := modbus.Respond(context.Background(), h, 1, req)
+	var exception *modbus.ExceptionResponse
+	if err != nil && !errors.As(err, &exception) {
+		t.Fatal(err)
+	}
+
+	b, err := resp.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+type status struct {
+	sync.Mutex
+
+	Temp     float32 `modbus:"input,0,abcd"`
+	Pressure int16   `modbus:"input,2"`
+	Running  bool    `modbus:"discrete,0"`
+	Start    bool    `modbus:"coil,0"`
+	Setpoint float32 `modbus:"holding,0,cdab"`
+	Serial   uint32  `modbus:"holding,2,ro"`
+	Counter  uint64  `modbus:"holding,0x10,dcba,rw"`
+
+	internal int
+}
+
+func TestBinding(t *testing.T) {
+	s := &status{Temp: 21.5, Pressure: -2, Running: true, Setpoint: 1, Serial: 0xdeadbeef, Counter: 0x0102030405060708}
+
+	var wrote [][]string
+	b, err := binding.Bind(s, func(c *binding.Config) {
+		c.OnWrite = func(fields []string) { wrote = append(wrote, fields) }
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name string
+		req  []byte
+		want []byte
+	}{
+		{"ReadInputRegisters", []byte{0x04, 0x00, 0x00, 0x00, 0x03}, []byte{0x04, 0x06, 0x41, 0xac, 0x00, 0x00, 0xff, 0xfe}},
+		{"ReadPartOfField", []byte{0x04, 0x00, 0x01, 0x00, 0x01}, []byte{0x04, 0x02, 0x00, 0x00}},
+		{"ReadDiscreteInputs", []byte{0x02, 0x00, 0x00, 0x00, 0x01}, []byte{0x02, 0x01, 0x01}},
+		{"ReadHoldingRegisters", []byte{0x03, 0x00, 0x00, 0x00, 0x04}, []byte{0x03, 0x08, 0x00, 0x00, 0x3f, 0x80, 0xde, 0xad, 0xbe, 0xef}},
+		{"ReadDCBA", []byte{0x03, 0x00, 0x10, 0x00, 0x04}, []byte{0x03, 0x08, 0x08, 0x07, 0x06, 0x05, 0x04, 0x03, 0x02, 0x01}},
+		{"ReadUnbound", []byte{0x03, 0x00, 0x03, 0x00, 0x02}, []byte{0x83, modbus.ExceptionCodeIllegalDataAddress}},
+		// 42.0 is 0x4228 0x0000, word swapped
+		{"WriteSetpoint", []byte{0x10, 0x00, 0x00, 0x00, 0x02, 0x04, 0x00, 0x00, 0x42, 0x28}, []byte{0x10, 0x00, 0x00, 0x00, 0x02}},
+		{"WriteCoil", []byte{0x05, 0x00, 0x00, 0xff, 0x00}, []byte{0x05, 0x00, 0x00, 0xff, 0x00}},
+		{"WritePartOfField", []byte{0x06, 0x00, 0x00, 0x12, 0x34}, []byte{0x86, modbus.ExceptionCodeIllegalDataAddress}},
+		{"WriteReadOnly", []byte{0x10, 0x00, 0x02, 0x00, 0x02, 0x04, 0x00, 0x00, 0x00, 0x00}, []byte{0x90, modbus.ExceptionCodeIllegalDataAddress}},
+		{"IllegalFunction", []byte{0x07}, []byte{0x87, modbus.ExceptionCodeIllegalFunction}},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(serve(t, b, tt.req...), tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+
+	if s.Setpoint != 42 || !s.Start || s.Serial != 0xdeadbeef {
+		t.Fatalf("got setpoint %v, start %v, serial 0x%x; want 42, true, 0xdeadbeef", s.Setpoint, s.Start, s.Serial)
+	}
+	if diff := cmp.Diff(wrote, [][]string{{"Setpoint"}, {"Start"}}); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestBindingMaskAndReadWrite(t *testing.T) {
+	s := &struct {
+		Mode    uint16 `modbus:"holding,0"`
+		Limit   int32  `modbus:"holding,1"`
+		Serial  uint16 `modbus:"holding,3,ro"`
+		Running bool   `modbus:"coil,0"`
+	}{Mode: 0x12f0, Serial: 7}
+
+	var wrote [][]string
+	b, err := binding.Bind(s, func(c *binding.Config) {
+		c.OnWrite = func(fields []string) { wrote = append(wrote, fields) }
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name string
+		req  []byte
+		want []byte
+	}{
+		// (0x12f0 AND 0x00f2) OR (0x0025 AND NOT 0x00f2) = 0x00f5
+		{"MaskWrite", []byte{0x16, 0x00, 0x00, 0x00, 0xf2, 0x00, 0x25}, []byte{0x16, 0x00, 0x00, 0x00, 0xf2, 0x00, 0x25}},
+		{"MaskWritePartOfField", []byte{0x16, 0x00, 0x01, 0x00, 0xf2, 0x00, 0x25}, []byte{0x96, modbus.ExceptionCodeIllegalDataAddress}},
+		{"ReadWrite", []byte{0x17, 0x00, 0x00, 0x00, 0x04, 0x00, 0x01, 0x00, 0x02, 0x04, 0xff, 0xff, 0xff, 0xfe}, []byte{0x17, 0x08, 0x00, 0xf5, 0xff, 0xff, 0xff, 0xfe, 0x00, 0x07}},
+		{"ReadWriteReadOnly", []byte{0x17, 0x00, 0x00, 0x00, 0x01, 0x00, 0x03, 0x00, 0x01, 0x02, 0x00, 0x00}, []byte{0x97, modbus.ExceptionCodeIllegalDataAddress}},
+		{"ReadWriteUnboundRead", []byte{0x17, 0x00, 0x04, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x02, 0x00, 0x00}, []byte{0x97, modbus.ExceptionCodeIllegalDataAddress}},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(serve(t, b, tt.req...), tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+
+	if s.Mode != 0x00f5 || s.Limit != -2 {
+		t.Fatalf("got mode 0x%x, limit %d; want 0xf5, -2", s.Mode, s.Limit)
+	}
+	if diff := cmp.Diff(wrote, [][]string{{"Mode"}, {"Limit"}}); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestBindErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		v    any
+	}{
+		{"NotPointer", struct{}{}},
+		{"Overlap", &struct {
+			A uint32 `modbus:"holding,0"`
+			B uint16 `modbus:"holding,1"`
+		}{}},
+		{"BitType", &struct {
+			A uint16 `modbus:"coil,0"`
+		}{}},
+		{"RegisterType", &struct {
+			A string `modbus:"holding,0"`
+		}{}},
+		{"Unexported", &struct {
+			a uint16 `modbus:"holding,0"`
+		}{}},
+		{"Table", &struct {
+			A uint16 `modbus:"holdings,0"`
+		}{}},
+		{"Address", &struct {
+			A uint16 `modbus:"holding,65536"`
+		}{}},
+		{"OutOfRange", &struct {
+			A uint32 `modbus:"holding,65535"`
+		}{}},
+		{"Option", &struct {
+			A uint32 `modbus:"holding,0,wx"`
+		}{}},
+		{"WritableInput", &struct {
+			A uint16 `modbus:"input,0,rw"`
+		}{}},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := binding.Bind(tt.v); err == nil {
+				t.Fatal("got nil; want error")
+			}
+		})
+	}
+}
+
+// TestBindLocker updates a struct guarded by an RWMutex while it is served,
+// for the race detector.
+func TestBindLocker(t *testing.T) {
+	var (
+		mut sync.RWMutex
+		v   struct {
+			Count uint32 `modbus:"input,0"`
+		}
+	)
+	b, err := binding.Bind(&v, func(c *binding.Config) {
+		c.Locker = &mut
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			mut.Lock()
+			v.Count++
+			mut.Unlock()
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		serve(t, b, 0x04, 0x00, 0x00, 0x00, 0x02)
+	}
+	<-done
+
+	if diff := cmp.Diff(serve(t, b, 0x04, 0x00, 0x00, 0x00, 0x02), []byte{0x04, 0x04, 0x00, 0x00, 0x00, 0x64}); diff != "" {
+		t.Fatal(diff)
+	}
+}
@@ -1,15 +1,27 @@
 package modbus
 
 import (
+	"context"
 	"fmt"
-	"sync"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/shasderias/modbus/internal/logging"
+	"github.com/shasderias/modbus/tracing"
 )
 
 type Client struct {
-	t ClientTransport
+	t        ClientTransport
+	logger   *slog.Logger
+	observer Observer
+	tracer   tracing.Tracer
+
+	// ctx is the context requests are sent with, see WithContext
+	ctx context.Context
 
-	closed    bool
-	closedMut sync.Mutex
+	// closed is shared with the copies made by WithContext
+	closed *atomic.Bool
 
 	slaveAddress byte
 }
@@ -19,21 +31,103 @@ type ClientTransport interface {
 	Close() error
 }
 
-func NewClient(slaveAddress int, t ClientTransport) (*Client, error) {
+// ContextClientTransport is a ClientTransport that accepts the context of a
+// request. A Client sends requests with WriteRequestContext if its transport
+// implements it, so that the transport can honour cancellation and add to the
+// span of the request (see package tracing).
+type ContextClientTransport interface {
+	ClientTransport
+	WriteRequestContext(ctx context.Context, slaveAddress byte, r PDU) (PDU, error)
+}
+
+type ClientConfig struct {
+	// Logger receives, at debug level, every request sent, with its
+	// outcome and latency. Defaults to discarding.
+	Logger *slog.Logger
+
+	// Observer, if set, is notified of every request sent, with its outcome
+	// and latency.
+	Observer Observer
+
+	// Tracer, if set, starts a span for every request sent. See package
+	// tracing.
+	Tracer tracing.Tracer
+}
+
+func NewClient(slaveAddress int, t ClientTransport, fns ...func(c *ClientConfig)) (*Client, error) {
 	if slaveAddress < 0 || slaveAddress > 247 {
 		return nil, fmt.Errorf("slave address must in the range [0:247]")
 	}
 
+	conf := ClientConfig{}
+	for _, fn := range fns {
+		fn(&conf)
+	}
+
 	return &Client{
-		t: t,
+		t:        t,
+		logger:   logging.OrDiscard(conf.Logger),
+		observer: conf.Observer,
+		tracer:   conf.Tracer,
+
+		ctx:    context.Background(),
+		closed: new(atomic.Bool),
 
 		slaveAddress: byte(slaveAddress),
 	}, nil
 }
 
+// WithContext returns a copy of c that sends its requests with ctx, so that
+// their spans are children of the span in ctx and, if the transport
+// implements ContextClientTransport, they are abandoned when ctx is done.
+// The copy shares the transport of c; closing either closes both.
+func (c *Client) WithContext(ctx context.Context) *Client {
+	if ctx == nil {
+		panic("nil context")
+	}
+	c2 := *c
+	c2.ctx = ctx
+	return &c2
+}
+
+// Do sends req and unmarshals the response into resp. It is intended for
+// function codes that Client does not implement a method for, such as
+// user-defined function codes.
+//
+// An exception response is returned as an *ExceptionResponse error. resp is
+// left untouched if the request is broadcast.
+func (c *Client) Do(req PDU, resp PDU) error {
+	if c.isClosed() {
+		return fmt.Errorf("client: %w", ErrClosed)
+	}
+
+	rawResp, err := c.writeRequest(req)
+	if err != nil {
+		return fmt.Errorf("client: error writing request: %w", err)
+	}
+
+	if c.slaveAddress == 0 {
+		return nil
+	}
+
+	switch rawResp.FunctionCode() {
+	case req.FunctionCode():
+		return UnmarshalAs(rawResp, resp)
+	case req.FunctionCode() + 0x80:
+		var resp ExceptionResponse
+		if err := UnmarshalAs(rawResp, &resp); err != nil {
+			return err
+		}
+		return &resp
+	default:
+		return fmt.Errorf("client: %w: function code, sent: 0x%x, recv: 0x%x", ErrUnexpectedResponse,
+			req.FunctionCode(), rawResp.FunctionCode())
+	}
+}
+
 func (c *Client) WriteBit(funcCode byte, startAddress int, value bool) (*WriteSingleBitResponse, error) {
 	if c.isClosed() {
-		return nil, fmt.Errorf("client: closed")
+		return nil, fmt.Errorf("client: %w", ErrClosed)
 	}
 
 	req, err := NewWriteSingleBitRequest(funcCode, startAddress, value)
@@ -41,7 +135,7 @@ func (c *Client) WriteBit(funcCode byte, startAddress int, value bool) (*WriteSi
 		return nil, err
 	}
 
-	rawResp, err := c.t.WriteRequest(c.slaveAddress, req)
+	rawResp, err := c.writeRequest(req)
 	if err != nil {
 		return nil, fmt.Errorf("client: error writing request: %w", err)
 	}
@@ -58,7 +152,7 @@ func (c *Client) WriteBit(funcCode byte, startAddress int, value bool) (*WriteSi
 		}
 
 		if int(resp.startAddress) != startAddress {
-			return nil, fmt.Errorf("client: unexpected address, sent: 0x%x, recv: 0x%x",
+			return nil, fmt.Errorf("client: %w: address, sent: 0x%x, recv: 0x%x", ErrUnexpectedResponse,
 				startAddress, resp.startAddress)
 		}
 		return &resp, nil
@@ -69,7 +163,7 @@ func (c *Client) WriteBit(funcCode byte, startAddress int, value bool) (*WriteSi
 		}
 		return nil, &resp
 	default:
-		return nil, fmt.Errorf("client: unexpected function code, sent: 0x%x, recv: 0x%x",
+		return nil, fmt.Errorf("client: %w: function code, sent: 0x%x, recv: 0x%x", ErrUnexpectedResponse,
 			funcCode, rawResp.FunctionCode())
 	}
 }
@@ -80,7 +174,7 @@ func (c *Client) WriteSingleCoil(address int, value bool) (*WriteSingleBitRespon
 
 func (c *Client) WriteBits(funcCode byte, startAddress int, values []bool) (*WriteMultipleBitsResponse, error) {
 	if c.isClosed() {
-		return nil, fmt.Errorf("client: closed")
+		return nil, fmt.Errorf("client: %w", ErrClosed)
 	}
 
 	req, err := NewWriteMultipleBitsRequestFromBools(funcCode, startAddress, values)
@@ -88,7 +182,7 @@ func (c *Client) WriteBits(funcCode byte, startAddress int, values []bool) (*Wri
 		return nil, err
 	}
 
-	rawResp, err := c.t.WriteRequest(c.slaveAddress, req)
+	rawResp, err := c.writeRequest(req)
 	if err != nil {
 		return nil, fmt.Errorf("client: error writing request: %w", err)
 	}
@@ -105,11 +199,11 @@ func (c *Client) WriteBits(funcCode byte, startAddress int, values []bool) (*Wri
 		}
 
 		if int(resp.startAddress) != startAddress {
-			return nil, fmt.Errorf("client: response start address (%d) does not match request start address (%d)",
+			return nil, fmt.Errorf("client: %w: response start address (%d) does not match request start address (%d)", ErrUnexpectedResponse,
 				resp.startAddress, startAddress)
 		}
 		if int(resp.count) != len(values) {
-			return nil, fmt.Errorf("client: response register count (%d) does not match request register count (%d)",
+			return nil, fmt.Errorf("client: %w: response register count (%d) does not match request register count (%d)", ErrUnexpectedResponse,
 				resp.count, len(values))
 		}
 
@@ -121,7 +215,7 @@ func (c *Client) WriteBits(funcCode byte, startAddress int, values []bool) (*Wri
 		}
 		return nil, &resp
 	default:
-		return nil, fmt.Errorf("client: unexpected function code: %d", rawResp.FunctionCode())
+		return nil, fmt.Errorf("client: %w: function code: 0x%x", ErrUnexpectedResponse, rawResp.FunctionCode())
 	}
 }
 
@@ -131,7 +225,11 @@ func (c *Client) WriteMultipleCoils(startAddress int, values []bool) (*WriteMult
 
 func (c *Client) ReadBits(funcCode byte, startAddress, count int) (*ReadBitResponse, error) {
 	if c.isClosed() {
-		return nil, fmt.Errorf("client: closed")
+		return nil, fmt.Errorf("client: %w", ErrClosed)
+	}
+
+	if c.slaveAddress == 0 {
+		return nil, fmt.Errorf("client: %w", ErrBroadcastRead)
 	}
 
 	req, err := NewReadBitRequest(funcCode, startAddress, count)
@@ -139,7 +237,7 @@ func (c *Client) ReadBits(funcCode byte, startAddress, count int) (*ReadBitRespo
 		return nil, err
 	}
 
-	rawResp, err := c.t.WriteRequest(c.slaveAddress, req)
+	rawResp, err := c.writeRequest(req)
 	if err != nil {
 		return nil, fmt.Errorf("client: error writing request: %w", err)
 	}
@@ -158,7 +256,7 @@ func (c *Client) ReadBits(funcCode byte, startAddress, count int) (*ReadBitRespo
 		}
 		return nil, &resp
 	default:
-		return nil, fmt.Errorf("client: unexpected function code: %d", rawResp.FunctionCode())
+		return nil, fmt.Errorf("client: %w: function code: 0x%x", ErrUnexpectedResponse, rawResp.FunctionCode())
 	}
 }
 
@@ -172,7 +270,7 @@ func (c *Client) ReadDiscreteInputs(startAddress, count int) (*ReadBitResponse,
 
 func (c *Client) WriteRegister(funcCode byte, address int, value uint16) (*WriteSingleRegisterResponse, error) {
 	if c.isClosed() {
-		return nil, fmt.Errorf("client: closed")
+		return nil, fmt.Errorf("client: %w", ErrClosed)
 	}
 
 	req, err := NewWriteSingleRegisterRequestFromUint16(FuncCodeWriteSingleRegister, address, value)
@@ -180,7 +278,7 @@ func (c *Client) WriteRegister(funcCode byte, address int, value uint16) (*Write
 		return nil, err
 	}
 
-	rawResp, err := c.t.WriteRequest(c.slaveAddress, req)
+	rawResp, err := c.writeRequest(req)
 	if err != nil {
 		return nil, fmt.Errorf("client: error writing request: %w", err)
 	}
@@ -197,7 +295,7 @@ func (c *Client) WriteRegister(funcCode byte, address int, value uint16) (*Write
 		}
 
 		if int(resp.address) != address {
-			return nil, fmt.Errorf("client: response start address (%d) does not match request start address (%d)",
+			return nil, fmt.Errorf("client: %w: response start address (%d) does not match request start address (%d)", ErrUnexpectedResponse,
 				resp.address, address)
 		}
 
@@ -209,7 +307,7 @@ func (c *Client) WriteRegister(funcCode byte, address int, value uint16) (*Write
 		}
 		return nil, &resp
 	default:
-		return nil, fmt.Errorf("client: unexpected function code: %d", rawResp.FunctionCode())
+		return nil, fmt.Errorf("client: %w: function code: 0x%x", ErrUnexpectedResponse, rawResp.FunctionCode())
 	}
 }
 
@@ -219,7 +317,7 @@ func (c *Client) WriteSingleRegister(address int, value uint16) (*WriteSingleReg
 
 func (c *Client) WriteRegisters(startAddress int, values []uint16) (*WriteMultipleRegistersResponse, error) {
 	if c.isClosed() {
-		return nil, fmt.Errorf("client: closed")
+		return nil, fmt.Errorf("client: %w", ErrClosed)
 	}
 
 	req, err := NewWriteMultipleRegistersRequestFromUint16s(FuncCodeWriteMultipleRegisters, startAddress, values)
@@ -227,7 +325,7 @@ func (c *Client) WriteRegisters(startAddress int, values []uint16) (*WriteMultip
 		return nil, err
 	}
 
-	rawResp, err := c.t.WriteRequest(c.slaveAddress, req)
+	rawResp, err := c.writeRequest(req)
 	if err != nil {
 		return nil, fmt.Errorf("client: error writing request: %w", err)
 	}
@@ -244,11 +342,11 @@ func (c *Client) WriteRegisters(startAddress int, values []uint16) (*WriteMultip
 		}
 
 		if int(resp.address) != startAddress {
-			return nil, fmt.Errorf("client: response start address (%d) does not match request start address (%d)",
+			return nil, fmt.Errorf("client: %w: response start address (%d) does not match request start address (%d)", ErrUnexpectedResponse,
 				resp.address, startAddress)
 		}
 		if int(resp.count) != len(values) {
-			return nil, fmt.Errorf("client: response register count (%d) does not match request register count (%d)",
+			return nil, fmt.Errorf("client: %w: response register count (%d) does not match request register count (%d)", ErrUnexpectedResponse,
 				resp.count, len(values))
 		}
 
@@ -260,13 +358,17 @@ func (c *Client) WriteRegisters(startAddress int, values []uint16) (*WriteMultip
 		}
 		return nil, &resp
 	default:
-		return nil, fmt.Errorf("client: unexpected function code: %d", rawResp.FunctionCode())
+		return nil, fmt.Errorf("client: %w: function code: 0x%x", ErrUnexpectedResponse, rawResp.FunctionCode())
 	}
 }
 
 func (c *Client) ReadRegisters(funcCode byte, startAddress, count int) (*ReadRegisterResponse, error) {
 	if c.isClosed() {
-		return nil, fmt.Errorf("client: closed")
+		return nil, fmt.Errorf("client: %w", ErrClosed)
+	}
+
+	if c.slaveAddress == 0 {
+		return nil, fmt.Errorf("client: %w", ErrBroadcastRead)
 	}
 
 	req, err := NewReadRegisterRequest(int(funcCode), startAddress, count)
@@ -274,7 +376,7 @@ func (c *Client) ReadRegisters(funcCode byte, startAddress, count int) (*ReadReg
 		return nil, err
 	}
 
-	rawResp, err := c.t.WriteRequest(c.slaveAddress, req)
+	rawResp, err := c.writeRequest(req)
 	if err != nil {
 		return nil, fmt.Errorf("client: error writing request: %w", err)
 	}
@@ -293,7 +395,7 @@ func (c *Client) ReadRegisters(funcCode byte, startAddress, count int) (*ReadReg
 		}
 		return nil, &resp
 	default:
-		return nil, fmt.Errorf("client: unexpected function code: sent: 0x%x, received 0x%x", funcCode, rawResp.FunctionCode())
+		return nil, fmt.Errorf("client: %w: function code, sent: 0x%x, recv: 0x%x", ErrUnexpectedResponse, funcCode, rawResp.FunctionCode())
 	}
 }
 
@@ -305,15 +407,105 @@ func (c *Client) ReadHoldingRegisters(startAddress, count int) (*ReadRegisterRes
 	return c.ReadRegisters(FuncCodeReadHoldingRegisters, startAddress, count)
 }
 
+// writeRequest sends req to the client's slave.
+func (c *Client) writeRequest(req PDU) (PDU, error) {
+	ctx, span := tracing.Start(c.ctx, c.tracer, "modbus "+FunctionName(req.FunctionCode()),
+		spanAttributes(c.slaveAddress, req)...)
+
+	start := time.Now()
+	var (
+		resp PDU
+		err  error
+	)
+	if t, ok := c.t.(ContextClientTransport); ok {
+		resp, err = t.WriteRequestContext(ctx, c.slaveAddress, req)
+	} else {
+		resp, err = c.t.WriteRequest(c.slaveAddress, req)
+	}
+	latency := time.Since(start)
+
+	if outcome, exceptionCode := ClassifyOutcome(resp, err); outcome == OutcomeException {
+		span.SetAttributes(tracing.Int(tracing.KeyExceptionCode, int(exceptionCode)))
+		span.RecordError(&ExceptionResponse{req.FunctionCode() | 0x80, exceptionCode})
+	} else {
+		span.RecordError(err)
+	}
+	span.End()
+
+	if c.observer != nil {
+		c.observe(req, resp, err, latency)
+	}
+
+	if c.logger.Enabled(ctx, slog.LevelDebug) {
+		attrs := []slog.Attr{
+			logging.UnitID(c.slaveAddress),
+			logging.FunctionCode(req.FunctionCode()),
+			logging.Latency(latency),
+		}
+		switch {
+		case err != nil:
+			attrs = append(attrs, logging.Err(err))
+		case resp != nil && resp.FunctionCode()&0x80 != 0:
+			attrs = append(attrs, slog.String("exception", DescribeResponse(resp)))
+		}
+		c.logger.LogAttrs(ctx, slog.LevelDebug, "client: request", attrs...)
+	}
+
+	return resp, err
+}
+
+func (c *Client) observe(req, resp PDU, err error, latency time.Duration) {
+	e := RequestEvent{
+		UnitID:       c.slaveAddress,
+		FunctionCode: req.FunctionCode(),
+		Latency:      latency,
+		Err:          err,
+	}
+	e.Outcome, e.ExceptionCode = ClassifyOutcome(resp, err)
+	if b, err := req.MarshalBinary(); err == nil {
+		e.RequestBytes = len(b)
+	}
+	if resp != nil {
+		if b, err := resp.MarshalBinary(); err == nil {
+			e.ResponseBytes = len(b)
+		}
+	}
+	c.observer.ObserveRequest(e)
+}
+
+// spanAttributes returns the attributes of the span of req, which is sent to
+// unitID.
+func spanAttributes(unitID byte, req PDU) []tracing.Attribute {
+	attrs := []tracing.Attribute{
+		tracing.Int(tracing.KeyUnitID, int(unitID)),
+		tracing.Int(tracing.KeyFunctionCode, int(req.FunctionCode())),
+	}
+
+	addressRange := func(address, count uint16) []tracing.Attribute {
+		return append(attrs, tracing.Int(tracing.KeyAddress, int(address)), tracing.Int(tracing.KeyCount, int(count)))
+	}
+	switch r := req.(type) {
+	case *ReadBitRequest:
+		return addressRange(r.startAddress, r.count)
+	case *WriteSingleBitRequest:
+		return addressRange(r.startAddress, 1)
+	case *WriteMultipleBitsRequest:
+		return addressRange(r.startAddress, r.count)
+	case *ReadRegisterRequest:
+		return addressRange(r.startAddress, r.count)
+	case *WriteSingleRegisterRequest:
+		return addressRange(r.address, 1)
+	case *WriteMultipleRegistersRequest:
+		return addressRange(r.address, r.count)
+	}
+	return attrs
+}
+
 func (c *Client) Close() error {
-	c.closedMut.Lock()
-	defer c.closedMut.Unlock()
-	c.closed = true
+	c.closed.Store(true)
 	return c.t.Close()
 }
 
 func (c *Client) isClosed() bool {
-	c.closedMut.Lock()
-	defer c.closedMut.Unlock()
-	return c.closed
+	return c.closed.Load()
 }
@@ -0,0 +1,335 @@
+// Command mbpoll is a Modbus master for the command line.
+//
+// It reads or writes coils, discrete inputs, holding registers and input
+// registers of a Modbus/TCP or Modbus/RTU slave using the same client as the
+// rest of this module.
+//
+// Usage:
+//
+//	mbpoll [flags] host[:port]    (-m tcp, the default)
+//	mbpoll [flags] device         (-m rtu)
+//
+// Examples:
+//
+//	# read 10 holding registers starting at address 100, once
+//	mbpoll -r 100 -c 10 192.168.0.10
+//
+//	# poll 2 word swapped float32 input registers every 500ms as CSV
+//	mbpoll -t input -d float32 -o cdab -c 2 -n 0 -l 500ms -f csv 192.168.0.10
+//
+//	# write 2 coils on slave 3 of an RTU bus
+//	mbpoll -m rtu -b 9600 -a 3 -t coil -r 7 -w 1,0 /dev/ttyUSB0
+//
+// Addresses are protocol addresses and start at 0.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/shasderias/modbus"
+	"github.com/shasderias/modbus/internal/serialport"
+	"github.com/shasderias/modbus/internal/wordorder"
+	"github.com/shasderias/modbus/transport/rtu"
+	"github.com/shasderias/modbus/transport/tcp"
+)
+
+type options struct {
+	mode    string
+	target  string
+	timeout time.Duration
+	debug   bool
+
+	slaveAddress int
+	table        string
+	address      int
+	count        int
+	dataType     string
+	order        string
+	write        string
+
+	polls    int
+	interval time.Duration
+	format   string
+
+	baudRate int
+	dataBits int
+	parity   string
+	stopBits int
+}
+
+const (
+	tableCoil     = "coil"
+	tableDiscrete = "discrete"
+	tableHolding  = "holding"
+	tableInput    = "input"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := run(ctx, os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "mbpoll: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	var opts options
+
+	fs := flag.NewFlagSet("mbpoll", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: mbpoll [flags] host[:port] | device\n\n")
+		fs.PrintDefaults()
+	}
+
+	fs.StringVar(&opts.mode, "m", "tcp", "transport: tcp or rtu")
+	fs.DurationVar(&opts.timeout, "timeout", 1*time.Second, "response timeout")
+	fs.BoolVar(&opts.debug, "debug", false, "log every frame sent and received to stderr")
+
+	fs.IntVar(&opts.slaveAddress, "a", 1, "slave address or unit ID, 0 to broadcast writes")
+	fs.StringVar(&opts.table, "t", tableHolding, "table: coil, discrete, holding or input")
+	fs.IntVar(&opts.address, "r", 0, "start address")
+	fs.IntVar(&opts.count, "c", 1, "number of values to read")
+	fs.StringVar(&opts.dataType, "d", "uint16", "register data type: uint16, int16, hex, uint32, int32, float32, uint64, int64 or float64")
+	fs.StringVar(&opts.order, "o", "abcd", "byte and word order of multi-register values: abcd, cdab, badc or dcba")
+	fs.StringVar(&opts.write, "w", "", "comma separated values to write instead of reading")
+
+	fs.IntVar(&opts.polls, "n", 1, "number of polls, 0 to poll until interrupted")
+	fs.DurationVar(&opts.interval, "l", 1*time.Second, "poll interval")
+	fs.StringVar(&opts.format, "f", "table", "output format: table, csv or json")
+
+	fs.IntVar(&opts.baudRate, "b", 19200, "RTU baud rate")
+	fs.IntVar(&opts.dataBits, "databits", 8, "RTU data bits")
+	fs.StringVar(&opts.parity, "p", "even", "RTU parity: none, even or odd")
+	fs.IntVar(&opts.stopBits, "s", 1, "RTU stop bits: 1 or 2")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected exactly one host or device")
+	}
+	opts.target = fs.Arg(0)
+
+	var logger *slog.Logger
+	if opts.debug {
+		logger = slog.New(slog.NewTextHandler(stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	}
+
+	transport, err := openTransport(opts, logger)
+	if err != nil {
+		return err
+	}
+
+	client, err := modbus.NewClient(opts.slaveAddress, transport, func(c *modbus.ClientConfig) {
+		c.Logger = logger
+	})
+	if err != nil {
+		transport.Close()
+		return err
+	}
+	defer client.Close()
+
+	if opts.write != "" {
+		return write(client, opts)
+	}
+
+	return poll(ctx, client, opts, stdout, stderr)
+}
+
+func openTransport(opts options, logger *slog.Logger) (modbus.ClientTransport, error) {
+	switch opts.mode {
+	case "tcp":
+		address := opts.target
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			address = net.JoinHostPort(address, "502")
+		}
+
+		conn, err := net.DialTimeout("tcp", address, opts.timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		return tcp.NewClient(conn, func(c *tcp.ClientConfig) {
+			c.RequestTimeout = opts.timeout
+			c.Logger = logger
+		})
+	case "rtu":
+		port, err := serialport.Open(opts.target, serialport.Config{
+			BaudRate: opts.baudRate,
+			DataBits: opts.dataBits,
+			Parity:   opts.parity,
+			StopBits: opts.stopBits,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return rtu.NewClient(port, func(c *rtu.ClientConfig) {
+			c.RequestTimeout = opts.timeout
+			c.InterFrameDelay = rtu.InterFrameDelay(opts.baudRate)
+			c.Logger = logger
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown transport %q, want one of tcp, rtu", opts.mode)
+	}
+}
+
+func poll(ctx context.Context, client *modbus.Client, opts options, stdout, stderr io.Writer) error {
+	dt, err := lookupDataType(opts.dataType)
+	if err != nil {
+		return err
+	}
+	order, err := wordorder.Parse(opts.order)
+	if err != nil {
+		return err
+	}
+
+	p, err := newPrinter(opts.format, stdout, stderr, opts.slaveAddress, opts.table)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(opts.interval)
+	defer ticker.Stop()
+
+	var lastErr error
+	for i := 0; opts.polls == 0 || i < opts.polls; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+		}
+
+		r := read(client, opts, dt, order)
+		lastErr = r.err
+
+		if err := p.print(r); err != nil {
+			return err
+		}
+	}
+
+	if lastErr != nil {
+		return errors.New("last poll failed")
+	}
+	return nil
+}
+
+func read(client *modbus.Client, opts options, dt dataType, order wordorder.Order) result {
+	r := result{time: time.Now()}
+
+	switch opts.table {
+	case tableCoil, tableDiscrete:
+		var (
+			resp *modbus.ReadBitResponse
+			err  error
+		)
+		if opts.table == tableCoil {
+			resp, err = client.ReadCoils(opts.address, opts.count)
+		} else {
+			resp, err = client.ReadDiscreteInputs(opts.address, opts.count)
+		}
+		if err != nil {
+			r.err = err
+			return r
+		}
+
+		bits := resp.BitValues()
+		if len(bits) < opts.count {
+			r.err = fmt.Errorf("short response: got %d bits, want %d", len(bits), opts.count)
+			return r
+		}
+		for i, b := range bits[:opts.count] {
+			r.addresses = append(r.addresses, opts.address+i)
+			if b {
+				r.values = append(r.values, 1)
+			} else {
+				r.values = append(r.values, 0)
+			}
+		}
+	case tableHolding, tableInput:
+		var (
+			resp *modbus.ReadRegisterResponse
+			err  error
+		)
+		count := opts.count * dt.registers
+		if opts.table == tableHolding {
+			resp, err = client.ReadHoldingRegisters(opts.address, count)
+		} else {
+			resp, err = client.ReadInputRegisters(opts.address, count)
+		}
+		if err != nil {
+			r.err = err
+			return r
+		}
+
+		values, err := decodeRegisters(resp.Values(), dt, order)
+		if err != nil {
+			r.err = err
+			return r
+		}
+		for i, v := range values {
+			r.addresses = append(r.addresses, opts.address+i*dt.registers)
+			r.values = append(r.values, v)
+		}
+	default:
+		r.err = fmt.Errorf("unknown table %q, want one of coil, discrete, holding, input", opts.table)
+	}
+
+	return r
+}
+
+func write(client *modbus.Client, opts options) error {
+	values := strings.Split(opts.write, ",")
+
+	switch opts.table {
+	case tableCoil:
+		bools, err := parseBools(values)
+		if err != nil {
+			return err
+		}
+		if len(bools) == 1 {
+			_, err = client.WriteSingleCoil(opts.address, bools[0])
+		} else {
+			_, err = client.WriteMultipleCoils(opts.address, bools)
+		}
+		return err
+	case tableHolding:
+		dt, err := lookupDataType(opts.dataType)
+		if err != nil {
+			return err
+		}
+		order, err := wordorder.Parse(opts.order)
+		if err != nil {
+			return err
+		}
+
+		registers, err := encodeRegisters(values, dt, order)
+		if err != nil {
+			return err
+		}
+		if len(registers) == 1 {
+			_, err = client.WriteSingleRegister(opts.address, registers[0])
+		} else {
+			_, err = client.WriteRegisters(opts.address, registers)
+		}
+		return err
+	default:
+		return fmt.Errorf("table %q is read-only, only coil and holding can be written", opts.table)
+	}
+}
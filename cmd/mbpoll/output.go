@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// result is the outcome of a single poll.
+type result struct {
+	time time.Time
+
+	// addresses[i] is the address of the first register or bit of values[i]
+	addresses []int
+	values    []any
+
+	err error
+}
+
+type printer interface {
+	print(r result) error
+}
+
+func newPrinter(format string, w, errW io.Writer, slaveAddress int, table string) (printer, error) {
+	switch format {
+	case "table":
+		return &tablePrinter{w: w, errW: errW}, nil
+	case "csv":
+		return &csvPrinter{w: csv.NewWriter(w), errW: errW}, nil
+	case "json":
+		return &jsonPrinter{enc: json.NewEncoder(w), slaveAddress: slaveAddress, table: table}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q, want one of table, csv, json", format)
+	}
+}
+
+type tablePrinter struct {
+	w, errW io.Writer
+}
+
+func (p *tablePrinter) print(r result) error {
+	if r.err != nil {
+		_, err := fmt.Fprintf(p.errW, "%s: %v\n", r.time.Format(time.RFC3339), r.err)
+		return err
+	}
+
+	if _, err := fmt.Fprintf(p.w, "-- %s\n", r.time.Format(time.RFC3339)); err != nil {
+		return err
+	}
+	for i, v := range r.values {
+		if _, err := fmt.Fprintf(p.w, "[%d]: %v\n", r.addresses[i], v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// csvPrinter prints a row per poll, with a column per value.
+type csvPrinter struct {
+	w             *csv.Writer
+	errW          io.Writer
+	headerWritten bool
+}
+
+func (p *csvPrinter) print(r result) error {
+	if r.err != nil {
+		_, err := fmt.Fprintf(p.errW, "%s: %v\n", r.time.Format(time.RFC3339), r.err)
+		return err
+	}
+
+	if !p.headerWritten {
+		header := []string{"time"}
+		for _, address := range r.addresses {
+			header = append(header, strconv.Itoa(address))
+		}
+		if err := p.w.Write(header); err != nil {
+			return err
+		}
+		p.headerWritten = true
+	}
+
+	row := []string{r.time.Format(time.RFC3339Nano)}
+	for _, v := range r.values {
+		row = append(row, fmt.Sprint(v))
+	}
+	if err := p.w.Write(row); err != nil {
+		return err
+	}
+
+	p.w.Flush()
+	return p.w.Error()
+}
+
+// jsonPrinter prints a JSON object per poll, one per line.
+type jsonPrinter struct {
+	enc          *json.Encoder
+	slaveAddress int
+	table        string
+}
+
+type jsonValue struct {
+	Address int `json:"address"`
+	Value   any `json:"value"`
+}
+
+type jsonResult struct {
+	Time         time.Time   `json:"time"`
+	SlaveAddress int         `json:"slave"`
+	Table        string      `json:"table"`
+	Values       []jsonValue `json:"values,omitempty"`
+	Error        string      `json:"error,omitempty"`
+}
+
+func (p *jsonPrinter) print(r result) error {
+	out := jsonResult{
+		Time:         r.time,
+		SlaveAddress: p.slaveAddress,
+		Table:        p.table,
+	}
+	if r.err != nil {
+		out.Error = r.err.Error()
+	}
+	for i, v := range r.values {
+		out.Values = append(out.Values, jsonValue{r.addresses[i], jsonSafe(v)})
+	}
+	return p.enc.Encode(out)
+}
+
+// jsonSafe returns v, or its string representation if v is a float that JSON
+// cannot represent.
+func jsonSafe(v any) any {
+	var f float64
+	switch v := v.(type) {
+	case float32:
+		f = float64(v)
+	case float64:
+		f = v
+	default:
+		return v
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return fmt.Sprint(v)
+	}
+	return v
+}
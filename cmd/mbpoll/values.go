@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/shasderias/modbus/internal/wordorder"
+)
+
+// dataType describes how values are encoded in registers.
+type dataType struct {
+	name      string
+	registers int
+
+	decode func(b []byte) any
+	encode func(s string) ([]byte, error)
+}
+
+var dataTypes = []dataType{
+	{"uint16", 1,
+		func(b []byte) any { return binary.BigEndian.Uint16(b) },
+		func(s string) ([]byte, error) {
+			v, err := strconv.ParseUint(s, 0, 16)
+			return binary.BigEndian.AppendUint16(nil, uint16(v)), err
+		}},
+	{"int16", 1,
+		func(b []byte) any { return int16(binary.BigEndian.Uint16(b)) },
+		func(s string) ([]byte, error) {
+			v, err := strconv.ParseInt(s, 0, 16)
+			return binary.BigEndian.AppendUint16(nil, uint16(v)), err
+		}},
+	{"hex", 1,
+		func(b []byte) any { return fmt.Sprintf("0x%04x", binary.BigEndian.Uint16(b)) },
+		func(s string) ([]byte, error) {
+			v, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(s), "0x"), 16, 16)
+			return binary.BigEndian.AppendUint16(nil, uint16(v)), err
+		}},
+	{"uint32", 2,
+		func(b []byte) any { return binary.BigEndian.Uint32(b) },
+		func(s string) ([]byte, error) {
+			v, err := strconv.ParseUint(s, 0, 32)
+			return binary.BigEndian.AppendUint32(nil, uint32(v)), err
+		}},
+	{"int32", 2,
+		func(b []byte) any { return int32(binary.BigEndian.Uint32(b)) },
+		func(s string) ([]byte, error) {
+			v, err := strconv.ParseInt(s, 0, 32)
+			return binary.BigEndian.AppendUint32(nil, uint32(v)), err
+		}},
+	{"float32", 2,
+		func(b []byte) any { return math.Float32frombits(binary.BigEndian.Uint32(b)) },
+		func(s string) ([]byte, error) {
+			v, err := strconv.ParseFloat(s, 32)
+			return binary.BigEndian.AppendUint32(nil, math.Float32bits(float32(v))), err
+		}},
+	{"uint64", 4,
+		func(b []byte) any { return binary.BigEndian.Uint64(b) },
+		func(s string) ([]byte, error) {
+			v, err := strconv.ParseUint(s, 0, 64)
+			return binary.BigEndian.AppendUint64(nil, v), err
+		}},
+	{"int64", 4,
+		func(b []byte) any { return int64(binary.BigEndian.Uint64(b)) },
+		func(s string) ([]byte, error) {
+			v, err := strconv.ParseInt(s, 0, 64)
+			return binary.BigEndian.AppendUint64(nil, uint64(v)), err
+		}},
+	{"float64", 4,
+		func(b []byte) any { return math.Float64frombits(binary.BigEndian.Uint64(b)) },
+		func(s string) ([]byte, error) {
+			v, err := strconv.ParseFloat(s, 64)
+			return binary.BigEndian.AppendUint64(nil, math.Float64bits(v)), err
+		}},
+}
+
+func lookupDataType(name string) (dataType, error) {
+	var names []string
+	for _, dt := range dataTypes {
+		if dt.name == name {
+			return dt, nil
+		}
+		names = append(names, dt.name)
+	}
+	return dataType{}, fmt.Errorf("unknown data type %q, want one of %s", name, strings.Join(names, ", "))
+}
+
+// decodeRegisters decodes register values, as returned in a read registers
+// response, into values of type dt stored in order.
+func decodeRegisters(registers []byte, dt dataType, order wordorder.Order) ([]any, error) {
+	size := 2 * dt.registers
+	if len(registers)%size != 0 {
+		return nil, fmt.Errorf("%d registers cannot be decoded as %s", len(registers)/2, dt.name)
+	}
+
+	values := make([]any, 0, len(registers)/size)
+	for i := 0; i < len(registers); i += size {
+		b := append([]byte(nil), registers[i:i+size]...)
+		order.Apply(b)
+		values = append(values, dt.decode(b))
+	}
+	return values, nil
+}
+
+// encodeRegisters encodes values of type dt into registers in order.
+func encodeRegisters(values []string, dt dataType, order wordorder.Order) ([]uint16, error) {
+	var registers []uint16
+	for _, s := range values {
+		b, err := dt.encode(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %q: %w", dt.name, s, err)
+		}
+		order.Apply(b)
+		for i := 0; i < len(b); i += 2 {
+			registers = append(registers, binary.BigEndian.Uint16(b[i:]))
+		}
+	}
+	return registers, nil
+}
+
+func parseBools(values []string) ([]bool, error) {
+	bools := make([]bool, len(values))
+	for i, s := range values {
+		switch strings.ToLower(s) {
+		case "1", "on", "true":
+			bools[i] = true
+		case "0", "off", "false":
+			bools[i] = false
+		default:
+			return nil, fmt.Errorf("invalid coil value %q, want 0 or 1", s)
+		}
+	}
+	return bools, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/shasderias/modbus/internal/wordorder"
+)
+
+func TestValuesRoundTrip(t *testing.T) {
+	testCases := []struct {
+		dataType string
+		order    wordorder.Order
+		values   []string
+		want     []uint16
+		decoded  []any
+	}{
+		{"uint16", wordorder.ABCD, []string{"1", "0x10"}, []uint16{1, 16}, []any{uint16(1), uint16(16)}},
+		{"int16", wordorder.ABCD, []string{"-1"}, []uint16{0xffff}, []any{int16(-1)}},
+		{"hex", wordorder.ABCD, []string{"0xbeef"}, []uint16{0xbeef}, []any{"0xbeef"}},
+		{"uint32", wordorder.ABCD, []string{"0x12345678"}, []uint16{0x1234, 0x5678}, []any{uint32(0x12345678)}},
+		{"uint32", wordorder.CDAB, []string{"0x12345678"}, []uint16{0x5678, 0x1234}, []any{uint32(0x12345678)}},
+		{"uint32", wordorder.BADC, []string{"0x12345678"}, []uint16{0x3412, 0x7856}, []any{uint32(0x12345678)}},
+		{"uint32", wordorder.DCBA, []string{"0x12345678"}, []uint16{0x7856, 0x3412}, []any{uint32(0x12345678)}},
+		{"float32", wordorder.ABCD, []string{"1.5"}, []uint16{0x3fc0, 0x0000}, []any{float32(1.5)}},
+		{"int64", wordorder.CDAB, []string{"-2"}, []uint16{0xfffe, 0xffff, 0xffff, 0xffff}, []any{int64(-2)}},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.dataType+"/"+tt.order.String(), func(t *testing.T) {
+			dt, err := lookupDataType(tt.dataType)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			registers, err := encodeRegisters(tt.values, dt, tt.order)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(registers, tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+
+			var b []byte
+			for _, r := range registers {
+				b = binary.BigEndian.AppendUint16(b, r)
+			}
+			decoded, err := decodeRegisters(b, dt, tt.order)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(decoded, tt.decoded); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestEncodeRegistersInvalid(t *testing.T) {
+	dt, _ := lookupDataType("int16")
+	if _, err := encodeRegisters([]string{"40000"}, dt, wordorder.ABCD); err == nil {
+		t.Fatal("got nil; want error for out of range value")
+	}
+}
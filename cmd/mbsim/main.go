@@ -0,0 +1,206 @@
+// Command mbsim simulates one or more Modbus slaves.
+//
+// It serves coils, discrete inputs, holding registers and input registers over
+// Modbus/TCP or Modbus/RTU. The units served and the contents of their tables
+// are read from a JSON map file (see simulator.Map); without one, mbsim serves
+// zeroed tables for the units given with -u.
+//
+// Usage:
+//
+//	mbsim [flags] [address]    (-m tcp, the default, address defaults to :502)
+//	mbsim [flags] device       (-m rtu)
+//
+// Examples:
+//
+//	# serve units 1 and 2 on port 5020, logging every request
+//	mbsim -u 1,2 -v :5020
+//
+//	# serve the units described in plant.json on a serial line
+//	mbsim -m rtu -b 9600 -p none -map plant.json /dev/ttyUSB0
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	"github.com/shasderias/modbus/internal/serialport"
+	"github.com/shasderias/modbus/simulator"
+	"github.com/shasderias/modbus/transport/rtu"
+	"github.com/shasderias/modbus/transport/tcp"
+)
+
+type options struct {
+	mode    string
+	target  string
+	mapFile string
+	units   string
+	verbose bool
+	debug   bool
+
+	baudRate int
+	dataBits int
+	parity   string
+	stopBits int
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := run(ctx, os.Args[1:], os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "mbsim: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, stderr io.Writer) error {
+	var opts options
+
+	fs := flag.NewFlagSet("mbsim", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: mbsim [flags] [address] | device\n\n")
+		fs.PrintDefaults()
+	}
+
+	fs.StringVar(&opts.mode, "m", "tcp", "transport: tcp or rtu")
+	fs.StringVar(&opts.mapFile, "map", "", "JSON file describing the units to serve")
+	fs.StringVar(&opts.units, "u", "1", "comma separated unit IDs to serve when no map is given")
+	fs.BoolVar(&opts.verbose, "v", false, "log every request")
+	fs.BoolVar(&opts.debug, "debug", false, "log every frame received and sent")
+
+	fs.IntVar(&opts.baudRate, "b", 19200, "RTU baud rate")
+	fs.IntVar(&opts.dataBits, "databits", 8, "RTU data bits")
+	fs.StringVar(&opts.parity, "p", "even", "RTU parity: none, even or odd")
+	fs.IntVar(&opts.stopBits, "s", 1, "RTU stop bits: 1 or 2")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	switch {
+	case fs.NArg() == 1:
+		opts.target = fs.Arg(0)
+	case fs.NArg() == 0 && opts.mode == "tcp":
+		opts.target = ":502"
+	default:
+		fs.Usage()
+		return errors.New("expected exactly one address or device")
+	}
+
+	m, err := loadMap(opts)
+	if err != nil {
+		return err
+	}
+
+	sim, err := simulator.New(m, func(c *simulator.Config) {
+		if opts.verbose {
+			c.RequestLog = stderr
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	level := slog.LevelInfo
+	if opts.debug {
+		level = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewTextHandler(stderr, &slog.HandlerOptions{Level: level}))
+
+	// the server cancels ctx with the error that stopped it, if it stops
+	// on its own
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	stopServer, err := serve(sim, opts, logger, cancel)
+	if err != nil {
+		return err
+	}
+	defer stopServer()
+
+	fmt.Fprintf(stderr, "serving units %v on %s\n", sim.Units(), opts.target)
+
+	sim.Run(ctx)
+
+	if err := context.Cause(ctx); !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
+
+func loadMap(opts options) (simulator.Map, error) {
+	if opts.mapFile != "" {
+		return simulator.LoadMap(opts.mapFile)
+	}
+
+	var m simulator.Map
+	for _, s := range strings.Split(opts.units, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return simulator.Map{}, fmt.Errorf("invalid unit ID %q", s)
+		}
+		m.Units = append(m.Units, simulator.Unit{ID: id})
+	}
+	return m, nil
+}
+
+// serve starts serving sim. If the server stops on its own, it calls failed
+// with the error that stopped it.
+func serve(sim *simulator.Simulator, opts options, logger *slog.Logger, failed func(err error)) (stop func() error, err error) {
+	switch opts.mode {
+	case "tcp":
+		server, err := tcp.NewServer(opts.target, sim, func(c *tcp.ServerConfig) {
+			c.Logger = logger
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := server.Start(); err != nil {
+			return nil, err
+		}
+		return server.Stop, nil
+	case "rtu":
+		port, err := serialport.Open(opts.target, serialport.Config{
+			BaudRate: opts.baudRate,
+			DataBits: opts.dataBits,
+			Parity:   opts.parity,
+			StopBits: opts.stopBits,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		server, err := rtu.NewServer(port, sim, func(c *rtu.ServerConfig) {
+			c.InterFrameDelay = rtu.InterFrameDelay(opts.baudRate)
+			c.Logger = logger
+		})
+		if err != nil {
+			port.Close()
+			return nil, err
+		}
+		if err := server.Start(); err != nil {
+			port.Close()
+			return nil, err
+		}
+		go func() {
+			<-server.Done()
+			if err := server.Err(); err != nil {
+				failed(err)
+			}
+		}()
+		return func() error {
+			server.Stop()
+			return port.Close()
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown transport %q, want one of tcp, rtu", opts.mode)
+	}
+}
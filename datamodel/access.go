@@ -0,0 +1,118 @@
+package datamodel
+
+import (
+	"fmt"
+
+	"github.com/shasderias/modbus"
+)
+
+// Access restricts what clients may do with an address of a table, so that a
+// Model can emulate the sparse, partly protected register maps of real
+// devices. The application is not restricted.
+type Access uint8
+
+const (
+	// ReadWrite addresses are read and written by clients, as the table
+	// allows; discrete inputs and input registers are never written by
+	// clients.
+	ReadWrite Access = iota
+	// ReadOnly addresses are read by clients. Writes of clients that span
+	// them are answered with Config.ReadOnlyException.
+	ReadOnly
+	// WriteOnly addresses are written by clients. Reads of clients that span
+	// them are answered with ExceptionCodeIllegalDataAddress.
+	WriteOnly
+	// NoAccess addresses do not exist, for clients. Reads and writes of
+	// clients that span them are answered with
+	// ExceptionCodeIllegalDataAddress.
+	NoAccess
+)
+
+func (a Access) String() string {
+	switch a {
+	case ReadWrite:
+		return "read-write"
+	case ReadOnly:
+		return "read-only"
+	case WriteOnly:
+		return "write-only"
+	case NoAccess:
+		return "no access"
+	default:
+		return fmt.Sprintf("Access(%d)", int(a))
+	}
+}
+
+// SetAccess sets the access of clients to the count addresses of t starting
+// at address to a. Every address is ReadWrite until it is set:
+//
+//	// holding registers 0-99 and 200-299, with a read-only block at 0-9
+//	m.SetAccess(datamodel.HoldingRegisters, 100, 100, datamodel.NoAccess)
+//	m.SetAccess(datamodel.HoldingRegisters, 300, 0x10000-300, datamodel.NoAccess)
+//	m.SetAccess(datamodel.HoldingRegisters, 0, 10, datamodel.ReadOnly)
+func (m *Model) SetAccess(t Table, address, count int, a Access) error {
+	if a > NoAccess {
+		return fmt.Errorf("datamodel: invalid access %v", a)
+	}
+
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	if t < Coils || t > InputRegisters {
+		return fmt.Errorf("datamodel: invalid table %v", t)
+	}
+	size := m.Size(t)
+	if address < 0 || count < 0 || address+count > size {
+		return fmt.Errorf("datamodel: addresses [%d, %d) out of range of %v [0, %d)", address, address+count, t, size)
+	}
+
+	// access tables are allocated on first use, as most models leave every
+	// address ReadWrite
+	if m.access[t] == nil {
+		if a == ReadWrite {
+			return nil
+		}
+		m.access[t] = make([]Access, size)
+	}
+	for i := address; i < address+count; i++ {
+		m.access[t][i] = a
+	}
+	return nil
+}
+
+// Access returns the access of clients to address of t.
+func (m *Model) Access(t Table, address int) Access {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+
+	if t < Coils || t > InputRegisters || address < 0 || address >= len(m.access[t]) {
+		return ReadWrite
+	}
+	return m.access[t][address]
+}
+
+// accessException returns the exception code to answer a read, or write, of
+// clients of the count addresses of t starting at address with, or 0 if they
+// may. m.mut must be held.
+func (m *Model) accessException(t Table, address, count int, write bool) byte {
+	if t < Coils || t > InputRegisters || m.access[t] == nil {
+		return 0
+	}
+	access := m.access[t]
+	if address < 0 || address+count > len(access) {
+		// reported by bitTable and registerTable
+		return 0
+	}
+
+	// addresses that do not exist take precedence over read-only addresses
+	var code byte
+	for _, a := range access[address : address+count] {
+		switch {
+		case a == NoAccess, a == WriteOnly && !write:
+			return modbus.ExceptionCodeIllegalDataAddress
+		case a == ReadOnly && write:
+			code = m.readOnlyException
+		}
+	}
+	return code
+}
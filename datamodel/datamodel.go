@@ -0,0 +1,501 @@
+// Package datamodel implements the Modbus data model: coils, discrete inputs,
+// holding registers and input registers, held in memory and served with
+// modbus.Handler.
+//
+// SetAccess marks addresses read-only, write-only or nonexistent to clients,
+// to emulate the sparse register maps of real devices.
+//
+// Applications veto the writes of clients with OnWrite, and react to changes
+// with Watch:
+//
+//	m.Watch(func(c datamodel.Change) {
+//		if c.Table == datamodel.Coils && c.Address == startCoil && c.NewBits[0] {
+//			go motor.Start()
+//		}
+//	})
+//
+// Simulators that must survive restarts save snapshots of their Model with
+// Save or SaveEvery, and restore them with Load:
+//
+//	if err := m.Load(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+//		return err
+//	}
+//	go m.SaveEvery(ctx, path, time.Minute)
+package datamodel
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/shasderias/modbus"
+	"github.com/shasderias/modbus/internal/tableserve"
+)
+
+// Table identifies one of the four tables of the Modbus data model.
+type Table int
+
+const (
+	Coils Table = iota
+	DiscreteInputs
+	HoldingRegisters
+	InputRegisters
+)
+
+func (t Table) String() string {
+	switch t {
+	case Coils:
+		return "coils"
+	case DiscreteInputs:
+		return "discrete inputs"
+	case HoldingRegisters:
+		return "holding registers"
+	case InputRegisters:
+		return "input registers"
+	default:
+		return fmt.Sprintf("Table(%d)", int(t))
+	}
+}
+
+// IsBits reports whether t holds single bits, as opposed to 16-bit registers.
+func (t Table) IsBits() bool {
+	return t == Coils || t == DiscreteInputs
+}
+
+// Model holds the four tables of a single device, or unit. It is safe for
+// concurrent use.
+type Model struct {
+	mut sync.RWMutex
+
+	coils            []bool
+	discreteInputs   []bool
+	holdingRegisters []uint16
+	inputRegisters   []uint16
+
+	// writeHooks and generation are guarded by mut; generation counts the
+	// writes that changed a value, so that SaveEvery skips unchanged
+	// snapshots
+	writeHooks []WriteHook
+	generation uint64
+
+	// access is guarded by mut, and indexed by Table; see SetAccess
+	access            [4][]Access
+	readOnlyException byte
+
+	watchMut    sync.Mutex
+	watchers    map[int]func(c Change)
+	nextWatcher int
+}
+
+// Change describes a write to a table of a Model.
+type Change struct {
+	Table   Table
+	Address int
+
+	// OldBits and NewBits, for bit tables, or OldRegisters and
+	// NewRegisters, for register tables, hold the values of the addresses
+	// written before and after the write.
+	OldBits, NewBits           []bool
+	OldRegisters, NewRegisters []uint16
+
+	// FunctionCode is the function code of the request of the client that
+	// wrote, or 0 if the application wrote with SetBits or SetRegisters.
+	FunctionCode byte
+	// UnitID is the unit ID of the request of the client that wrote.
+	UnitID byte
+}
+
+// WriteHook is called before a write of a client to coils or holding
+// registers, with FC05, FC06, FC0F, FC10, FC16 or FC17, is applied. It
+// returns 0 to allow the write, or the exception code to answer the request
+// with instead, such as modbus.ExceptionCodeIllegalDataValue.
+//
+// Hooks are called with the Model locked, so that the values they are passed
+// are those that are replaced, and must not call its methods; react to
+// writes that were applied with Watch.
+type WriteHook func(c Change) (exceptionCode byte)
+
+// Config sets the number of addresses in each table. Addresses outside a table
+// are answered with ExceptionCodeIllegalDataAddress.
+type Config struct {
+	Coils            int
+	DiscreteInputs   int
+	HoldingRegisters int
+	InputRegisters   int
+
+	// ReadOnlyException is the exception code writes of clients to ReadOnly
+	// addresses are answered with. It defaults to
+	// modbus.ExceptionCodeIllegalDataAddress; some devices answer
+	// modbus.ExceptionCodeIllegalDataValue instead.
+	ReadOnlyException byte
+}
+
+// New returns a Model with every bit and register set to 0. By default, each
+// table spans the entire address space (65536 addresses).
+func New(fns ...func(c *Config)) *Model {
+	conf := Config{
+		Coils:            0x10000,
+		DiscreteInputs:   0x10000,
+		HoldingRegisters: 0x10000,
+		InputRegisters:   0x10000,
+
+		ReadOnlyException: modbus.ExceptionCodeIllegalDataAddress,
+	}
+	for _, fn := range fns {
+		fn(&conf)
+	}
+	if conf.ReadOnlyException == 0 {
+		conf.ReadOnlyException = modbus.ExceptionCodeIllegalDataAddress
+	}
+
+	return &Model{
+		coils:             make([]bool, clampSize(conf.Coils)),
+		discreteInputs:    make([]bool, clampSize(conf.DiscreteInputs)),
+		holdingRegisters:  make([]uint16, clampSize(conf.HoldingRegisters)),
+		inputRegisters:    make([]uint16, clampSize(conf.InputRegisters)),
+		readOnlyException: conf.ReadOnlyException,
+		watchers:          make(map[int]func(c Change)),
+	}
+}
+
+func clampSize(n int) int {
+	if n < 0 {
+		return 0
+	}
+	if n > 0x10000 {
+		return 0x10000
+	}
+	return n
+}
+
+// Size returns the number of addresses in t.
+func (m *Model) Size(t Table) int {
+	switch t {
+	case Coils:
+		return len(m.coils)
+	case DiscreteInputs:
+		return len(m.discreteInputs)
+	case HoldingRegisters:
+		return len(m.holdingRegisters)
+	case InputRegisters:
+		return len(m.inputRegisters)
+	default:
+		return 0
+	}
+}
+
+// Bits returns count bits of t starting at address.
+func (m *Model) Bits(t Table, address, count int) ([]bool, error) {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+
+	bits, err := m.bitTable(t, address, count)
+	if err != nil {
+		return nil, err
+	}
+	return append([]bool(nil), bits...), nil
+}
+
+// SetBits sets the bits of t starting at address to values.
+func (m *Model) SetBits(t Table, address int, values ...bool) error {
+	_, err := m.writeBits(t, address, values, 0, 0)
+	return err
+}
+
+// writeBits sets the bits of t starting at address to values, if the access
+// of clients and the write hooks allow it, and notifies the watchers of the
+// change. It returns the exception code of the hook that vetoed the write,
+// if any.
+func (m *Model) writeBits(t Table, address int, values []bool, unitID, functionCode byte) (byte, error) {
+	m.mut.Lock()
+
+	bits, err := m.bitTable(t, address, len(values))
+	if err != nil {
+		m.mut.Unlock()
+		return 0, err
+	}
+	if functionCode != 0 {
+		if code := m.accessException(t, address, len(values), true); code != 0 {
+			m.mut.Unlock()
+			return code, nil
+		}
+	}
+
+	c := Change{
+		Table:        t,
+		Address:      address,
+		OldBits:      append([]bool(nil), bits...),
+		NewBits:      append([]bool(nil), values...),
+		FunctionCode: functionCode,
+		UnitID:       unitID,
+	}
+	if code := m.runWriteHooks(c); code != 0 {
+		m.mut.Unlock()
+		return code, nil
+	}
+	copy(bits, values)
+	changed := !slices.Equal(c.OldBits, c.NewBits)
+	if changed {
+		m.generation++
+	}
+
+	m.mut.Unlock()
+
+	if changed {
+		m.notify(c)
+	}
+	return 0, nil
+}
+
+// Registers returns count registers of t starting at address.
+func (m *Model) Registers(t Table, address, count int) ([]uint16, error) {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+
+	registers, err := m.registerTable(t, address, count)
+	if err != nil {
+		return nil, err
+	}
+	return append([]uint16(nil), registers...), nil
+}
+
+// SetRegisters sets the registers of t starting at address to values.
+func (m *Model) SetRegisters(t Table, address int, values ...uint16) error {
+	_, err := m.writeRegisters(t, address, len(values), func([]uint16) []uint16 { return values }, 0, 0)
+	return err
+}
+
+// writeRegisters sets the count registers of t starting at address to the
+// values returned by update, which is passed their current values, as
+// writeBits does.
+func (m *Model) writeRegisters(t Table, address, count int, update func(old []uint16) []uint16, unitID, functionCode byte) (byte, error) {
+	m.mut.Lock()
+	changed, code, err := m.writeRegistersLocked(t, address, count, update, unitID, functionCode)
+	m.mut.Unlock()
+
+	if changed != nil {
+		m.notify(*changed)
+	}
+	return code, err
+}
+
+// writeRegistersLocked is writeRegisters, but for notifying the watchers: it
+// returns the change to notify them of, or nil if no value changed. m.mut
+// must be held.
+func (m *Model) writeRegistersLocked(t Table, address, count int, update func(old []uint16) []uint16, unitID, functionCode byte) (*Change, byte, error) {
+	registers, err := m.registerTable(t, address, count)
+	if err != nil {
+		return nil, 0, err
+	}
+	if functionCode != 0 {
+		if code := m.accessException(t, address, count, true); code != 0 {
+			return nil, code, nil
+		}
+	}
+
+	old := append([]uint16(nil), registers...)
+	c := Change{
+		Table:        t,
+		Address:      address,
+		OldRegisters: old,
+		NewRegisters: append([]uint16(nil), update(old)...),
+		FunctionCode: functionCode,
+		UnitID:       unitID,
+	}
+	if code := m.runWriteHooks(c); code != 0 {
+		return nil, code, nil
+	}
+	copy(registers, c.NewRegisters)
+	if slices.Equal(c.OldRegisters, c.NewRegisters) {
+		return nil, 0, nil
+	}
+	m.generation++
+	return &c, 0, nil
+}
+
+// OnWrite adds hook to the hooks called before every write of a client.
+// Hooks are called in the order they were added, until one vetoes the write.
+func (m *Model) OnWrite(hook WriteHook) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	m.writeHooks = append(m.writeHooks, hook)
+}
+
+// runWriteHooks returns the exception code of the first write hook that
+// vetoes c, or 0. Writes of the application are not passed to hooks. m.mut
+// must be held.
+func (m *Model) runWriteHooks(c Change) byte {
+	if c.FunctionCode == 0 {
+		return 0
+	}
+	for _, hook := range m.writeHooks {
+		if code := hook(c); code != 0 {
+			return code
+		}
+	}
+	return 0
+}
+
+// Watch calls fn with every change to the values of m, made by clients or
+// the application, until stop is called. Writes that leave every value
+// unchanged are not reported.
+//
+// fn is called synchronously, after the change is applied, from the
+// goroutine that made it, and may call the methods of m. Changes made
+// concurrently may be reported concurrently and out of order.
+func (m *Model) Watch(fn func(c Change)) (stop func()) {
+	m.watchMut.Lock()
+	defer m.watchMut.Unlock()
+
+	id := m.nextWatcher
+	m.nextWatcher++
+	m.watchers[id] = fn
+
+	return func() {
+		m.watchMut.Lock()
+		defer m.watchMut.Unlock()
+		delete(m.watchers, id)
+	}
+}
+
+func (m *Model) notify(c Change) {
+	m.watchMut.Lock()
+	watchers := make([]func(c Change), 0, len(m.watchers))
+	for _, fn := range m.watchers {
+		watchers = append(watchers, fn)
+	}
+	m.watchMut.Unlock()
+
+	for _, fn := range watchers {
+		fn(c)
+	}
+}
+
+// bitTable returns the slice of t spanning [address, address+count). m.mut
+// must be held.
+func (m *Model) bitTable(t Table, address, count int) ([]bool, error) {
+	var table []bool
+	switch t {
+	case Coils:
+		table = m.coils
+	case DiscreteInputs:
+		table = m.discreteInputs
+	default:
+		return nil, fmt.Errorf("datamodel: %v do not hold bits", t)
+	}
+	if address < 0 || count < 0 || address+count > len(table) {
+		return nil, fmt.Errorf("datamodel: addresses [%d, %d) out of range of %v [0, %d)", address, address+count, t, len(table))
+	}
+	return table[address : address+count], nil
+}
+
+// registerTable returns the slice of t spanning [address, address+count).
+// m.mut must be held.
+func (m *Model) registerTable(t Table, address, count int) ([]uint16, error) {
+	var table []uint16
+	switch t {
+	case HoldingRegisters:
+		table = m.holdingRegisters
+	case InputRegisters:
+		table = m.inputRegisters
+	default:
+		return nil, fmt.Errorf("datamodel: %v do not hold registers", t)
+	}
+	if address < 0 || count < 0 || address+count > len(table) {
+		return nil, fmt.Errorf("datamodel: addresses [%d, %d) out of range of %v [0, %d)", address, address+count, t, len(table))
+	}
+	return table[address : address+count], nil
+}
+
+// ServeModbus implements modbus.Handler for the read and write coil, discrete
+// input, holding register and input register function codes, and the mask
+// write register and read/write multiple registers function codes. unitID is
+// ignored, but for being passed to write hooks. Other function codes are
+// answered with ExceptionCodeIllegalFunction.
+func (m *Model) ServeModbus(ctx context.Context, unitID byte, req modbus.PDU) (modbus.PDU, error) {
+	return tableserve.Serve(modelTables{m}, unitID, req)
+}
+
+// modelTables serves the tables of a Model to clients, subject to their
+// access and to the write hooks.
+type modelTables struct {
+	m *Model
+}
+
+func (mt modelTables) ReadBits(t tableserve.Table, address, count int) ([]bool, byte) {
+	m := mt.m
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+
+	bits, err := m.bitTable(Table(t), address, count)
+	if err != nil {
+		return nil, modbus.ExceptionCodeIllegalDataAddress
+	}
+	if code := m.accessException(Table(t), address, count, false); code != 0 {
+		return nil, code
+	}
+	return append([]bool(nil), bits...), 0
+}
+
+func (mt modelTables) ReadRegisters(t tableserve.Table, address, count int) ([]uint16, byte) {
+	m := mt.m
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+
+	return m.clientReadRegisters(Table(t), address, count)
+}
+
+func (mt modelTables) WriteCoils(w tableserve.CoilWrite) byte {
+	code, err := mt.m.writeBits(Coils, w.Address, w.Values, w.UnitID, w.FunctionCode)
+	return writeException(code, err)
+}
+
+func (mt modelTables) WriteHoldingRegisters(w tableserve.RegisterWrite) ([]uint16, byte) {
+	m := mt.m
+	m.mut.Lock()
+
+	if w.ReadCount > 0 {
+		if _, code := m.clientReadRegisters(HoldingRegisters, w.ReadAddress, w.ReadCount); code != 0 {
+			m.mut.Unlock()
+			return nil, code
+		}
+	}
+	changed, code, err := m.writeRegistersLocked(HoldingRegisters, w.Address, w.Count, w.Update, w.UnitID, w.FunctionCode)
+	code = writeException(code, err)
+	var registers []uint16
+	if code == 0 && w.ReadCount > 0 {
+		registers, code = m.clientReadRegisters(HoldingRegisters, w.ReadAddress, w.ReadCount)
+	}
+
+	m.mut.Unlock()
+
+	if changed != nil {
+		m.notify(*changed)
+	}
+	return registers, code
+}
+
+// clientReadRegisters reads count registers of t starting at address for a
+// client, and returns the exception code to answer it with if the read
+// fails. m.mut must be held.
+func (m *Model) clientReadRegisters(t Table, address, count int) ([]uint16, byte) {
+	registers, err := m.registerTable(t, address, count)
+	if err != nil {
+		return nil, modbus.ExceptionCodeIllegalDataAddress
+	}
+	if code := m.accessException(t, address, count, false); code != 0 {
+		return nil, code
+	}
+	return append([]uint16(nil), registers...), 0
+}
+
+// writeException returns the exception code to answer a client with after a
+// write that was vetoed with exceptionCode, or failed with err.
+func writeException(exceptionCode byte, err error) byte {
+	if err != nil {
+		return modbus.ExceptionCodeIllegalDataAddress
+	}
+	return exceptionCode
+}
@@ -0,0 +1,296 @@
+package datamodel_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/shasderias/modbus"
+	"github.com/shasderias/modbus/datamodel"
+)
+
+func serve(t *testing.T, h modbus.Handler, pdu ...byte) []byte {
+	t.Helper()
+
+	req, err := modbus.NewRawPDU(pdu)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := modbus.Respond(context.Background(), h, 1, req)
+	var exception *modbus.ExceptionResponse
+	if err != nil && !errors.As(err, &exception) {
+		t.Fatal(err)
+	}
+
+	b, err := resp.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestModelServeModbus(t *testing.T) {
+	m := datamodel.New(func(c *datamodel.Config) {
+		c.Coils = 16
+		c.DiscreteInputs = 16
+		c.HoldingRegisters = 16
+		c.InputRegisters = 16
+	})
+	if err := m.SetBits(datamodel.DiscreteInputs, 1, true, false, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetRegisters(datamodel.InputRegisters, 14, 0x1234, 0x5678); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name string
+		req  []byte
+		want []byte
+	}{
+		{"ReadDiscreteInputs", []byte{0x02, 0x00, 0x00, 0x00, 0x05}, []byte{0x02, 0x01, 0b00000101 << 1}},
+		{"ReadInputRegisters", []byte{0x04, 0x00, 0x0e, 0x00, 0x02}, []byte{0x04, 0x04, 0x12, 0x34, 0x56, 0x78}},
+		{"WriteSingleCoil", []byte{0x05, 0x00, 0x03, 0xff, 0x00}, []byte{0x05, 0x00, 0x03, 0xff, 0x00}},
+		{"WriteMultipleCoils", []byte{0x0f, 0x00, 0x08, 0x00, 0x03, 0x01, 0b101}, []byte{0x0f, 0x00, 0x08, 0x00, 0x03}},
+		{"ReadCoils", []byte{0x01, 0x00, 0x00, 0x00, 0x10}, []byte{0x01, 0x02, 0b00001000, 0b00000101}},
+		{"WriteSingleRegister", []byte{0x06, 0x00, 0x00, 0xbe, 0xef}, []byte{0x06, 0x00, 0x00, 0xbe, 0xef}},
+		{"WriteMultipleRegisters", []byte{0x10, 0x00, 0x01, 0x00, 0x02, 0x04, 0x00, 0x01, 0x00, 0x02}, []byte{0x10, 0x00, 0x01, 0x00, 0x02}},
+		{"ReadHoldingRegisters", []byte{0x03, 0x00, 0x00, 0x00, 0x03}, []byte{0x03, 0x06, 0xbe, 0xef, 0x00, 0x01, 0x00, 0x02}},
+		{"IllegalFunction", []byte{0x07}, []byte{0x87, modbus.ExceptionCodeIllegalFunction}},
+		{"IllegalDataAddress", []byte{0x03, 0x00, 0x0f, 0x00, 0x02}, []byte{0x83, modbus.ExceptionCodeIllegalDataAddress}},
+		{"IllegalDataValue", []byte{0x03, 0x00, 0x00, 0x00, 0x00}, []byte{0x83, modbus.ExceptionCodeIllegalDataValue}},
+		{"WriteInputRegister", []byte{0x06, 0x00, 0x10, 0x00, 0x01}, []byte{0x86, modbus.ExceptionCodeIllegalDataAddress}},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(serve(t, m, tt.req...), tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestModelAccessors(t *testing.T) {
+	m := datamodel.New()
+
+	if got := m.Size(datamodel.HoldingRegisters); got != 0x10000 {
+		t.Fatalf("got size %d; want 65536", got)
+	}
+
+	if err := m.SetRegisters(datamodel.Coils, 0, 1); err == nil {
+		t.Fatal("got nil; want error setting registers of a bit table")
+	}
+	if _, err := m.Bits(datamodel.Coils, 0xfffe, 3); err == nil {
+		t.Fatal("got nil; want error reading past the end of a table")
+	}
+
+	if err := m.SetRegisters(datamodel.HoldingRegisters, 0xffff, 7); err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.Registers(datamodel.HoldingRegisters, 0xfffe, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, []uint16{0, 7}); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestModelWriteHooks(t *testing.T) {
+	m := datamodel.New(func(c *datamodel.Config) {
+		c.Coils = 16
+		c.HoldingRegisters = 16
+	})
+	if err := m.SetRegisters(datamodel.HoldingRegisters, 0, 0x00f0, 0x0001); err != nil {
+		t.Fatal(err)
+	}
+
+	var changes []datamodel.Change
+	m.OnWrite(func(c datamodel.Change) byte {
+		changes = append(changes, c)
+		return 0
+	})
+	// register 1 is a setpoint in [0, 1000]
+	m.OnWrite(func(c datamodel.Change) byte {
+		if c.Table != datamodel.HoldingRegisters {
+			return 0
+		}
+		for i, v := range c.NewRegisters {
+			if c.Address+i == 1 && v > 1000 {
+				return modbus.ExceptionCodeIllegalDataValue
+			}
+		}
+		return 0
+	})
+
+	testCases := []struct {
+		name string
+		req  []byte
+		want []byte
+	}{
+		{"WriteSingleCoil", []byte{0x05, 0x00, 0x03, 0xff, 0x00}, []byte{0x05, 0x00, 0x03, 0xff, 0x00}},
+		{"WriteSingleRegister", []byte{0x06, 0x00, 0x01, 0x03, 0xe8}, []byte{0x06, 0x00, 0x01, 0x03, 0xe8}},
+		{"WriteSingleRegisterVetoed", []byte{0x06, 0x00, 0x01, 0x03, 0xe9}, []byte{0x86, modbus.ExceptionCodeIllegalDataValue}},
+		{"WriteMultipleRegistersVetoed", []byte{0x10, 0x00, 0x00, 0x00, 0x02, 0x04, 0x00, 0x00, 0xff, 0xff}, []byte{0x90, modbus.ExceptionCodeIllegalDataValue}},
+		// 0x00f0 AND 0x0ff0 OR (0x1234 AND NOT 0x0ff0)
+		{"MaskWriteRegister", []byte{0x16, 0x00, 0x00, 0x0f, 0xf0, 0x12, 0x34}, []byte{0x16, 0x00, 0x00, 0x0f, 0xf0, 0x12, 0x34}},
+		{"ReadWriteMultipleRegisters", []byte{0x17, 0x00, 0x00, 0x00, 0x03, 0x00, 0x02, 0x00, 0x01, 0x02, 0xab, 0xcd}, []byte{0x17, 0x06, 0x10, 0xf4, 0x03, 0xe8, 0xab, 0xcd}},
+		{"ReadWriteMultipleRegistersOutOfRange", []byte{0x17, 0x00, 0x0f, 0x00, 0x02, 0x00, 0x02, 0x00, 0x01, 0x02, 0xab, 0xcd}, []byte{0x97, modbus.ExceptionCodeIllegalDataAddress}},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(serve(t, m, tt.req...), tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+
+	want := []datamodel.Change{
+		{Table: datamodel.Coils, Address: 3, OldBits: []bool{false}, NewBits: []bool{true}, FunctionCode: 0x05, UnitID: 1},
+		{Table: datamodel.HoldingRegisters, Address: 1, OldRegisters: []uint16{1}, NewRegisters: []uint16{1000}, FunctionCode: 0x06, UnitID: 1},
+		{Table: datamodel.HoldingRegisters, Address: 1, OldRegisters: []uint16{1000}, NewRegisters: []uint16{1001}, FunctionCode: 0x06, UnitID: 1},
+		{Table: datamodel.HoldingRegisters, Address: 0, OldRegisters: []uint16{0x00f0, 1000}, NewRegisters: []uint16{0, 0xffff}, FunctionCode: 0x10, UnitID: 1},
+		{Table: datamodel.HoldingRegisters, Address: 0, OldRegisters: []uint16{0x00f0}, NewRegisters: []uint16{0x10f4}, FunctionCode: 0x16, UnitID: 1},
+		{Table: datamodel.HoldingRegisters, Address: 2, OldRegisters: []uint16{0}, NewRegisters: []uint16{0xabcd}, FunctionCode: 0x17, UnitID: 1},
+	}
+	if diff := cmp.Diff(changes, want); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestModelWatch(t *testing.T) {
+	m := datamodel.New(func(c *datamodel.Config) {
+		c.Coils = 16
+		c.DiscreteInputs = 16
+	})
+
+	// a start coil that starts a simulated motor
+	var changes []datamodel.Change
+	stop := m.Watch(func(c datamodel.Change) {
+		changes = append(changes, c)
+		if c.Table == datamodel.Coils && c.Address == 0 {
+			if err := m.SetBits(datamodel.DiscreteInputs, 0, c.NewBits[0]); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+
+	serve(t, m, 0x05, 0x00, 0x00, 0xff, 0x00)
+	// unchanged
+	serve(t, m, 0x05, 0x00, 0x00, 0xff, 0x00)
+
+	running, err := m.Bits(datamodel.DiscreteInputs, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !running[0] {
+		t.Fatal("got motor stopped; want running")
+	}
+
+	stop()
+	serve(t, m, 0x05, 0x00, 0x00, 0x00, 0x00)
+
+	want := []datamodel.Change{
+		{Table: datamodel.Coils, Address: 0, OldBits: []bool{false}, NewBits: []bool{true}, FunctionCode: 0x05, UnitID: 1},
+		{Table: datamodel.DiscreteInputs, Address: 0, OldBits: []bool{false}, NewBits: []bool{true}},
+	}
+	if diff := cmp.Diff(changes, want); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+// TestModelReadWriteMultipleRegistersAtomic checks that FC17 reads the values
+// it wrote, although a watcher changes them as soon as it can.
+func TestModelReadWriteMultipleRegistersAtomic(t *testing.T) {
+	m := datamodel.New(func(c *datamodel.Config) {
+		c.HoldingRegisters = 4
+	})
+	m.Watch(func(c datamodel.Change) {
+		if c.FunctionCode != 0 {
+			if err := m.SetRegisters(datamodel.HoldingRegisters, c.Address, 0x9999); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+
+	got := serve(t, m, 0x17, 0x00, 0x00, 0x00, 0x02, 0x00, 0x01, 0x00, 0x01, 0x02, 0xab, 0xcd)
+	if diff := cmp.Diff(got, []byte{0x17, 0x04, 0x00, 0x00, 0xab, 0xcd}); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestModelAccess(t *testing.T) {
+	m := datamodel.New(func(c *datamodel.Config) {
+		c.Coils = 16
+		c.HoldingRegisters = 16
+		c.ReadOnlyException = modbus.ExceptionCodeIllegalDataValue
+	})
+	for _, a := range []struct {
+		t              datamodel.Table
+		address, count int
+		access         datamodel.Access
+	}{
+		{datamodel.HoldingRegisters, 0, 2, datamodel.ReadOnly},
+		{datamodel.HoldingRegisters, 4, 2, datamodel.NoAccess},
+		{datamodel.HoldingRegisters, 8, 1, datamodel.WriteOnly},
+		{datamodel.Coils, 8, 8, datamodel.NoAccess},
+	} {
+		if err := m.SetAccess(a.t, a.address, a.count, a.access); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.SetRegisters(datamodel.HoldingRegisters, 0, 0x1234); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name string
+		req  []byte
+		want []byte
+	}{
+		{"ReadReadOnly", []byte{0x03, 0x00, 0x00, 0x00, 0x02}, []byte{0x03, 0x04, 0x12, 0x34, 0x00, 0x00}},
+		{"WriteReadOnly", []byte{0x06, 0x00, 0x01, 0x00, 0x01}, []byte{0x86, modbus.ExceptionCodeIllegalDataValue}},
+		{"WriteSpanningReadOnly", []byte{0x10, 0x00, 0x01, 0x00, 0x02, 0x04, 0x00, 0x01, 0x00, 0x02}, []byte{0x90, modbus.ExceptionCodeIllegalDataValue}},
+		{"WriteSpanningReadOnlyAndHole", []byte{0x10, 0x00, 0x01, 0x00, 0x04, 0x08, 0, 0, 0, 0, 0, 0, 0, 0}, []byte{0x90, modbus.ExceptionCodeIllegalDataAddress}},
+		{"MaskWriteReadOnly", []byte{0x16, 0x00, 0x00, 0x00, 0xf2, 0x00, 0x25}, []byte{0x96, modbus.ExceptionCodeIllegalDataValue}},
+		{"ReadSpanningHole", []byte{0x03, 0x00, 0x02, 0x00, 0x03}, []byte{0x83, modbus.ExceptionCodeIllegalDataAddress}},
+		{"ReadAroundHole", []byte{0x03, 0x00, 0x02, 0x00, 0x02}, []byte{0x03, 0x04, 0x00, 0x00, 0x00, 0x00}},
+		{"WriteHole", []byte{0x06, 0x00, 0x05, 0x00, 0x01}, []byte{0x86, modbus.ExceptionCodeIllegalDataAddress}},
+		{"WriteWriteOnly", []byte{0x06, 0x00, 0x08, 0x00, 0x07}, []byte{0x06, 0x00, 0x08, 0x00, 0x07}},
+		{"ReadWriteOnly", []byte{0x03, 0x00, 0x08, 0x00, 0x01}, []byte{0x83, modbus.ExceptionCodeIllegalDataAddress}},
+		{"ReadWriteWriteOnly", []byte{0x17, 0x00, 0x08, 0x00, 0x01, 0x00, 0x08, 0x00, 0x01, 0x02, 0x00, 0x09}, []byte{0x97, modbus.ExceptionCodeIllegalDataAddress}},
+		{"ReadCoilHole", []byte{0x01, 0x00, 0x00, 0x00, 0x09}, []byte{0x81, modbus.ExceptionCodeIllegalDataAddress}},
+		{"WriteCoils", []byte{0x0f, 0x00, 0x00, 0x00, 0x08, 0x01, 0xff}, []byte{0x0f, 0x00, 0x00, 0x00, 0x08}},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(serve(t, m, tt.req...), tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+
+	// FC17 must not write if its read is refused
+	got, err := m.Registers(datamodel.HoldingRegisters, 8, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, []uint16{7}); diff != "" {
+		t.Fatal(diff)
+	}
+
+	// the application is not restricted
+	if err := m.SetRegisters(datamodel.HoldingRegisters, 4, 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Access(datamodel.HoldingRegisters, 5); got != datamodel.NoAccess {
+		t.Fatalf("got %v; want %v", got, datamodel.NoAccess)
+	}
+}
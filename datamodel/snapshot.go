@@ -0,0 +1,255 @@
+package datamodel
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Snapshots hold the values of the four tables of a Model. They are written
+// by WriteTo and Save, and read by ReadFrom and Load, in this format, with
+// every integer big endian:
+//
+//	offset  length  content
+//	0       4       magic, "MBDM"
+//	4       1       format version, 1
+//	5       ...     the tables, in the order coils, discrete inputs, holding
+//	                registers, input registers, each as:
+//	                  4 bytes: the number of addresses n
+//	                  bit tables: (n+7)/8 bytes, packed as in Modbus PDUs,
+//	                  the first address in the least significant bit of
+//	                  the first byte
+//	                  register tables: 2n bytes, a register per address
+//	end-4   4       CRC-32 (IEEE) of every preceding byte
+const (
+	snapshotMagic   = "MBDM"
+	snapshotVersion = 1
+)
+
+var (
+	// ErrSnapshotCorrupt is returned by ReadFrom and Load for snapshots that
+	// are truncated, fail their checksum or are not snapshots.
+	ErrSnapshotCorrupt = errors.New("datamodel: snapshot corrupt")
+	// ErrSnapshotMismatch is returned by ReadFrom and Load for snapshots
+	// whose tables differ in size from those of the Model.
+	ErrSnapshotMismatch = errors.New("datamodel: snapshot does not match model")
+)
+
+// WriteTo writes a snapshot of m to w.
+func (m *Model) WriteTo(w io.Writer) (int64, error) {
+	b, _ := m.snapshot()
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+// snapshot returns a snapshot of m, and the generation of m it was taken at.
+func (m *Model) snapshot() ([]byte, uint64) {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+
+	b := append([]byte(snapshotMagic), snapshotVersion)
+	for _, bits := range [][]bool{m.coils, m.discreteInputs} {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(bits)))
+		packed := make([]byte, (len(bits)+7)/8)
+		for i, bit := range bits {
+			if bit {
+				packed[i/8] |= 1 << (i % 8)
+			}
+		}
+		b = append(b, packed...)
+	}
+	for _, registers := range [][]uint16{m.holdingRegisters, m.inputRegisters} {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(registers)))
+		for _, register := range registers {
+			b = binary.BigEndian.AppendUint16(b, register)
+		}
+	}
+	b = binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b))
+
+	return b, m.generation
+}
+
+// ReadFrom reads a snapshot from r, to its end, and sets the values of m to
+// those of the snapshot. The tables of the snapshot must be the size of
+// those of m. m is left unchanged if the snapshot is invalid. Write hooks
+// and watchers are not called.
+func (m *Model) ReadFrom(r io.Reader) (int64, error) {
+	b, err := io.ReadAll(r)
+	n := int64(len(b))
+	if err != nil {
+		return n, err
+	}
+
+	if len(b) < len(snapshotMagic)+1+4 || string(b[:len(snapshotMagic)]) != snapshotMagic {
+		return n, fmt.Errorf("%w: bad magic", ErrSnapshotCorrupt)
+	}
+	body, sum := b[:len(b)-4], binary.BigEndian.Uint32(b[len(b)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return n, fmt.Errorf("%w: bad checksum", ErrSnapshotCorrupt)
+	}
+	if v := body[len(snapshotMagic)]; v != snapshotVersion {
+		return n, fmt.Errorf("%w: unsupported format version %d", ErrSnapshotCorrupt, v)
+	}
+	body = body[len(snapshotMagic)+1:]
+
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	// check every table before writing any
+	tables := []struct {
+		t    Table
+		size int
+	}{{Coils, len(m.coils)}, {DiscreteInputs, len(m.discreteInputs)}, {HoldingRegisters, len(m.holdingRegisters)}, {InputRegisters, len(m.inputRegisters)}}
+	data := make([][]byte, len(tables))
+	for i, table := range tables {
+		if len(body) < 4 {
+			return n, fmt.Errorf("%w: truncated", ErrSnapshotCorrupt)
+		}
+		size := int(binary.BigEndian.Uint32(body))
+		body = body[4:]
+		if size != table.size {
+			return n, fmt.Errorf("%w: snapshot has %d %s; model has %d", ErrSnapshotMismatch, size, table.t, table.size)
+		}
+
+		length := 2 * size
+		if table.t.IsBits() {
+			length = (size + 7) / 8
+		}
+		if len(body) < length {
+			return n, fmt.Errorf("%w: truncated", ErrSnapshotCorrupt)
+		}
+		data[i], body = body[:length], body[length:]
+	}
+	if len(body) != 0 {
+		return n, fmt.Errorf("%w: %d trailing bytes", ErrSnapshotCorrupt, len(body))
+	}
+
+	for i, bits := range [][]bool{m.coils, m.discreteInputs} {
+		for j := range bits {
+			bits[j] = data[i][j/8]&(1<<(j%8)) != 0
+		}
+	}
+	for i, registers := range [][]uint16{m.holdingRegisters, m.inputRegisters} {
+		for j := range registers {
+			registers[j] = binary.BigEndian.Uint16(data[2+i][2*j:])
+		}
+	}
+	m.generation++
+
+	return n, nil
+}
+
+// Save writes a snapshot of m to the file at path. The snapshot is written
+// to a temporary file in the same directory, which is synced and renamed to
+// path, so that path holds either the previous snapshot or the new one, even
+// if the process or the machine crashes while saving.
+func (m *Model) Save(path string) error {
+	b, _ := m.snapshot()
+	return writeFileAtomic(path, b)
+}
+
+// Load sets the values of m to those of the snapshot in the file at path, as
+// ReadFrom does. If there is no file at path, the error returned matches
+// fs.ErrNotExist, so that applications can start from a blank Model:
+//
+//	if err := m.Load(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+//		return err
+//	}
+func (m *Model) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("datamodel: loading snapshot: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := m.ReadFrom(f); err != nil {
+		return fmt.Errorf("datamodel: loading snapshot %s: %w", path, err)
+	}
+	return nil
+}
+
+// SaveEvery saves a snapshot of m to the file at path, as Save does, every
+// interval, if any value changed since the last snapshot, until ctx is done.
+// It then saves a final snapshot, if any value changed, and returns the
+// error of that save. It returns early with the error of the first save that
+// fails, and at once with an error if interval is not positive.
+func (m *Model) SaveEvery(ctx context.Context, path string, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("datamodel: saving snapshots: interval must be positive: %v", interval)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	saved := false
+	var savedGeneration uint64
+	save := func() error {
+		b, generation := m.snapshot()
+		if saved && generation == savedGeneration {
+			return nil
+		}
+		if err := writeFileAtomic(path, b); err != nil {
+			return err
+		}
+		saved, savedGeneration = true, generation
+		return nil
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := save(); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return save()
+		}
+	}
+}
+
+// writeFileAtomic replaces the file at path with one holding b.
+func writeFileAtomic(path string, b []byte) (err error) {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+
+	f, err := os.CreateTemp(dir, "."+name+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("datamodel: saving snapshot: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			err = fmt.Errorf("datamodel: saving snapshot %s: %w", path, err)
+		}
+	}()
+
+	if _, err := f.Write(b); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+
+	// sync the directory, so that the rename survives a crash; not every
+	// platform supports this, so failures are ignored
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
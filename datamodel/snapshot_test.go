@@ -0,0 +1,179 @@
+package datamodel_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/shasderias/modbus/datamodel"
+)
+
+func newSnapshotModel() *datamodel.Model {
+	return datamodel.New(func(c *datamodel.Config) {
+		c.Coils = 10
+		c.DiscreteInputs = 3
+		c.HoldingRegisters = 4
+		c.InputRegisters = 2
+	})
+}
+
+func TestModelSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "model.snapshot")
+
+	m := newSnapshotModel()
+	if err := m.SetBits(datamodel.Coils, 0, true, false, true, false, false, false, false, false, false, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetBits(datamodel.DiscreteInputs, 1, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetRegisters(datamodel.HoldingRegisters, 0, 0x0102, 0, 0xffff, 7); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetRegisters(datamodel.InputRegisters, 1, 0xbeef); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		'M', 'B', 'D', 'M', 1,
+		0, 0, 0, 10, 0x05, 0x02,
+		0, 0, 0, 3, 0x02,
+		0, 0, 0, 4, 0x01, 0x02, 0x00, 0x00, 0xff, 0xff, 0x00, 0x07,
+		0, 0, 0, 2, 0x00, 0x00, 0xbe, 0xef,
+	}
+	if diff := cmp.Diff(b[:len(b)-4], want); diff != "" {
+		t.Fatal(diff)
+	}
+
+	restored := newSnapshotModel()
+	if err := restored.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []datamodel.Table{datamodel.Coils, datamodel.DiscreteInputs} {
+		got, _ := restored.Bits(table, 0, m.Size(table))
+		want, _ := m.Bits(table, 0, m.Size(table))
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Fatalf("%s: %s", table, diff)
+		}
+	}
+	for _, table := range []datamodel.Table{datamodel.HoldingRegisters, datamodel.InputRegisters} {
+		got, _ := restored.Registers(table, 0, m.Size(table))
+		want, _ := m.Registers(table, 0, m.Size(table))
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Fatalf("%s: %s", table, diff)
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d files; want only the snapshot", len(entries))
+	}
+}
+
+func TestModelLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "model.snapshot")
+	if err := newSnapshotModel().Save(path); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	corrupt := append([]byte(nil), b...)
+	corrupt[len(corrupt)/2] ^= 0xff
+	if err := os.WriteFile(filepath.Join(dir, "corrupt"), corrupt, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "truncated"), b[:len(b)-1], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name  string
+		model *datamodel.Model
+		path  string
+		want  error
+	}{
+		{"NotExist", newSnapshotModel(), filepath.Join(dir, "missing"), fs.ErrNotExist},
+		{"Corrupt", newSnapshotModel(), filepath.Join(dir, "corrupt"), datamodel.ErrSnapshotCorrupt},
+		{"Truncated", newSnapshotModel(), filepath.Join(dir, "truncated"), datamodel.ErrSnapshotCorrupt},
+		{"Mismatch", datamodel.New(), path, datamodel.ErrSnapshotMismatch},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.model.SetRegisters(datamodel.HoldingRegisters, 0, 42); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := tt.model.Load(tt.path); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v; want %v", err, tt.want)
+			}
+			if got, _ := tt.model.Registers(datamodel.HoldingRegisters, 0, 1); got[0] != 42 {
+				t.Fatalf("got %d after failed load; want 42", got[0])
+			}
+		})
+	}
+}
+
+func TestModelSaveEvery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "model.snapshot")
+	m := newSnapshotModel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- m.SaveEvery(ctx, path, time.Millisecond) }()
+
+	if err := m.SetRegisters(datamodel.HoldingRegisters, 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := m.SetRegisters(datamodel.HoldingRegisters, 0, 2); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	restored := newSnapshotModel()
+	if err := restored.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := restored.Registers(datamodel.HoldingRegisters, 0, 1); got[0] != 2 {
+		t.Fatalf("got %d; want 2", got[0])
+	}
+}
+
+func TestModelSaveEveryInvalidInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "model.snapshot")
+	m := newSnapshotModel()
+
+	for _, interval := range []time.Duration{0, -time.Second} {
+		if err := m.SaveEvery(context.Background(), path, interval); err == nil {
+			t.Fatalf("got nil for interval %v; want error", interval)
+		}
+	}
+	if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("got %v; want %v", err, fs.ErrNotExist)
+	}
+}
@@ -0,0 +1,256 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var functionNames = map[byte]string{
+	FuncCodeReadCoils:                      "Read Coils",
+	FuncCodeReadDiscreteInputs:             "Read Discrete Inputs",
+	FuncCodeReadHoldingRegisters:           "Read Holding Registers",
+	FuncCodeReadInputRegisters:             "Read Input Registers",
+	FuncCodeWriteSingleCoil:                "Write Single Coil",
+	FuncCodeWriteSingleRegister:            "Write Single Register",
+	FuncCodeReadExceptionStatus:            "Read Exception Status",
+	FuncCodeDiagnostic:                     "Diagnostics",
+	FuncCodeGetCommEventCounter:            "Get Comm Event Counter",
+	FuncCodeGetCommEventLog:                "Get Comm Event Log",
+	FuncCodeReportServerID:                 "Report Server ID",
+	FuncCodeReadFileRecord:                 "Read File Record",
+	FuncCodeWriteFileRecord:                "Write File Record",
+	FuncCodeWriteMultipleCoils:             "Write Multiple Coils",
+	FuncCodeWriteMultipleRegisters:         "Write Multiple Registers",
+	FuncCodeMaskWriteRegister:              "Mask Write Register",
+	FuncCodeReadWriteMultipleRegisters:     "Read/Write Multiple Registers",
+	FuncCodeReadFIFOQueue:                  "Read FIFO Queue",
+	FuncCodeEncapsulatedInterfaceTransport: "Encapsulated Interface Transport",
+}
+
+var exceptionNames = map[byte]string{
+	ExceptionCodeIllegalFunction:                    "Illegal Function",
+	ExceptionCodeIllegalDataAddress:                 "Illegal Data Address",
+	ExceptionCodeIllegalDataValue:                   "Illegal Data Value",
+	ExceptionCodeServerDeviceFailure:                "Server Device Failure",
+	ExceptionCodeAcknowledge:                        "Acknowledge",
+	ExceptionCodeServerDeviceBusy:                   "Server Device Busy",
+	ExceptionCodeMemoryParityError:                  "Memory Parity Error",
+	ExceptionCodeGatewayPathUnavailable:             "Gateway Path Unavailable",
+	ExceptionCodeGatewayTargetDeviceFailedToRespond: "Gateway Target Device Failed to Respond",
+}
+
+// FunctionName returns the name of the function with code, such as "Read
+// Holding Registers" for FuncCodeReadHoldingRegisters, or the name of the
+// Function registered for it. The exception bit of code is ignored.
+func FunctionName(code byte) string {
+	code &^= 0x80
+	if name, ok := functionNames[code]; ok {
+		return name
+	}
+	if f, ok := LookupFunction(code); ok && f.Name != "" {
+		return f.Name
+	}
+	return fmt.Sprintf("Function 0x%02x", code)
+}
+
+// ExceptionName returns the name of the exception code, such as "Illegal
+// Data Address" for ExceptionCodeIllegalDataAddress.
+func ExceptionName(code byte) string {
+	if name, ok := exceptionNames[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("Exception 0x%02x", code)
+}
+
+// Describe returns a human-readable, field-by-field description of p, such as
+//
+//	Read Holding Registers (0x03) request: address 0, count 3
+//
+// The PDUs of this package describe themselves as requests or responses by
+// their type. A *RawPDU is described by its function code and data, unless it
+// is an exception response; use DescribeRequest or DescribeResponse to decode
+// it first. PDUs of registered functions that implement fmt.Stringer are
+// described by their String method.
+//
+// The PDUs of this package also implement fmt.Formatter: the %v and %s verbs
+// print their description and the %x and %X verbs print their bytes in hex.
+func Describe(p PDU) string {
+	var (
+		kind   = "request"
+		fields string
+	)
+
+	switch p := p.(type) {
+	case *ReadBitRequest:
+		fields = fmt.Sprintf("address %d, count %d", p.startAddress, p.count)
+	case *ReadBitResponse:
+		kind, fields = "response", "values "+formatBits(p.BitValues())
+	case *WriteSingleBitRequest:
+		fields = fmt.Sprintf("address %d, value %s", p.startAddress, onOff(p.value))
+	case *WriteSingleBitResponse:
+		kind, fields = "response", fmt.Sprintf("address %d, value %s", p.startAddress, onOff(p.value))
+	case *WriteMultipleBitsRequest:
+		values := p.BitValues()
+		if int(p.count) < len(values) {
+			values = values[:p.count]
+		}
+		fields = fmt.Sprintf("address %d, count %d, values %s", p.startAddress, p.count, formatBits(values))
+	case *WriteMultipleBitsResponse:
+		kind, fields = "response", fmt.Sprintf("address %d, count %d", p.startAddress, p.count)
+	case *ReadRegisterRequest:
+		fields = fmt.Sprintf("address %d, count %d", p.startAddress, p.count)
+	case *ReadRegisterResponse:
+		kind, fields = "response", "values "+formatRegisters(p.values)
+	case *WriteSingleRegisterRequest:
+		fields = fmt.Sprintf("address %d, value %s", p.address, formatRegisters(p.value))
+	case *WriteSingleRegisterResponse:
+		kind, fields = "response", fmt.Sprintf("address %d, value %s", p.address, formatRegisters(p.value))
+	case *WriteMultipleRegistersRequest:
+		fields = fmt.Sprintf("address %d, count %d, values %s", p.address, p.count, formatRegisters(p.values))
+	case *WriteMultipleRegistersResponse:
+		kind, fields = "response", fmt.Sprintf("address %d, count %d", p.address, p.count)
+	case *ExceptionResponse:
+		kind, fields = "exception", fmt.Sprintf("%s (0x%02x)", ExceptionName(p.exceptionCode), p.exceptionCode)
+	case *RawPDU:
+		if p.FunctionCode()&0x80 != 0 && len(p.b) == 2 {
+			return Describe(&ExceptionResponse{p.b[0], p.b[1]})
+		}
+		return describeData(p.FunctionCode(), "", p.b[1:])
+	case fmt.Stringer:
+		return p.String()
+	default:
+		b, err := p.MarshalBinary()
+		if err != nil || len(b) == 0 {
+			return fmt.Sprintf("%s (0x%02x)", FunctionName(p.FunctionCode()), p.FunctionCode())
+		}
+		return describeData(p.FunctionCode(), "", b[1:])
+	}
+
+	return fmt.Sprintf("%s (0x%02x) %s: %s", FunctionName(p.FunctionCode()), p.FunctionCode(), kind, fields)
+}
+
+// DescribeRequest decodes p with DecodeRequest and describes it. PDUs that
+// cannot be decoded are described by their function code and data.
+func DescribeRequest(p PDU) string {
+	decoded, err := DecodeRequest(p)
+	if err != nil {
+		return describeInvalid(p, "request", err)
+	}
+	if raw, ok := decoded.(*RawPDU); ok {
+		return describeData(raw.FunctionCode(), "request", raw.b[1:])
+	}
+	return Describe(decoded)
+}
+
+// DescribeResponse decodes p with DecodeResponse and describes it. PDUs that
+// cannot be decoded are described by their function code and data.
+func DescribeResponse(p PDU) string {
+	decoded, err := DecodeResponse(p)
+	if err != nil {
+		return describeInvalid(p, "response", err)
+	}
+	if raw, ok := decoded.(*RawPDU); ok {
+		return describeData(raw.FunctionCode(), "response", raw.b[1:])
+	}
+	return Describe(decoded)
+}
+
+func describeInvalid(p PDU, kind string, err error) string {
+	b, _ := p.MarshalBinary()
+	if len(b) == 0 {
+		return fmt.Sprintf("invalid %s: %v", kind, err)
+	}
+	return fmt.Sprintf("%s (invalid: %v)", describeData(b[0], kind, b[1:]), err)
+}
+
+func describeData(code byte, kind string, data []byte) string {
+	s := fmt.Sprintf("%s (0x%02x)", FunctionName(code), code)
+	if kind != "" {
+		s += " " + kind
+	}
+	if len(data) == 0 {
+		return s + ": no data"
+	}
+	return s + ": data " + formatHex(data)
+}
+
+func formatBits(values []bool) string {
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, v := range values {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		if v {
+			b.WriteByte('1')
+		} else {
+			b.WriteByte('0')
+		}
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+// formatRegisters formats the big-endian registers in b. A single register is
+// formatted without brackets.
+func formatRegisters(b []byte) string {
+	registers := make([]string, len(b)/2)
+	for i := range registers {
+		registers[i] = strconv.Itoa(int(binary.BigEndian.Uint16(b[i*2:])))
+	}
+	if len(registers) == 1 {
+		return registers[0]
+	}
+	return "[" + strings.Join(registers, " ") + "]"
+}
+
+func formatHex(b []byte) string {
+	return fmt.Sprintf("% x", b)
+}
+
+func onOff(v bool) string {
+	if v {
+		return "on"
+	}
+	return "off"
+}
+
+// formatPDU implements fmt.Formatter for the PDUs of this package.
+func formatPDU(f fmt.State, verb rune, p PDU) {
+	switch verb {
+	case 'v', 's':
+		fmt.Fprint(f, Describe(p))
+	case 'q':
+		fmt.Fprint(f, strconv.Quote(Describe(p)))
+	case 'x', 'X':
+		b, err := p.MarshalBinary()
+		if err != nil {
+			fmt.Fprintf(f, "%%!%c(%v)", verb, err)
+			return
+		}
+		s := hex.EncodeToString(b)
+		if verb == 'X' {
+			s = strings.ToUpper(s)
+		}
+		fmt.Fprint(f, s)
+	default:
+		fmt.Fprintf(f, "%%!%c(%s)", verb, Describe(p))
+	}
+}
+
+func (r *ReadBitRequest) Format(f fmt.State, verb rune)                 { formatPDU(f, verb, r) }
+func (r *ReadBitResponse) Format(f fmt.State, verb rune)                { formatPDU(f, verb, r) }
+func (r *WriteSingleBitRequest) Format(f fmt.State, verb rune)          { formatPDU(f, verb, r) }
+func (r *WriteSingleBitResponse) Format(f fmt.State, verb rune)         { formatPDU(f, verb, r) }
+func (r *WriteMultipleBitsRequest) Format(f fmt.State, verb rune)       { formatPDU(f, verb, r) }
+func (r *WriteMultipleBitsResponse) Format(f fmt.State, verb rune)      { formatPDU(f, verb, r) }
+func (r *ReadRegisterRequest) Format(f fmt.State, verb rune)            { formatPDU(f, verb, r) }
+func (r *ReadRegisterResponse) Format(f fmt.State, verb rune)           { formatPDU(f, verb, r) }
+func (w *WriteSingleRegisterRequest) Format(f fmt.State, verb rune)     { formatPDU(f, verb, w) }
+func (w *WriteSingleRegisterResponse) Format(f fmt.State, verb rune)    { formatPDU(f, verb, w) }
+func (w *WriteMultipleRegistersRequest) Format(f fmt.State, verb rune)  { formatPDU(f, verb, w) }
+func (w *WriteMultipleRegistersResponse) Format(f fmt.State, verb rune) { formatPDU(f, verb, w) }
+func (p *RawPDU) Format(f fmt.State, verb rune)                         { formatPDU(f, verb, p) }
@@ -0,0 +1,154 @@
+package modbus_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/shasderias/modbus"
+)
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func TestDescribe(t *testing.T) {
+	testCases := []struct {
+		name string
+		pdu  modbus.PDU
+		want string
+	}{
+		{"ReadCoilsRequest",
+			must(modbus.NewReadBitRequest(modbus.FuncCodeReadCoils, 19, 10)),
+			"Read Coils (0x01) request: address 19, count 10"},
+		{"ReadDiscreteInputsResponse",
+			must(modbus.NewReadBitResponseFromBool(modbus.FuncCodeReadDiscreteInputs, []bool{true, false, true})),
+			"Read Discrete Inputs (0x02) response: values [1 0 1 0 0 0 0 0]"},
+		{"WriteSingleCoilRequest",
+			must(modbus.NewWriteSingleBitRequest(modbus.FuncCodeWriteSingleCoil, 172, true)),
+			"Write Single Coil (0x05) request: address 172, value on"},
+		{"WriteSingleCoilResponse",
+			must(modbus.NewWriteSingleBitResponse(modbus.FuncCodeWriteSingleCoil, 172, false)),
+			"Write Single Coil (0x05) response: address 172, value off"},
+		{"WriteMultipleCoilsRequest",
+			must(modbus.NewWriteMultipleBitsRequestFromBools(modbus.FuncCodeWriteMultipleCoils, 19, []bool{true, true, false})),
+			"Write Multiple Coils (0x0f) request: address 19, count 3, values [1 1 0]"},
+		{"WriteMultipleCoilsResponse",
+			must(modbus.NewWriteMultipleBitsResponse(modbus.FuncCodeWriteMultipleCoils, 19, 3)),
+			"Write Multiple Coils (0x0f) response: address 19, count 3"},
+		{"ReadHoldingRegistersRequest",
+			must(modbus.NewReadRegisterRequest(modbus.FuncCodeReadHoldingRegisters, 107, 3)),
+			"Read Holding Registers (0x03) request: address 107, count 3"},
+		{"ReadInputRegistersResponse",
+			must(modbus.NewReadRegisterResponseFromUint16s(modbus.FuncCodeReadInputRegisters, []uint16{555, 0, 100})),
+			"Read Input Registers (0x04) response: values [555 0 100]"},
+		{"WriteSingleRegisterRequest",
+			must(modbus.NewWriteSingleRegisterRequestFromUint16(modbus.FuncCodeWriteSingleRegister, 1, 3)),
+			"Write Single Register (0x06) request: address 1, value 3"},
+		{"WriteSingleRegisterResponse",
+			must(modbus.NewWriteSingleRegisterResponseFromUint16(modbus.FuncCodeWriteSingleRegister, 1, 3)),
+			"Write Single Register (0x06) response: address 1, value 3"},
+		{"WriteMultipleRegistersRequest",
+			must(modbus.NewWriteMultipleRegistersRequestFromUint16s(modbus.FuncCodeWriteMultipleRegisters, 1, []uint16{10, 258})),
+			"Write Multiple Registers (0x10) request: address 1, count 2, values [10 258]"},
+		{"WriteMultipleRegistersResponse",
+			must(modbus.NewWriteMultipleRegistersResponse(modbus.FuncCodeWriteMultipleRegisters, 1, 2)),
+			"Write Multiple Registers (0x10) response: address 1, count 2"},
+		{"Exception",
+			must(modbus.NewExceptionResponse(0x83, modbus.ExceptionCodeIllegalDataAddress)),
+			"Read Holding Registers (0x83) exception: Illegal Data Address (0x02)"},
+		{"UnknownException",
+			must(modbus.NewExceptionResponse(0xc1, 0x42)),
+			"Function 0x41 (0xc1) exception: Exception 0x42 (0x42)"},
+		{"Raw",
+			must(modbus.NewRawPDU([]byte{0x08, 0x00, 0x00, 0xa5, 0x37})),
+			"Diagnostics (0x08): data 00 00 a5 37"},
+		{"RawException",
+			must(modbus.NewRawPDU([]byte{0x90, 0x04})),
+			"Write Multiple Registers (0x90) exception: Server Device Failure (0x04)"},
+		{"RawNoData",
+			must(modbus.NewRawPDU([]byte{0x07})),
+			"Read Exception Status (0x07): no data"},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := modbus.Describe(tt.pdu); got != tt.want {
+				t.Fatalf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDescribeRequestResponse(t *testing.T) {
+	testCases := []struct {
+		name     string
+		pdu      []byte
+		request  string
+		response string
+	}{
+		{"ReadHoldingRegisters",
+			[]byte{0x03, 0x02, 0x00, 0x2a},
+			"Read Holding Registers (0x03) request: data 02 00 2a (invalid: too few bytes to unmarshal as ReadRegisterRequest: [3 2 0 42])",
+			"Read Holding Registers (0x03) response: values 42"},
+		{"WriteSingleCoil",
+			[]byte{0x05, 0x00, 0x01, 0xff, 0x00},
+			"Write Single Coil (0x05) request: address 1, value on",
+			"Write Single Coil (0x05) response: address 1, value on"},
+		{"Unsupported",
+			[]byte{0x11},
+			"Report Server ID (0x11) request: no data",
+			"Report Server ID (0x11) response: no data"},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			pdu := must(modbus.NewRawPDU(tt.pdu))
+			if got := modbus.DescribeRequest(pdu); got != tt.request {
+				t.Errorf("got request %q; want %q", got, tt.request)
+			}
+			if got := modbus.DescribeResponse(pdu); got != tt.response {
+				t.Errorf("got response %q; want %q", got, tt.response)
+			}
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	req := must(modbus.NewReadRegisterRequest(modbus.FuncCodeReadHoldingRegisters, 107, 3))
+
+	testCases := []struct {
+		format string
+		want   string
+	}{
+		{"%v", "Read Holding Registers (0x03) request: address 107, count 3"},
+		{"%s", "Read Holding Registers (0x03) request: address 107, count 3"},
+		{"%q", `"Read Holding Registers (0x03) request: address 107, count 3"`},
+		{"%x", "03006b0003"},
+		{"%X", "03006B0003"},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.format, func(t *testing.T) {
+			if got := fmt.Sprintf(tt.format, req); got != tt.want {
+				t.Fatalf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorDescriptions(t *testing.T) {
+	exception := modbus.NewExceptionResponseTo(
+		must(modbus.NewReadRegisterRequest(modbus.FuncCodeReadInputRegisters, 0, 1)),
+		modbus.ExceptionCodeGatewayPathUnavailable)
+	if got, want := exception.Error(), "modbus: exception 0x84:0xa (Read Input Registers: Gateway Path Unavailable)"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+
+	crcErr := modbus.ErrBadCRC{Got: 0x1234, Want: 0xc5cd, Frame: []byte{0x01, 0x03, 0x00, 0x00, 0x34, 0x12}}
+	if got, want := crcErr.Error(), "bad CRC; got: 0x1234, want: 0xc5cd, frame: 01 03 00 00 34 12 (slave 1, Read Holding Registers)"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,85 @@
+package modbus
+
+import (
+	"errors"
+	"os"
+	"strings"
+)
+
+// The errors below are returned, wrapped with the component that returned
+// them, by the clients, servers and transports of this module, such as
+//
+//	modbus/tcp: timeout waiting for response
+//	client: unexpected response: function code, sent: 0x3, recv: 0x4
+//
+// Test for them with errors.Is.
+var (
+	// ErrClosed is returned by clients and transports that have been
+	// closed.
+	ErrClosed = errors.New("closed")
+
+	// ErrTimeout is returned when a response does not arrive in time. It
+	// implements Timeout() bool, like net.Error, and IsTimeout reports true
+	// for it.
+	ErrTimeout error = timeoutError{}
+
+	// ErrUnexpectedResponse is returned when a response does not match its
+	// request, such as a response from another unit or with another
+	// function code, address or count.
+	ErrUnexpectedResponse = errors.New("unexpected response")
+
+	// ErrFrameTooLong is returned when a frame exceeds the maximum frame
+	// length of its transport.
+	ErrFrameTooLong = errors.New("frame too long")
+
+	// ErrFrameTooShort is returned when a frame is shorter than the minimum
+	// frame length of its transport, or ends early.
+	ErrFrameTooShort = errors.New("frame too short")
+
+	// ErrInvalidFrame is returned when a frame is malformed in other ways,
+	// such as a Modbus/TCP frame with a protocol ID other than 0.
+	ErrInvalidFrame = errors.New("invalid frame")
+
+	// ErrBroadcastRead is returned by clients asked to read from slave
+	// address 0, the broadcast address, to which slaves do not respond.
+	ErrBroadcastRead = errors.New("cannot read from slave address 0 (broadcast)")
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string { return "timeout waiting for response" }
+func (timeoutError) Timeout() bool { return true }
+
+// IsTimeout reports whether err is, or wraps, ErrTimeout or another error
+// that is a timeout, such as os.ErrDeadlineExceeded or a net.Error whose
+// Timeout method reports true.
+func IsTimeout(err error) bool {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	var timeout interface{ Timeout() bool }
+	return errors.As(err, &timeout) && timeout.Timeout()
+}
+
+// Errors that an *ExceptionResponse with the corresponding exception code
+// matches with errors.Is, whatever its function code:
+//
+//	if errors.Is(err, modbus.ErrIllegalDataAddress) {
+var (
+	ErrIllegalFunction                    error = exceptionError(ExceptionCodeIllegalFunction)
+	ErrIllegalDataAddress                 error = exceptionError(ExceptionCodeIllegalDataAddress)
+	ErrIllegalDataValue                   error = exceptionError(ExceptionCodeIllegalDataValue)
+	ErrServerDeviceFailure                error = exceptionError(ExceptionCodeServerDeviceFailure)
+	ErrAcknowledge                        error = exceptionError(ExceptionCodeAcknowledge)
+	ErrServerDeviceBusy                   error = exceptionError(ExceptionCodeServerDeviceBusy)
+	ErrMemoryParityError                  error = exceptionError(ExceptionCodeMemoryParityError)
+	ErrGatewayPathUnavailable             error = exceptionError(ExceptionCodeGatewayPathUnavailable)
+	ErrGatewayTargetDeviceFailedToRespond error = exceptionError(ExceptionCodeGatewayTargetDeviceFailedToRespond)
+)
+
+// exceptionError is an exception code as an error.
+type exceptionError byte
+
+func (e exceptionError) Error() string {
+	return strings.ToLower(ExceptionName(byte(e)))
+}
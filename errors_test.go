@@ -0,0 +1,84 @@
+package modbus_test
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/shasderias/modbus"
+)
+
+func TestExceptionIs(t *testing.T) {
+	exception := must(modbus.NewExceptionResponse(0x83, modbus.ExceptionCodeIllegalDataAddress))
+	err := fmt.Errorf("wrapped: %w", exception)
+
+	if !errors.Is(err, modbus.ErrIllegalDataAddress) {
+		t.Errorf("got errors.Is(%v, ErrIllegalDataAddress) false; want true", err)
+	}
+	if errors.Is(err, modbus.ErrIllegalDataValue) {
+		t.Errorf("got errors.Is(%v, ErrIllegalDataValue) true; want false", err)
+	}
+	if got, want := modbus.ErrGatewayTargetDeviceFailedToRespond.Error(), "gateway target device failed to respond"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestIsTimeout(t *testing.T) {
+	testCases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"ErrTimeout", fmt.Errorf("modbus/tcp: %w", modbus.ErrTimeout), true},
+		{"DeadlineExceeded", fmt.Errorf("rtu/client: %w", os.ErrDeadlineExceeded), true},
+		{"NetError", &net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}, true},
+		{"Closed", fmt.Errorf("client: %w", modbus.ErrClosed), false},
+		{"Nil", nil, false},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := modbus.IsTimeout(tt.err); got != tt.want {
+				t.Fatalf("got %t; want %t", got, tt.want)
+			}
+		})
+	}
+
+	var timeout interface{ Timeout() bool }
+	if !errors.As(modbus.ErrTimeout, &timeout) || !timeout.Timeout() {
+		t.Fatal("got ErrTimeout.Timeout() false; want true")
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	transport := &fakeTransport{}
+	client, err := modbus.NewClient(1, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transport.resp = must(modbus.NewReadRegisterResponseFromUint16s(modbus.FuncCodeReadInputRegisters, []uint16{1}))
+	if _, err := client.ReadHoldingRegisters(0, 1); !errors.Is(err, modbus.ErrUnexpectedResponse) {
+		t.Errorf("got %v; want ErrUnexpectedResponse", err)
+	}
+
+	transport.resp = must(modbus.NewExceptionResponse(0x83, modbus.ExceptionCodeServerDeviceBusy))
+	if _, err := client.ReadHoldingRegisters(0, 1); !errors.Is(err, modbus.ErrServerDeviceBusy) {
+		t.Errorf("got %v; want ErrServerDeviceBusy", err)
+	}
+
+	client.Close()
+	if _, err := client.ReadHoldingRegisters(0, 1); !errors.Is(err, modbus.ErrClosed) {
+		t.Errorf("got %v; want ErrClosed", err)
+	}
+}
+
+// fakeTransport responds to every request with resp.
+type fakeTransport struct {
+	resp modbus.PDU
+}
+
+func (t *fakeTransport) WriteRequest(byte, modbus.PDU) (modbus.PDU, error) { return t.resp, nil }
+func (t *fakeTransport) Close() error                                      { return nil }
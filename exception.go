@@ -30,8 +30,22 @@ func NewExceptionResponse(errorCode, exceptionCode byte) (*ExceptionResponse, er
 	return &ExceptionResponse{errorCode, exceptionCode}, nil
 }
 
+// NewExceptionResponseTo returns an exception response to req carrying
+// exceptionCode.
+func NewExceptionResponseTo(req PDU, exceptionCode byte) *ExceptionResponse {
+	return &ExceptionResponse{req.FunctionCode() | 0x80, exceptionCode}
+}
+
 func (r *ExceptionResponse) Error() string {
-	return fmt.Sprintf("modbus: exception 0x%x:0x%x", r.errorCode, r.exceptionCode)
+	return fmt.Sprintf("modbus: exception 0x%x:0x%x (%s: %s)",
+		r.errorCode, r.exceptionCode, FunctionName(r.errorCode), ExceptionName(r.exceptionCode))
+}
+
+// Is reports whether target is the error of r's exception code, such as
+// ErrIllegalDataAddress.
+func (r *ExceptionResponse) Is(target error) bool {
+	code, ok := target.(exceptionError)
+	return ok && byte(code) == r.exceptionCode
 }
 
 func (r *ExceptionResponse) FunctionCode() byte  { return r.errorCode }
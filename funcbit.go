@@ -250,6 +250,7 @@ func NewWriteMultipleBitsRequestFromBools(functionCode byte, address int, values
 
 func (r *WriteMultipleBitsRequest) FunctionCode() byte   { return r.functionCode }
 func (r *WriteMultipleBitsRequest) StartAddress() uint16 { return r.startAddress }
+func (r *WriteMultipleBitsRequest) BitCount() uint16     { return r.count }
 func (r *WriteMultipleBitsRequest) Values() []byte       { return r.values }
 func (r *WriteMultipleBitsRequest) BitValues() []bool {
 	return bytesToBools(r.values)
@@ -22,10 +22,13 @@ func NewReadRegisterRequest(
 	if functionCode < 1 || functionCode >= 0x80 {
 		return nil, fmt.Errorf("function code out of range [1, 0x80): %v", functionCode)
 	}
-	if startAddress < 0 || startAddress > 0xff {
-		return nil, fmt.Errorf("start address out of range [0, 0xff]: %v", startAddress)
+	if startAddress < 0 || startAddress > 0xffff {
+		return nil, fmt.Errorf("start address out of range [0, 0xffff]: %v", startAddress)
 	}
-	if startAddress+count > 0xff {
+	if count < 1 || count > 0x7d {
+		return nil, fmt.Errorf("register count out of range [1, 0x7d]: %v", count)
+	}
+	if startAddress+count > 0x10000 {
 		return nil, fmt.Errorf("requested addresses out of range: start address: %v, register count: %v", startAddress, count)
 	}
 
@@ -284,6 +287,10 @@ func NewWriteMultipleRegistersRequestFromUint16s(functionCode, address int, valu
 
 func (w *WriteMultipleRegistersRequest) FunctionCode() byte { return w.functionCode }
 
+func (w *WriteMultipleRegistersRequest) Address() uint16       { return w.address }
+func (w *WriteMultipleRegistersRequest) RegisterCount() uint16 { return w.count }
+func (w *WriteMultipleRegistersRequest) Values() []byte        { return w.values }
+
 func (w *WriteMultipleRegistersRequest) MarshalBinary() ([]byte, error) {
 	buf := databuilder.New(6 + len(w.values))
 	buf.WriteBytes(w.functionCode)
@@ -352,6 +359,9 @@ func NewWriteMultipleRegistersResponse(functionCode, address, count int) (*Write
 
 func (w *WriteMultipleRegistersResponse) FunctionCode() byte { return w.functionCode }
 
+func (w *WriteMultipleRegistersResponse) Address() uint16       { return w.address }
+func (w *WriteMultipleRegistersResponse) RegisterCount() uint16 { return w.count }
+
 func (w *WriteMultipleRegistersResponse) MarshalBinary() ([]byte, error) {
 	buf := databuilder.New(5)
 	buf.WriteBytes(w.functionCode)
@@ -0,0 +1,188 @@
+package modbus
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// UnknownLength is returned by a Function's length functions when the length
+// of a PDU cannot be determined from its contents.
+const UnknownLength = -1
+
+// Function describes a function code that is not implemented by this package,
+// such as a user-defined function code (65-72 and 100-110) or a vendor
+// specific one. Register a Function so that serial line transports can frame
+// its PDUs, and so that handlers can decode its requests with DecodeRequest.
+// Issue its requests with Client.Do.
+//
+// Servers dispatch its requests to its Handler when they serve a handler
+// wrapped with ServeFunctions:
+//
+//	srv, err := tcp.NewServer(":502", modbus.ServeFunctions(model))
+type Function struct {
+	Code byte
+	Name string
+
+	// NewRequest and NewResponse return empty request and response PDUs for
+	// DecodeRequest and DecodeResponse to unmarshal into. If nil, PDUs are
+	// left as they are, typically *RawPDU.
+	NewRequest  func() PDU
+	NewResponse func() PDU
+
+	// RequestLength and ResponseLength return the length of the request or
+	// response PDU that begins with pdu. They allow serial line transports to
+	// find the end of a frame without waiting for the line to go silent.
+	//
+	// If the returned length exceeds len(pdu), the transport reads until
+	// len(pdu) reaches the returned length and calls the function again. The
+	// length has been determined when the returned length equals len(pdu).
+	// pdu is at least 1 byte (the function code) long. Return UnknownLength
+	// if the length cannot be determined from pdu.
+	//
+	// req is the request PDU the response answers. If a length function is
+	// nil, the transport waits for the line to go silent.
+	RequestLength  func(pdu []byte) int
+	ResponseLength func(req, pdu []byte) int
+
+	// Handler, if not nil, serves requests for Code under ServeFunctions.
+	// Requests are passed to it decoded with DecodeRequest.
+	Handler Handler
+}
+
+var (
+	functionsMut sync.RWMutex
+	functions    = map[byte]Function{}
+)
+
+// RegisterFunction registers f. Public function codes and function codes that
+// have already been registered cannot be registered.
+func RegisterFunction(f Function) error {
+	if f.Code < 1 || f.Code >= 0x80 {
+		return fmt.Errorf("modbus: function code out of range [1, 0x80): %v", f.Code)
+	}
+	if _, ok := functionNames[f.Code]; ok {
+		return fmt.Errorf("modbus: cannot register public function code: 0x%x", f.Code)
+	}
+
+	functionsMut.Lock()
+	defer functionsMut.Unlock()
+
+	if _, ok := functions[f.Code]; ok {
+		return fmt.Errorf("modbus: function code already registered: 0x%x", f.Code)
+	}
+
+	functions[f.Code] = f
+
+	return nil
+}
+
+// UnregisterFunction removes the Function registered for code, if any.
+func UnregisterFunction(code byte) {
+	functionsMut.Lock()
+	defer functionsMut.Unlock()
+
+	delete(functions, code)
+}
+
+// LookupFunction returns the Function registered for code.
+func LookupFunction(code byte) (Function, bool) {
+	functionsMut.RLock()
+	defer functionsMut.RUnlock()
+
+	f, ok := functions[code]
+	return f, ok
+}
+
+// ServeFunctions returns a Handler that passes requests whose function code
+// has a registered Function with a non-nil Handler to that Handler, decoded,
+// and all other requests to next. Requests that DecodeRequest fails to decode
+// are answered with ExceptionCodeIllegalDataValue. Functions are looked up
+// for every request, so that Functions registered later are served too.
+func ServeFunctions(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, unitID byte, req PDU) (PDU, error) {
+		f, ok := LookupFunction(req.FunctionCode())
+		if !ok || f.Handler == nil {
+			return next.ServeModbus(ctx, unitID, req)
+		}
+
+		decoded, err := DecodeRequest(req)
+		if err != nil {
+			return nil, fmt.Errorf("modbus: error decoding %s request: %v: %w", f.Name, err,
+				NewExceptionResponseTo(req, ExceptionCodeIllegalDataValue))
+		}
+		return f.Handler.ServeModbus(ctx, unitID, decoded)
+	})
+}
+
+var builtinRequests = map[byte]func() PDU{
+	FuncCodeReadCoils:              func() PDU { return &ReadBitRequest{} },
+	FuncCodeReadDiscreteInputs:     func() PDU { return &ReadBitRequest{} },
+	FuncCodeReadHoldingRegisters:   func() PDU { return &ReadRegisterRequest{} },
+	FuncCodeReadInputRegisters:     func() PDU { return &ReadRegisterRequest{} },
+	FuncCodeWriteSingleCoil:        func() PDU { return &WriteSingleBitRequest{} },
+	FuncCodeWriteSingleRegister:    func() PDU { return &WriteSingleRegisterRequest{} },
+	FuncCodeWriteMultipleCoils:     func() PDU { return &WriteMultipleBitsRequest{} },
+	FuncCodeWriteMultipleRegisters: func() PDU { return &WriteMultipleRegistersRequest{} },
+}
+
+var builtinResponses = map[byte]func() PDU{
+	FuncCodeReadCoils:              func() PDU { return &ReadBitResponse{} },
+	FuncCodeReadDiscreteInputs:     func() PDU { return &ReadBitResponse{} },
+	FuncCodeReadHoldingRegisters:   func() PDU { return &ReadRegisterResponse{} },
+	FuncCodeReadInputRegisters:     func() PDU { return &ReadRegisterResponse{} },
+	FuncCodeWriteSingleCoil:        func() PDU { return &WriteSingleBitResponse{} },
+	FuncCodeWriteSingleRegister:    func() PDU { return &WriteSingleRegisterResponse{} },
+	FuncCodeWriteMultipleCoils:     func() PDU { return &WriteMultipleBitsResponse{} },
+	FuncCodeWriteMultipleRegisters: func() PDU { return &WriteMultipleRegistersResponse{} },
+}
+
+// DecodeRequest unmarshals p into the request PDU type for its function code,
+// such as *ReadRegisterRequest for FuncCodeReadHoldingRegisters, or the
+// request PDU of the Function registered for it. p is returned unchanged if
+// there is no such type.
+func DecodeRequest(p PDU) (PDU, error) {
+	newRequest := builtinRequests[p.FunctionCode()]
+	if f, ok := LookupFunction(p.FunctionCode()); ok {
+		newRequest = f.NewRequest
+	}
+	if newRequest == nil {
+		return p, nil
+	}
+
+	req := newRequest()
+	if err := UnmarshalAs(p, req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// DecodeResponse unmarshals p into the response PDU type for its function
+// code, or the response PDU of the Function registered for it. Exception
+// responses are decoded as *ExceptionResponse. p is returned unchanged if
+// there is no such type.
+func DecodeResponse(p PDU) (PDU, error) {
+	if p.FunctionCode()&0x80 != 0 {
+		var resp ExceptionResponse
+		if err := UnmarshalAs(p, &resp); err != nil {
+			return nil, err
+		}
+		return &resp, nil
+	}
+
+	newResponse := builtinResponses[p.FunctionCode()]
+	if f, ok := LookupFunction(p.FunctionCode()); ok {
+		newResponse = f.NewResponse
+	}
+	if newResponse == nil {
+		return p, nil
+	}
+
+	resp := newResponse()
+	if err := UnmarshalAs(p, resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,185 @@
+package modbus_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/shasderias/modbus"
+)
+
+const funcCodeReadDriveStatus = 0x41
+
+// driveStatusRequest and driveStatusResponse implement a fictional
+// user-defined function that reads the status word of a drive axis.
+type driveStatusRequest struct {
+	axis byte
+}
+
+func (r *driveStatusRequest) FunctionCode() byte { return funcCodeReadDriveStatus }
+func (r *driveStatusRequest) MarshalBinary() ([]byte, error) {
+	return []byte{funcCodeReadDriveStatus, r.axis}, nil
+}
+func (r *driveStatusRequest) UnmarshalBinary(data []byte) error {
+	if len(data) != 2 {
+		return fmt.Errorf("want 2 bytes: %v", data)
+	}
+	r.axis = data[1]
+	return nil
+}
+
+type driveStatusResponse struct {
+	axis   byte
+	status byte
+}
+
+func (r *driveStatusResponse) FunctionCode() byte { return funcCodeReadDriveStatus }
+func (r *driveStatusResponse) MarshalBinary() ([]byte, error) {
+	return []byte{funcCodeReadDriveStatus, r.axis, r.status}, nil
+}
+func (r *driveStatusResponse) UnmarshalBinary(data []byte) error {
+	if len(data) != 3 {
+		return fmt.Errorf("want 3 bytes: %v", data)
+	}
+	r.axis, r.status = data[1], data[2]
+	return nil
+}
+
+// handlerTransport is a ClientTransport that passes requests to a handler as
+// a server would, as *modbus.RawPDU.
+type handlerTransport struct {
+	h modbus.Handler
+}
+
+func (t handlerTransport) WriteRequest(slaveAddress byte, r modbus.PDU) (modbus.PDU, error) {
+	reqBytes, err := r.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	req, err := modbus.NewRawPDU(reqBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.h.ServeModbus(context.Background(), slaveAddress, req)
+	if err != nil {
+		return nil, err
+	}
+
+	respBytes, err := resp.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return modbus.NewRawPDU(respBytes)
+}
+
+func (t handlerTransport) Close() error { return nil }
+
+func TestUserDefinedFunction(t *testing.T) {
+	err := modbus.RegisterFunction(modbus.Function{
+		Code:        funcCodeReadDriveStatus,
+		Name:        "Read Drive Status",
+		NewRequest:  func() modbus.PDU { return &driveStatusRequest{} },
+		NewResponse: func() modbus.PDU { return &driveStatusResponse{} },
+		RequestLength: func(pdu []byte) int {
+			return 2
+		},
+		ResponseLength: func(req, pdu []byte) int {
+			return 3
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { modbus.UnregisterFunction(funcCodeReadDriveStatus) })
+
+	if err := modbus.RegisterFunction(modbus.Function{Code: funcCodeReadDriveStatus}); err == nil {
+		t.Fatal("got nil error; want error registering function code twice")
+	}
+	if err := modbus.RegisterFunction(modbus.Function{Code: modbus.FuncCodeReadCoils}); err == nil {
+		t.Fatal("got nil error; want error registering public function code")
+	}
+
+	handler := modbus.HandlerFunc(func(_ context.Context, unitID byte, rawReq modbus.PDU) (modbus.PDU, error) {
+		pdu, err := modbus.DecodeRequest(rawReq)
+		if err != nil {
+			return nil, err
+		}
+
+		req, ok := pdu.(*driveStatusRequest)
+		if !ok {
+			return nil, fmt.Errorf("got %T; want *driveStatusRequest", pdu)
+		}
+		if req.axis > 1 {
+			return modbus.NewExceptionResponseTo(req, modbus.ExceptionCodeIllegalDataAddress), nil
+		}
+		return &driveStatusResponse{req.axis, 0x80 | req.axis}, nil
+	})
+
+	client, err := modbus.NewClient(1, handlerTransport{handler})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resp driveStatusResponse
+	if err := client.Do(&driveStatusRequest{axis: 1}, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.axis != 1 || resp.status != 0x81 {
+		t.Fatalf("got %+v; want axis 1, status 0x81", resp)
+	}
+
+	err = client.Do(&driveStatusRequest{axis: 2}, &resp)
+	var exception *modbus.ExceptionResponse
+	if !errors.As(err, &exception) {
+		t.Fatalf("got %v; want exception response", err)
+	}
+	if exception.ExceptionCode() != modbus.ExceptionCodeIllegalDataAddress {
+		t.Fatalf("got exception code %d; want %d", exception.ExceptionCode(), modbus.ExceptionCodeIllegalDataAddress)
+	}
+}
+
+func TestServeFunctions(t *testing.T) {
+	err := modbus.RegisterFunction(modbus.Function{
+		Code:        funcCodeReadDriveStatus,
+		Name:        "Read Drive Status",
+		NewRequest:  func() modbus.PDU { return &driveStatusRequest{} },
+		NewResponse: func() modbus.PDU { return &driveStatusResponse{} },
+		Handler: modbus.HandlerFunc(func(_ context.Context, unitID byte, pdu modbus.PDU) (modbus.PDU, error) {
+			req := pdu.(*driveStatusRequest)
+			return &driveStatusResponse{req.axis, unitID}, nil
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { modbus.UnregisterFunction(funcCodeReadDriveStatus) })
+
+	mux := modbus.NewServeMux()
+	mux.Handle(7, named("unit 7"))
+	client, err := modbus.NewClient(7, handlerTransport{modbus.ServeFunctions(mux)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resp driveStatusResponse
+	if err := client.Do(&driveStatusRequest{axis: 1}, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.axis != 1 || resp.status != 7 {
+		t.Fatalf("got %+v; want axis 1, status 7", resp)
+	}
+
+	// malformed requests are answered with an exception
+	malformed := must(modbus.NewRawPDU([]byte{funcCodeReadDriveStatus, 1, 2}))
+	if _, err := modbus.ServeFunctions(mux).ServeModbus(context.Background(), 7, malformed); !errors.Is(err, modbus.ErrIllegalDataValue) {
+		t.Fatalf("got %v; want ErrIllegalDataValue", err)
+	}
+
+	// other requests are passed on
+	if _, err := client.ReadHoldingRegisters(0, 1); err == nil || !strings.HasSuffix(err.Error(), "unit 7") {
+		t.Fatalf("got %v; want unit 7", err)
+	}
+}
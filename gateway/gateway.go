@@ -0,0 +1,194 @@
+// Package gateway implements a Modbus/TCP to Modbus/RTU gateway.
+//
+// A Gateway is a modbus.Handler. Serve it with a tcp.Server to accept
+// connections from any number of Modbus/TCP clients:
+//
+//	gw := gateway.New()
+//	bus, err := gw.AddBus(rtu.NewClient(port), 1, 2, 3)
+//	...
+//	srv, err := tcp.NewServer(":502", gw)
+//	...
+//	err = srv.Start()
+//
+// Requests are forwarded to the bus their unit ID is routed to. Each bus
+// carries one transaction at a time; requests for different buses proceed in
+// parallel.
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/shasderias/modbus"
+	"github.com/shasderias/modbus/internal/logging"
+)
+
+// Bus is a serial line behind the gateway.
+type Bus struct {
+	t modbus.ClientTransport
+
+	// sem holds a value while a request is on the bus
+	sem chan struct{}
+}
+
+type route struct {
+	bus          *Bus
+	slaveAddress byte
+}
+
+type Config struct {
+	// Logger receives the errors of requests answered with
+	// ExceptionCodeGatewayTargetDeviceFailedToRespond, which are not
+	// otherwise reported. Defaults to discarding.
+	Logger *slog.Logger
+}
+
+type Gateway struct {
+	logger *slog.Logger
+
+	mut    sync.RWMutex
+	buses  []*Bus
+	routes map[byte]route
+}
+
+func New(fns ...func(c *Config)) *Gateway {
+	conf := Config{}
+	for _, fn := range fns {
+		fn(&conf)
+	}
+
+	return &Gateway{
+		logger: logging.OrDiscard(conf.Logger),
+		routes: make(map[byte]route),
+	}
+}
+
+// AddBus adds a bus that requests are forwarded over, typically an
+// *rtu.Client, and routes each of unitIDs to the slave with the same address
+// on it. If any of unitIDs cannot be routed, neither the bus nor any route is
+// added.
+func (g *Gateway) AddBus(t modbus.ClientTransport, unitIDs ...byte) (*Bus, error) {
+	bus := &Bus{t: t, sem: make(chan struct{}, 1)}
+
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	seen := make(map[byte]bool, len(unitIDs))
+	for _, unitID := range unitIDs {
+		if err := g.checkRoute(unitID, unitID); err != nil {
+			return nil, err
+		}
+		if seen[unitID] {
+			return nil, fmt.Errorf("gateway: unit ID %d already routed", unitID)
+		}
+		seen[unitID] = true
+	}
+
+	g.buses = append(g.buses, bus)
+	for _, unitID := range unitIDs {
+		g.routes[unitID] = route{bus, unitID}
+	}
+
+	return bus, nil
+}
+
+// Route forwards requests for unitID to slaveAddress on bus.
+func (g *Gateway) Route(unitID byte, bus *Bus, slaveAddress byte) error {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	if err := g.checkRoute(unitID, slaveAddress); err != nil {
+		return err
+	}
+
+	g.routes[unitID] = route{bus, slaveAddress}
+
+	return nil
+}
+
+// checkRoute returns an error if unitID cannot be routed to slaveAddress.
+// g.mut must be held.
+func (g *Gateway) checkRoute(unitID, slaveAddress byte) error {
+	if slaveAddress > 247 {
+		return fmt.Errorf("gateway: slave address must be in the range [0:247]: %d", slaveAddress)
+	}
+	if _, ok := g.routes[unitID]; ok {
+		return fmt.Errorf("gateway: unit ID %d already routed", unitID)
+	}
+	return nil
+}
+
+// Unroute removes the route for unitID, if any.
+func (g *Gateway) Unroute(unitID byte) {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	delete(g.routes, unitID)
+}
+
+// ServeModbus forwards req to the slave unitID is routed to.
+//
+// Requests for unit IDs without a route are answered with
+// ExceptionCodeGatewayPathUnavailable. If the bus fails to return a valid
+// response, either because the slave timed out or because its reply was
+// corrupt, or because the deadline of ctx passed while the request waited
+// for the bus, the request is answered with
+// ExceptionCodeGatewayTargetDeviceFailedToRespond. Requests canceled while
+// they wait return the error of ctx.
+func (g *Gateway) ServeModbus(ctx context.Context, unitID byte, req modbus.PDU) (modbus.PDU, error) {
+	g.mut.RLock()
+	r, ok := g.routes[unitID]
+	g.mut.RUnlock()
+
+	if !ok {
+		g.logger.LogAttrs(ctx, slog.LevelDebug, "gateway: no route to unit",
+			logging.UnitID(unitID), logging.FunctionCode(req.FunctionCode()))
+		return modbus.NewExceptionResponseTo(req, modbus.ExceptionCodeGatewayPathUnavailable), nil
+	}
+
+	resp, err := r.bus.writeRequest(ctx, r.slaveAddress, req)
+	if errors.Is(err, context.Canceled) {
+		return nil, err
+	} else if err != nil {
+		g.logger.LogAttrs(ctx, slog.LevelWarn, "gateway: target device failed to respond",
+			logging.UnitID(unitID), slog.Int("slave", int(r.slaveAddress)),
+			logging.FunctionCode(req.FunctionCode()), logging.Err(err))
+		return modbus.NewExceptionResponseTo(req, modbus.ExceptionCodeGatewayTargetDeviceFailedToRespond), nil
+	}
+
+	return resp, nil
+}
+
+// Close closes every bus added to the gateway.
+func (g *Gateway) Close() error {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	var firstErr error
+	for _, bus := range g.buses {
+		if err := bus.t.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+func (b *Bus) writeRequest(ctx context.Context, slaveAddress byte, req modbus.PDU) (modbus.PDU, error) {
+	select {
+	case b.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-b.sem }()
+
+	// ctx may be done as well, if the bus was freed at the same time
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return b.t.WriteRequest(slaveAddress, req)
+}
@@ -0,0 +1,279 @@
+package gateway_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/shasderias/modbus"
+	"github.com/shasderias/modbus/gateway"
+	"github.com/shasderias/modbus/transport/tcp"
+)
+
+// fakeBus answers read holding register requests with the slave address it
+// was addressed at, and times out for slaves listed in silent.
+type fakeBus struct {
+	delay  time.Duration
+	silent map[byte]bool
+
+	active, maxActive int32
+}
+
+func (b *fakeBus) WriteRequest(slaveAddress byte, r modbus.PDU) (modbus.PDU, error) {
+	active := atomic.AddInt32(&b.active, 1)
+	defer atomic.AddInt32(&b.active, -1)
+
+	for {
+		max := atomic.LoadInt32(&b.maxActive)
+		if active <= max || atomic.CompareAndSwapInt32(&b.maxActive, max, active) {
+			break
+		}
+	}
+
+	time.Sleep(b.delay)
+
+	if b.silent[slaveAddress] {
+		return nil, fmt.Errorf("fake: error reading response: %w", os.ErrDeadlineExceeded)
+	}
+
+	return modbus.NewReadRegisterResponseFromUint16s(modbus.FuncCodeReadHoldingRegisters, []uint16{uint16(slaveAddress)})
+}
+
+func (b *fakeBus) Close() error { return nil }
+
+func startGateway(t *testing.T, gw *gateway.Gateway) string {
+	t.Helper()
+
+	srv, err := tcp.NewServer("127.0.0.1:0", gw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Stop() })
+
+	return srv.Addr().String()
+}
+
+func dialClient(t *testing.T, address string, unitID int) *modbus.Client {
+	t.Helper()
+
+	conn, err := (&net.Dialer{Timeout: time.Second}).Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transport, err := tcp.NewClient(conn, func(c *tcp.ClientConfig) {
+		c.RequestTimeout = 2 * time.Second
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := modbus.NewClient(unitID, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+func TestGateway(t *testing.T) {
+	var (
+		gw   = gateway.New()
+		bus1 = &fakeBus{silent: map[byte]bool{3: true}}
+		bus2 = &fakeBus{}
+	)
+
+	if _, err := gw.AddBus(bus1, 1, 3); err != nil {
+		t.Fatal(err)
+	}
+	b2, err := gw.AddBus(bus2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Route(20, b2, 7); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Route(1, b2, 1); err == nil {
+		t.Fatal("expected error routing unit ID that is already routed")
+	}
+
+	address := startGateway(t, gw)
+
+	testCases := []struct {
+		name          string
+		unitID        int
+		want          []uint16
+		wantException byte
+	}{
+		{"Routed", 1, []uint16{1}, 0},
+		{"Translated", 20, []uint16{7}, 0},
+		{"Timeout", 3, nil, modbus.ExceptionCodeGatewayTargetDeviceFailedToRespond},
+		{"Unrouted", 4, nil, modbus.ExceptionCodeGatewayPathUnavailable},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			client := dialClient(t, address, tt.unitID)
+
+			resp, err := client.ReadHoldingRegisters(0, 1)
+			if tt.wantException != 0 {
+				var exception *modbus.ExceptionResponse
+				if !errors.As(err, &exception) {
+					t.Fatalf("got %v; want exception response", err)
+				}
+				if exception.ExceptionCode() != tt.wantException {
+					t.Fatalf("got exception code 0x%x; want 0x%x", exception.ExceptionCode(), tt.wantException)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(resp.Uint16(), tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestGatewaySerializesBus(t *testing.T) {
+	var (
+		gw  = gateway.New()
+		bus = &fakeBus{delay: 2 * time.Millisecond}
+	)
+
+	if _, err := gw.AddBus(bus, 1, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	address := startGateway(t, gw)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		unitID := i%2 + 1
+		client := dialClient(t, address, unitID)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				resp, err := client.ReadHoldingRegisters(0, 1)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if got := resp.Uint16()[0]; got != uint16(unitID) {
+					t.Errorf("got %d; want %d", got, unitID)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if bus.maxActive != 1 {
+		t.Fatalf("got %d concurrent requests on bus; want 1", bus.maxActive)
+	}
+}
+
+func TestGatewayAddBusConflict(t *testing.T) {
+	gw := gateway.New()
+	if _, err := gw.AddBus(&fakeBus{}, 1, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name    string
+		unitIDs []byte
+	}{
+		{"Routed", []byte{3, 2}},
+		{"Duplicate", []byte{3, 3}},
+		{"InvalidAddress", []byte{3, 248}},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := gw.AddBus(&fakeBus{}, tt.unitIDs...); err == nil {
+				t.Fatal("got nil; want error")
+			}
+
+			// unit 3 must not have been routed by the failed call
+			req, err := modbus.NewReadRegisterRequest(modbus.FuncCodeReadHoldingRegisters, 0, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := gw.ServeModbus(context.Background(), 3, req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exception, _ := resp.(error); !errors.Is(exception, modbus.ErrGatewayPathUnavailable) {
+				t.Fatalf("got %v; want ErrGatewayPathUnavailable", resp)
+			}
+		})
+	}
+}
+
+// TestGatewayQueuedRequestDone checks that requests waiting for a busy bus
+// give up when their ctx is done, without waiting for the bus.
+func TestGatewayQueuedRequestDone(t *testing.T) {
+	var (
+		gw  = gateway.New()
+		bus = &fakeBus{delay: 200 * time.Millisecond}
+	)
+	if _, err := gw.AddBus(bus, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := modbus.NewReadRegisterRequest(modbus.FuncCodeReadHoldingRegisters, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// occupy the bus
+	go gw.ServeModbus(context.Background(), 1, req)
+	for atomic.LoadInt32(&bus.active) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Run("DeadlineExceeded", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		resp, err := gw.ServeModbus(ctx, 1, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exception, _ := resp.(error); !errors.Is(exception, modbus.ErrGatewayTargetDeviceFailedToRespond) {
+			t.Fatalf("got %v; want ErrGatewayTargetDeviceFailedToRespond", resp)
+		}
+		if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+			t.Fatalf("got response after %v; want it at the deadline", elapsed)
+		}
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+
+		start := time.Now()
+		if _, err := gw.ServeModbus(ctx, 1, req); !errors.Is(err, context.Canceled) {
+			t.Fatalf("got %v; want %v", err, context.Canceled)
+		}
+		if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+			t.Fatalf("got error after %v; want it when canceled", elapsed)
+		}
+	})
+}
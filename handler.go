@@ -0,0 +1,41 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+)
+
+// Handler responds to a Modbus request addressed to unitID.
+//
+// ServeModbus returns the PDU to send back to the client. Returning a nil PDU
+// and a nil error sends nothing, as is required for broadcast requests. A
+// non-nil error is turned into an exception response by Respond.
+type Handler interface {
+	ServeModbus(ctx context.Context, unitID byte, req PDU) (PDU, error)
+}
+
+// HandlerFunc adapts an ordinary function to the Handler interface.
+type HandlerFunc func(ctx context.Context, unitID byte, req PDU) (PDU, error)
+
+func (f HandlerFunc) ServeModbus(ctx context.Context, unitID byte, req PDU) (PDU, error) {
+	return f(ctx, unitID, req)
+}
+
+// Respond invokes h and returns the PDU a server should send in reply to req.
+//
+// If h returns an *ExceptionResponse as its error, that exception is sent. Any
+// other error is reported as ExceptionCodeServerDeviceFailure. The error
+// returned by h is passed through so that servers can log it.
+func Respond(ctx context.Context, h Handler, unitID byte, req PDU) (PDU, error) {
+	resp, err := h.ServeModbus(ctx, unitID, req)
+	if err == nil {
+		return resp, nil
+	}
+
+	var exception *ExceptionResponse
+	if errors.As(err, &exception) {
+		return exception, err
+	}
+
+	return NewExceptionResponseTo(req, ExceptionCodeServerDeviceFailure), err
+}
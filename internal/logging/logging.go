@@ -0,0 +1,46 @@
+// Package logging holds the log/slog helpers shared by the clients, servers
+// and transports of this module, so that they log with the same attribute
+// keys.
+package logging
+
+import (
+	"context"
+	"encoding/hex"
+	"log/slog"
+	"time"
+)
+
+// Discard is the logger of clients and servers that are not given one. It
+// discards every record.
+var Discard = slog.New(discardHandler{})
+
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// OrDiscard returns l, or Discard if l is nil.
+func OrDiscard(l *slog.Logger) *slog.Logger {
+	if l == nil {
+		return Discard
+	}
+	return l
+}
+
+// Hex is a byte slice that is logged in hex. It is only formatted if the
+// record is logged.
+type Hex []byte
+
+func (h Hex) LogValue() slog.Value {
+	return slog.StringValue(hex.EncodeToString(h))
+}
+
+func UnitID(id byte) slog.Attr          { return slog.Int("unit", int(id)) }
+func FunctionCode(code byte) slog.Attr  { return slog.Int("function", int(code)) }
+func TxID(id uint16) slog.Attr          { return slog.Int("tx_id", int(id)) }
+func Latency(d time.Duration) slog.Attr { return slog.Duration("latency", d) }
+func Frame(b []byte) slog.Attr          { return slog.Any("frame", Hex(b)) }
+func Remote(addr string) slog.Attr      { return slog.String("remote", addr) }
+func Err(err error) slog.Attr           { return slog.Any("err", err) }
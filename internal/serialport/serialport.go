@@ -0,0 +1,54 @@
+// Package serialport opens the serial ports of the commands, so that they
+// depend on rtu.Port only, and the serial line library is imported in one
+// place.
+package serialport
+
+import (
+	"fmt"
+
+	"github.com/shasderias/serial"
+
+	"github.com/shasderias/modbus/transport/rtu"
+)
+
+// Config holds the line settings of a port, as given on the command line.
+type Config struct {
+	BaudRate int
+	DataBits int
+	// Parity is one of none, even or odd.
+	Parity string
+	// StopBits is 1 or 2.
+	StopBits int
+}
+
+// Open opens the serial port name with the settings of conf.
+func Open(name string, conf Config) (rtu.Port, error) {
+	var parity serial.Parity
+	switch conf.Parity {
+	case "none":
+		parity = serial.ParityNone
+	case "even":
+		parity = serial.ParityEven
+	case "odd":
+		parity = serial.ParityOdd
+	default:
+		return nil, fmt.Errorf("unknown parity %q, want one of none, even, odd", conf.Parity)
+	}
+
+	var stopBits serial.StopBits
+	switch conf.StopBits {
+	case 1:
+		stopBits = serial.StopBits1
+	case 2:
+		stopBits = serial.StopBits2
+	default:
+		return nil, fmt.Errorf("unsupported number of stop bits: %d", conf.StopBits)
+	}
+
+	return serial.Open(name, func(c *serial.Config) {
+		c.BaudRate = conf.BaudRate
+		c.DataBits = conf.DataBits
+		c.Parity = parity
+		c.StopBits = stopBits
+	})
+}
@@ -0,0 +1,248 @@
+// Package tableserve serves the function codes that read and write the four
+// tables of the Modbus data model, decoding and validating their requests
+// once for package datamodel and package binding, which hold the tables
+// differently.
+package tableserve
+
+import (
+	"encoding/binary"
+
+	"github.com/shasderias/modbus"
+)
+
+// Table identifies one of the four tables, in the order of datamodel.Table.
+type Table int
+
+const (
+	Coils Table = iota
+	DiscreteInputs
+	HoldingRegisters
+	InputRegisters
+)
+
+// Tables holds the tables served by Serve. Its methods return 0, or the
+// exception code to answer the request with.
+type Tables interface {
+	// ReadBits returns the count bits of t, Coils or DiscreteInputs,
+	// starting at address.
+	ReadBits(t Table, address, count int) ([]bool, byte)
+
+	// ReadRegisters returns the count registers of t, HoldingRegisters or
+	// InputRegisters, starting at address.
+	ReadRegisters(t Table, address, count int) ([]uint16, byte)
+
+	// WriteCoils sets the coils starting at address to values.
+	WriteCoils(w CoilWrite) byte
+
+	// WriteHoldingRegisters sets the holding registers of w, and reads
+	// those w asks for, as one operation.
+	WriteHoldingRegisters(w RegisterWrite) ([]uint16, byte)
+}
+
+// CoilWrite is a write of a client to coils.
+type CoilWrite struct {
+	UnitID, FunctionCode byte
+
+	Address int
+	Values  []bool
+}
+
+// RegisterWrite is a write of a client to holding registers.
+type RegisterWrite struct {
+	UnitID, FunctionCode byte
+
+	// Address and Count are the holding registers written, with the values
+	// Update returns when passed their current values.
+	Address, Count int
+	Update         func(old []uint16) []uint16
+
+	// ReadAddress and ReadCount are the holding registers read after the
+	// write, for FC17; ReadCount is 0 otherwise. The read is checked
+	// before writing, so that a read that fails leaves the registers
+	// unchanged.
+	ReadAddress, ReadCount int
+}
+
+// Serve answers req, for the read and write coil, discrete input, holding
+// register and input register function codes, and the mask write register
+// and read/write multiple registers function codes, from tables. Other
+// function codes are answered with ExceptionCodeIllegalFunction, and
+// requests that are malformed with ExceptionCodeIllegalDataValue.
+func Serve(tables Tables, unitID byte, req modbus.PDU) (modbus.PDU, error) {
+	switch req.FunctionCode() {
+	case modbus.FuncCodeMaskWriteRegister:
+		return maskWriteRegister(tables, unitID, req)
+	case modbus.FuncCodeReadWriteMultipleRegisters:
+		return readWriteMultipleRegisters(tables, unitID, req)
+	}
+
+	decoded, err := modbus.DecodeRequest(req)
+	if err != nil {
+		return nil, modbus.NewExceptionResponseTo(req, modbus.ExceptionCodeIllegalDataValue)
+	}
+
+	switch r := decoded.(type) {
+	case *modbus.ReadBitRequest:
+		t := Coils
+		if r.FunctionCode() == modbus.FuncCodeReadDiscreteInputs {
+			t = DiscreteInputs
+		}
+
+		bits, code := tables.ReadBits(t, int(r.StartAddress()), int(r.BitCount()))
+		if code != 0 {
+			return nil, modbus.NewExceptionResponseTo(req, code)
+		}
+		return modbus.NewReadBitResponseFromBool(r.FunctionCode(), bits)
+
+	case *modbus.ReadRegisterRequest:
+		t := HoldingRegisters
+		if r.FunctionCode() == modbus.FuncCodeReadInputRegisters {
+			t = InputRegisters
+		}
+
+		registers, code := tables.ReadRegisters(t, int(r.StartAddress()), int(r.RegisterCount()))
+		if code != 0 {
+			return nil, modbus.NewExceptionResponseTo(req, code)
+		}
+		return modbus.NewReadRegisterResponseFromUint16s(int(r.FunctionCode()), registers)
+
+	case *modbus.WriteSingleBitRequest:
+		if err := writeCoils(tables, unitID, req, int(r.StartAddress()), r.BitValue()); err != nil {
+			return nil, err
+		}
+		return &modbus.WriteSingleBitResponse{WriteSingleBitRequest: *r}, nil
+
+	case *modbus.WriteSingleRegisterRequest:
+		if err := writeHoldingRegisters(tables, unitID, req, int(r.Address()), binary.BigEndian.Uint16(r.Value())); err != nil {
+			return nil, err
+		}
+		return &modbus.WriteSingleRegisterResponse{WriteSingleRegisterRequest: *r}, nil
+
+	case *modbus.WriteMultipleBitsRequest:
+		bits := r.BitValues()[:r.BitCount()]
+		if err := writeCoils(tables, unitID, req, int(r.StartAddress()), bits...); err != nil {
+			return nil, err
+		}
+		return modbus.NewWriteMultipleBitsResponse(r.FunctionCode(), int(r.StartAddress()), int(r.BitCount()))
+
+	case *modbus.WriteMultipleRegistersRequest:
+		values := make([]uint16, r.RegisterCount())
+		for i := range values {
+			values[i] = binary.BigEndian.Uint16(r.Values()[2*i:])
+		}
+		if err := writeHoldingRegisters(tables, unitID, req, int(r.Address()), values...); err != nil {
+			return nil, err
+		}
+		return modbus.NewWriteMultipleRegistersResponse(int(r.FunctionCode()), int(r.Address()), int(r.RegisterCount()))
+
+	default:
+		return nil, modbus.NewExceptionResponseTo(req, modbus.ExceptionCodeIllegalFunction)
+	}
+}
+
+// writeCoils writes values to the coils starting at address for req, and
+// returns the exception to answer req with if the write fails.
+func writeCoils(tables Tables, unitID byte, req modbus.PDU, address int, values ...bool) error {
+	code := tables.WriteCoils(CoilWrite{
+		UnitID:       unitID,
+		FunctionCode: req.FunctionCode(),
+		Address:      address,
+		Values:       values,
+	})
+	if code != 0 {
+		return modbus.NewExceptionResponseTo(req, code)
+	}
+	return nil
+}
+
+// writeHoldingRegisters writes values to the holding registers starting at
+// address for req, as writeCoils does.
+func writeHoldingRegisters(tables Tables, unitID byte, req modbus.PDU, address int, values ...uint16) error {
+	_, code := tables.WriteHoldingRegisters(RegisterWrite{
+		UnitID:       unitID,
+		FunctionCode: req.FunctionCode(),
+		Address:      address,
+		Count:        len(values),
+		Update:       func([]uint16) []uint16 { return values },
+	})
+	if code != 0 {
+		return modbus.NewExceptionResponseTo(req, code)
+	}
+	return nil
+}
+
+// maskWriteRegister serves FC16, which sets a holding register to
+// (current AND andMask) OR (orMask AND NOT andMask).
+func maskWriteRegister(tables Tables, unitID byte, req modbus.PDU) (modbus.PDU, error) {
+	b, err := req.MarshalBinary()
+	if err != nil || len(b) != 7 {
+		return nil, modbus.NewExceptionResponseTo(req, modbus.ExceptionCodeIllegalDataValue)
+	}
+
+	var (
+		address = int(binary.BigEndian.Uint16(b[1:]))
+		andMask = binary.BigEndian.Uint16(b[3:])
+		orMask  = binary.BigEndian.Uint16(b[5:])
+	)
+	_, code := tables.WriteHoldingRegisters(RegisterWrite{
+		UnitID:       unitID,
+		FunctionCode: req.FunctionCode(),
+		Address:      address,
+		Count:        1,
+		Update: func(old []uint16) []uint16 {
+			return []uint16{old[0]&andMask | orMask&^andMask}
+		},
+	})
+	if code != 0 {
+		return nil, modbus.NewExceptionResponseTo(req, code)
+	}
+
+	// the response echoes the request
+	return modbus.NewRawPDU(b)
+}
+
+// readWriteMultipleRegisters serves FC17, which writes holding registers and
+// then reads holding registers, as one operation.
+func readWriteMultipleRegisters(tables Tables, unitID byte, req modbus.PDU) (modbus.PDU, error) {
+	b, err := req.MarshalBinary()
+	if err != nil || len(b) < 10 {
+		return nil, modbus.NewExceptionResponseTo(req, modbus.ExceptionCodeIllegalDataValue)
+	}
+
+	var (
+		readAddress  = int(binary.BigEndian.Uint16(b[1:]))
+		readCount    = int(binary.BigEndian.Uint16(b[3:]))
+		writeAddress = int(binary.BigEndian.Uint16(b[5:]))
+		writeCount   = int(binary.BigEndian.Uint16(b[7:]))
+		byteCount    = int(b[9])
+	)
+	// MODBUS Application Protocol Specification V1.1b3, 6.17
+	if readCount < 1 || readCount > 0x7d || writeCount < 1 || writeCount > 0x79 ||
+		byteCount != 2*writeCount || len(b) != 10+byteCount {
+		return nil, modbus.NewExceptionResponseTo(req, modbus.ExceptionCodeIllegalDataValue)
+	}
+
+	values := make([]uint16, writeCount)
+	for i := range values {
+		values[i] = binary.BigEndian.Uint16(b[10+2*i:])
+	}
+	registers, code := tables.WriteHoldingRegisters(RegisterWrite{
+		UnitID:       unitID,
+		FunctionCode: req.FunctionCode(),
+		Address:      writeAddress,
+		Count:        writeCount,
+		Update:       func([]uint16) []uint16 { return values },
+		ReadAddress:  readAddress,
+		ReadCount:    readCount,
+	})
+	if code != 0 {
+		return nil, modbus.NewExceptionResponseTo(req, code)
+	}
+
+	resp := make([]byte, 2, 2+2*len(registers))
+	resp[0], resp[1] = req.FunctionCode(), byte(2*len(registers))
+	for _, r := range registers {
+		resp = binary.BigEndian.AppendUint16(resp, r)
+	}
+	return modbus.NewRawPDU(resp)
+}
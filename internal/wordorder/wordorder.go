@@ -0,0 +1,72 @@
+// Package wordorder converts multi-register values between big-endian byte
+// order and the byte and word orders used by Modbus devices.
+//
+// Orders are named after how the bytes A, B, C and D of the big-endian
+// representation of a 32-bit value 0xAABBCCDD are laid out over two
+// registers. The same naming is extended to 64-bit values.
+package wordorder
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Order int
+
+const (
+	// ABCD is big-endian, the order described by the Modbus specification.
+	ABCD Order = iota
+	// CDAB swaps the order of the registers, also known as word swapped.
+	CDAB
+	// BADC swaps the bytes within each register.
+	BADC
+	// DCBA is little-endian.
+	DCBA
+)
+
+func Parse(s string) (Order, error) {
+	switch strings.ToLower(s) {
+	case "abcd", "":
+		return ABCD, nil
+	case "cdab":
+		return CDAB, nil
+	case "badc":
+		return BADC, nil
+	case "dcba":
+		return DCBA, nil
+	default:
+		return 0, fmt.Errorf("wordorder: unknown order %q, want one of abcd, cdab, badc, dcba", s)
+	}
+}
+
+func (o Order) String() string {
+	switch o {
+	case ABCD:
+		return "abcd"
+	case CDAB:
+		return "cdab"
+	case BADC:
+		return "badc"
+	case DCBA:
+		return "dcba"
+	default:
+		return fmt.Sprintf("Order(%d)", int(o))
+	}
+}
+
+// Apply converts b, a value of an even number of bytes, between big-endian
+// and o in place. As every conversion is its own inverse, Apply is used both
+// to encode and to decode.
+func (o Order) Apply(b []byte) {
+	if o == CDAB || o == DCBA {
+		// reverse the order of the registers
+		for i, j := 0, len(b)-2; i < j; i, j = i+2, j-2 {
+			b[i], b[i+1], b[j], b[j+1] = b[j], b[j+1], b[i], b[i+1]
+		}
+	}
+	if o == BADC || o == DCBA {
+		for i := 0; i+1 < len(b); i += 2 {
+			b[i], b[i+1] = b[i+1], b[i]
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package wordorder_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/shasderias/modbus/internal/wordorder"
+)
+
+func TestApply(t *testing.T) {
+	testCases := []struct {
+		order    wordorder.Order
+		in, want []byte
+	}{
+		{wordorder.ABCD, []byte{0xa, 0xb, 0xc, 0xd}, []byte{0xa, 0xb, 0xc, 0xd}},
+		{wordorder.CDAB, []byte{0xa, 0xb, 0xc, 0xd}, []byte{0xc, 0xd, 0xa, 0xb}},
+		{wordorder.BADC, []byte{0xa, 0xb, 0xc, 0xd}, []byte{0xb, 0xa, 0xd, 0xc}},
+		{wordorder.DCBA, []byte{0xa, 0xb, 0xc, 0xd}, []byte{0xd, 0xc, 0xb, 0xa}},
+		{wordorder.CDAB, []byte{1, 2, 3, 4, 5, 6, 7, 8}, []byte{7, 8, 5, 6, 3, 4, 1, 2}},
+		{wordorder.DCBA, []byte{1, 2, 3, 4, 5, 6, 7, 8}, []byte{8, 7, 6, 5, 4, 3, 2, 1}},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.order.String(), func(t *testing.T) {
+			b := append([]byte(nil), tt.in...)
+
+			tt.order.Apply(b)
+			if diff := cmp.Diff(b, tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+
+			tt.order.Apply(b)
+			if diff := cmp.Diff(b, tt.in); diff != "" {
+				t.Fatalf("not an involution: %s", diff)
+			}
+		})
+	}
+}
@@ -7,12 +7,15 @@ import (
 	"github.com/google/go-cmp/cmp"
 
 	"github.com/shasderias/modbus"
+	"github.com/shasderias/modbus/simulator"
 	"github.com/shasderias/modbus/transport/rtu"
 	"github.com/shasderias/modbus/transport/tcp"
 )
 
 func TestRTUClient(t *testing.T) {
-	port := StartDiagSlaveRTU(t)
+	t.Parallel()
+
+	_, port := StartSimulatorRTU(t, simulator.DefaultMap())
 
 	client, err := modbus.NewClient(1, rtu.NewClient(port))
 	if err != nil {
@@ -23,8 +26,49 @@ func TestRTUClient(t *testing.T) {
 	testClient(t, client)
 }
 
+func TestRTUClientSlowLine(t *testing.T) {
+	t.Parallel()
+
+	_, port := StartSimulatorRTU(t, simulator.DefaultMap(), func(c *PortConfig) {
+		c.BaudRate = 9600
+		// pause in the middle of every frame, as a buffering adapter might
+		c.Gap = func(i int) time.Duration {
+			if i%8 == 3 {
+				return 5 * time.Millisecond
+			}
+			return 0
+		}
+	})
+
+	client, err := modbus.NewClient(1, rtu.NewClient(port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	testClient(t, client)
+}
+
+func TestRTUClientNoisyLine(t *testing.T) {
+	t.Parallel()
+
+	_, port := StartSimulatorRTU(t, simulator.DefaultMap(), func(c *PortConfig) {
+		c.NoiseRate = 1
+	})
+
+	client, err := modbus.NewClient(1, rtu.NewClient(port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, err := client.ReadHoldingRegisters(0, 1); err == nil {
+		t.Fatal("got nil; want error reading over a corrupting line")
+	}
+}
+
 func TestTCPClient(t *testing.T) {
-	conn, cleanup := StartDiagSlaveTCP(t)
+	_, conn, stop := StartSimulatorTCP(t, simulator.DefaultMap())
 
 	transport, err := tcp.NewClient(conn)
 	if err != nil {
@@ -54,7 +98,7 @@ func TestTCPClient(t *testing.T) {
 	}()
 
 	time.Sleep(3 * time.Second)
-	cleanup()
+	stop()
 
 	select {
 	case <-time.After(1 * time.Second):
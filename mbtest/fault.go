@@ -0,0 +1,307 @@
+package mbtest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/shasderias/modbus"
+	"github.com/shasderias/modbus/internal/crc"
+	"github.com/shasderias/modbus/transport/rtu"
+)
+
+// Fault is a fault injected into a response.
+type Fault int
+
+const (
+	FaultNone Fault = iota
+
+	// FaultDrop discards the response.
+	FaultDrop
+
+	// FaultDelay sends the response after FaultConfig.Delay.
+	FaultDelay
+
+	// FaultCorrupt flips a bit of the last byte of the response, which breaks
+	// the CRC of an RTU frame.
+	FaultCorrupt
+
+	// FaultTruncate sends the first half of the response.
+	FaultTruncate
+
+	// FaultWrongTransactionID increments the transaction ID of a Modbus/TCP
+	// response. RTU frames do not have transaction IDs and are left alone.
+	FaultWrongTransactionID
+
+	// FaultWrongUnitID increments the unit ID, or slave address, of the
+	// response.
+	FaultWrongUnitID
+
+	// FaultDuplicate sends the response twice.
+	FaultDuplicate
+
+	// FaultException replaces the response with an exception response
+	// carrying FaultConfig.ExceptionCode.
+	FaultException
+)
+
+func (f Fault) String() string {
+	switch f {
+	case FaultNone:
+		return "none"
+	case FaultDrop:
+		return "drop"
+	case FaultDelay:
+		return "delay"
+	case FaultCorrupt:
+		return "corrupt"
+	case FaultTruncate:
+		return "truncate"
+	case FaultWrongTransactionID:
+		return "wrong transaction ID"
+	case FaultWrongUnitID:
+		return "wrong unit ID"
+	case FaultDuplicate:
+		return "duplicate"
+	case FaultException:
+		return "exception"
+	default:
+		return fmt.Sprintf("Fault(%d)", int(f))
+	}
+}
+
+type FaultConfig struct {
+	// Fault returns the fault to inject into the nth response, counting from
+	// 0. See FaultSequence and RandomFaults. Defaults to FaultNone for every
+	// response.
+	Fault func(n int) Fault
+
+	// Delay is how long FaultDelay delays a response. Defaults to 1s.
+	Delay time.Duration
+
+	// ExceptionCode is the exception code FaultException responds with.
+	// Defaults to modbus.ExceptionCodeServerDeviceBusy.
+	ExceptionCode byte
+}
+
+// FaultSequence injects faults[n] into the nth response, and no fault into
+// responses past the end of faults.
+func FaultSequence(faults ...Fault) func(n int) Fault {
+	return func(n int) Fault {
+		if n < len(faults) {
+			return faults[n]
+		}
+		return FaultNone
+	}
+}
+
+// RandomFaults injects one of faults, chosen at random, into a response with
+// probability rate. The same seed injects the same faults.
+func RandomFaults(seed int64, rate float64, faults ...Fault) func(n int) Fault {
+	var (
+		mut sync.Mutex
+		rnd = rand.New(rand.NewSource(seed))
+	)
+	return func(int) Fault {
+		mut.Lock()
+		defer mut.Unlock()
+
+		if len(faults) == 0 || rnd.Float64() >= rate {
+			return FaultNone
+		}
+		return faults[rnd.Intn(len(faults))]
+	}
+}
+
+// faultInjector numbers responses and decides which fault to inject into
+// each.
+type faultInjector struct {
+	conf FaultConfig
+
+	mut sync.Mutex
+	n   int
+}
+
+func newFaultInjector(fns []func(c *FaultConfig)) *faultInjector {
+	conf := FaultConfig{
+		Fault:         FaultSequence(),
+		Delay:         1 * time.Second,
+		ExceptionCode: modbus.ExceptionCodeServerDeviceBusy,
+	}
+	for _, fn := range fns {
+		fn(&conf)
+	}
+	return &faultInjector{conf: conf}
+}
+
+func (fi *faultInjector) next() Fault {
+	fi.mut.Lock()
+	n := fi.n
+	fi.n++
+	fi.mut.Unlock()
+
+	return fi.conf.Fault(n)
+}
+
+// FaultyTransport injects faults into the responses returned by a
+// modbus.ClientTransport. Faults that concern framing, such as
+// FaultWrongTransactionID, FaultWrongUnitID and FaultDuplicate, cannot be
+// represented at this level and are ignored; use FaultyConn or FaultyPort to
+// inject them.
+type FaultyTransport struct {
+	t  modbus.ClientTransport
+	fi *faultInjector
+}
+
+func NewFaultyTransport(t modbus.ClientTransport, fns ...func(c *FaultConfig)) *FaultyTransport {
+	return &FaultyTransport{t: t, fi: newFaultInjector(fns)}
+}
+
+func (t *FaultyTransport) WriteRequest(slaveAddress byte, req modbus.PDU) (modbus.PDU, error) {
+	resp, err := t.t.WriteRequest(slaveAddress, req)
+	if err != nil || resp == nil {
+		return resp, err
+	}
+
+	switch fault := t.fi.next(); fault {
+	case FaultDrop:
+		time.Sleep(t.fi.conf.Delay)
+		return nil, fmt.Errorf("mbtest: response dropped: %w", modbus.ErrTimeout)
+	case FaultDelay:
+		time.Sleep(t.fi.conf.Delay)
+		return resp, nil
+	case FaultCorrupt, FaultTruncate:
+		b, err := resp.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		b = append([]byte(nil), b...)
+		if fault == FaultCorrupt {
+			b[len(b)-1] ^= 0x01
+		} else {
+			b = b[:(len(b)+1)/2]
+		}
+		return modbus.NewRawPDU(b)
+	case FaultException:
+		return modbus.NewExceptionResponseTo(req, t.fi.conf.ExceptionCode), nil
+	default:
+		return resp, nil
+	}
+}
+
+func (t *FaultyTransport) Close() error {
+	return t.t.Close()
+}
+
+// framing describes how frames are laid out on a byte stream.
+type framing interface {
+	// unitIDOffset is the offset of the unit ID, or slave address
+	unitIDOffset() int
+	// wrongTransactionID modifies the transaction ID of frame
+	wrongTransactionID(frame []byte)
+	// fix recomputes the length fields and checksums of frame
+	fix(frame []byte) []byte
+	// exception returns frame replaced by an exception response
+	exception(frame []byte, exceptionCode byte) []byte
+}
+
+type tcpFraming struct{}
+
+func (tcpFraming) unitIDOffset() int { return 6 }
+func (tcpFraming) wrongTransactionID(frame []byte) {
+	binary.BigEndian.PutUint16(frame, binary.BigEndian.Uint16(frame)+1)
+}
+func (tcpFraming) fix(frame []byte) []byte {
+	binary.BigEndian.PutUint16(frame[4:], uint16(len(frame)-6))
+	return frame
+}
+func (f tcpFraming) exception(frame []byte, exceptionCode byte) []byte {
+	return f.fix(append(frame[:7:7], frame[7]|0x80, exceptionCode))
+}
+
+type rtuFraming struct{}
+
+func (rtuFraming) unitIDOffset() int           { return 0 }
+func (rtuFraming) wrongTransactionID(_ []byte) {}
+func (rtuFraming) fix(frame []byte) []byte {
+	binary.LittleEndian.PutUint16(frame[len(frame)-2:], crc.Checksum(frame[:len(frame)-2]))
+	return frame
+}
+func (f rtuFraming) exception(frame []byte, exceptionCode byte) []byte {
+	return f.fix([]byte{frame[0], frame[1] | 0x80, exceptionCode, 0, 0})
+}
+
+// writeFrame writes frame to w after injecting the next fault into it.
+func (fi *faultInjector) writeFrame(f framing, write func([]byte) (int, error), frame []byte) (int, error) {
+	n := len(frame)
+	frame = append([]byte(nil), frame...)
+
+	switch fi.next() {
+	case FaultDrop:
+		return n, nil
+	case FaultDelay:
+		time.Sleep(fi.conf.Delay)
+	case FaultCorrupt:
+		frame[len(frame)-1] ^= 0x01
+	case FaultTruncate:
+		frame = frame[:len(frame)/2]
+	case FaultWrongTransactionID:
+		f.wrongTransactionID(frame)
+	case FaultWrongUnitID:
+		frame[f.unitIDOffset()]++
+		frame = f.fix(frame)
+	case FaultDuplicate:
+		if _, err := write(frame); err != nil {
+			return 0, err
+		}
+	case FaultException:
+		frame = f.exception(frame, fi.conf.ExceptionCode)
+	}
+
+	if _, err := write(frame); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// FaultyConn injects faults into the Modbus/TCP frames written to a
+// connection. Wrap the server's end of the connection, so that faults are
+// injected into responses, and serve it with tcp.Server.ServeConn. Every
+// write must be a complete frame, as the writes of tcp.Server are.
+type FaultyConn struct {
+	net.Conn
+	fi *faultInjector
+}
+
+func NewFaultyConn(conn net.Conn, fns ...func(c *FaultConfig)) *FaultyConn {
+	return &FaultyConn{Conn: conn, fi: newFaultInjector(fns)}
+}
+
+func (c *FaultyConn) Write(b []byte) (int, error) {
+	if len(b) < 8 {
+		return c.Conn.Write(b)
+	}
+	return c.fi.writeFrame(tcpFraming{}, c.Conn.Write, b)
+}
+
+// FaultyPort injects faults into the RTU frames written to a serial port.
+// Wrap the port of an rtu.Server, so that faults are injected into responses.
+// Every write must be a complete frame, as the writes of rtu.Server are.
+type FaultyPort struct {
+	rtu.Port
+	fi *faultInjector
+}
+
+func NewFaultyPort(port rtu.Port, fns ...func(c *FaultConfig)) *FaultyPort {
+	return &FaultyPort{Port: port, fi: newFaultInjector(fns)}
+}
+
+func (p *FaultyPort) Write(b []byte) (int, error) {
+	if len(b) < 4 {
+		return p.Port.Write(b)
+	}
+	return p.fi.writeFrame(rtuFraming{}, p.Port.Write, b)
+}
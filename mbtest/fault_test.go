@@ -0,0 +1,276 @@
+package mbtest
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/shasderias/modbus"
+	"github.com/shasderias/modbus/simulator"
+	"github.com/shasderias/modbus/transport/rtu"
+	"github.com/shasderias/modbus/transport/tcp"
+)
+
+// faultCase describes how a client is expected to react to a fault injected
+// into the response to its first request.
+type faultCase struct {
+	fault Fault
+	check func(t *testing.T, err error)
+}
+
+func wantOK(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("got %v; want nil", err)
+	}
+}
+
+func wantError(t *testing.T, err error) {
+	t.Helper()
+	if err == nil {
+		t.Fatal("got nil; want error")
+	}
+}
+
+func wantTimeout(t *testing.T, err error) {
+	t.Helper()
+	if !errors.Is(err, modbus.ErrTimeout) {
+		t.Fatalf("got %v; want modbus.ErrTimeout", err)
+	}
+}
+
+func wantUnexpectedResponse(t *testing.T, err error) {
+	t.Helper()
+	if !errors.Is(err, modbus.ErrUnexpectedResponse) {
+		t.Fatalf("got %v; want modbus.ErrUnexpectedResponse", err)
+	}
+}
+
+func wantException(t *testing.T, err error) {
+	t.Helper()
+	if !errors.Is(err, modbus.ErrServerDeviceBusy) {
+		t.Fatalf("got %v; want server device busy exception", err)
+	}
+}
+
+func wantBadCRC(t *testing.T, err error) {
+	t.Helper()
+	var crcErr modbus.ErrBadCRC
+	if !errors.As(err, &crcErr) {
+		t.Fatalf("got %v; want modbus.ErrBadCRC", err)
+	}
+}
+
+func faultConfig(fault Fault) func(c *FaultConfig) {
+	return func(c *FaultConfig) {
+		c.Fault = FaultSequence(fault)
+		c.Delay = 20 * time.Millisecond
+	}
+}
+
+func newSimulator(t *testing.T) *simulator.Simulator {
+	t.Helper()
+	sim, err := simulator.New(simulator.DefaultMap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sim
+}
+
+func TestFaultyPort(t *testing.T) {
+	t.Parallel()
+
+	testCases := []faultCase{
+		{FaultNone, wantOK},
+		{FaultDrop, wantTimeout},
+		{FaultDelay, wantOK},
+		{FaultCorrupt, wantBadCRC},
+		{FaultTruncate, wantError},
+		{FaultWrongTransactionID, wantOK},
+		{FaultWrongUnitID, wantUnexpectedResponse},
+		{FaultDuplicate, wantOK},
+		{FaultException, wantException},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+		t.Run(tt.fault.String(), func(t *testing.T) {
+			t.Parallel()
+
+			masterPort, slavePort := NewPortPair()
+			defer masterPort.Close()
+
+			server, err := rtu.NewServer(NewFaultyPort(slavePort, faultConfig(tt.fault)), newSimulator(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := server.Start(); err != nil {
+				t.Fatal(err)
+			}
+			defer server.Stop()
+
+			client, err := modbus.NewClient(1, rtu.NewClient(masterPort))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = client.ReadHoldingRegisters(0, 4)
+			tt.check(t, err)
+		})
+	}
+}
+
+func TestFaultyConn(t *testing.T) {
+	t.Parallel()
+
+	testCases := []faultCase{
+		{FaultNone, wantOK},
+		{FaultDrop, wantTimeout},
+		{FaultDelay, wantOK},
+		{FaultTruncate, wantError},
+		{FaultWrongTransactionID, wantTimeout},
+		{FaultWrongUnitID, wantUnexpectedResponse},
+		{FaultDuplicate, wantOK},
+		{FaultException, wantException},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+		t.Run(tt.fault.String(), func(t *testing.T) {
+			t.Parallel()
+
+			masterConn, slaveConn := net.Pipe()
+
+			server, err := tcp.NewServer("", newSimulator(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			go server.ServeConn(NewFaultyConn(slaveConn, faultConfig(tt.fault)))
+			defer server.Stop()
+
+			transport, err := tcp.NewClient(masterConn, func(c *tcp.ClientConfig) {
+				c.RequestTimeout = 200 * time.Millisecond
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client, err := modbus.NewClient(1, transport)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			_, err = client.ReadHoldingRegisters(0, 4)
+			tt.check(t, err)
+		})
+	}
+}
+
+// TestTCPClientStrayResponses checks that late, duplicate and misaddressed
+// responses fail at most the request they answer, and not the client.
+func TestTCPClientStrayResponses(t *testing.T) {
+	t.Parallel()
+
+	const timeout = 100 * time.Millisecond
+
+	masterConn, slaveConn := net.Pipe()
+
+	server, err := tcp.NewServer("", newSimulator(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.ServeConn(NewFaultyConn(slaveConn, func(c *FaultConfig) {
+		c.Fault = FaultSequence(FaultDelay, FaultDuplicate, FaultWrongTransactionID, FaultWrongUnitID)
+		c.Delay = 2 * timeout
+	}))
+	defer server.Stop()
+
+	transport, err := tcp.NewClient(masterConn, func(c *tcp.ClientConfig) {
+		c.RequestTimeout = timeout
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := modbus.NewClient(1, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	checks := []func(t *testing.T, err error){
+		wantTimeout, wantOK, wantTimeout, wantUnexpectedResponse, wantOK,
+	}
+	for i, check := range checks {
+		_, err := client.ReadHoldingRegisters(0, 4)
+		check(t, err)
+		if i == 0 {
+			// let the late response arrive
+			time.Sleep(2 * timeout)
+		}
+	}
+
+	// the late response, the duplicate and the response with the wrong
+	// transaction ID
+	if got, want := transport.DiscardedResponses(), uint64(3); got != want {
+		t.Fatalf("got %d discarded responses; want %d", got, want)
+	}
+}
+
+func TestFaultyTransport(t *testing.T) {
+	t.Parallel()
+
+	testCases := []faultCase{
+		{FaultNone, wantOK},
+		{FaultDrop, wantTimeout},
+		{FaultDelay, wantOK},
+		{FaultTruncate, wantError},
+		{FaultDuplicate, wantOK},
+		{FaultException, wantException},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+		t.Run(tt.fault.String(), func(t *testing.T) {
+			t.Parallel()
+
+			_, port := StartSimulatorRTU(t, simulator.DefaultMap())
+
+			client, err := modbus.NewClient(1, NewFaultyTransport(rtu.NewClient(port), faultConfig(tt.fault)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = client.ReadHoldingRegisters(0, 4)
+			tt.check(t, err)
+
+			// only the first response is faulty
+			if _, err := client.ReadHoldingRegisters(0, 4); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestRandomFaults(t *testing.T) {
+	t.Parallel()
+
+	var (
+		a = RandomFaults(42, 0.5, FaultDrop, FaultCorrupt)
+		b = RandomFaults(42, 0.5, FaultDrop, FaultCorrupt)
+
+		faulty int
+	)
+	for n := 0; n < 1000; n++ {
+		fa, fb := a(n), b(n)
+		if fa != fb {
+			t.Fatalf("response %d: got %v and %v with the same seed", n, fa, fb)
+		}
+		if fa != FaultNone {
+			faulty++
+		}
+	}
+	if faulty < 400 || faulty > 600 {
+		t.Fatalf("got %d faulty responses out of 1000; want about 500", faulty)
+	}
+}
@@ -0,0 +1,240 @@
+package mbtest
+
+import (
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+type PortConfig struct {
+	// BaudRate, if not 0, delays every byte by the time it takes to transmit
+	// a character (11 bits) at BaudRate. Bytes are received one after the
+	// other, as on a serial line.
+	BaudRate int
+
+	// Gap, if not nil, is called with the index of every byte written to a
+	// port, counting from 0, and returns how long the line stays silent
+	// before the byte is transmitted. Use it to split frames, as USB to
+	// serial adapters sometimes do.
+	Gap func(i int) time.Duration
+
+	// NoiseRate is the probability, between 0 and 1, that a byte is
+	// corrupted in transit. Corrupt bytes have a random bit flipped.
+	NoiseRate float64
+
+	// Seed seeds the random number generator that decides which bytes are
+	// corrupted.
+	Seed int64
+}
+
+// Port is one end of an in-memory serial line created by NewPortPair. It
+// implements rtu.Port.
+//
+// Unlike net.Pipe, writes never block: bytes written are buffered until they
+// are read from the other end, or dropped if the other end has been closed.
+type Port struct {
+	conf PortConfig
+	rx   *line
+	tx   *line
+
+	rnd     *rand.Rand
+	written int
+
+	deadlineMut   sync.Mutex
+	writeDeadline time.Time
+}
+
+// NewPortPair returns the two ends of an in-memory serial line. Bytes written
+// to one end are read from the other.
+func NewPortPair(fns ...func(c *PortConfig)) (*Port, *Port) {
+	conf := PortConfig{}
+	for _, fn := range fns {
+		fn(&conf)
+	}
+
+	var (
+		ab = newLine()
+		ba = newLine()
+	)
+
+	return &Port{conf: conf, rx: ba, tx: ab, rnd: rand.New(rand.NewSource(conf.Seed))},
+		&Port{conf: conf, rx: ab, tx: ba, rnd: rand.New(rand.NewSource(conf.Seed + 1))}
+}
+
+func (p *Port) Read(b []byte) (int, error) {
+	return p.rx.read(b)
+}
+
+func (p *Port) Write(b []byte) (int, error) {
+	p.deadlineMut.Lock()
+	deadline := p.writeDeadline
+	p.deadlineMut.Unlock()
+
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	// tx.mut guards p.rnd and p.written, as a port has a single tx line
+	p.tx.mut.Lock()
+	defer p.tx.mut.Unlock()
+
+	if p.tx.writerClosed {
+		return 0, os.ErrClosed
+	}
+
+	var charTime time.Duration
+	if p.conf.BaudRate > 0 {
+		charTime = 11 * time.Second / time.Duration(p.conf.BaudRate)
+	}
+
+	now := time.Now()
+	for _, c := range b {
+		at := p.tx.lastAt
+		if at.Before(now) {
+			at = now
+		}
+		if p.conf.Gap != nil {
+			at = at.Add(p.conf.Gap(p.written))
+		}
+		at = at.Add(charTime)
+
+		if p.conf.NoiseRate > 0 && p.rnd.Float64() < p.conf.NoiseRate {
+			c ^= 1 << p.rnd.Intn(8)
+		}
+
+		p.tx.lastAt = at
+		p.written++
+
+		if !p.tx.readerClosed {
+			p.tx.buf = append(p.tx.buf, timedByte{c, at})
+		}
+	}
+	p.tx.notify()
+
+	return len(b), nil
+}
+
+// Close closes the port. Reads from the other end return io.EOF once every
+// byte written before Close has been read.
+func (p *Port) Close() error {
+	p.rx.mut.Lock()
+	p.rx.readerClosed = true
+	p.rx.buf = nil
+	p.rx.notify()
+	p.rx.mut.Unlock()
+
+	p.tx.mut.Lock()
+	p.tx.writerClosed = true
+	p.tx.notify()
+	p.tx.mut.Unlock()
+
+	return nil
+}
+
+func (p *Port) SetReadDeadline(t time.Time) error {
+	p.rx.mut.Lock()
+	defer p.rx.mut.Unlock()
+
+	p.rx.readDeadline = t
+	p.rx.notify()
+
+	return nil
+}
+
+func (p *Port) SetWriteDeadline(t time.Time) error {
+	p.deadlineMut.Lock()
+	defer p.deadlineMut.Unlock()
+
+	p.writeDeadline = t
+
+	return nil
+}
+
+type timedByte struct {
+	b  byte
+	at time.Time // when the byte has been received in full
+}
+
+// line carries bytes in one direction.
+type line struct {
+	mut sync.Mutex
+
+	buf    []timedByte
+	lastAt time.Time
+
+	readDeadline time.Time
+	readerClosed bool
+	writerClosed bool
+
+	// changed is closed, and replaced, whenever any of the above changes
+	changed chan struct{}
+}
+
+func newLine() *line {
+	return &line{changed: make(chan struct{})}
+}
+
+// notify wakes up a blocked reader. l.mut must be held.
+func (l *line) notify() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+func (l *line) read(b []byte) (int, error) {
+	for {
+		l.mut.Lock()
+
+		if l.readerClosed {
+			l.mut.Unlock()
+			return 0, os.ErrClosed
+		}
+
+		now := time.Now()
+
+		n := 0
+		for n < len(l.buf) && n < len(b) && !l.buf[n].at.After(now) {
+			b[n] = l.buf[n].b
+			n++
+		}
+		if n > 0 || len(b) == 0 {
+			l.buf = l.buf[n:]
+			l.mut.Unlock()
+			return n, nil
+		}
+
+		if len(l.buf) == 0 && l.writerClosed {
+			l.mut.Unlock()
+			return 0, io.EOF
+		}
+
+		deadline := l.readDeadline
+		if !deadline.IsZero() && !now.Before(deadline) {
+			l.mut.Unlock()
+			return 0, os.ErrDeadlineExceeded
+		}
+
+		// wait for the next byte, the deadline or a change, whichever is
+		// first
+		wake := deadline
+		if len(l.buf) > 0 && (wake.IsZero() || l.buf[0].at.Before(wake)) {
+			wake = l.buf[0].at
+		}
+		changed := l.changed
+
+		l.mut.Unlock()
+
+		if wake.IsZero() {
+			<-changed
+			continue
+		}
+
+		timer := time.NewTimer(time.Until(wake))
+		select {
+		case <-changed:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
@@ -0,0 +1,174 @@
+package mbtest
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func readAll(t *testing.T, p *Port, n int) []byte {
+	t.Helper()
+
+	if err := p.SetReadDeadline(time.Now().Add(1 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(p, b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestPortPair(t *testing.T) {
+	t.Parallel()
+
+	a, b := NewPortPair()
+
+	if _, err := a.Write([]byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Write([]byte{4, 5}); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(readAll(t, b, 3), []byte{1, 2, 3}); diff != "" {
+		t.Fatal(diff)
+	}
+	if diff := cmp.Diff(readAll(t, a, 2), []byte{4, 5}); diff != "" {
+		t.Fatal(diff)
+	}
+
+	if err := a.SetReadDeadline(time.Now().Add(10 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("got %v; want os.ErrDeadlineExceeded", err)
+	}
+
+	if _, err := b.Write([]byte{6}); err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+	if diff := cmp.Diff(readAll(t, a, 1), []byte{6}); diff != "" {
+		t.Fatal(diff)
+	}
+	if _, err := a.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("got %v; want io.EOF", err)
+	}
+	if _, err := b.Write([]byte{7}); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("got %v; want os.ErrClosed", err)
+	}
+}
+
+func TestPortPairBlockedReadWakesUp(t *testing.T) {
+	t.Parallel()
+
+	a, b := NewPortPair()
+
+	done := make(chan error)
+	go func() {
+		_, err := a.Read(make([]byte, 1))
+		done <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	a.SetReadDeadline(time.Now())
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("got %v; want os.ErrDeadlineExceeded", err)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("read did not return after its deadline was set")
+	}
+
+	b.Close()
+}
+
+func TestPortPairTiming(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		conf    PortConfig
+		minTime time.Duration
+	}{
+		// 11 bits per character, 10 characters at 9600 baud
+		{"BaudRate", PortConfig{BaudRate: 9600}, 11 * time.Millisecond},
+		{"Gap", PortConfig{Gap: func(i int) time.Duration {
+			if i == 5 {
+				return 20 * time.Millisecond
+			}
+			return 0
+		}}, 20 * time.Millisecond},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			a, b := NewPortPair(func(c *PortConfig) { *c = tt.conf })
+
+			start := time.Now()
+			if _, err := a.Write(make([]byte, 10)); err != nil {
+				t.Fatal(err)
+			}
+			readAll(t, b, 10)
+
+			if elapsed := time.Since(start); elapsed < tt.minTime {
+				t.Fatalf("received 10 bytes after %v; want at least %v", elapsed, tt.minTime)
+			}
+		})
+	}
+}
+
+func TestPortPairGapSplitsReads(t *testing.T) {
+	t.Parallel()
+
+	a, b := NewPortPair(func(c *PortConfig) {
+		c.Gap = func(i int) time.Duration {
+			if i == 2 {
+				return 20 * time.Millisecond
+			}
+			return 0
+		}
+	})
+
+	if _, err := a.Write([]byte{1, 2, 3, 4}); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 4)
+	n, err := b.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(buf[:n], []byte{1, 2}); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestPortPairNoise(t *testing.T) {
+	t.Parallel()
+
+	a, b := NewPortPair(func(c *PortConfig) {
+		c.NoiseRate = 1
+	})
+
+	sent := []byte{0x00, 0xff, 0x55}
+	if _, err := a.Write(sent); err != nil {
+		t.Fatal(err)
+	}
+
+	got := readAll(t, b, len(sent))
+	for i := range sent {
+		if diff := sent[i] ^ got[i]; diff == 0 || diff&(diff-1) != 0 {
+			t.Fatalf("byte %d: sent %08b, got %08b; want a single bit flipped", i, sent[i], got[i])
+		}
+	}
+}
@@ -1 +1,85 @@
 package mbtest
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/shasderias/modbus/simulator"
+	"github.com/shasderias/modbus/transport/rtu"
+	"github.com/shasderias/modbus/transport/tcp"
+)
+
+// StartSimulatorTCP starts a simulator serving m on a Modbus/TCP server
+// listening on a free local port, and returns a connection to the server.
+// stop stops the server and closes every connection to it. The server is
+// stopped when the test completes.
+func StartSimulatorTCP(t *testing.T, m simulator.Map) (sim *simulator.Simulator, conn net.Conn, stop func()) {
+	t.Helper()
+
+	sim = startSimulator(t, m)
+
+	server, err := tcp.NewServer("127.0.0.1:0", sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Stop() })
+
+	conn, err = net.DialTimeout("tcp", server.Addr().String(), 1*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return sim, conn, func() { server.Stop() }
+}
+
+// StartSimulatorRTU starts a simulator serving m on one end of an in-memory
+// serial line (see NewPortPair), and returns the other end. fns configure the
+// line.
+func StartSimulatorRTU(t *testing.T, m simulator.Map, fns ...func(c *PortConfig)) (*simulator.Simulator, *Port) {
+	t.Helper()
+
+	masterPort, slavePort := NewPortPair(fns...)
+	t.Cleanup(func() {
+		masterPort.Close()
+		slavePort.Close()
+	})
+
+	sim := startSimulator(t, m)
+
+	server, err := rtu.NewServer(slavePort, sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Stop() })
+
+	return sim, masterPort
+}
+
+func startSimulator(t *testing.T, m simulator.Map) *simulator.Simulator {
+	sim, err := simulator.New(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		sim.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return sim
+}
@@ -0,0 +1,236 @@
+// Package metrics collects the requests reported by clients, client
+// transports and servers (see modbus.Observer and middleware.Observe) into
+// counters and histograms, and exposes them in the Prometheus text exposition
+// format.
+//
+// A Collector is set as the Observer of a client or transport:
+//
+//	collector := metrics.NewCollector()
+//	client, err := modbus.NewClient(1, transport, func(c *modbus.ClientConfig) {
+//		c.Observer = collector
+//	})
+//	http.Handle("/metrics", collector)
+//
+// Every series is labelled with the unit ID and function code of the request,
+// so that slow or unreliable devices stand out. Requests are counted by
+// outcome, exceptions by exception code.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/shasderias/modbus"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the buckets of the
+// request duration histogram.
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5}
+
+// numOutcomes is the number of modbus.Outcome values.
+const numOutcomes = int(modbus.OutcomeTransportError) + 1
+
+type CollectorConfig struct {
+	// Namespace is the prefix of the metric names, such as
+	// "modbus_requests_total". Defaults to "modbus". Collectors observing
+	// different layers, such as a Client and its transport, should be given
+	// different namespaces.
+	Namespace string
+
+	// Buckets are the upper bounds, in seconds and in increasing order, of
+	// the buckets of the request duration histogram. Defaults to
+	// DefaultBuckets.
+	Buckets []float64
+}
+
+// Collector is a modbus.Observer that aggregates the requests it observes.
+// It is an http.Handler that serves the aggregates in the Prometheus text
+// exposition format. A Collector is safe for concurrent use.
+type Collector struct {
+	conf CollectorConfig
+
+	mut    sync.Mutex
+	series map[seriesKey]*series
+}
+
+type seriesKey struct {
+	unitID, functionCode byte
+}
+
+type series struct {
+	outcomes   [numOutcomes]uint64
+	exceptions map[byte]uint64
+
+	requestBytes, responseBytes uint64
+
+	// buckets[i] counts the requests that took at most conf.Buckets[i], and
+	// longer than conf.Buckets[i-1]
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func NewCollector(fns ...func(c *CollectorConfig)) *Collector {
+	conf := CollectorConfig{
+		Namespace: "modbus",
+		Buckets:   DefaultBuckets,
+	}
+	for _, fn := range fns {
+		fn(&conf)
+	}
+
+	return &Collector{
+		conf:   conf,
+		series: make(map[seriesKey]*series),
+	}
+}
+
+func (c *Collector) ObserveRequest(e modbus.RequestEvent) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	key := seriesKey{e.UnitID, e.FunctionCode}
+	s, ok := c.series[key]
+	if !ok {
+		s = &series{
+			exceptions: make(map[byte]uint64),
+			buckets:    make([]uint64, len(c.conf.Buckets)),
+		}
+		c.series[key] = s
+	}
+
+	if int(e.Outcome) >= 0 && int(e.Outcome) < numOutcomes {
+		s.outcomes[e.Outcome]++
+	}
+	if e.Outcome == modbus.OutcomeException {
+		s.exceptions[e.ExceptionCode]++
+	}
+	s.requestBytes += uint64(e.RequestBytes)
+	s.responseBytes += uint64(e.ResponseBytes)
+
+	seconds := e.Latency.Seconds()
+	for i, bound := range c.conf.Buckets {
+		if seconds <= bound {
+			s.buckets[i]++
+			break
+		}
+	}
+	s.count++
+	s.sum += seconds
+}
+
+// ServeHTTP writes the aggregates in the Prometheus text exposition format.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.WriteTo(w)
+}
+
+// WriteTo writes the aggregates to w in the Prometheus text exposition format.
+// Series are ordered by unit ID, then function code.
+func (c *Collector) WriteTo(w io.Writer) (int64, error) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	keys := make([]seriesKey, 0, len(c.series))
+	for key := range c.series {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].unitID != keys[j].unitID {
+			return keys[i].unitID < keys[j].unitID
+		}
+		return keys[i].functionCode < keys[j].functionCode
+	})
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	ns := c.conf.Namespace
+
+	header(cw, ns+"_requests_total", "counter", "Requests completed, by outcome.")
+	for _, key := range keys {
+		for outcome, n := range c.series[key].outcomes {
+			if n == 0 {
+				continue
+			}
+			fmt.Fprintf(cw, "%s_requests_total{%s,outcome=%q} %d\n",
+				ns, key.labels(), modbus.Outcome(outcome).String(), n)
+		}
+	}
+
+	header(cw, ns+"_exceptions_total", "counter", "Exception responses received, by exception code.")
+	for _, key := range keys {
+		s := c.series[key]
+		codes := make([]byte, 0, len(s.exceptions))
+		for code := range s.exceptions {
+			codes = append(codes, code)
+		}
+		sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+		for _, code := range codes {
+			fmt.Fprintf(cw, "%s_exceptions_total{%s,exception=\"%d\"} %d\n",
+				ns, key.labels(), code, s.exceptions[code])
+		}
+	}
+
+	header(cw, ns+"_request_bytes_total", "counter", "Bytes sent in requests.")
+	for _, key := range keys {
+		fmt.Fprintf(cw, "%s_request_bytes_total{%s} %d\n", ns, key.labels(), c.series[key].requestBytes)
+	}
+
+	header(cw, ns+"_response_bytes_total", "counter", "Bytes received in responses.")
+	for _, key := range keys {
+		fmt.Fprintf(cw, "%s_response_bytes_total{%s} %d\n", ns, key.labels(), c.series[key].responseBytes)
+	}
+
+	header(cw, ns+"_request_duration_seconds", "histogram", "Time from sending a request to its completion.")
+	for _, key := range keys {
+		s := c.series[key]
+		var cumulative uint64
+		for i, bound := range c.conf.Buckets {
+			cumulative += s.buckets[i]
+			fmt.Fprintf(cw, "%s_request_duration_seconds_bucket{%s,le=%q} %d\n",
+				ns, key.labels(), formatFloat(bound), cumulative)
+		}
+		fmt.Fprintf(cw, "%s_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", ns, key.labels(), s.count)
+		fmt.Fprintf(cw, "%s_request_duration_seconds_sum{%s} %s\n", ns, key.labels(), formatFloat(s.sum))
+		fmt.Fprintf(cw, "%s_request_duration_seconds_count{%s} %d\n", ns, key.labels(), s.count)
+	}
+
+	if err := cw.w.Flush(); err != nil && cw.err == nil {
+		cw.err = err
+	}
+	return cw.n, cw.err
+}
+
+func (k seriesKey) labels() string {
+	return fmt.Sprintf("unit=\"%d\",function=\"%d\"", k.unitID, k.functionCode)
+}
+
+func header(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// countingWriter counts the bytes written to w, and remembers the first
+// error.
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
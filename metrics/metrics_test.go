@@ -0,0 +1,178 @@
+package metrics_test
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/shasderias/modbus"
+	"github.com/shasderias/modbus/mbtest"
+	"github.com/shasderias/modbus/metrics"
+	"github.com/shasderias/modbus/simulator"
+	"github.com/shasderias/modbus/transport/rtu"
+)
+
+func TestCollector(t *testing.T) {
+	collector := metrics.NewCollector(func(c *metrics.CollectorConfig) {
+		c.Buckets = []float64{0.01, 0.1}
+	})
+
+	events := []modbus.RequestEvent{
+		{UnitID: 2, FunctionCode: 3, RequestBytes: 8, ResponseBytes: 7, Latency: 5 * time.Millisecond},
+		{UnitID: 2, FunctionCode: 3, RequestBytes: 8, ResponseBytes: 5, Latency: 50 * time.Millisecond,
+			Outcome: modbus.OutcomeException, ExceptionCode: 2},
+		{UnitID: 2, FunctionCode: 3, RequestBytes: 8, Latency: 500 * time.Millisecond,
+			Outcome: modbus.OutcomeTimeout, Err: errors.New("timeout")},
+		{UnitID: 1, FunctionCode: 6, RequestBytes: 8, ResponseBytes: 8, Latency: 5 * time.Millisecond},
+	}
+	for _, e := range events {
+		collector.ObserveRequest(e)
+	}
+
+	rec := httptest.NewRecorder()
+	collector.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if got, want := rec.Header().Get("Content-Type"), "text/plain; version=0.0.4; charset=utf-8"; got != want {
+		t.Fatalf("got content type %q; want %q", got, want)
+	}
+
+	want := `# HELP modbus_requests_total Requests completed, by outcome.
+# TYPE modbus_requests_total counter
+modbus_requests_total{unit="1",function="6",outcome="success"} 1
+modbus_requests_total{unit="2",function="3",outcome="success"} 1
+modbus_requests_total{unit="2",function="3",outcome="exception"} 1
+modbus_requests_total{unit="2",function="3",outcome="timeout"} 1
+# HELP modbus_exceptions_total Exception responses received, by exception code.
+# TYPE modbus_exceptions_total counter
+modbus_exceptions_total{unit="2",function="3",exception="2"} 1
+# HELP modbus_request_bytes_total Bytes sent in requests.
+# TYPE modbus_request_bytes_total counter
+modbus_request_bytes_total{unit="1",function="6"} 8
+modbus_request_bytes_total{unit="2",function="3"} 24
+# HELP modbus_response_bytes_total Bytes received in responses.
+# TYPE modbus_response_bytes_total counter
+modbus_response_bytes_total{unit="1",function="6"} 8
+modbus_response_bytes_total{unit="2",function="3"} 12
+# HELP modbus_request_duration_seconds Time from sending a request to its completion.
+# TYPE modbus_request_duration_seconds histogram
+modbus_request_duration_seconds_bucket{unit="1",function="6",le="0.01"} 1
+modbus_request_duration_seconds_bucket{unit="1",function="6",le="0.1"} 1
+modbus_request_duration_seconds_bucket{unit="1",function="6",le="+Inf"} 1
+modbus_request_duration_seconds_sum{unit="1",function="6"} 0.005
+modbus_request_duration_seconds_count{unit="1",function="6"} 1
+modbus_request_duration_seconds_bucket{unit="2",function="3",le="0.01"} 1
+modbus_request_duration_seconds_bucket{unit="2",function="3",le="0.1"} 2
+modbus_request_duration_seconds_bucket{unit="2",function="3",le="+Inf"} 3
+modbus_request_duration_seconds_sum{unit="2",function="3"} 0.555
+modbus_request_duration_seconds_count{unit="2",function="3"} 3
+`
+	if diff := cmp.Diff(rec.Body.String(), want); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+// TestOutcomes checks that the outcomes reported by a client and its
+// transport are classified alike.
+func TestOutcomes(t *testing.T) {
+	sim, err := simulator.New(simulator.DefaultMap())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	masterPort, slavePort := mbtest.NewPortPair()
+	defer masterPort.Close()
+
+	faultyPort := mbtest.NewFaultyPort(slavePort, func(c *mbtest.FaultConfig) {
+		c.Fault = mbtest.FaultSequence(mbtest.FaultNone, mbtest.FaultCorrupt, mbtest.FaultDrop, mbtest.FaultException)
+	})
+	server, err := rtu.NewServer(faultyPort, sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	var clientEvents, transportEvents []modbus.RequestEvent
+	transport := rtu.NewClient(masterPort, func(c *rtu.ClientConfig) {
+		c.RequestTimeout = 100 * time.Millisecond
+		c.Observer = modbus.ObserverFunc(func(e modbus.RequestEvent) {
+			transportEvents = append(transportEvents, e)
+		})
+	})
+	client, err := modbus.NewClient(1, transport, func(c *modbus.ClientConfig) {
+		c.Observer = modbus.ObserverFunc(func(e modbus.RequestEvent) {
+			clientEvents = append(clientEvents, e)
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 4; i++ {
+		client.ReadHoldingRegisters(0, 2)
+		// let a dropped response time out before the next request
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	type summary struct {
+		Outcome       modbus.Outcome
+		ExceptionCode byte
+		ResponseBytes int
+	}
+	summarize := func(events []modbus.RequestEvent) []summary {
+		var s []summary
+		for _, e := range events {
+			if e.UnitID != 1 || e.FunctionCode != modbus.FuncCodeReadHoldingRegisters {
+				t.Fatalf("got unit %d, function %d; want unit 1, function 3", e.UnitID, e.FunctionCode)
+			}
+			s = append(s, summary{e.Outcome, e.ExceptionCode, e.ResponseBytes})
+		}
+		return s
+	}
+
+	if diff := cmp.Diff(summarize(transportEvents), []summary{
+		{modbus.OutcomeSuccess, 0, 9},
+		{modbus.OutcomeBadCRC, 0, 0},
+		{modbus.OutcomeTimeout, 0, 0},
+		{modbus.OutcomeException, modbus.ExceptionCodeServerDeviceBusy, 5},
+	}); diff != "" {
+		t.Fatalf("transport: %s", diff)
+	}
+	if diff := cmp.Diff(summarize(clientEvents), []summary{
+		{modbus.OutcomeSuccess, 0, 6},
+		{modbus.OutcomeBadCRC, 0, 0},
+		{modbus.OutcomeTimeout, 0, 0},
+		{modbus.OutcomeException, modbus.ExceptionCodeServerDeviceBusy, 2},
+	}); diff != "" {
+		t.Fatalf("client: %s", diff)
+	}
+}
+
+func TestCollectorNamespace(t *testing.T) {
+	collector := metrics.NewCollector(func(c *metrics.CollectorConfig) {
+		c.Namespace = "plc"
+	})
+	collector.ObserveRequest(modbus.RequestEvent{UnitID: 1, FunctionCode: 3})
+
+	var b strings.Builder
+	n, err := collector.WriteTo(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(b.Len()) {
+		t.Fatalf("got %d bytes written; want %d", n, b.Len())
+	}
+	if !strings.Contains(b.String(), `plc_requests_total{unit="1",function="3",outcome="success"} 1`) {
+		t.Fatalf("got %q; want plc_ metrics", b.String())
+	}
+	if _, err := collector.WriteTo(io.Discard); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,228 @@
+// Package middleware implements handler middleware: wrappers that add
+// logging, panic recovery, timeouts, metrics and rate limiting to any
+// modbus.Handler, under any server.
+//
+//	h := middleware.Chain(model,
+//		middleware.Logging(logger),
+//		middleware.Recover(logger),
+//		middleware.Timeout(100*time.Millisecond),
+//	)
+//	srv, err := tcp.NewServer(":502", h)
+//
+// Middleware reply to the requests they refuse, or whose handler fails, with
+// exception responses returned as errors, as handlers do (see
+// modbus.Respond).
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/shasderias/modbus"
+	"github.com/shasderias/modbus/internal/logging"
+)
+
+// Middleware wraps a handler.
+type Middleware func(h modbus.Handler) modbus.Handler
+
+// Chain returns h wrapped with mw. The first middleware is the outermost, and
+// sees every request first:
+//
+//	Chain(h, Logging(logger), Recover(logger))
+//
+// is Logging(logger)(Recover(logger)(h)).
+func Chain(h modbus.Handler, mw ...Middleware) modbus.Handler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h
+}
+
+// Logging logs every request at info level, with its outcome and latency.
+// Handler errors other than exception responses are logged at error level.
+func Logging(logger *slog.Logger) Middleware {
+	logger = logging.OrDiscard(logger)
+
+	return func(h modbus.Handler) modbus.Handler {
+		return modbus.HandlerFunc(func(ctx context.Context, unitID byte, req modbus.PDU) (modbus.PDU, error) {
+			start := time.Now()
+			resp, err := h.ServeModbus(ctx, unitID, req)
+
+			attrs := []slog.Attr{logging.UnitID(unitID), logging.FunctionCode(req.FunctionCode()), logging.Latency(time.Since(start))}
+			level := slog.LevelInfo
+			outcome, exceptionCode := modbus.ClassifyOutcome(resp, err)
+			switch outcome {
+			case modbus.OutcomeSuccess:
+			case modbus.OutcomeException:
+				attrs = append(attrs, slog.Int("exception", int(exceptionCode)))
+			default:
+				level = slog.LevelError
+			}
+			if err != nil {
+				attrs = append(attrs, logging.Err(err))
+			}
+			logger.LogAttrs(ctx, level, "middleware: handled request", attrs...)
+
+			return resp, err
+		})
+	}
+}
+
+// Recover replies ExceptionCodeServerDeviceFailure to requests whose handler
+// panics, and logs the panic and its stack trace to logger at error level.
+func Recover(logger *slog.Logger) Middleware {
+	logger = logging.OrDiscard(logger)
+
+	return func(h modbus.Handler) modbus.Handler {
+		return modbus.HandlerFunc(func(ctx context.Context, unitID byte, req modbus.PDU) (resp modbus.PDU, err error) {
+			defer func() {
+				if v := recover(); v != nil {
+					logger.LogAttrs(ctx, slog.LevelError, "middleware: handler panicked",
+						logging.UnitID(unitID), logging.FunctionCode(req.FunctionCode()),
+						slog.Any("panic", v), slog.String("stack", string(debug.Stack())))
+
+					resp = nil
+					err = fmt.Errorf("middleware: handler panicked: %v: %w", v,
+						modbus.NewExceptionResponseTo(req, modbus.ExceptionCodeServerDeviceFailure))
+				}
+			}()
+
+			return h.ServeModbus(ctx, unitID, req)
+		})
+	}
+}
+
+// Timeout replies ExceptionCodeServerDeviceBusy to requests whose handler
+// does not return within d. The handler is passed a context that is done
+// after d, and should return when it is; its response is discarded. If the
+// context of the request is done first, such as when the server shuts down,
+// its error is returned instead. Panics of the handler are raised again in the caller of Timeout, so that a Recover
+// chained before Timeout recovers them.
+func Timeout(d time.Duration) Middleware {
+	return func(h modbus.Handler) modbus.Handler {
+		return modbus.HandlerFunc(func(parent context.Context, unitID byte, req modbus.PDU) (modbus.PDU, error) {
+			ctx, cancel := context.WithTimeout(parent, d)
+			defer cancel()
+
+			type result struct {
+				resp     modbus.PDU
+				err      error
+				panicked any
+			}
+			// buffered, so that a handler that returns late does not block
+			done := make(chan result, 1)
+			go func() {
+				defer func() {
+					if v := recover(); v != nil {
+						done <- result{panicked: v}
+					}
+				}()
+				resp, err := h.ServeModbus(ctx, unitID, req)
+				done <- result{resp: resp, err: err}
+			}()
+
+			select {
+			case r := <-done:
+				if r.panicked != nil {
+					panic(r.panicked)
+				}
+				return r.resp, r.err
+			case <-ctx.Done():
+				if err := parent.Err(); err != nil {
+					return nil, err
+				}
+				return nil, fmt.Errorf("middleware: handler timed out after %s: %w", d,
+					modbus.NewExceptionResponseTo(req, modbus.ExceptionCodeServerDeviceBusy))
+			}
+		})
+	}
+}
+
+// Observe notifies o, such as a metrics.Collector, of every request, with the
+// lengths of the request and response PDUs, the outcome of the response sent
+// and the latency of the handler. Handler errors other than exception
+// responses are reported as the ExceptionCodeServerDeviceFailure exception
+// that is sent in their place.
+func Observe(o modbus.Observer) Middleware {
+	return func(h modbus.Handler) modbus.Handler {
+		return modbus.HandlerFunc(func(ctx context.Context, unitID byte, req modbus.PDU) (modbus.PDU, error) {
+			start := time.Now()
+			// Respond replaces errors with the exception sent, which
+			// servers send in turn
+			resp, err := modbus.Respond(ctx, h, unitID, req)
+
+			e := modbus.RequestEvent{
+				UnitID:       unitID,
+				FunctionCode: req.FunctionCode(),
+				Latency:      time.Since(start),
+				Err:          err,
+			}
+			e.Outcome, e.ExceptionCode = modbus.ClassifyOutcome(resp, nil)
+			if b, err := req.MarshalBinary(); err == nil {
+				e.RequestBytes = len(b)
+			}
+			if resp != nil {
+				if b, err := resp.MarshalBinary(); err == nil {
+					e.ResponseBytes = len(b)
+				}
+			}
+			o.ObserveRequest(e)
+
+			return resp, err
+		})
+	}
+}
+
+// RateLimit replies ExceptionCodeServerDeviceBusy to requests in excess of
+// perSecond requests a second, allowing bursts of up to burst requests. The
+// limit is shared by every unit; chain a RateLimit into the handler of each
+// unit to limit units separately.
+func RateLimit(perSecond float64, burst int) Middleware {
+	return func(h modbus.Handler) modbus.Handler {
+		b := &bucket{
+			rate:   perSecond,
+			burst:  float64(burst),
+			tokens: float64(burst),
+			last:   time.Now(),
+		}
+
+		return modbus.HandlerFunc(func(ctx context.Context, unitID byte, req modbus.PDU) (modbus.PDU, error) {
+			if !b.take(time.Now()) {
+				return nil, fmt.Errorf("middleware: rate limit exceeded: %w",
+					modbus.NewExceptionResponseTo(req, modbus.ExceptionCodeServerDeviceBusy))
+			}
+			return h.ServeModbus(ctx, unitID, req)
+		})
+	}
+}
+
+// bucket is a token bucket, refilled at rate tokens a second up to burst.
+type bucket struct {
+	rate, burst float64
+
+	mut    sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// take takes a token, if there is one, at now.
+func (b *bucket) take(now time.Time) bool {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
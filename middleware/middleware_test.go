@@ -0,0 +1,263 @@
+package middleware_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/shasderias/modbus"
+	"github.com/shasderias/modbus/mbtest"
+	"github.com/shasderias/modbus/middleware"
+	"github.com/shasderias/modbus/transport/rtu"
+	"github.com/shasderias/modbus/transport/tcp"
+)
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+var (
+	readHolding = must(modbus.NewReadRegisterRequest(modbus.FuncCodeReadHoldingRegisters, 0, 1))
+	readInput   = must(modbus.NewReadRegisterRequest(modbus.FuncCodeReadInputRegisters, 0, 1))
+	readCoils   = must(modbus.NewReadBitRequest(modbus.FuncCodeReadCoils, 0, 1))
+	// a user defined function code
+	userDefined = must(modbus.NewRawPDU([]byte{0x41}))
+)
+
+// device answers holding register reads, panics on input register reads and
+// hangs on coil reads until ctx is done.
+var device = modbus.HandlerFunc(func(ctx context.Context, unitID byte, req modbus.PDU) (modbus.PDU, error) {
+	switch req.FunctionCode() {
+	case modbus.FuncCodeReadHoldingRegisters:
+		return modbus.NewReadRegisterResponseFromUint16s(modbus.FuncCodeReadHoldingRegisters, []uint16{0x1234})
+	case modbus.FuncCodeReadInputRegisters:
+		panic("emulator bug")
+	case modbus.FuncCodeReadCoils:
+		<-ctx.Done()
+		return nil, ctx.Err()
+	default:
+		return nil, errors.New("not implemented")
+	}
+})
+
+func TestChain(t *testing.T) {
+	var order []string
+	mark := func(name string) middleware.Middleware {
+		return func(h modbus.Handler) modbus.Handler {
+			return modbus.HandlerFunc(func(ctx context.Context, unitID byte, req modbus.PDU) (modbus.PDU, error) {
+				order = append(order, name)
+				return h.ServeModbus(ctx, unitID, req)
+			})
+		}
+	}
+
+	h := middleware.Chain(device, mark("outer"), mark("inner"))
+	if _, err := h.ServeModbus(context.Background(), 1, readHolding); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(order, []string{"outer", "inner"}); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	h := middleware.Recover(nil)(device)
+
+	if _, err := h.ServeModbus(context.Background(), 1, readInput); !errors.Is(err, modbus.ErrServerDeviceFailure) {
+		t.Fatalf("got %v; want ErrServerDeviceFailure", err)
+	}
+	if _, err := h.ServeModbus(context.Background(), 1, readHolding); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	h := middleware.Timeout(20 * time.Millisecond)(device)
+
+	if _, err := h.ServeModbus(context.Background(), 1, readCoils); !errors.Is(err, modbus.ErrServerDeviceBusy) {
+		t.Fatalf("got %v; want ErrServerDeviceBusy", err)
+	}
+	if _, err := h.ServeModbus(context.Background(), 1, readHolding); err != nil {
+		t.Fatal(err)
+	}
+
+	// a request canceled before the timeout is not answered busy
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(5*time.Millisecond, cancel)
+	if _, err := h.ServeModbus(ctx, 1, readCoils); !errors.Is(err, context.Canceled) || errors.Is(err, modbus.ErrServerDeviceBusy) {
+		t.Fatalf("got %v; want %v", err, context.Canceled)
+	}
+
+	// nor is a request whose own deadline is earlier
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if _, err := h.ServeModbus(ctx, 1, readCoils); !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, modbus.ErrServerDeviceBusy) {
+		t.Fatalf("got %v; want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	// the bucket does not refill within the test
+	h := middleware.RateLimit(0.001, 2)(device)
+
+	for i := 0; i < 2; i++ {
+		if _, err := h.ServeModbus(context.Background(), 1, readHolding); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := h.ServeModbus(context.Background(), 1, readHolding); !errors.Is(err, modbus.ErrServerDeviceBusy) {
+		t.Fatalf("got %v; want ErrServerDeviceBusy", err)
+	}
+}
+
+func TestObserve(t *testing.T) {
+	type event struct {
+		FunctionCode  byte
+		Outcome       modbus.Outcome
+		ExceptionCode byte
+		ResponseBytes int
+	}
+	var events []event
+	h := middleware.Chain(device,
+		middleware.Observe(modbus.ObserverFunc(func(e modbus.RequestEvent) {
+			events = append(events, event{e.FunctionCode, e.Outcome, e.ExceptionCode, e.ResponseBytes})
+		})),
+		middleware.Recover(nil),
+	)
+
+	for _, req := range []modbus.PDU{readHolding, readInput, userDefined} {
+		h.ServeModbus(context.Background(), 1, req)
+	}
+
+	want := []event{
+		{modbus.FuncCodeReadHoldingRegisters, modbus.OutcomeSuccess, 0, 4},
+		{modbus.FuncCodeReadInputRegisters, modbus.OutcomeException, modbus.ExceptionCodeServerDeviceFailure, 2},
+		{0x41, modbus.OutcomeException, modbus.ExceptionCodeServerDeviceFailure, 2},
+	}
+	if diff := cmp.Diff(events, want); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	h := middleware.Chain(device, middleware.Logging(logger), middleware.Recover(nil))
+	h.ServeModbus(context.Background(), 1, readHolding)
+	h.ServeModbus(context.Background(), 2, readInput)
+
+	type record struct {
+		Level     string
+		Unit      int
+		Function  int
+		Exception int
+	}
+	var got []record
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var r struct {
+			Level     string `json:"level"`
+			Unit      int    `json:"unit"`
+			Function  int    `json:"function"`
+			Exception int    `json:"exception"`
+		}
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, record{r.Level, r.Unit, r.Function, r.Exception})
+	}
+
+	want := []record{
+		{"INFO", 1, modbus.FuncCodeReadHoldingRegisters, 0},
+		{"INFO", 2, modbus.FuncCodeReadInputRegisters, modbus.ExceptionCodeServerDeviceFailure},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+// TestServers runs the same chain under a TCP and an RTU server.
+func TestServers(t *testing.T) {
+	h := middleware.Chain(device,
+		middleware.Recover(nil),
+		middleware.Timeout(50*time.Millisecond),
+	)
+
+	testCases := []struct {
+		name      string
+		transport func(t *testing.T) modbus.ClientTransport
+	}{
+		{"TCP", func(t *testing.T) modbus.ClientTransport {
+			server, err := tcp.NewServer("127.0.0.1:0", h)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := server.Start(); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { server.Stop() })
+
+			conn, err := net.Dial("tcp", server.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			transport, err := tcp.NewClient(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return transport
+		}},
+		{"RTU", func(t *testing.T) modbus.ClientTransport {
+			masterPort, slavePort := mbtest.NewPortPair()
+			t.Cleanup(func() {
+				masterPort.Close()
+				slavePort.Close()
+			})
+
+			server, err := rtu.NewServer(slavePort, h)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := server.Start(); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { server.Stop() })
+
+			return rtu.NewClient(masterPort, func(c *rtu.ClientConfig) {
+				c.RequestTimeout = 200 * time.Millisecond
+			})
+		}},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := modbus.NewClient(1, tt.transport(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			if _, err := client.ReadHoldingRegisters(0, 1); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := client.ReadInputRegisters(0, 1); !errors.Is(err, modbus.ErrServerDeviceFailure) {
+				t.Fatalf("got %v; want ErrServerDeviceFailure", err)
+			}
+			if _, err := client.ReadCoils(0, 1); !errors.Is(err, modbus.ErrServerDeviceBusy) {
+				t.Fatalf("got %v; want ErrServerDeviceBusy", err)
+			}
+		})
+	}
+}
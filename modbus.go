@@ -23,6 +23,8 @@ const (
 	FuncCodeMaskWriteRegister          = 0x16
 	FuncCodeReadWriteMultipleRegisters = 0x17
 	FuncCodeReadFIFOQueue              = 0x18
+
+	FuncCodeEncapsulatedInterfaceTransport = 0x2b
 )
 
 const (
@@ -36,7 +38,11 @@ type ErrBadCRC struct {
 }
 
 func (e ErrBadCRC) Error() string {
-	return fmt.Sprintf("bad CRC; got: %x, want: %x, frame: %x", e.Got, e.Want, e.Frame)
+	if len(e.Frame) < 2 {
+		return fmt.Sprintf("bad CRC; got: 0x%04x, want: 0x%04x, frame: % x", e.Got, e.Want, e.Frame)
+	}
+	return fmt.Sprintf("bad CRC; got: 0x%04x, want: 0x%04x, frame: % x (slave %d, %s)",
+		e.Got, e.Want, e.Frame, e.Frame[0], FunctionName(e.Frame[1]))
 }
 
 type Transport interface {
@@ -66,7 +72,7 @@ type RawPDU struct {
 }
 
 func NewRawPDU(b []byte) (*RawPDU, error) {
-	if len(b) < 2 {
+	if len(b) < 1 {
 		return nil, fmt.Errorf("insufficient bytes for PDU: %v", b)
 	}
 	return &RawPDU{b}, nil
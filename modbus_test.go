@@ -30,6 +30,22 @@ func TestNewReadRegisterRequest(t *testing.T) {
 			modbus.FuncCodeReadHoldingRegisters,
 			107, 3,
 			true, []byte{0x03, 0x00, 0x6b, 0x00, 0x03}},
+		{"LastAddress",
+			modbus.FuncCodeReadHoldingRegisters,
+			0xffff, 1,
+			true, []byte{0x03, 0xff, 0xff, 0x00, 0x01}},
+		{"MaxCount",
+			modbus.FuncCodeReadHoldingRegisters,
+			0, 0x7d,
+			true, []byte{0x03, 0x00, 0x00, 0x00, 0x7d}},
+		{"PastLastAddress",
+			modbus.FuncCodeReadHoldingRegisters,
+			0xffff, 2,
+			false, nil},
+		{"CountTooLarge",
+			modbus.FuncCodeReadHoldingRegisters,
+			0, 0x7e,
+			false, nil},
 	}
 
 	for _, tt := range testCases {
@@ -39,8 +55,11 @@ func TestNewReadRegisterRequest(t *testing.T) {
 			if tt.valid && err != nil {
 				t.Fatalf("got err; want valid request: %v", err)
 			}
-			if !tt.valid && err == nil {
-				t.Fatalf("did not get err; want invalid request")
+			if !tt.valid {
+				if err == nil {
+					t.Fatalf("did not get err; want invalid request")
+				}
+				return
 			}
 
 			pduBytes, err := req.MarshalBinary()
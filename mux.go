@@ -0,0 +1,201 @@
+package modbus
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// ServeMux is a Handler that routes requests to the handlers registered for
+// their unit ID and function code, so that one server can emulate many
+// devices, such as the slaves behind a gateway:
+//
+//	mux := modbus.NewServeMux()
+//	for id := byte(1); id <= 20; id++ {
+//		mux.Handle(id, datamodel.New())
+//	}
+//	mux.Handle(7, diagnostics, modbus.FuncCodeDiagnostic)
+//	srv, err := tcp.NewServer(":502", mux)
+//
+// Handlers are registered for a unit ID, a range of unit IDs or, by default,
+// every unit ID, and for every function code or specific function codes. The
+// registrations that match the unit ID of a request are tried in order: the
+// unit ID itself, the ranges that contain it, in the order they were
+// registered, and the default. The first with a handler for the function code
+// of the request, or for every function code, handles it, preferring the
+// former. If none has, the request is answered with
+// ExceptionCodeIllegalFunction. Requests for unit IDs nothing is registered
+// for are not answered, as a missing serial slave would not, unless
+// ServeMuxConfig.UnknownUnitException is set.
+type ServeMux struct {
+	unknownUnitException byte
+
+	mut      sync.RWMutex
+	units    map[byte]*unitRoutes
+	ranges   []unitRange
+	fallback *unitRoutes
+}
+
+type unitRange struct {
+	first, last byte
+	routes      *unitRoutes
+}
+
+// unitRoutes are the handlers of a unit registration.
+type unitRoutes struct {
+	// all is the handler for every function code, if any
+	all    Handler
+	byCode map[byte]Handler
+}
+
+type ServeMuxConfig struct {
+	// UnknownUnitException, if not 0, is the exception code requests for
+	// unit IDs nothing is registered for are answered with, such as
+	// ExceptionCodeGatewayPathUnavailable or
+	// ExceptionCodeGatewayTargetDeviceFailedToRespond, as a gateway would.
+	UnknownUnitException byte
+}
+
+func NewServeMux(fns ...func(c *ServeMuxConfig)) *ServeMux {
+	conf := ServeMuxConfig{}
+	for _, fn := range fns {
+		fn(&conf)
+	}
+
+	return &ServeMux{
+		unknownUnitException: conf.UnknownUnitException,
+		units:                make(map[byte]*unitRoutes),
+	}
+}
+
+// Handle registers h for requests to unitID with one of functionCodes, or
+// with any function code if none are given. Handle panics if a handler is
+// already registered for unitID and one of the function codes.
+func (m *ServeMux) Handle(unitID byte, h Handler, functionCodes ...byte) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	routes, ok := m.units[unitID]
+	if !ok {
+		routes = &unitRoutes{}
+		m.units[unitID] = routes
+	}
+	routes.register(fmt.Sprintf("unit %d", unitID), h, functionCodes)
+}
+
+// HandleRange registers h for requests to the unit IDs from first to last,
+// inclusive, as Handle does. Overlapping ranges are allowed; the first
+// registered is chosen.
+func (m *ServeMux) HandleRange(first, last byte, h Handler, functionCodes ...byte) {
+	if first > last {
+		panic(fmt.Sprintf("modbus: invalid unit ID range %d-%d", first, last))
+	}
+
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	var routes *unitRoutes
+	for _, r := range m.ranges {
+		if r.first == first && r.last == last {
+			routes = r.routes
+			break
+		}
+	}
+	if routes == nil {
+		routes = &unitRoutes{}
+		m.ranges = append(m.ranges, unitRange{first, last, routes})
+	}
+	routes.register(fmt.Sprintf("units %d-%d", first, last), h, functionCodes)
+}
+
+// HandleDefault registers h for requests to unit IDs no other registration
+// matches, as Handle does.
+func (m *ServeMux) HandleDefault(h Handler, functionCodes ...byte) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	if m.fallback == nil {
+		m.fallback = &unitRoutes{}
+	}
+	m.fallback.register("default unit", h, functionCodes)
+}
+
+func (r *unitRoutes) register(units string, h Handler, functionCodes []byte) {
+	if h == nil {
+		panic("modbus: nil handler")
+	}
+
+	if len(functionCodes) == 0 {
+		if r.all != nil {
+			panic(fmt.Sprintf("modbus: multiple registrations for %s", units))
+		}
+		r.all = h
+		return
+	}
+
+	if r.byCode == nil {
+		r.byCode = make(map[byte]Handler)
+	}
+	for _, code := range functionCodes {
+		if _, ok := r.byCode[code]; ok {
+			panic(fmt.Sprintf("modbus: multiple registrations for %s, function code 0x%02x", units, code))
+		}
+		r.byCode[code] = h
+	}
+}
+
+// handler returns the handler of functionCode in r, or nil.
+func (r *unitRoutes) handler(functionCode byte) Handler {
+	if h, ok := r.byCode[functionCode]; ok {
+		return h
+	}
+	return r.all
+}
+
+// Handler returns the handler requests to unitID with functionCode are
+// routed to, and whether any handler is registered for unitID. h is nil if
+// the request is answered by the ServeMux itself.
+func (m *ServeMux) Handler(unitID, functionCode byte) (h Handler, unitKnown bool) {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+
+	if routes, ok := m.units[unitID]; ok {
+		unitKnown = true
+		if h := routes.handler(functionCode); h != nil {
+			return h, true
+		}
+	}
+	for _, r := range m.ranges {
+		if unitID < r.first || unitID > r.last {
+			continue
+		}
+		unitKnown = true
+		if h := r.routes.handler(functionCode); h != nil {
+			return h, true
+		}
+	}
+	if m.fallback != nil {
+		unitKnown = true
+		if h := m.fallback.handler(functionCode); h != nil {
+			return h, true
+		}
+	}
+
+	return nil, unitKnown
+}
+
+// ServeModbus routes req to the handler registered for unitID and its
+// function code.
+func (m *ServeMux) ServeModbus(ctx context.Context, unitID byte, req PDU) (PDU, error) {
+	h, unitKnown := m.Handler(unitID, req.FunctionCode())
+	switch {
+	case h != nil:
+		return h.ServeModbus(ctx, unitID, req)
+	case unitKnown:
+		return nil, NewExceptionResponseTo(req, ExceptionCodeIllegalFunction)
+	case m.unknownUnitException != 0:
+		return nil, NewExceptionResponseTo(req, m.unknownUnitException)
+	default:
+		return nil, nil
+	}
+}
@@ -0,0 +1,156 @@
+package modbus_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/shasderias/modbus"
+	"github.com/shasderias/modbus/datamodel"
+	"github.com/shasderias/modbus/transport/tcp"
+)
+
+// named is a handler that fails with its name, so that tests can tell which
+// handler a request was routed to.
+type named string
+
+func (n named) ServeModbus(context.Context, byte, modbus.PDU) (modbus.PDU, error) {
+	return nil, errors.New(string(n))
+}
+
+func TestServeMux(t *testing.T) {
+	mux := modbus.NewServeMux()
+	mux.Handle(1, named("unit 1"))
+	mux.Handle(1, named("unit 1 coils"), modbus.FuncCodeReadCoils, modbus.FuncCodeWriteSingleCoil)
+	mux.Handle(2, named("unit 2 coils"), modbus.FuncCodeReadCoils)
+	mux.HandleRange(2, 9, named("units 2-9"))
+	mux.HandleRange(5, 15, named("units 5-15"))
+	mux.HandleRange(5, 15, named("units 5-15 holding"), modbus.FuncCodeReadHoldingRegisters)
+	mux.HandleRange(16, 20, named("units 16-20 coils"), modbus.FuncCodeReadCoils)
+
+	testCases := []struct {
+		unitID       byte
+		functionCode byte
+		want         string
+	}{
+		{1, modbus.FuncCodeReadHoldingRegisters, "unit 1"},
+		{1, modbus.FuncCodeWriteSingleCoil, "unit 1 coils"},
+		{2, modbus.FuncCodeReadCoils, "unit 2 coils"},
+		{2, modbus.FuncCodeReadHoldingRegisters, "units 2-9"},
+		{7, modbus.FuncCodeReadHoldingRegisters, "units 2-9"},
+		{12, modbus.FuncCodeReadHoldingRegisters, "units 5-15 holding"},
+		{12, modbus.FuncCodeReadInputRegisters, "units 5-15"},
+		{16, modbus.FuncCodeReadCoils, "units 16-20 coils"},
+		{16, modbus.FuncCodeReadInputRegisters, "illegal function"},
+		{21, modbus.FuncCodeReadCoils, "no response"},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+		t.Run(fmt.Sprintf("%d/0x%02x", tt.unitID, tt.functionCode), func(t *testing.T) {
+			req := must(modbus.NewRawPDU([]byte{tt.functionCode, 0, 0, 0, 1}))
+
+			resp, err := mux.ServeModbus(context.Background(), tt.unitID, req)
+			var got string
+			switch {
+			case errors.Is(err, modbus.ErrIllegalFunction):
+				got = "illegal function"
+			case err != nil:
+				got = err.Error()
+			case resp == nil:
+				got = "no response"
+			}
+			if got != tt.want {
+				t.Fatalf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServeMuxDefault(t *testing.T) {
+	mux := modbus.NewServeMux(func(c *modbus.ServeMuxConfig) {
+		c.UnknownUnitException = modbus.ExceptionCodeGatewayPathUnavailable
+	})
+	mux.Handle(1, named("unit 1"))
+
+	req := must(modbus.NewReadRegisterRequest(modbus.FuncCodeReadHoldingRegisters, 0, 1))
+	if _, err := mux.ServeModbus(context.Background(), 2, req); !errors.Is(err, modbus.ErrGatewayPathUnavailable) {
+		t.Fatalf("got %v; want ErrGatewayPathUnavailable", err)
+	}
+
+	mux.HandleDefault(named("default"))
+	if _, err := mux.ServeModbus(context.Background(), 2, req); err == nil || err.Error() != "default" {
+		t.Fatalf("got %v; want default", err)
+	}
+}
+
+func TestServeMuxConflict(t *testing.T) {
+	mux := modbus.NewServeMux()
+	mux.Handle(1, named("unit 1"), modbus.FuncCodeReadCoils)
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("got no panic registering a handler twice; want panic")
+		}
+	}()
+	mux.Handle(1, named("unit 1 again"), modbus.FuncCodeReadCoils)
+}
+
+// TestServeMuxGateway emulates a gateway with 20 slaves behind it, each with
+// its own data model, on one TCP server.
+func TestServeMuxGateway(t *testing.T) {
+	mux := modbus.NewServeMux(func(c *modbus.ServeMuxConfig) {
+		c.UnknownUnitException = modbus.ExceptionCodeGatewayTargetDeviceFailedToRespond
+	})
+	for unitID := byte(1); unitID <= 20; unitID++ {
+		mux.Handle(unitID, datamodel.New())
+	}
+
+	server, err := tcp.NewServer("127.0.0.1:0", mux)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport, err := tcp.NewClient(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Close()
+
+	client := func(unitID int) *modbus.Client {
+		c, err := modbus.NewClient(unitID, transport)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	for unitID := 1; unitID <= 20; unitID++ {
+		if _, err := client(unitID).WriteSingleRegister(0, uint16(unitID*100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for unitID := 1; unitID <= 20; unitID++ {
+		resp, err := client(unitID).ReadHoldingRegisters(0, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := resp.Uint16()[0], uint16(unitID*100); got != want {
+			t.Fatalf("got %d from unit %d; want %d", got, unitID, want)
+		}
+	}
+
+	if _, err := client(21).ReadHoldingRegisters(0, 1); !errors.Is(err, modbus.ErrGatewayTargetDeviceFailedToRespond) {
+		t.Fatalf("got %v; want ErrGatewayTargetDeviceFailedToRespond", err)
+	}
+}
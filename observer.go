@@ -0,0 +1,105 @@
+package modbus
+
+import (
+	"errors"
+	"time"
+)
+
+// Outcome is the outcome of a request, as reported to an Observer.
+type Outcome int
+
+const (
+	// OutcomeSuccess is a request that was answered with a normal response,
+	// or a broadcast request that was sent.
+	OutcomeSuccess Outcome = iota
+	// OutcomeException is a request that was answered with an exception
+	// response.
+	OutcomeException
+	// OutcomeTimeout is a request that was not answered in time.
+	OutcomeTimeout
+	// OutcomeBadCRC is a request whose response failed the CRC check.
+	OutcomeBadCRC
+	// OutcomeTransportError is a request that failed for any other reason.
+	OutcomeTransportError
+)
+
+var outcomeNames = [...]string{
+	OutcomeSuccess:        "success",
+	OutcomeException:      "exception",
+	OutcomeTimeout:        "timeout",
+	OutcomeBadCRC:         "bad_crc",
+	OutcomeTransportError: "transport_error",
+}
+
+func (o Outcome) String() string {
+	if o < 0 || int(o) >= len(outcomeNames) {
+		return "unknown"
+	}
+	return outcomeNames[o]
+}
+
+// RequestEvent describes a request that completed, successfully or not.
+type RequestEvent struct {
+	UnitID       byte
+	FunctionCode byte
+
+	// RequestBytes and ResponseBytes are the lengths of the request and
+	// response. Transports report the lengths of the frames they sent and
+	// received, Client the lengths of the PDUs. ResponseBytes is 0 if no
+	// valid response was received.
+	RequestBytes, ResponseBytes int
+
+	Latency time.Duration
+	Outcome Outcome
+	// ExceptionCode is the exception code of the response, if Outcome is
+	// OutcomeException.
+	ExceptionCode byte
+	// Err is the error the request failed with, if Outcome is
+	// OutcomeTimeout, OutcomeBadCRC or OutcomeTransportError. For requests
+	// a server handled, it is the error of the handler, if any.
+	Err error
+}
+
+// Observer is notified of every request a Client or client transport sends,
+// or, with middleware.Observe, a server handles. ObserveRequest is called
+// synchronously, after the request completes, and may be called
+// concurrently; implementations must be quick and safe for concurrent use.
+type Observer interface {
+	ObserveRequest(e RequestEvent)
+}
+
+// ObserverFunc adapts a function to an Observer.
+type ObserverFunc func(e RequestEvent)
+
+func (f ObserverFunc) ObserveRequest(e RequestEvent) { f(e) }
+
+// ClassifyOutcome returns the outcome of a request that returned resp and
+// err, and the exception code if the outcome is OutcomeException.
+//
+// Errors for which IsTimeout reports true are timeouts, ErrBadCRC errors are
+// CRC errors and *ExceptionResponse errors are exceptions.
+func ClassifyOutcome(resp PDU, err error) (Outcome, byte) {
+	if err != nil {
+		var (
+			crcErr    ErrBadCRC
+			exception *ExceptionResponse
+		)
+		switch {
+		case errors.As(err, &exception):
+			return OutcomeException, exception.exceptionCode
+		case errors.As(err, &crcErr):
+			return OutcomeBadCRC, 0
+		case IsTimeout(err):
+			return OutcomeTimeout, 0
+		default:
+			return OutcomeTransportError, 0
+		}
+	}
+
+	if resp != nil && resp.FunctionCode()&0x80 != 0 {
+		if b, err := resp.MarshalBinary(); err == nil && len(b) == 2 {
+			return OutcomeException, b[1]
+		}
+	}
+	return OutcomeSuccess, 0
+}
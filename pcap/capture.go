@@ -0,0 +1,200 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/shasderias/modbus"
+	"github.com/shasderias/modbus/internal/crc"
+)
+
+type CaptureConfig struct {
+	// Client and Server are the endpoints of the TCP connection the
+	// exchanges are written as, in captures that are not of link type
+	// LinkTypeRTU. Default to 127.0.0.1:49152 and 127.0.0.1:502.
+	Client, Server netip.AddrPort
+}
+
+// Capture is a modbus.ClientTransport that writes every request it passes to
+// its underlying transport, and the response, to a capture.
+//
+// If the capture is of link type LinkTypeRTU, exchanges are written as RTU
+// frames. If it is of link type LinkTypeEthernet or LinkTypeRaw, exchanges are
+// written as Modbus/TCP frames on a TCP connection between the configured
+// endpoints, whatever the underlying transport. Transaction identifiers are
+// assigned by the Capture, and need not match those the transport sends.
+type Capture struct {
+	conf CaptureConfig
+	t    modbus.ClientTransport
+	w    *Writer
+
+	mut       sync.Mutex
+	err       error
+	connected bool
+	txID      uint16
+	// clientSeq and serverSeq are the next sequence numbers of the
+	// synthesized connection
+	clientSeq, serverSeq uint32
+}
+
+func NewCapture(t modbus.ClientTransport, w *Writer, fns ...func(c *CaptureConfig)) *Capture {
+	conf := CaptureConfig{
+		Client: netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), 49152),
+		Server: netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), 502),
+	}
+	for _, fn := range fns {
+		fn(&conf)
+	}
+
+	return &Capture{
+		conf: conf,
+		t:    t,
+		w:    w,
+	}
+}
+
+func (c *Capture) WriteRequest(unitID byte, req modbus.PDU) (modbus.PDU, error) {
+	start := time.Now()
+	resp, err := c.t.WriteRequest(unitID, req)
+	end := time.Now()
+
+	c.capture(start, end, unitID, req, resp)
+
+	return resp, err
+}
+
+func (c *Capture) capture(start, end time.Time, unitID byte, req, resp modbus.PDU) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if c.err != nil {
+		return
+	}
+
+	reqBytes, err := req.MarshalBinary()
+	if err != nil {
+		c.err = fmt.Errorf("pcap: error marshalling request: %w", err)
+		return
+	}
+	var respBytes []byte
+	if resp != nil {
+		if respBytes, err = resp.MarshalBinary(); err != nil {
+			c.err = fmt.Errorf("pcap: error marshalling response: %w", err)
+			return
+		}
+	}
+
+	if c.w.LinkType() == LinkTypeRTU {
+		c.err = c.writeRTU(start, end, unitID, reqBytes, respBytes)
+	} else {
+		c.err = c.writeTCP(start, end, unitID, reqBytes, respBytes)
+	}
+}
+
+func (c *Capture) writeRTU(start, end time.Time, unitID byte, req, resp []byte) error {
+	frame := func(pdu []byte) []byte {
+		b := append([]byte{unitID}, pdu...)
+		return binary.LittleEndian.AppendUint16(b, crc.Checksum(b))
+	}
+
+	if err := c.w.WritePacket(start, frame(req)); err != nil {
+		return err
+	}
+	if resp == nil {
+		return nil
+	}
+	return c.w.WritePacket(end, frame(resp))
+}
+
+func (c *Capture) writeTCP(start, end time.Time, unitID byte, req, resp []byte) error {
+	if !c.connected {
+		if err := c.handshake(start); err != nil {
+			return err
+		}
+		c.connected = true
+	}
+
+	c.txID++
+	frame := func(pdu []byte) []byte {
+		b := make([]byte, mbapHeaderLength, mbapHeaderLength+len(pdu))
+		binary.BigEndian.PutUint16(b[0:2], c.txID)
+		binary.BigEndian.PutUint16(b[4:6], uint16(1+len(pdu)))
+		b[6] = unitID
+		return append(b, pdu...)
+	}
+
+	if err := c.writeSegment(start, c.conf.Client, c.conf.Server, tcpFlagPSH|tcpFlagACK, frame(req)); err != nil {
+		return err
+	}
+	if resp == nil {
+		return nil
+	}
+	return c.writeSegment(end, c.conf.Server, c.conf.Client, tcpFlagPSH|tcpFlagACK, frame(resp))
+}
+
+// handshake writes the segments that open the connection, so that tools
+// analysing the capture see the whole connection.
+func (c *Capture) handshake(t time.Time) error {
+	c.clientSeq, c.serverSeq = 1000, 2000
+
+	if err := c.writeSegment(t, c.conf.Client, c.conf.Server, tcpFlagSYN, nil); err != nil {
+		return err
+	}
+	if err := c.writeSegment(t, c.conf.Server, c.conf.Client, tcpFlagSYN|tcpFlagACK, nil); err != nil {
+		return err
+	}
+	return c.writeSegment(t, c.conf.Client, c.conf.Server, tcpFlagACK, nil)
+}
+
+// writeSegment writes a segment from src to dst and advances the sequence
+// numbers.
+func (c *Capture) writeSegment(t time.Time, src, dst netip.AddrPort, flags byte, payload []byte) error {
+	seq, ack := &c.clientSeq, &c.serverSeq
+	if src == c.conf.Server {
+		seq, ack = ack, seq
+	}
+
+	seg := segment{
+		src:     src,
+		dst:     dst,
+		seq:     *seq,
+		flags:   flags,
+		payload: payload,
+	}
+	if flags&tcpFlagACK != 0 {
+		seg.ack = *ack
+	}
+
+	packet, err := marshalSegment(c.w.LinkType(), seg)
+	if err != nil {
+		return err
+	}
+	if err := c.w.WritePacket(t, packet); err != nil {
+		return err
+	}
+
+	*seq += uint32(len(payload))
+	if flags&tcpFlagSYN != 0 {
+		*seq++
+	}
+	return nil
+}
+
+// Err returns the first error encountered while capturing. Requests are still
+// passed to the underlying transport after an error, but are no longer
+// captured.
+func (c *Capture) Err() error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	return c.err
+}
+
+// Close closes the underlying transport. The writer the Capture writes to is
+// not closed.
+func (c *Capture) Close() error {
+	return c.t.Close()
+}
@@ -0,0 +1,519 @@
+package pcap
+
+import (
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"time"
+
+	"github.com/shasderias/modbus"
+	"github.com/shasderias/modbus/internal/crc"
+)
+
+type Direction int
+
+const (
+	DirectionUnknown Direction = iota
+	DirectionRequest
+	DirectionResponse
+	// DirectionNoResponse marks the records the Decoder emits for Modbus/TCP
+	// requests it stopped awaiting a response to. They have no PDU or Frame;
+	// their Request is the request.
+	DirectionNoResponse
+)
+
+func (d Direction) String() string {
+	switch d {
+	case DirectionRequest:
+		return "request"
+	case DirectionResponse:
+		return "response"
+	case DirectionNoResponse:
+		return "no response"
+	default:
+		return "unknown"
+	}
+}
+
+// Frame is a Modbus frame decoded from a capture.
+type Frame struct {
+	// Time is when the packet that completed the frame was captured.
+	Time      time.Time
+	Direction Direction
+
+	// Client and Server are the endpoints of the TCP connection the frame was
+	// sent on. They are not valid for RTU frames.
+	Client, Server netip.AddrPort
+
+	// TransactionID is the transaction identifier from the MBAP header. It is
+	// 0 for RTU frames.
+	TransactionID uint16
+
+	// UnitID is the unit identifier from the MBAP header, or the slave
+	// address of an RTU frame.
+	UnitID byte
+
+	// PDU is the frame's PDU, decoded with modbus.DecodeRequest or
+	// modbus.DecodeResponse. It is nil if the frame is corrupt, and a
+	// *modbus.RawPDU if it could not be decoded.
+	PDU modbus.PDU
+
+	// Frame is the frame as captured: the MBAP header and PDU, or the RTU
+	// frame including the slave address and CRC.
+	Frame []byte
+
+	// Request is the request a response answers, or the request of a
+	// DirectionNoResponse record. It is nil for requests and for responses
+	// that could not be paired with a request.
+	Request *Frame
+
+	// Err is set if the frame is corrupt or its PDU could not be decoded.
+	Err error
+}
+
+// String describes the frame, such as
+//
+//	192.0.2.1:49152 > 192.0.2.2:502 tx 1 unit 1: Read Holding Registers (0x03) request: address 0, count 3
+//
+// or, for RTU frames,
+//
+//	slave 1: Read Holding Registers (0x03) request: address 0, count 3
+//
+// or, for DirectionNoResponse records,
+//
+//	192.0.2.1:49152 > 192.0.2.2:502 tx 1 unit 1: no response to Read Holding Registers (0x03) request: address 0, count 3
+func (f *Frame) String() string {
+	if f.Direction == DirectionNoResponse {
+		return fmt.Sprintf("%s > %s tx %d unit %d: no response to %s",
+			f.Client, f.Server, f.TransactionID, f.UnitID, modbus.Describe(f.Request.PDU))
+	}
+
+	var s string
+	switch {
+	case !f.Client.IsValid():
+		s = fmt.Sprintf("slave %d: ", f.UnitID)
+	case f.Direction == DirectionResponse:
+		s = fmt.Sprintf("%s > %s tx %d unit %d: ", f.Server, f.Client, f.TransactionID, f.UnitID)
+	default:
+		s = fmt.Sprintf("%s > %s tx %d unit %d: ", f.Client, f.Server, f.TransactionID, f.UnitID)
+	}
+
+	if f.PDU != nil {
+		s += modbus.Describe(f.PDU)
+	} else {
+		s += fmt.Sprintf("%s frame % x", f.Direction, f.Frame)
+	}
+	if f.Err != nil {
+		s += fmt.Sprintf(" (%v)", f.Err)
+	}
+	return s
+}
+
+type DecoderConfig struct {
+	// Port is the TCP port of Modbus servers. Segments sent to it are
+	// requests and segments sent from it are responses; other segments are
+	// ignored. Defaults to 502.
+	Port uint16
+
+	// MaxPending is the maximum number of Modbus/TCP requests awaiting a
+	// response. Once it is exceeded, the oldest is no longer awaited.
+	// Defaults to 1024.
+	MaxPending int
+
+	// PendingTimeout is how long, in capture time, a Modbus/TCP request
+	// awaits a response. Defaults to 1 minute.
+	PendingTimeout time.Duration
+}
+
+// Decoder decodes the Modbus frames in a capture.
+//
+// Modbus/TCP streams are reassembled from their segments, retransmissions
+// and segments captured out of order included, and responses are paired with
+// requests by transaction identifier. RTU frames are read from captures with
+// link type LinkTypeRTU, one frame per packet; a frame is taken to be the
+// response to the preceding request if it is from the same slave and has
+// the same function code.
+//
+// A Modbus/TCP request is no longer awaited once it is older than
+// PendingTimeout, once MaxPending newer requests await a response, or once
+// its transaction identifier is reused, so that captures of lossy networks
+// are decoded in bounded memory. The Decoder then emits a
+// DirectionNoResponse record for it, and a late response to it is not
+// paired with it.
+type Decoder struct {
+	conf DecoderConfig
+	r    *Reader
+
+	streams map[flow]*stream
+	// pending holds the elements of pendingOrder, the Modbus/TCP requests
+	// awaiting a response, oldest first
+	pending      map[transaction]*list.Element
+	pendingOrder *list.List
+	// pendingRTU is the last RTU request that has not been answered
+	pendingRTU *Frame
+
+	frames []*Frame
+}
+
+type flow struct {
+	src, dst netip.AddrPort
+}
+
+type transaction struct {
+	client, server netip.AddrPort
+	txID           uint16
+}
+
+func NewDecoder(r *Reader, fns ...func(c *DecoderConfig)) *Decoder {
+	conf := DecoderConfig{
+		Port:           502,
+		MaxPending:     1024,
+		PendingTimeout: time.Minute,
+	}
+	for _, fn := range fns {
+		fn(&conf)
+	}
+
+	return &Decoder{
+		conf:         conf,
+		r:            r,
+		streams:      make(map[flow]*stream),
+		pending:      make(map[transaction]*list.Element),
+		pendingOrder: list.New(),
+	}
+}
+
+// Next returns the next frame in the capture, or io.EOF at the end of the
+// capture. Packets that cannot be parsed, and TCP segments that are not to
+// or from the Modbus port, are skipped.
+func (d *Decoder) Next() (*Frame, error) {
+	for len(d.frames) == 0 {
+		p, err := d.r.Next()
+		if err != nil {
+			return nil, err
+		}
+		d.decodePacket(p)
+	}
+
+	f := d.frames[0]
+	d.frames = d.frames[1:]
+	return f, nil
+}
+
+// ReadFrames reads the capture from r and returns the Modbus frames in it.
+func ReadFrames(r io.Reader, fns ...func(c *DecoderConfig)) ([]*Frame, error) {
+	pr, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		d      = NewDecoder(pr, fns...)
+		frames []*Frame
+	)
+	for {
+		f, err := d.Next()
+		if errors.Is(err, io.EOF) {
+			return frames, nil
+		} else if err != nil {
+			return frames, err
+		}
+		frames = append(frames, f)
+	}
+}
+
+func (d *Decoder) decodePacket(p Packet) {
+	if p.LinkType == LinkTypeRTU {
+		d.decodeRTU(p.Time, p.Data)
+		return
+	}
+
+	seg, err := parseSegment(p.LinkType, p.Data)
+	if err != nil {
+		return
+	}
+
+	d.expirePending(p.Time)
+
+	var dir Direction
+	switch {
+	case seg.dst.Port() == d.conf.Port:
+		dir = DirectionRequest
+	case seg.src.Port() == d.conf.Port:
+		dir = DirectionResponse
+	default:
+		return
+	}
+
+	key := flow{seg.src, seg.dst}
+	s, ok := d.streams[key]
+	if !ok {
+		s = &stream{}
+		d.streams[key] = s
+	}
+
+	s.add(seg)
+	for _, frame := range s.frames() {
+		d.decodeMBAP(p.Time, dir, seg.src, seg.dst, frame)
+	}
+
+	if seg.flags&(tcpFlagFIN|tcpFlagRST) != 0 {
+		delete(d.streams, key)
+	}
+}
+
+func (d *Decoder) decodeMBAP(t time.Time, dir Direction, src, dst netip.AddrPort, frame []byte) {
+	f := &Frame{
+		Time:      t,
+		Direction: dir,
+		Client:    src,
+		Server:    dst,
+		Frame:     frame,
+	}
+	if dir == DirectionResponse {
+		f.Client, f.Server = dst, src
+	}
+	d.frames = append(d.frames, f)
+
+	if len(frame) < mbapHeaderLength+1 ||
+		binary.BigEndian.Uint16(frame[2:4]) != 0 ||
+		int(binary.BigEndian.Uint16(frame[4:6]))+6 != len(frame) {
+		f.Err = fmt.Errorf("pcap: invalid MBAP frame: %x", frame)
+		return
+	}
+	f.TransactionID = binary.BigEndian.Uint16(frame[0:2])
+	f.UnitID = frame[6]
+
+	raw, err := modbus.NewRawPDU(frame[7:])
+	if err != nil {
+		f.Err = err
+		return
+	}
+
+	tx := transaction{f.Client, f.Server, f.TransactionID}
+	switch dir {
+	case DirectionRequest:
+		f.PDU, f.Err = modbus.DecodeRequest(raw)
+		if _, ok := d.pending[tx]; ok {
+			d.abandon(t, tx)
+		}
+		d.pending[tx] = d.pendingOrder.PushBack(f)
+		if len(d.pending) > d.conf.MaxPending {
+			d.abandon(t, pendingTransaction(d.pendingOrder.Front()))
+		}
+	case DirectionResponse:
+		f.PDU, f.Err = modbus.DecodeResponse(raw)
+		if e, ok := d.pending[tx]; ok {
+			f.Request = e.Value.(*Frame)
+			d.pendingOrder.Remove(e)
+			delete(d.pending, tx)
+		}
+	}
+	if f.Err != nil {
+		f.PDU = raw
+	}
+}
+
+// expirePending stops awaiting a response to the Modbus/TCP requests older
+// than PendingTimeout at t.
+func (d *Decoder) expirePending(t time.Time) {
+	for e := d.pendingOrder.Front(); e != nil; e = d.pendingOrder.Front() {
+		if t.Sub(e.Value.(*Frame).Time) <= d.conf.PendingTimeout {
+			return
+		}
+		d.abandon(t, pendingTransaction(e))
+	}
+}
+
+// abandon stops awaiting a response to the pending request of tx and emits a
+// DirectionNoResponse record for it, at t.
+func (d *Decoder) abandon(t time.Time, tx transaction) {
+	e := d.pending[tx]
+	d.pendingOrder.Remove(e)
+	delete(d.pending, tx)
+
+	req := e.Value.(*Frame)
+	d.frames = append(d.frames, &Frame{
+		Time:          t,
+		Direction:     DirectionNoResponse,
+		Client:        req.Client,
+		Server:        req.Server,
+		TransactionID: req.TransactionID,
+		UnitID:        req.UnitID,
+		Request:       req,
+	})
+}
+
+// pendingTransaction returns the transaction of the pending request e.
+func pendingTransaction(e *list.Element) transaction {
+	req := e.Value.(*Frame)
+	return transaction{req.Client, req.Server, req.TransactionID}
+}
+
+func (d *Decoder) decodeRTU(t time.Time, data []byte) {
+	f := &Frame{
+		Time:      t,
+		Direction: DirectionRequest,
+		Frame:     append([]byte(nil), data...),
+	}
+	d.frames = append(d.frames, f)
+
+	if len(data) < 4 {
+		f.Err = fmt.Errorf("pcap: RTU frame too short: %x", data)
+		return
+	}
+	f.UnitID = data[0]
+
+	var (
+		funcCode = data[1]
+		gotCRC   = binary.LittleEndian.Uint16(data[len(data)-2:])
+		wantCRC  = crc.Checksum(data[:len(data)-2])
+	)
+	if gotCRC != wantCRC {
+		f.Err = modbus.ErrBadCRC{Got: gotCRC, Want: wantCRC, Frame: f.Frame}
+		return
+	}
+
+	raw, err := modbus.NewRawPDU(f.Frame[1 : len(f.Frame)-2])
+	if err != nil {
+		f.Err = err
+		return
+	}
+
+	req := d.pendingRTU
+	if req != nil && req.UnitID == f.UnitID && req.PDU.FunctionCode() == funcCode&0x7f {
+		f.Direction = DirectionResponse
+		f.Request = req
+		d.pendingRTU = nil
+		f.PDU, f.Err = modbus.DecodeResponse(raw)
+	} else {
+		f.PDU, f.Err = modbus.DecodeRequest(raw)
+		d.pendingRTU = nil
+		if f.UnitID != 0 {
+			d.pendingRTU = f
+		}
+	}
+	if f.Err != nil {
+		f.PDU = raw
+	}
+}
+
+const (
+	// maxPendingSegments bounds how many segments received out of order are
+	// held while waiting for the segments before them. Beyond it, the
+	// missing segments are assumed to have been dropped by the capture.
+	maxPendingSegments = 64
+
+	mbapHeaderLength = 7
+	maxMBAPLength    = 256 + mbapHeaderLength
+)
+
+// stream reassembles the data sent in one direction of a TCP connection.
+type stream struct {
+	synced bool
+	// next is the sequence number of the next byte expected
+	next uint32
+	// pending holds segments received out of order, by sequence number
+	pending map[uint32][]byte
+	buf     []byte
+}
+
+func (s *stream) add(seg segment) {
+	if seg.flags&tcpFlagSYN != 0 {
+		*s = stream{synced: true, next: seg.seq + 1}
+		return
+	}
+	if len(seg.payload) == 0 {
+		return
+	}
+	if !s.synced {
+		// the capture started mid-connection
+		s.synced, s.next = true, seg.seq
+	}
+
+	if int32(seg.seq-s.next) > 0 {
+		if s.pending == nil {
+			s.pending = make(map[uint32][]byte)
+		}
+		s.pending[seg.seq] = append([]byte(nil), seg.payload...)
+		if len(s.pending) > maxPendingSegments {
+			s.skipGap()
+		}
+	} else {
+		s.append(seg.seq, seg.payload)
+	}
+
+	for s.drainPending() {
+	}
+}
+
+// append appends the part of payload, which starts at sequence number seq,
+// that has not already been received.
+func (s *stream) append(seq uint32, payload []byte) {
+	if overlap := s.next - seq; int32(overlap) > 0 {
+		if int(overlap) >= len(payload) {
+			return // retransmission
+		}
+		payload = payload[overlap:]
+	}
+	s.buf = append(s.buf, payload...)
+	s.next += uint32(len(payload))
+}
+
+// drainPending appends a pending segment that is no longer out of order and
+// reports whether it found one.
+func (s *stream) drainPending() bool {
+	for seq, payload := range s.pending {
+		if int32(seq-s.next) <= 0 {
+			delete(s.pending, seq)
+			s.append(seq, payload)
+			return true
+		}
+	}
+	return false
+}
+
+// skipGap gives up on the missing data before the earliest pending segment.
+// The frame being reassembled is discarded, as its remainder is lost.
+func (s *stream) skipGap() {
+	first, found := uint32(0), false
+	for seq := range s.pending {
+		if !found || int32(seq-first) < 0 {
+			first, found = seq, true
+		}
+	}
+	s.next = first
+	s.buf = nil
+}
+
+// frames removes complete MBAP frames from the reassembled data and returns
+// them. If the data is not a valid MBAP frame, the data is returned as is
+// and discarded so that the stream can resynchronise on later segments.
+func (s *stream) frames() [][]byte {
+	var frames [][]byte
+	for len(s.buf) >= mbapHeaderLength {
+		var (
+			protocolID = binary.BigEndian.Uint16(s.buf[2:4])
+			length     = int(binary.BigEndian.Uint16(s.buf[4:6]))
+			frameLen   = 6 + length
+		)
+		if protocolID != 0 || length < 2 || frameLen > maxMBAPLength {
+			frames = append(frames, s.buf)
+			s.buf = nil
+			break
+		}
+		if len(s.buf) < frameLen {
+			break
+		}
+
+		frames = append(frames, append([]byte(nil), s.buf[:frameLen]...))
+		s.buf = s.buf[frameLen:]
+	}
+	if len(s.buf) == 0 {
+		s.buf = nil
+	}
+	return frames
+}
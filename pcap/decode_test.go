@@ -0,0 +1,339 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var (
+	testClient = netip.MustParseAddrPort("192.0.2.1:49152")
+	testServer = netip.MustParseAddrPort("192.0.2.2:502")
+)
+
+func TestReassembly(t *testing.T) {
+	var (
+		req1 = []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x01}
+		req2 = []byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x01, 0x00, 0x01}
+		resp = []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x05, 0x01, 0x03, 0x02, 0x00, 0x2a}
+	)
+	toServer := func(seq uint32, payload []byte) segment {
+		return segment{src: testClient, dst: testServer, seq: seq, flags: tcpFlagACK, payload: payload}
+	}
+	toClient := func(seq uint32, payload []byte) segment {
+		return segment{src: testServer, dst: testClient, seq: seq, flags: tcpFlagACK, payload: payload}
+	}
+	// without a SYN, a stream starts at the first segment captured
+	syn := segment{src: testClient, dst: testServer, seq: 99, flags: tcpFlagSYN}
+	both := append(append([]byte(nil), req1...), req2...)
+
+	testCases := []struct {
+		name     string
+		segments []segment
+		want     [][]byte
+	}{
+		{
+			"InOrder",
+			[]segment{toServer(100, req1), toServer(112, req2)},
+			[][]byte{req1, req2},
+		},
+		{
+			"Coalesced",
+			[]segment{toServer(100, both)},
+			[][]byte{req1, req2},
+		},
+		{
+			"Split",
+			[]segment{toServer(100, both[:3]), toServer(103, both[3:15]), toServer(115, both[15:])},
+			[][]byte{req1, req2},
+		},
+		{
+			"OutOfOrder",
+			[]segment{syn, toServer(112, req2), toServer(105, req1[5:]), toServer(100, req1[:5])},
+			[][]byte{req1, req2},
+		},
+		{
+			"Retransmitted",
+			[]segment{toServer(100, req1), toServer(100, req1), toServer(106, both[6:])},
+			[][]byte{req1, req2},
+		},
+		{
+			"SequenceWraparound",
+			[]segment{toServer(0xfffffffa, req1), toServer(6, req2)},
+			[][]byte{req1, req2},
+		},
+		{
+			"Handshake",
+			[]segment{syn, toServer(100, req1), toClient(500, resp)},
+			[][]byte{req1, resp},
+		},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, LinkTypeRaw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, seg := range tt.segments {
+				packet, err := marshalSegment(LinkTypeRaw, seg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := w.WritePacket(time.Now(), packet); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			frames, err := ReadFrames(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got [][]byte
+			for _, f := range frames {
+				if f.Err != nil {
+					t.Fatal(f.Err)
+				}
+				got = append(got, f.Frame)
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestPendingEviction(t *testing.T) {
+	req := func(txID byte) []byte {
+		return []byte{0x00, txID, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x01}
+	}
+	resp := func(txID byte) []byte {
+		return []byte{0x00, txID, 0x00, 0x00, 0x00, 0x05, 0x01, 0x03, 0x02, 0x00, 0x2a}
+	}
+	type packet struct {
+		after   time.Duration
+		src     netip.AddrPort
+		payload []byte
+	}
+	type record struct {
+		Direction     Direction
+		TransactionID uint16
+		Paired        bool
+	}
+
+	testCases := []struct {
+		name    string
+		packets []packet
+		want    []record
+	}{
+		{
+			"MaxPending",
+			[]packet{{0, testClient, req(1)}, {0, testClient, req(2)}, {0, testClient, req(3)}, {0, testServer, resp(1)}, {0, testServer, resp(3)}},
+			[]record{
+				{DirectionRequest, 1, false},
+				{DirectionRequest, 2, false},
+				{DirectionRequest, 3, false},
+				{DirectionNoResponse, 1, true},
+				{DirectionResponse, 1, false},
+				{DirectionResponse, 3, true},
+			},
+		},
+		{
+			"PendingTimeout",
+			[]packet{{0, testClient, req(1)}, {time.Second, testClient, req(2)}, {2 * time.Second, testServer, resp(2)}},
+			[]record{
+				{DirectionRequest, 1, false},
+				{DirectionRequest, 2, false},
+				{DirectionNoResponse, 1, true},
+				{DirectionResponse, 2, true},
+			},
+		},
+		{
+			"ReusedTransactionID",
+			[]packet{{0, testClient, req(1)}, {0, testClient, req(1)}, {0, testServer, resp(1)}},
+			[]record{
+				{DirectionRequest, 1, false},
+				{DirectionRequest, 1, false},
+				{DirectionNoResponse, 1, true},
+				{DirectionResponse, 1, true},
+			},
+		},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, LinkTypeRaw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			start := time.Unix(0, 0)
+			seq := map[netip.AddrPort]uint32{testClient: 100, testServer: 500}
+			for _, p := range tt.packets {
+				dst := testServer
+				if p.src == testServer {
+					dst = testClient
+				}
+				packet, err := marshalSegment(LinkTypeRaw, segment{src: p.src, dst: dst, seq: seq[p.src], flags: tcpFlagACK, payload: p.payload})
+				if err != nil {
+					t.Fatal(err)
+				}
+				seq[p.src] += uint32(len(p.payload))
+				if err := w.WritePacket(start.Add(p.after), packet); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			frames, err := ReadFrames(&buf, func(c *DecoderConfig) {
+				c.MaxPending = 2
+				c.PendingTimeout = 1500 * time.Millisecond
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []record
+			for _, f := range frames {
+				if f.Err != nil {
+					t.Fatal(f.Err)
+				}
+				got = append(got, record{f.Direction, f.TransactionID, f.Request != nil})
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestReader(t *testing.T) {
+	var (
+		packet = []byte{0xde, 0xad, 0xbe, 0xef}
+		ts     = time.Unix(1700000000, 123456789)
+	)
+
+	pcapFile := func(order binary.AppendByteOrder, magic uint32, frac uint32) []byte {
+		var b []byte
+		b = order.AppendUint32(b, magic)
+		b = order.AppendUint16(b, 2)
+		b = order.AppendUint16(b, 4)
+		b = append(b, make([]byte, 8)...)
+		b = order.AppendUint32(b, snapLen)
+		b = order.AppendUint32(b, uint32(LinkTypeRaw))
+		b = order.AppendUint32(b, uint32(ts.Unix()))
+		b = order.AppendUint32(b, frac)
+		b = order.AppendUint32(b, uint32(len(packet)))
+		b = order.AppendUint32(b, uint32(len(packet)))
+		return append(b, packet...)
+	}
+
+	block := func(order binary.AppendByteOrder, blockType uint32, body []byte) []byte {
+		length := uint32(12 + len(body))
+		var b []byte
+		b = order.AppendUint32(b, blockType)
+		b = order.AppendUint32(b, length)
+		b = append(b, body...)
+		return order.AppendUint32(b, length)
+	}
+	pcapngFile := func(order binary.AppendByteOrder, tsresol byte, tsUnits uint64) []byte {
+		var shb []byte
+		shb = order.AppendUint32(shb, pcapngByteOrderMagic)
+		shb = order.AppendUint16(shb, 1)
+		shb = order.AppendUint16(shb, 0)
+		shb = order.AppendUint64(shb, 0xffffffffffffffff)
+
+		var idb []byte
+		idb = order.AppendUint16(idb, uint16(LinkTypeRaw))
+		idb = order.AppendUint16(idb, 0)
+		idb = order.AppendUint32(idb, 0)
+		idb = order.AppendUint16(idb, pcapngOptionIfTsresol)
+		idb = order.AppendUint16(idb, 1)
+		idb = append(idb, tsresol, 0, 0, 0)
+		idb = order.AppendUint16(idb, pcapngOptionEnd)
+		idb = order.AppendUint16(idb, 0)
+
+		var epb []byte
+		epb = order.AppendUint32(epb, 0)
+		epb = order.AppendUint32(epb, uint32(tsUnits>>32))
+		epb = order.AppendUint32(epb, uint32(tsUnits))
+		epb = order.AppendUint32(epb, uint32(len(packet)))
+		epb = order.AppendUint32(epb, uint32(len(packet)))
+		epb = append(epb, packet...)
+
+		// a name resolution block, which is skipped
+		nrb := order.AppendUint32(nil, 0)
+
+		var b []byte
+		b = append(b, block(order, pcapngSectionHeader, shb)...)
+		b = append(b, block(order, pcapngInterfaceDesc, idb)...)
+		b = append(b, block(order, 0x00000004, nrb)...)
+		b = append(b, block(order, pcapngEnhancedPacket, epb)...)
+		return b
+	}
+
+	testCases := []struct {
+		name string
+		file []byte
+		want time.Time
+	}{
+		{"PcapLittleEndianMicroseconds", pcapFile(binary.LittleEndian, pcapMagicMicroseconds, 123456), ts.Truncate(time.Microsecond)},
+		{"PcapBigEndianNanoseconds", pcapFile(binary.BigEndian, pcapMagicNanoseconds, 123456789), ts},
+		{"PcapngLittleEndian", pcapngFile(binary.LittleEndian, 6, uint64(ts.UnixNano()/1000)), ts.Truncate(time.Microsecond)},
+		{"PcapngBigEndianNanoseconds", pcapngFile(binary.BigEndian, 9, uint64(ts.UnixNano())), ts},
+		// 2^-40 second units, in which ts is out of range
+		{"PcapngBinaryResolution", pcapngFile(binary.LittleEndian, 0x80|40, 1000<<40|1<<39), time.Unix(1000, 500000000)},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewReader(bytes.NewReader(tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := r.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := Packet{Time: tt.want, LinkType: LinkTypeRaw, Data: packet, Length: len(packet)}
+			if diff := cmp.Diff(got, want); diff != "" {
+				t.Fatal(diff)
+			}
+
+			if _, err := r.Next(); err == nil {
+				t.Fatal("got nil; want io.EOF")
+			}
+		})
+	}
+}
+
+func TestParseInterfaceOptions(t *testing.T) {
+	testCases := []struct {
+		name    string
+		options []byte
+		want    uint64
+	}{
+		{"Padded", []byte{0x09, 0x00, 0x01, 0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, 1000},
+		{"UnpaddedLast", []byte{0x09, 0x00, 0x01, 0x00, 0x03}, 1000},
+		{"Truncated", []byte{0x09, 0x00, 0x02, 0x00, 0x03}, 1000000},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Reader{order: binary.LittleEndian}
+			iface := &pcapngInterface{tsPerSecond: 1000000}
+			r.parseInterfaceOptions(iface, tt.options)
+			if iface.tsPerSecond != tt.want {
+				t.Fatalf("got %d; want %d", iface.tsPerSecond, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package pcap_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/shasderias/modbus"
+	"github.com/shasderias/modbus/mbtest"
+	"github.com/shasderias/modbus/pcap"
+	"github.com/shasderias/modbus/simulator"
+	"github.com/shasderias/modbus/transport/rtu"
+	"github.com/shasderias/modbus/transport/tcp"
+)
+
+var testMap = simulator.Map{Units: []simulator.Unit{{ID: 1, Size: map[string]int{"holding": 8}}}}
+
+// poll is the traffic captured by the tests.
+func poll(t *testing.T, transport modbus.ClientTransport) {
+	t.Helper()
+
+	client, err := modbus.NewClient(1, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.WriteRegisters(0, []uint16{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ReadHoldingRegisters(0, 3); err != nil {
+		t.Fatal(err)
+	}
+	var exception *modbus.ExceptionResponse
+	if _, err := client.ReadHoldingRegisters(6, 4); !errors.As(err, &exception) {
+		t.Fatalf("got %v; want exception", err)
+	}
+}
+
+type summary struct {
+	Direction pcap.Direction
+	TxID      uint16
+	UnitID    byte
+	PDU       []byte
+	Paired    bool
+}
+
+func summarize(t *testing.T, frames []*pcap.Frame) []summary {
+	t.Helper()
+
+	var got []summary
+	for _, f := range frames {
+		if f.Err != nil {
+			t.Fatalf("frame %x: %v", f.Frame, f.Err)
+		}
+		if f.Time.IsZero() {
+			t.Fatal("frame not timestamped")
+		}
+		pdu, err := f.PDU.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, summary{f.Direction, f.TransactionID, f.UnitID, pdu, f.Request != nil})
+	}
+	return got
+}
+
+func TestCaptureTCP(t *testing.T) {
+	testCases := []struct {
+		name     string
+		linkType pcap.LinkType
+	}{
+		{"Ethernet", pcap.LinkTypeEthernet},
+		{"Raw", pcap.LinkTypeRaw},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			_, conn, stop := mbtest.StartSimulatorTCP(t, testMap)
+			defer stop()
+
+			client, err := tcp.NewClient(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			w, err := pcap.NewWriter(&buf, tt.linkType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			capture := pcap.NewCapture(client, w)
+			poll(t, capture)
+			if err := capture.Err(); err != nil {
+				t.Fatal(err)
+			}
+
+			frames, err := pcap.ReadFrames(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := []summary{
+				{pcap.DirectionRequest, 1, 1, []byte{0x10, 0x00, 0x00, 0x00, 0x03, 0x06, 0x00, 0x01, 0x00, 0x02, 0x00, 0x03}, false},
+				{pcap.DirectionResponse, 1, 1, []byte{0x10, 0x00, 0x00, 0x00, 0x03}, true},
+				{pcap.DirectionRequest, 2, 1, []byte{0x03, 0x00, 0x00, 0x00, 0x03}, false},
+				{pcap.DirectionResponse, 2, 1, []byte{0x03, 0x06, 0x00, 0x01, 0x00, 0x02, 0x00, 0x03}, true},
+				{pcap.DirectionRequest, 3, 1, []byte{0x03, 0x00, 0x06, 0x00, 0x04}, false},
+				{pcap.DirectionResponse, 3, 1, []byte{0x83, 0x02}, true},
+			}
+			if diff := cmp.Diff(summarize(t, frames), want); diff != "" {
+				t.Fatal(diff)
+			}
+
+			if got, want := frames[1].Server.Port(), uint16(502); got != want {
+				t.Fatalf("got server port %d; want %d", got, want)
+			}
+			if _, ok := frames[3].PDU.(*modbus.ReadRegisterResponse); !ok {
+				t.Fatalf("got %T; want *modbus.ReadRegisterResponse", frames[3].PDU)
+			}
+			if got, want := frames[2].String(), "127.0.0.1:49152 > 127.0.0.1:502 tx 2 unit 1: Read Holding Registers (0x03) request: address 0, count 3"; got != want {
+				t.Fatalf("got %q; want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestCaptureRTU(t *testing.T) {
+	_, port := mbtest.StartSimulatorRTU(t, testMap)
+
+	var buf bytes.Buffer
+	w, err := pcap.NewWriter(&buf, pcap.LinkTypeRTU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	capture := pcap.NewCapture(rtu.NewClient(port), w)
+	poll(t, capture)
+	if err := capture.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	frames, err := pcap.ReadFrames(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []summary{
+		{pcap.DirectionRequest, 0, 1, []byte{0x10, 0x00, 0x00, 0x00, 0x03, 0x06, 0x00, 0x01, 0x00, 0x02, 0x00, 0x03}, false},
+		{pcap.DirectionResponse, 0, 1, []byte{0x10, 0x00, 0x00, 0x00, 0x03}, true},
+		{pcap.DirectionRequest, 0, 1, []byte{0x03, 0x00, 0x00, 0x00, 0x03}, false},
+		{pcap.DirectionResponse, 0, 1, []byte{0x03, 0x06, 0x00, 0x01, 0x00, 0x02, 0x00, 0x03}, true},
+		{pcap.DirectionRequest, 0, 1, []byte{0x03, 0x00, 0x06, 0x00, 0x04}, false},
+		{pcap.DirectionResponse, 0, 1, []byte{0x83, 0x02}, true},
+	}
+	if diff := cmp.Diff(summarize(t, frames), want); diff != "" {
+		t.Fatal(diff)
+	}
+
+	if got, want := frames[5].String(), "slave 1: Read Holding Registers (0x83) exception: Illegal Data Address (0x02)"; got != want {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,322 @@
+// Package pcap reads and writes packet captures in the pcap and pcapng
+// formats, and decodes the Modbus traffic they contain. It does not depend on
+// libpcap.
+//
+// Modbus/TCP traffic is read from captures of Ethernet, Linux cooked, BSD
+// loopback or raw IP packets. TCP streams are reassembled, split into MBAP
+// frames, and requests are paired with their responses. Modbus RTU traffic is
+// read from and written to captures with link type LinkTypeRTU, where every
+// packet is an RTU frame, CRC included.
+//
+// To view RTU captures in Wireshark, map DLT_USER0 (147) to the mbrtu
+// protocol under Preferences > Protocols > DLT_USER.
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// LinkType is the type of the link layer header packets begin with.
+type LinkType uint16
+
+const (
+	LinkTypeNull     LinkType = 0   // BSD loopback
+	LinkTypeEthernet LinkType = 1   // Ethernet II
+	LinkTypeRaw      LinkType = 101 // raw IPv4 or IPv6
+	LinkTypeLinuxSLL LinkType = 113 // Linux cooked capture
+
+	// LinkTypeRTU is DLT_USER0, used by this package for Modbus RTU frames.
+	LinkTypeRTU LinkType = 147
+)
+
+// Packet is a packet read from a capture.
+type Packet struct {
+	Time     time.Time
+	LinkType LinkType
+	Data     []byte
+
+	// Length is the length of the packet on the wire, which is greater than
+	// len(Data) if the packet was truncated when it was captured.
+	Length int
+}
+
+const (
+	pcapMagicMicroseconds = 0xa1b2c3d4
+	pcapMagicNanoseconds  = 0xa1b23c4d
+
+	pcapngSectionHeader         = 0x0a0d0d0a
+	pcapngInterfaceDesc         = 0x00000001
+	pcapngSimplePacket          = 0x00000003
+	pcapngEnhancedPacket        = 0x00000006
+	pcapngByteOrderMagic        = 0x1a2b3c4d
+	pcapngByteOrderMagicSwapped = 0x4d3c2b1a
+	pcapngOptionEnd             = 0
+	pcapngOptionIfTsresol       = 9
+	maxBlockLength              = 16 << 20
+	defaultTimestampsPerSecond  = 1e6
+)
+
+// Reader reads packets from a pcap or pcapng capture.
+type Reader struct {
+	r *bufio.Reader
+
+	// pcapng is true for pcapng captures
+	pcapng bool
+	order  binary.ByteOrder
+
+	// pcap
+	linkType    LinkType
+	nanoseconds bool
+
+	// pcapng
+	interfaces []pcapngInterface
+}
+
+type pcapngInterface struct {
+	linkType LinkType
+	snapLen  uint32
+	// timestamps are in units of 1/tsPerSecond seconds
+	tsPerSecond uint64
+}
+
+// NewReader returns a Reader that reads the capture from r. The format of the
+// capture is determined from its first bytes.
+func NewReader(r io.Reader) (*Reader, error) {
+	pr := &Reader{r: bufio.NewReader(r)}
+
+	magic, err := pr.r.Peek(4)
+	if err != nil {
+		return nil, fmt.Errorf("pcap: error reading header: %w", err)
+	}
+
+	switch {
+	case binary.LittleEndian.Uint32(magic) == pcapngSectionHeader:
+		pr.pcapng = true
+		return pr, nil
+	case binary.LittleEndian.Uint32(magic) == pcapMagicMicroseconds:
+		pr.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(magic) == pcapMagicMicroseconds:
+		pr.order = binary.BigEndian
+	case binary.LittleEndian.Uint32(magic) == pcapMagicNanoseconds:
+		pr.order, pr.nanoseconds = binary.LittleEndian, true
+	case binary.BigEndian.Uint32(magic) == pcapMagicNanoseconds:
+		pr.order, pr.nanoseconds = binary.BigEndian, true
+	default:
+		return nil, fmt.Errorf("pcap: unknown file format, magic: %x", magic)
+	}
+
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(pr.r, header); err != nil {
+		return nil, fmt.Errorf("pcap: error reading header: %w", err)
+	}
+	pr.linkType = LinkType(pr.order.Uint32(header[20:24]))
+
+	return pr, nil
+}
+
+// Next returns the next packet in the capture, or io.EOF at the end of the
+// capture.
+func (r *Reader) Next() (Packet, error) {
+	if r.pcapng {
+		return r.nextPcapng()
+	}
+	return r.nextPcap()
+}
+
+func (r *Reader) nextPcap() (Packet, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r.r, header); err == io.EOF {
+		return Packet{}, io.EOF
+	} else if err != nil {
+		return Packet{}, fmt.Errorf("pcap: error reading packet header: %w", err)
+	}
+
+	var (
+		sec      = r.order.Uint32(header[0:4])
+		frac     = r.order.Uint32(header[4:8])
+		inclLen  = r.order.Uint32(header[8:12])
+		origLen  = r.order.Uint32(header[12:16])
+		fracUnit = time.Microsecond
+	)
+	if r.nanoseconds {
+		fracUnit = time.Nanosecond
+	}
+	if inclLen > maxBlockLength {
+		return Packet{}, fmt.Errorf("pcap: packet too long: %d", inclLen)
+	}
+
+	data := make([]byte, inclLen)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return Packet{}, fmt.Errorf("pcap: error reading packet: %w", err)
+	}
+
+	return Packet{
+		Time:     time.Unix(int64(sec), int64(frac)*int64(fracUnit)),
+		LinkType: r.linkType,
+		Data:     data,
+		Length:   int(origLen),
+	}, nil
+}
+
+func (r *Reader) nextPcapng() (Packet, error) {
+	for {
+		blockType, body, err := r.readBlock()
+		if err != nil {
+			return Packet{}, err
+		}
+
+		switch blockType {
+		case pcapngSectionHeader:
+			// a new section may change the byte order, and starts a new
+			// list of interfaces
+			r.interfaces = nil
+
+		case pcapngInterfaceDesc:
+			if len(body) < 8 {
+				return Packet{}, fmt.Errorf("pcap: interface description block too short")
+			}
+			iface := pcapngInterface{
+				linkType:    LinkType(r.order.Uint16(body[0:2])),
+				snapLen:     r.order.Uint32(body[4:8]),
+				tsPerSecond: defaultTimestampsPerSecond,
+			}
+			r.parseInterfaceOptions(&iface, body[8:])
+			r.interfaces = append(r.interfaces, iface)
+
+		case pcapngEnhancedPacket:
+			if len(body) < 20 {
+				return Packet{}, fmt.Errorf("pcap: enhanced packet block too short")
+			}
+			var (
+				ifaceID = r.order.Uint32(body[0:4])
+				ts      = uint64(r.order.Uint32(body[4:8]))<<32 | uint64(r.order.Uint32(body[8:12]))
+				capLen  = r.order.Uint32(body[12:16])
+				origLen = r.order.Uint32(body[16:20])
+			)
+			if int(ifaceID) >= len(r.interfaces) {
+				return Packet{}, fmt.Errorf("pcap: packet on unknown interface %d", ifaceID)
+			}
+			if uint64(capLen) > uint64(len(body)-20) {
+				return Packet{}, fmt.Errorf("pcap: packet length %d exceeds block", capLen)
+			}
+			iface := r.interfaces[ifaceID]
+
+			return Packet{
+				Time:     timestamp(ts, iface.tsPerSecond),
+				LinkType: iface.linkType,
+				Data:     body[20 : 20+capLen],
+				Length:   int(origLen),
+			}, nil
+
+		case pcapngSimplePacket:
+			if len(body) < 4 {
+				return Packet{}, fmt.Errorf("pcap: simple packet block too short")
+			}
+			if len(r.interfaces) == 0 {
+				return Packet{}, fmt.Errorf("pcap: simple packet before interface description")
+			}
+			var (
+				iface   = r.interfaces[0]
+				origLen = r.order.Uint32(body[0:4])
+				data    = body[4:]
+			)
+			if uint32(len(data)) > origLen {
+				data = data[:origLen]
+			}
+			if iface.snapLen != 0 && uint32(len(data)) > iface.snapLen {
+				data = data[:iface.snapLen]
+			}
+
+			return Packet{
+				LinkType: iface.linkType,
+				Data:     data,
+				Length:   int(origLen),
+			}, nil
+
+		default:
+			// statistics, name resolution, custom blocks, etc.
+		}
+	}
+}
+
+// readBlock reads a pcapng block and returns its type and body.
+func (r *Reader) readBlock() (uint32, []byte, error) {
+	// every block is at least 12 bytes long
+	header, err := r.r.Peek(12)
+	if len(header) == 0 && err == io.EOF {
+		return 0, nil, io.EOF
+	} else if err != nil {
+		return 0, nil, fmt.Errorf("pcap: error reading block: %w", err)
+	}
+
+	if binary.LittleEndian.Uint32(header) == pcapngSectionHeader {
+		switch binary.LittleEndian.Uint32(header[8:12]) {
+		case pcapngByteOrderMagic:
+			r.order = binary.LittleEndian
+		case pcapngByteOrderMagicSwapped:
+			r.order = binary.BigEndian
+		default:
+			return 0, nil, fmt.Errorf("pcap: invalid byte order magic: %x", header[8:12])
+		}
+	}
+	if r.order == nil {
+		return 0, nil, fmt.Errorf("pcap: block before section header")
+	}
+
+	blockType := r.order.Uint32(header[0:4])
+	length := r.order.Uint32(header[4:8])
+	if length < 12 || length > maxBlockLength || length%4 != 0 {
+		return 0, nil, fmt.Errorf("pcap: invalid block length: %d", length)
+	}
+
+	block := make([]byte, length)
+	if _, err := io.ReadFull(r.r, block); err != nil {
+		return 0, nil, fmt.Errorf("pcap: error reading block: %w", err)
+	}
+	if trailer := r.order.Uint32(block[length-4:]); trailer != length {
+		return 0, nil, fmt.Errorf("pcap: block length %d does not match trailer %d", length, trailer)
+	}
+
+	return blockType, block[8 : length-4], nil
+}
+
+func (r *Reader) parseInterfaceOptions(iface *pcapngInterface, options []byte) {
+	for len(options) >= 4 {
+		code := r.order.Uint16(options[0:2])
+		length := int(r.order.Uint16(options[2:4]))
+		if code == pcapngOptionEnd || 4+length > len(options) {
+			return
+		}
+		value := options[4 : 4+length]
+
+		if code == pcapngOptionIfTsresol && length >= 1 {
+			exp := uint64(value[0] & 0x7f)
+			switch {
+			case value[0]&0x80 != 0 && exp < 64:
+				iface.tsPerSecond = 1 << exp
+			case value[0]&0x80 == 0 && exp <= 19:
+				iface.tsPerSecond = uint64(math.Pow10(int(exp)))
+			}
+		}
+
+		// options are padded to 32 bits; the padding of the last may be
+		// missing from malformed blocks
+		options = options[min(4+(length+3)&^3, len(options)):]
+	}
+}
+
+func timestamp(ts, tsPerSecond uint64) time.Time {
+	sec := ts / tsPerSecond
+	frac := ts % tsPerSecond
+	// frac*time.Second overflows 64 bits at resolutions finer than 2^-34
+	// seconds; as frac < tsPerSecond, the quotient fits
+	hi, lo := bits.Mul64(frac, uint64(time.Second))
+	nsec, _ := bits.Div64(hi, lo, tsPerSecond)
+	return time.Unix(int64(sec), int64(nsec))
+}
@@ -0,0 +1,250 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+)
+
+const (
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86dd
+	etherTypeVLAN = 0x8100
+
+	protocolTCP = 6
+
+	tcpFlagFIN = 0x01
+	tcpFlagSYN = 0x02
+	tcpFlagRST = 0x04
+	tcpFlagPSH = 0x08
+	tcpFlagACK = 0x10
+)
+
+// segment is a TCP segment.
+type segment struct {
+	src, dst netip.AddrPort
+	seq, ack uint32
+	flags    byte
+	payload  []byte
+}
+
+// errNotTCP is returned by parseSegment for packets that do not carry a TCP
+// segment. They are skipped.
+var errNotTCP = fmt.Errorf("pcap: not a TCP segment")
+
+// parseSegment parses the TCP segment in the packet data with link type lt.
+func parseSegment(lt LinkType, data []byte) (segment, error) {
+	var etherType uint16
+
+	switch lt {
+	case LinkTypeEthernet:
+		if len(data) < 14 {
+			return segment{}, fmt.Errorf("pcap: Ethernet frame too short")
+		}
+		etherType, data = binary.BigEndian.Uint16(data[12:14]), data[14:]
+		for etherType == etherTypeVLAN {
+			if len(data) < 4 {
+				return segment{}, fmt.Errorf("pcap: VLAN tag too short")
+			}
+			etherType, data = binary.BigEndian.Uint16(data[2:4]), data[4:]
+		}
+
+	case LinkTypeLinuxSLL:
+		if len(data) < 16 {
+			return segment{}, fmt.Errorf("pcap: Linux cooked header too short")
+		}
+		etherType, data = binary.BigEndian.Uint16(data[14:16]), data[16:]
+
+	case LinkTypeNull:
+		if len(data) < 4 {
+			return segment{}, fmt.Errorf("pcap: loopback header too short")
+		}
+		// the address family is in the byte order of the capturing host;
+		// AF_INET is 2 everywhere, AF_INET6 varies between 10, 24, 28 and 30
+		family := binary.LittleEndian.Uint32(data[0:4])
+		if family > 0xffff {
+			family = binary.BigEndian.Uint32(data[0:4])
+		}
+		etherType, data = etherTypeIPv6, data[4:]
+		if family == 2 {
+			etherType = etherTypeIPv4
+		}
+
+	case LinkTypeRaw:
+		if len(data) < 1 {
+			return segment{}, fmt.Errorf("pcap: IP packet too short")
+		}
+		etherType = etherTypeIPv4
+		if data[0]>>4 == 6 {
+			etherType = etherTypeIPv6
+		}
+
+	default:
+		return segment{}, fmt.Errorf("pcap: unsupported link type %d", lt)
+	}
+
+	switch etherType {
+	case etherTypeIPv4:
+		return parseIPv4(data)
+	case etherTypeIPv6:
+		return parseIPv6(data)
+	default:
+		return segment{}, errNotTCP
+	}
+}
+
+func parseIPv4(data []byte) (segment, error) {
+	if len(data) < 20 || data[0]>>4 != 4 {
+		return segment{}, fmt.Errorf("pcap: invalid IPv4 header")
+	}
+	var (
+		headerLen = int(data[0]&0x0f) * 4
+		totalLen  = int(binary.BigEndian.Uint16(data[2:4]))
+		fragment  = binary.BigEndian.Uint16(data[6:8])
+		protocol  = data[9]
+		src       = netip.AddrFrom4(*(*[4]byte)(data[12:16]))
+		dst       = netip.AddrFrom4(*(*[4]byte)(data[16:20]))
+	)
+	if protocol != protocolTCP {
+		return segment{}, errNotTCP
+	}
+	if headerLen < 20 || totalLen < headerLen || totalLen > len(data) {
+		return segment{}, fmt.Errorf("pcap: invalid IPv4 header")
+	}
+	// more fragments, or a fragment offset
+	if fragment&0x3fff != 0 {
+		return segment{}, fmt.Errorf("pcap: fragmented IPv4 packets are not supported")
+	}
+
+	return parseTCP(src, dst, data[headerLen:totalLen])
+}
+
+func parseIPv6(data []byte) (segment, error) {
+	if len(data) < 40 || data[0]>>4 != 6 {
+		return segment{}, fmt.Errorf("pcap: invalid IPv6 header")
+	}
+	var (
+		payloadLen = int(binary.BigEndian.Uint16(data[4:6]))
+		nextHeader = data[6]
+		src        = netip.AddrFrom16(*(*[16]byte)(data[8:24]))
+		dst        = netip.AddrFrom16(*(*[16]byte)(data[24:40]))
+	)
+	// extension headers are not followed
+	if nextHeader != protocolTCP {
+		return segment{}, errNotTCP
+	}
+	if 40+payloadLen > len(data) {
+		return segment{}, fmt.Errorf("pcap: invalid IPv6 header")
+	}
+
+	return parseTCP(src, dst, data[40:40+payloadLen])
+}
+
+func parseTCP(src, dst netip.Addr, data []byte) (segment, error) {
+	if len(data) < 20 {
+		return segment{}, fmt.Errorf("pcap: TCP header too short")
+	}
+	headerLen := int(data[12]>>4) * 4
+	if headerLen < 20 || headerLen > len(data) {
+		return segment{}, fmt.Errorf("pcap: invalid TCP header")
+	}
+
+	return segment{
+		src:     netip.AddrPortFrom(src, binary.BigEndian.Uint16(data[0:2])),
+		dst:     netip.AddrPortFrom(dst, binary.BigEndian.Uint16(data[2:4])),
+		seq:     binary.BigEndian.Uint32(data[4:8]),
+		ack:     binary.BigEndian.Uint32(data[8:12]),
+		flags:   data[13],
+		payload: data[headerLen:],
+	}, nil
+}
+
+// marshalSegment returns s as a packet with link type lt, which must be
+// LinkTypeEthernet or LinkTypeRaw. The addresses of s must both be IPv4 or
+// both be IPv6.
+func marshalSegment(lt LinkType, s segment) ([]byte, error) {
+	tcp := make([]byte, 20+len(s.payload))
+	binary.BigEndian.PutUint16(tcp[0:2], s.src.Port())
+	binary.BigEndian.PutUint16(tcp[2:4], s.dst.Port())
+	binary.BigEndian.PutUint32(tcp[4:8], s.seq)
+	binary.BigEndian.PutUint32(tcp[8:12], s.ack)
+	tcp[12] = 5 << 4
+	tcp[13] = s.flags
+	binary.BigEndian.PutUint16(tcp[14:16], 0xffff) // window
+	copy(tcp[20:], s.payload)
+
+	var (
+		src, dst  = s.src.Addr(), s.dst.Addr()
+		ip        []byte
+		etherType uint16
+	)
+	switch {
+	case src.Is4() && dst.Is4():
+		etherType = etherTypeIPv4
+		ip = make([]byte, 20, 20+len(tcp))
+		ip[0] = 4<<4 | 5
+		binary.BigEndian.PutUint16(ip[2:4], uint16(20+len(tcp)))
+		ip[6] = 0x40 // don't fragment
+		ip[8] = 64   // TTL
+		ip[9] = protocolTCP
+		copy(ip[12:16], src.AsSlice())
+		copy(ip[16:20], dst.AsSlice())
+		binary.BigEndian.PutUint16(ip[10:12], checksum(0, ip))
+
+	case src.Is6() && dst.Is6():
+		etherType = etherTypeIPv6
+		ip = make([]byte, 40, 40+len(tcp))
+		ip[0] = 6 << 4
+		binary.BigEndian.PutUint16(ip[4:6], uint16(len(tcp)))
+		ip[6] = protocolTCP
+		ip[7] = 64 // hop limit
+		copy(ip[8:24], src.AsSlice())
+		copy(ip[24:40], dst.AsSlice())
+
+	default:
+		return nil, fmt.Errorf("pcap: invalid TCP endpoints %s and %s", s.src, s.dst)
+	}
+
+	// the TCP checksum covers a pseudo header of the addresses, protocol and
+	// TCP length
+	pseudo := append(src.AsSlice(), dst.AsSlice()...)
+	pseudo = append(pseudo, 0, protocolTCP, byte(len(tcp)>>8), byte(len(tcp)))
+	binary.BigEndian.PutUint16(tcp[16:18], checksum(sum(0, pseudo), tcp))
+
+	packet := append(ip, tcp...)
+
+	switch lt {
+	case LinkTypeRaw:
+		return packet, nil
+	case LinkTypeEthernet:
+		// locally administered MAC addresses
+		eth := []byte{
+			0x02, 0, 0, 0, 0, 0x02,
+			0x02, 0, 0, 0, 0, 0x01,
+			byte(etherType >> 8), byte(etherType),
+		}
+		return append(eth, packet...), nil
+	default:
+		return nil, fmt.Errorf("pcap: cannot write TCP segments to captures with link type %d", lt)
+	}
+}
+
+// sum adds b to the one's complement sum s.
+func sum(s uint32, b []byte) uint32 {
+	for i := 0; i+1 < len(b); i += 2 {
+		s += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		s += uint32(b[len(b)-1]) << 8
+	}
+	return s
+}
+
+// checksum returns the Internet checksum of b, continuing the sum s.
+func checksum(s uint32, b []byte) uint16 {
+	s = sum(s, b)
+	for s > 0xffff {
+		s = s>>16 + s&0xffff
+	}
+	return ^uint16(s)
+}
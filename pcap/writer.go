@@ -0,0 +1,66 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const snapLen = 65535
+
+// Writer writes packets to a pcap capture. Captures are written in the
+// original pcap format, with microsecond timestamps, which every version of
+// Wireshark and tcpdump reads.
+type Writer struct {
+	linkType LinkType
+
+	mut sync.Mutex
+	w   io.Writer
+}
+
+// NewWriter writes the header of a capture of packets with link type
+// linkType to w and returns a Writer that writes packets to it.
+func NewWriter(w io.Writer, linkType LinkType) (*Writer, error) {
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:4], pcapMagicMicroseconds)
+	binary.LittleEndian.PutUint16(header[4:6], 2) // version 2.4
+	binary.LittleEndian.PutUint16(header[6:8], 4)
+	binary.LittleEndian.PutUint32(header[16:20], snapLen)
+	binary.LittleEndian.PutUint32(header[20:24], uint32(linkType))
+
+	if _, err := w.Write(header); err != nil {
+		return nil, fmt.Errorf("pcap: error writing header: %w", err)
+	}
+
+	return &Writer{
+		linkType: linkType,
+		w:        w,
+	}, nil
+}
+
+func (w *Writer) LinkType() LinkType { return w.linkType }
+
+// WritePacket writes a packet captured at t. data must begin with a header
+// of the link type of the capture.
+func (w *Writer) WritePacket(t time.Time, data []byte) error {
+	if len(data) > snapLen {
+		return fmt.Errorf("pcap: packet too long: %d", len(data))
+	}
+
+	record := make([]byte, 16+len(data))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(t.Unix()))
+	binary.LittleEndian.PutUint32(record[4:8], uint32(t.Nanosecond()/int(time.Microsecond)))
+	binary.LittleEndian.PutUint32(record[8:12], uint32(len(data)))
+	binary.LittleEndian.PutUint32(record[12:16], uint32(len(data)))
+	copy(record[16:], data)
+
+	w.mut.Lock()
+	defer w.mut.Unlock()
+
+	if _, err := w.w.Write(record); err != nil {
+		return fmt.Errorf("pcap: error writing packet: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/shasderias/modbus"
+)
+
+// Recorder is a modbus.ClientTransport that writes every request it passes to
+// its underlying transport, and the response, to w as an Exchange.
+type Recorder struct {
+	t modbus.ClientTransport
+
+	mut sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+func NewRecorder(t modbus.ClientTransport, w io.Writer) *Recorder {
+	return &Recorder{
+		t:   t,
+		enc: json.NewEncoder(w),
+	}
+}
+
+func (r *Recorder) WriteRequest(unitID byte, req modbus.PDU) (modbus.PDU, error) {
+	start := time.Now()
+	resp, err := r.t.WriteRequest(unitID, req)
+
+	ex := Exchange{
+		Time:     start,
+		Duration: time.Since(start),
+		UnitID:   unitID,
+	}
+	if err != nil {
+		ex.Err = err.Error()
+		ex.ErrKind = errorKind(err)
+	}
+
+	r.record(ex, req, resp)
+
+	return resp, err
+}
+
+func (r *Recorder) record(ex Exchange, req, resp modbus.PDU) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	if r.err != nil {
+		return
+	}
+
+	var err error
+	if ex.Request, err = req.MarshalBinary(); err != nil {
+		r.err = fmt.Errorf("session: error marshalling request: %w", err)
+		return
+	}
+	if resp != nil {
+		if ex.Response, err = resp.MarshalBinary(); err != nil {
+			r.err = fmt.Errorf("session: error marshalling response: %w", err)
+			return
+		}
+	}
+
+	if err := r.enc.Encode(ex); err != nil {
+		r.err = fmt.Errorf("session: error recording exchange: %w", err)
+	}
+}
+
+// Err returns the first error encountered while recording. Requests are still
+// passed to the underlying transport after an error, but are no longer
+// recorded.
+func (r *Recorder) Err() error {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	return r.err
+}
+
+// Close closes the underlying transport. The writer the Recorder writes to is
+// not closed.
+func (r *Recorder) Close() error {
+	return r.t.Close()
+}
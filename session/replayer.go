@@ -0,0 +1,147 @@
+package session
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/shasderias/modbus"
+)
+
+// ErrMismatch is returned by a Replayer for requests that do not match the
+// recording.
+var ErrMismatch = errors.New("session: request does not match recording")
+
+type Matching int
+
+const (
+	// Strict requires requests to be sent in the order they were recorded.
+	// Every request must match the next recorded request exactly.
+	Strict Matching = iota
+
+	// Lenient answers a request with the response to the first recorded
+	// request with the same unit ID and PDU that has not yet been replayed,
+	// regardless of order. Once every such exchange has been replayed, the
+	// last one is replayed again, so a recording of a single poll can answer
+	// any number of polls.
+	Lenient
+)
+
+type ReplayerConfig struct {
+	Matching Matching
+
+	// Latency, if true, delays every response by the time it took to arrive
+	// when it was recorded.
+	Latency bool
+}
+
+// Replayer is a modbus.ClientTransport that answers requests with recorded
+// responses.
+type Replayer struct {
+	conf ReplayerConfig
+
+	mut       sync.Mutex
+	exchanges []Exchange
+	replayed  []bool
+	next      int
+}
+
+func NewReplayer(exchanges []Exchange, fns ...func(c *ReplayerConfig)) *Replayer {
+	conf := ReplayerConfig{}
+	for _, fn := range fns {
+		fn(&conf)
+	}
+
+	return &Replayer{
+		conf:      conf,
+		exchanges: exchanges,
+		replayed:  make([]bool, len(exchanges)),
+	}
+}
+
+func (r *Replayer) WriteRequest(unitID byte, req modbus.PDU) (modbus.PDU, error) {
+	reqBytes, err := req.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	ex, err := r.match(unitID, reqBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.conf.Latency {
+		time.Sleep(ex.Duration)
+	}
+
+	if ex.Err != "" {
+		return nil, replayError(ex)
+	}
+	if ex.Response == nil {
+		return nil, nil
+	}
+	return modbus.NewRawPDU(append([]byte(nil), ex.Response...))
+}
+
+func (r *Replayer) match(unitID byte, req []byte) (Exchange, error) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	matches := func(ex Exchange) bool {
+		return ex.UnitID == unitID && bytes.Equal(ex.Request, req)
+	}
+
+	switch r.conf.Matching {
+	case Strict:
+		if r.next >= len(r.exchanges) {
+			return Exchange{}, fmt.Errorf("%w: unit %d request %x sent after the end of the recording", ErrMismatch, unitID, req)
+		}
+		ex := r.exchanges[r.next]
+		if !matches(ex) {
+			return Exchange{}, fmt.Errorf("%w: exchange %d: got unit %d request %x, want unit %d request %x",
+				ErrMismatch, r.next, unitID, req, ex.UnitID, []byte(ex.Request))
+		}
+		r.replayed[r.next] = true
+		r.next++
+		return ex, nil
+
+	default:
+		last := -1
+		for i, ex := range r.exchanges {
+			if !matches(ex) {
+				continue
+			}
+			if !r.replayed[i] {
+				r.replayed[i] = true
+				return ex, nil
+			}
+			last = i
+		}
+		if last == -1 {
+			return Exchange{}, fmt.Errorf("%w: unit %d request %x was not recorded", ErrMismatch, unitID, req)
+		}
+		return r.exchanges[last], nil
+	}
+}
+
+// Remaining returns the number of recorded exchanges that have not been
+// replayed. Tests using Strict matching check that it is 0 to ensure that
+// every recorded request was sent.
+func (r *Replayer) Remaining() int {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	n := 0
+	for _, replayed := range r.replayed {
+		if !replayed {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *Replayer) Close() error {
+	return nil
+}
@@ -0,0 +1,158 @@
+// Package session records the requests a client sends and the responses it
+// receives, and replays recorded sessions in place of the devices they were
+// recorded from.
+//
+// Record a session on site by wrapping the client's transport:
+//
+//	f, err := os.Create("boiler.jsonl")
+//	...
+//	client, err := modbus.NewClient(1, session.NewRecorder(transport, f))
+//
+// and replay it in tests:
+//
+//	exchanges, err := session.LoadFile("testdata/boiler.jsonl")
+//	...
+//	client, err := modbus.NewClient(1, session.NewReplayer(exchanges))
+package session
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/shasderias/modbus"
+)
+
+// Exchange is a request and the response it received.
+type Exchange struct {
+	// Time is when the request was sent.
+	Time time.Time `json:"time"`
+
+	// Duration is how long the response took to arrive.
+	Duration time.Duration `json:"duration"`
+
+	UnitID   byte  `json:"unit"`
+	Request  Bytes `json:"request"`
+	Response Bytes `json:"response,omitempty"`
+
+	// Err is the error returned in place of a response, such as a timeout.
+	Err string `json:"error,omitempty"`
+	// ErrKind is the kind of Err, so that the replayed error matches the
+	// same modbus sentinel error with errors.Is, and modbus.IsTimeout, as
+	// the recorded one: "closed", "timeout", "unexpected response", "frame
+	// too long", "frame too short", "invalid frame", "canceled" or
+	// "deadline exceeded". Errors of other kinds are replayed as plain
+	// errors.
+	ErrKind string `json:"errorKind,omitempty"`
+}
+
+// errorKinds are the errors whose kind is recorded, in the order they are
+// tested for.
+var errorKinds = []struct {
+	kind string
+	err  error
+}{
+	{"closed", modbus.ErrClosed},
+	{"timeout", modbus.ErrTimeout},
+	{"unexpected response", modbus.ErrUnexpectedResponse},
+	{"frame too long", modbus.ErrFrameTooLong},
+	{"frame too short", modbus.ErrFrameTooShort},
+	{"invalid frame", modbus.ErrInvalidFrame},
+	{"canceled", context.Canceled},
+	{"deadline exceeded", context.DeadlineExceeded},
+}
+
+// errorKind returns the kind of err recorded in Exchange.ErrKind: the kind
+// of the modbus sentinel error or context error it wraps, "timeout" for
+// other errors that modbus.IsTimeout reports true for, or "" for other
+// errors.
+func errorKind(err error) string {
+	for _, k := range errorKinds {
+		if errors.Is(err, k.err) {
+			return k.kind
+		}
+	}
+	if modbus.IsTimeout(err) {
+		return "timeout"
+	}
+	return ""
+}
+
+// replayedError is a recorded error. It has the message of the recorded
+// error, and wraps the error of its kind.
+type replayedError struct {
+	msg string
+	err error
+}
+
+func (e *replayedError) Error() string { return e.msg }
+func (e *replayedError) Unwrap() error { return e.err }
+
+// replayError returns the error recorded in ex.
+func replayError(ex Exchange) error {
+	for _, k := range errorKinds {
+		if k.kind == ex.ErrKind {
+			return &replayedError{ex.Err, k.err}
+		}
+	}
+	return errors.New(ex.Err)
+}
+
+// Bytes is a PDU, written as a hex string in JSON.
+type Bytes []byte
+
+func (b Bytes) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(b)), nil
+}
+
+func (b *Bytes) UnmarshalText(text []byte) error {
+	decoded, err := hex.DecodeString(string(text))
+	if err != nil {
+		return fmt.Errorf("session: %w", err)
+	}
+	*b = decoded
+	return nil
+}
+
+// Load reads exchanges written by a Recorder from r: one JSON object per line.
+func Load(r io.Reader) ([]Exchange, error) {
+	var exchanges []Exchange
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var ex Exchange
+		if err := json.Unmarshal(scanner.Bytes(), &ex); err != nil {
+			return nil, fmt.Errorf("session: line %d: %w", line, err)
+		}
+		if len(ex.Request) == 0 {
+			return nil, fmt.Errorf("session: line %d: empty request", line)
+		}
+		exchanges = append(exchanges, ex)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("session: %w", err)
+	}
+
+	return exchanges, nil
+}
+
+// LoadFile reads exchanges written by a Recorder from the file name.
+func LoadFile(name string) ([]Exchange, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("session: %w", err)
+	}
+	defer f.Close()
+
+	return Load(f)
+}
@@ -0,0 +1,217 @@
+package session_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/shasderias/modbus"
+	"github.com/shasderias/modbus/mbtest"
+	"github.com/shasderias/modbus/session"
+	"github.com/shasderias/modbus/simulator"
+	"github.com/shasderias/modbus/transport/rtu"
+)
+
+// poll is the session recorded and replayed by the tests.
+func poll(client *modbus.Client) ([]uint16, error) {
+	if _, err := client.WriteRegisters(0, []uint16{1, 2, 3}); err != nil {
+		return nil, err
+	}
+	resp, err := client.ReadHoldingRegisters(0, 3)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Uint16(), nil
+}
+
+func record(t *testing.T) []session.Exchange {
+	t.Helper()
+
+	m := simulator.Map{Units: []simulator.Unit{{ID: 1, Size: map[string]int{"holding": 8}}}}
+	_, port := mbtest.StartSimulatorRTU(t, m)
+
+	var buf bytes.Buffer
+	recorder := session.NewRecorder(rtu.NewClient(port), &buf)
+
+	client, err := modbus.NewClient(1, recorder)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := poll(client); err != nil {
+		t.Fatal(err)
+	}
+	var exception *modbus.ExceptionResponse
+	if _, err := client.ReadHoldingRegisters(6, 4); !errors.As(err, &exception) {
+		t.Fatalf("got %v; want exception", err)
+	}
+	if err := recorder.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	exchanges, err := session.Load(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exchanges
+}
+
+func TestRecord(t *testing.T) {
+	exchanges := record(t)
+
+	type summary struct {
+		UnitID   byte
+		Request  session.Bytes
+		Response session.Bytes
+	}
+	var got []summary
+	for _, ex := range exchanges {
+		if ex.Time.IsZero() {
+			t.Fatal("exchange not timestamped")
+		}
+		got = append(got, summary{ex.UnitID, ex.Request, ex.Response})
+	}
+
+	want := []summary{
+		{1, session.Bytes{0x10, 0x00, 0x00, 0x00, 0x03, 0x06, 0x00, 0x01, 0x00, 0x02, 0x00, 0x03}, session.Bytes{0x10, 0x00, 0x00, 0x00, 0x03}},
+		{1, session.Bytes{0x03, 0x00, 0x00, 0x00, 0x03}, session.Bytes{0x03, 0x06, 0x00, 0x01, 0x00, 0x02, 0x00, 0x03}},
+		{1, session.Bytes{0x03, 0x00, 0x06, 0x00, 0x04}, session.Bytes{0x83, 0x02}},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestReplayStrict(t *testing.T) {
+	replayer := session.NewReplayer(record(t))
+
+	client, err := modbus.NewClient(1, replayer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := poll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(values, []uint16{1, 2, 3}); diff != "" {
+		t.Fatal(diff)
+	}
+	if got := replayer.Remaining(); got != 1 {
+		t.Fatalf("got %d remaining exchanges; want 1", got)
+	}
+
+	if _, err := client.ReadHoldingRegisters(0, 3); !errors.Is(err, session.ErrMismatch) {
+		t.Fatalf("got %v; want session.ErrMismatch", err)
+	}
+
+	var exception *modbus.ExceptionResponse
+	if _, err := client.ReadHoldingRegisters(6, 4); !errors.As(err, &exception) {
+		t.Fatalf("got %v; want exception", err)
+	}
+	if _, err := client.ReadHoldingRegisters(6, 4); !errors.Is(err, session.ErrMismatch) {
+		t.Fatalf("got %v; want session.ErrMismatch past the end of the recording", err)
+	}
+}
+
+func TestReplayLenient(t *testing.T) {
+	replayer := session.NewReplayer(record(t), func(c *session.ReplayerConfig) {
+		c.Matching = session.Lenient
+	})
+
+	client, err := modbus.NewClient(1, replayer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		resp, err := client.ReadHoldingRegisters(0, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(resp.Uint16(), []uint16{1, 2, 3}); diff != "" {
+			t.Fatal(diff)
+		}
+	}
+
+	if _, err := client.ReadHoldingRegisters(1, 3); !errors.Is(err, session.ErrMismatch) {
+		t.Fatalf("got %v; want session.ErrMismatch", err)
+	}
+
+	other, err := modbus.NewClient(2, replayer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.ReadHoldingRegisters(0, 3); !errors.Is(err, session.ErrMismatch) {
+		t.Fatalf("got %v; want session.ErrMismatch for another unit", err)
+	}
+}
+
+func TestReplayRecordedError(t *testing.T) {
+	exchanges := []session.Exchange{
+		{UnitID: 1, Request: session.Bytes{0x03, 0x00, 0x00, 0x00, 0x01}, Err: "rtu/client: error reading response: timeout"},
+	}
+
+	client, err := modbus.NewClient(1, session.NewReplayer(exchanges))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ReadHoldingRegisters(0, 1); err == nil {
+		t.Fatal("got nil; want recorded error")
+	}
+}
+
+// failingTransport fails every request with err.
+type failingTransport struct {
+	err error
+}
+
+func (t failingTransport) WriteRequest(byte, modbus.PDU) (modbus.PDU, error) { return nil, t.err }
+func (t failingTransport) Close() error                                      { return nil }
+
+func TestReplayRecordedErrorKind(t *testing.T) {
+	testCases := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"Timeout", fmt.Errorf("modbus/tcp: %w", modbus.ErrTimeout), modbus.ErrTimeout},
+		{"Deadline", fmt.Errorf("rtu/client: error reading: %w", os.ErrDeadlineExceeded), modbus.ErrTimeout},
+		{"Closed", fmt.Errorf("modbus/tcp: %w", modbus.ErrClosed), modbus.ErrClosed},
+		{"UnexpectedResponse", fmt.Errorf("modbus/tcp: %w", modbus.ErrUnexpectedResponse), modbus.ErrUnexpectedResponse},
+		{"InvalidFrame", fmt.Errorf("rtu/client: %w: bad CRC", modbus.ErrInvalidFrame), modbus.ErrInvalidFrame},
+		{"Other", errors.New("unplugged"), nil},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			req, err := modbus.NewReadRegisterRequest(modbus.FuncCodeReadHoldingRegisters, 0, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			session.NewRecorder(failingTransport{tt.err}, &buf).WriteRequest(1, req)
+
+			exchanges, err := session.Load(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = session.NewReplayer(exchanges).WriteRequest(1, req)
+
+			if err == nil || err.Error() != tt.err.Error() {
+				t.Fatalf("got %v; want %v", err, tt.err)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("got %v; want match for %v", err, tt.want)
+			}
+			if got, want := modbus.IsTimeout(err), modbus.IsTimeout(tt.err); got != want {
+				t.Fatalf("got IsTimeout %v; want %v", got, want)
+			}
+		})
+	}
+}
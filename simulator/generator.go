@@ -0,0 +1,106 @@
+package simulator
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/shasderias/modbus/datamodel"
+)
+
+// generator is a Generator bound to the model it updates.
+type generator struct {
+	model    *datamodel.Model
+	table    datamodel.Table
+	address  int
+	interval time.Duration
+
+	// value returns the value for the nth update, made elapsed after the
+	// first
+	value func(n int, elapsed time.Duration) uint16
+}
+
+func newGenerator(model *datamodel.Model, g Generator) (*generator, error) {
+	table, err := ParseTable(g.Table)
+	if err != nil {
+		return nil, err
+	}
+	if g.Address < 0 || g.Address >= model.Size(table) {
+		return nil, fmt.Errorf("simulator: generator address %d out of range of %v", g.Address, table)
+	}
+
+	var (
+		min      = uint64(g.Min)
+		max      uint64
+		step     = uint64(g.Step)
+		interval = time.Duration(g.Interval)
+		period   = time.Duration(g.Period)
+	)
+	switch {
+	case g.Max != nil:
+		max = uint64(*g.Max)
+	case table.IsBits():
+		max = 1
+	default:
+		max = 0xffff
+	}
+	if step == 0 {
+		step = 1
+	}
+	if interval <= 0 {
+		interval = 1 * time.Second
+	}
+	if period <= 0 {
+		period = 60 * time.Second
+	}
+	if min > max {
+		return nil, fmt.Errorf("simulator: generator min %d greater than max %d", min, max)
+	}
+
+	var value func(n int, elapsed time.Duration) uint16
+
+	switch g.Type {
+	case "counter":
+		span := (max-min)/step + 1
+		value = func(n int, _ time.Duration) uint16 {
+			return uint16(min + uint64(n)%span*step)
+		}
+	case "random":
+		rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+		value = func(int, time.Duration) uint16 {
+			return uint16(min + uint64(rnd.Int63n(int64(max-min+1))))
+		}
+	case "sine":
+		value = func(_ int, elapsed time.Duration) uint16 {
+			phase := 2 * math.Pi * float64(elapsed) / float64(period)
+			return uint16(math.Round(float64(min) + float64(max-min)*(1+math.Sin(phase))/2))
+		}
+	case "toggle":
+		value = func(n int, _ time.Duration) uint16 {
+			if n%2 == 0 {
+				return uint16(min)
+			}
+			return uint16(max)
+		}
+	default:
+		return nil, fmt.Errorf("simulator: unknown generator type %q, want one of counter, random, sine, toggle", g.Type)
+	}
+
+	return &generator{
+		model:    model,
+		table:    table,
+		address:  g.Address,
+		interval: interval,
+		value:    value,
+	}, nil
+}
+
+// update sets the generator's address to its nth value.
+func (g *generator) update(n int, elapsed time.Duration) error {
+	v := g.value(n, elapsed)
+	if g.table.IsBits() {
+		return g.model.SetBits(g.table, g.address, v != 0)
+	}
+	return g.model.SetRegisters(g.table, g.address, v)
+}
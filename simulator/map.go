@@ -0,0 +1,151 @@
+package simulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/shasderias/modbus/datamodel"
+)
+
+// Map describes the units a Simulator serves and the contents of their
+// tables. Maps are usually loaded from a JSON file:
+//
+//	{
+//	  "units": [
+//	    {
+//	      "id": 1,
+//	      "size": {"coils": 100, "holding": 1000},
+//	      "values": [
+//	        {"table": "holding", "address": 0, "values": [1, 2, 3]},
+//	        {"table": "coils", "address": 7, "values": [1, 0, 1]}
+//	      ],
+//	      "generators": [
+//	        {"table": "input", "address": 0, "type": "counter", "interval": "1s"},
+//	        {"table": "input", "address": 1, "type": "sine", "min": 0, "max": 1000, "period": "30s"},
+//	        {"table": "discrete", "address": 0, "type": "toggle", "interval": "500ms"}
+//	      ]
+//	    }
+//	  ]
+//	}
+type Map struct {
+	Units []Unit `json:"units"`
+}
+
+// Unit describes a single unit, or slave.
+type Unit struct {
+	ID int `json:"id"`
+
+	// Size maps table names to the number of addresses in the table. Tables
+	// that are not listed span the entire address space.
+	Size map[string]int `json:"size,omitempty"`
+
+	// Values are the initial values of the unit's tables. Every table starts
+	// out zeroed.
+	Values []Values `json:"values,omitempty"`
+
+	Generators []Generator `json:"generators,omitempty"`
+}
+
+// Values sets consecutive addresses of a table, starting at Address. Values of
+// coils and discrete inputs are 0 or 1.
+type Values struct {
+	Table   string   `json:"table"`
+	Address int      `json:"address"`
+	Values  []uint16 `json:"values"`
+}
+
+// Generator periodically updates the value at Address.
+//
+// Type is one of:
+//
+//   - counter: counts from Min to Max in increments of Step, then starts over
+//   - random: picks a value between Min and Max
+//   - sine: follows a sine wave between Min and Max that repeats every Period
+//   - toggle: alternates between Min and Max
+//
+// Max defaults, if unset, to 65535 for registers and 1 for coils and discrete
+// inputs, which are set to 1 for any value other than 0. It is a pointer so
+// that a Max of 0 can be set. Step defaults to 1, Interval
+// to 1s and Period to 60s.
+type Generator struct {
+	Table    string   `json:"table"`
+	Address  int      `json:"address"`
+	Type     string   `json:"type"`
+	Min      uint16   `json:"min,omitempty"`
+	Max      *uint16  `json:"max,omitempty"`
+	Step     uint16   `json:"step,omitempty"`
+	Interval Duration `json:"interval,omitempty"`
+	Period   Duration `json:"period,omitempty"`
+}
+
+// Duration is a time.Duration that is written as a string, such as "1.5s", in
+// JSON.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("simulator: duration must be a string such as \"1s\": %s", b)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("simulator: %w", err)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// DefaultMap returns a Map with a single unit, ID 1, whose tables span the
+// entire address space and are zeroed.
+func DefaultMap() Map {
+	return Map{Units: []Unit{{ID: 1}}}
+}
+
+// ParseMap reads a Map in JSON from r.
+func ParseMap(r io.Reader) (Map, error) {
+	var m Map
+
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&m); err != nil {
+		return Map{}, fmt.Errorf("simulator: error parsing map: %w", err)
+	}
+
+	return m, nil
+}
+
+// LoadMap reads a Map from the JSON file name.
+func LoadMap(name string) (Map, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return Map{}, fmt.Errorf("simulator: %w", err)
+	}
+	defer f.Close()
+
+	return ParseMap(f)
+}
+
+// ParseTable returns the table named s: coils, discrete, holding or input.
+// Singular forms are accepted as well.
+func ParseTable(s string) (datamodel.Table, error) {
+	switch strings.ToLower(s) {
+	case "coil", "coils":
+		return datamodel.Coils, nil
+	case "discrete", "discrete input", "discrete inputs":
+		return datamodel.DiscreteInputs, nil
+	case "holding", "holding register", "holding registers":
+		return datamodel.HoldingRegisters, nil
+	case "input", "input register", "input registers":
+		return datamodel.InputRegisters, nil
+	default:
+		return 0, fmt.Errorf("simulator: unknown table %q, want one of coils, discrete, holding, input", s)
+	}
+}
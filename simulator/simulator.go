@@ -0,0 +1,223 @@
+// Package simulator implements a Modbus slave simulator that serves any number
+// of units, each with its own data model, through a single modbus.Handler.
+//
+// It backs the mbsim command and the mbtest helpers, and can be served with
+// the TCP and RTU servers of this module:
+//
+//	sim, err := simulator.New(simulator.DefaultMap())
+//	...
+//	go sim.Run(ctx)
+//	server, err := tcp.NewServer(":502", sim)
+package simulator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shasderias/modbus"
+	"github.com/shasderias/modbus/datamodel"
+)
+
+type Config struct {
+	// RequestLog, if not nil, receives a line describing every request
+	// handled and its response.
+	RequestLog io.Writer
+}
+
+type Simulator struct {
+	conf Config
+
+	units      map[byte]*datamodel.Model
+	generators []*generator
+
+	logMut sync.Mutex
+}
+
+// New returns a Simulator serving the units described by m.
+func New(m Map, fns ...func(c *Config)) (*Simulator, error) {
+	conf := Config{}
+	for _, fn := range fns {
+		fn(&conf)
+	}
+
+	s := &Simulator{
+		conf:  conf,
+		units: make(map[byte]*datamodel.Model),
+	}
+
+	for _, u := range m.Units {
+		if u.ID < 0 || u.ID > 0xff {
+			return nil, fmt.Errorf("simulator: unit ID out of range [0, 255]: %d", u.ID)
+		}
+		if _, ok := s.units[byte(u.ID)]; ok {
+			return nil, fmt.Errorf("simulator: duplicate unit ID: %d", u.ID)
+		}
+
+		model, err := newModel(u)
+		if err != nil {
+			return nil, fmt.Errorf("simulator: unit %d: %w", u.ID, err)
+		}
+		s.units[byte(u.ID)] = model
+
+		for _, g := range u.Generators {
+			gen, err := newGenerator(model, g)
+			if err != nil {
+				return nil, fmt.Errorf("simulator: unit %d: %w", u.ID, err)
+			}
+			if err := gen.update(0, 0); err != nil {
+				return nil, fmt.Errorf("simulator: unit %d: %w", u.ID, err)
+			}
+			s.generators = append(s.generators, gen)
+		}
+	}
+
+	return s, nil
+}
+
+func newModel(u Unit) (*datamodel.Model, error) {
+	sizes := make(map[datamodel.Table]int)
+	for name, size := range u.Size {
+		table, err := ParseTable(name)
+		if err != nil {
+			return nil, err
+		}
+		sizes[table] = size
+	}
+
+	model := datamodel.New(func(c *datamodel.Config) {
+		for table, size := range sizes {
+			switch table {
+			case datamodel.Coils:
+				c.Coils = size
+			case datamodel.DiscreteInputs:
+				c.DiscreteInputs = size
+			case datamodel.HoldingRegisters:
+				c.HoldingRegisters = size
+			case datamodel.InputRegisters:
+				c.InputRegisters = size
+			}
+		}
+	})
+
+	for _, v := range u.Values {
+		table, err := ParseTable(v.Table)
+		if err != nil {
+			return nil, err
+		}
+
+		if table.IsBits() {
+			bits := make([]bool, len(v.Values))
+			for i, value := range v.Values {
+				bits[i] = value != 0
+			}
+			err = model.SetBits(table, v.Address, bits...)
+		} else {
+			err = model.SetRegisters(table, v.Address, v.Values...)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return model, nil
+}
+
+// Unit returns the data model of unit id, or nil if the simulator does not
+// serve id.
+func (s *Simulator) Unit(id byte) *datamodel.Model {
+	return s.units[id]
+}
+
+// Units returns the IDs of the units the simulator serves, in ascending order.
+func (s *Simulator) Units() []byte {
+	ids := make([]byte, 0, len(s.units))
+	for id := range s.units {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Run updates the values of the simulator's generators until ctx is done.
+func (s *Simulator) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+
+	start := time.Now()
+	for _, g := range s.generators {
+		wg.Add(1)
+		go func(g *generator) {
+			defer wg.Done()
+
+			ticker := time.NewTicker(g.interval)
+			defer ticker.Stop()
+
+			// every generator was set to its first value by New
+			for n := 1; ; n++ {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+
+				g.update(n, time.Since(start))
+			}
+		}(g)
+	}
+
+	<-ctx.Done()
+	wg.Wait()
+
+	return ctx.Err()
+}
+
+// ServeModbus passes req to the data model of unitID. Requests to units the
+// simulator does not serve are not answered, as a serial line slave would.
+//
+// Unit ID 0 is the broadcast address. Unless the simulator serves unit 0,
+// broadcast requests are passed to every unit and not answered.
+func (s *Simulator) ServeModbus(ctx context.Context, unitID byte, req modbus.PDU) (modbus.PDU, error) {
+	if model, ok := s.units[unitID]; ok {
+		resp, err := model.ServeModbus(ctx, unitID, req)
+		s.logRequest(unitID, req, resp, err)
+		return resp, err
+	}
+
+	if unitID == 0 {
+		for _, id := range s.Units() {
+			s.units[id].ServeModbus(ctx, id, req)
+		}
+	}
+
+	s.logRequest(unitID, req, nil, nil)
+	return nil, nil
+}
+
+func (s *Simulator) logRequest(unitID byte, req, resp modbus.PDU, err error) {
+	if s.conf.RequestLog == nil {
+		return
+	}
+
+	line := fmt.Sprintf("%s unit %d: %s", time.Now().Format("15:04:05.000"), unitID, modbus.DescribeRequest(req))
+
+	var exception *modbus.ExceptionResponse
+	switch {
+	case errors.As(err, &exception):
+		line += "; " + modbus.Describe(exception)
+	case err != nil:
+		line += fmt.Sprintf("; error: %v", err)
+	case resp != nil:
+		line += "; " + modbus.DescribeResponse(resp)
+	default:
+		line += "; no response"
+	}
+
+	s.logMut.Lock()
+	defer s.logMut.Unlock()
+
+	fmt.Fprintln(s.conf.RequestLog, line)
+}
@@ -0,0 +1,174 @@
+package simulator_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/shasderias/modbus"
+	"github.com/shasderias/modbus/datamodel"
+	"github.com/shasderias/modbus/simulator"
+)
+
+const testMap = `{
+  "units": [
+    {
+      "id": 1,
+      "size": {"holding": 10},
+      "values": [
+        {"table": "holding", "address": 2, "values": [1, 2, 3]},
+        {"table": "coils", "address": 7, "values": [1, 0, 1]}
+      ]
+    },
+    {
+      "id": 2,
+      "generators": [
+        {"table": "input", "address": 0, "type": "counter", "min": 10, "max": 12, "interval": "1ms"},
+        {"table": "input", "address": 1, "type": "counter", "max": 0, "interval": "1ms"},
+        {"table": "discrete", "address": 0, "type": "toggle", "interval": "1ms"}
+      ]
+    }
+  ]
+}`
+
+func TestSimulator(t *testing.T) {
+	m, err := simulator.ParseMap(strings.NewReader(testMap))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var log bytes.Buffer
+	sim, err := simulator.New(m, func(c *simulator.Config) {
+		c.RequestLog = &log
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(sim.Units(), []byte{1, 2}); diff != "" {
+		t.Fatal(diff)
+	}
+
+	unit1 := sim.Unit(1)
+	if got := unit1.Size(datamodel.HoldingRegisters); got != 10 {
+		t.Fatalf("got %d holding registers; want 10", got)
+	}
+	registers, err := unit1.Registers(datamodel.HoldingRegisters, 0, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(registers, []uint16{0, 0, 1, 2, 3, 0}); diff != "" {
+		t.Fatal(diff)
+	}
+	coils, err := unit1.Bits(datamodel.Coils, 7, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(coils, []bool{true, false, true}); diff != "" {
+		t.Fatal(diff)
+	}
+
+	req, err := modbus.NewReadRegisterRequest(modbus.FuncCodeReadHoldingRegisters, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := sim.ServeModbus(context.Background(), 1, req); err != nil || resp == nil {
+		t.Fatalf("got %v, %v; want response", resp, err)
+	}
+	if resp, err := sim.ServeModbus(context.Background(), 3, req); err != nil || resp != nil {
+		t.Fatalf("got %v, %v; want no response from unknown unit", resp, err)
+	}
+
+	wantLog := []string{
+		"unit 1: Read Holding Registers (0x03) request: address 2, count 1; Read Holding Registers (0x03) response: values 1",
+		"unit 3: Read Holding Registers (0x03) request: address 2, count 1; no response",
+	}
+	lines := strings.Split(strings.TrimSpace(log.String()), "\n")
+	if len(lines) != len(wantLog) {
+		t.Fatalf("got log %q; want %d lines", log.String(), len(wantLog))
+	}
+	for i, line := range lines {
+		if !strings.HasSuffix(line, wantLog[i]) {
+			t.Fatalf("got log line %q; want suffix %q", line, wantLog[i])
+		}
+	}
+}
+
+func TestSimulatorGenerators(t *testing.T) {
+	m, err := simulator.ParseMap(strings.NewReader(testMap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim, err := simulator.New(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- sim.Run(ctx) }()
+
+	unit2 := sim.Unit(2)
+	seen := map[uint16]bool{}
+	toggled := map[bool]bool{}
+	for deadline := time.Now().Add(1 * time.Second); time.Now().Before(deadline) && (len(seen) < 3 || len(toggled) < 2); {
+		registers, err := unit2.Registers(datamodel.InputRegisters, 0, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[registers[0]] = true
+		if registers[1] != 0 {
+			t.Fatalf("got %d from a counter with max 0; want 0", registers[1])
+		}
+
+		bits, err := unit2.Bits(datamodel.DiscreteInputs, 0, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		toggled[bits[0]] = true
+
+		time.Sleep(1 * time.Millisecond)
+	}
+
+	cancel()
+	<-done
+
+	if diff := cmp.Diff(seen, map[uint16]bool{10: true, 11: true, 12: true}); diff != "" {
+		t.Fatal(diff)
+	}
+	if len(toggled) != 2 {
+		t.Fatalf("got discrete input values %v; want both", toggled)
+	}
+}
+
+func TestSimulatorInvalidMap(t *testing.T) {
+	testCases := []struct {
+		name string
+		m    string
+	}{
+		{"UnknownField", `{"units": [{"id": 1, "registers": []}]}`},
+		{"UnknownTable", `{"units": [{"id": 1, "values": [{"table": "flags", "values": [1]}]}]}`},
+		{"DuplicateUnit", `{"units": [{"id": 1}, {"id": 1}]}`},
+		{"UnitOutOfRange", `{"units": [{"id": 256}]}`},
+		{"ValuesOutOfRange", `{"units": [{"id": 1, "size": {"coils": 1}, "values": [{"table": "coils", "values": [1, 1]}]}]}`},
+		{"UnknownGenerator", `{"units": [{"id": 1, "generators": [{"table": "input", "type": "square"}]}]}`},
+		{"MinAboveZeroMax", `{"units": [{"id": 1, "generators": [{"table": "input", "type": "counter", "min": 5, "max": 0}]}]}`},
+		{"BadInterval", `{"units": [{"id": 1, "generators": [{"table": "input", "type": "counter", "interval": 5}]}]}`},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := simulator.ParseMap(strings.NewReader(tt.m))
+			if err != nil {
+				return
+			}
+			if _, err := simulator.New(m); err == nil {
+				t.Fatal("got nil; want error")
+			}
+		})
+	}
+}
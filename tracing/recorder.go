@@ -0,0 +1,118 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RecordedSpan is a span that ended, as recorded by a Recorder.
+type RecordedSpan struct {
+	Name string
+	// ID identifies the span within its Recorder. ParentID is the ID of the
+	// parent span, or 0 if the span has no parent recorded by the same
+	// Recorder.
+	ID, ParentID uint64
+
+	Attributes map[string]any
+	Err        error
+
+	Start, End time.Time
+}
+
+// Recorder is a Tracer that keeps the spans it starts in memory. It is
+// intended for tests. A Recorder is safe for concurrent use.
+type Recorder struct {
+	mut    sync.Mutex
+	lastID uint64
+	ended  []RecordedSpan
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+func (r *Recorder) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	r.mut.Lock()
+	r.lastID++
+	id := r.lastID
+	r.mut.Unlock()
+
+	span := &recorderSpan{
+		r: r,
+		s: RecordedSpan{
+			Name:       name,
+			ID:         id,
+			Attributes: make(map[string]any),
+			Start:      time.Now(),
+		},
+	}
+	if parent, ok := SpanFromContext(ctx).(*recorderSpan); ok && parent.r == r {
+		span.s.ParentID = parent.s.ID
+	}
+	span.SetAttributes(attrs...)
+
+	return ContextWithSpan(ctx, span), span
+}
+
+// Spans returns the spans that ended, in the order they ended.
+func (r *Recorder) Spans() []RecordedSpan {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	return append([]RecordedSpan(nil), r.ended...)
+}
+
+// Reset discards the spans that ended.
+func (r *Recorder) Reset() {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	r.ended = nil
+}
+
+type recorderSpan struct {
+	r *Recorder
+
+	mut   sync.Mutex
+	s     RecordedSpan
+	ended bool
+}
+
+func (s *recorderSpan) SetAttributes(attrs ...Attribute) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if s.ended {
+		return
+	}
+	for _, attr := range attrs {
+		s.s.Attributes[attr.Key] = attr.Value
+	}
+}
+
+func (s *recorderSpan) RecordError(err error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if s.ended || err == nil {
+		return
+	}
+	s.s.Err = err
+}
+
+func (s *recorderSpan) End() {
+	s.mut.Lock()
+	if s.ended {
+		s.mut.Unlock()
+		return
+	}
+	s.ended = true
+	s.s.End = time.Now()
+	recorded := s.s
+	s.mut.Unlock()
+
+	s.r.mut.Lock()
+	s.r.ended = append(s.r.ended, recorded)
+	s.r.mut.Unlock()
+}
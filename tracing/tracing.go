@@ -0,0 +1,87 @@
+// Package tracing defines the span interface the clients and client
+// transports of this module are instrumented with, and an in-memory Recorder
+// for tests.
+//
+// A modbus.Client given a Tracer starts a span for every request, as a child
+// of the span in the context the Client is bound to (see
+// modbus.Client.WithContext). The span carries the unit ID, function code,
+// address range and exception code of the request, and the transport adds
+// its name and remote address. TCP and RTU transports given a Tracer add
+// child spans for the time a request is queued and the time it is on the
+// wire.
+//
+// The interfaces mirror a subset of those of OpenTelemetry, so that adapting
+// an OpenTelemetry tracer takes a few lines. Tracer.Start must return a
+// context that SpanFromContext returns the new span from; adapters wrap their
+// spans with ContextWithSpan.
+package tracing
+
+import (
+	"context"
+)
+
+// Keys of the attributes of the spans started by this module.
+const (
+	KeyTransport     = "modbus.transport"
+	KeyRemote        = "modbus.remote"
+	KeyUnitID        = "modbus.unit_id"
+	KeyFunctionCode  = "modbus.function_code"
+	KeyAddress       = "modbus.address"
+	KeyCount         = "modbus.count"
+	KeyExceptionCode = "modbus.exception_code"
+	KeyTxID          = "modbus.tx_id"
+)
+
+// Attribute is a key-value pair attached to a span. Value is a string or an
+// int.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+func String(key, value string) Attribute  { return Attribute{key, value} }
+func Int(key string, value int) Attribute { return Attribute{key, value} }
+
+type Tracer interface {
+	// Start starts a span, as a child of the span in ctx if there is one,
+	// and returns a context carrying it.
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	// RecordError marks the span as failed with err. A nil err is ignored.
+	RecordError(err error)
+	End()
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns a copy of ctx carrying span.
+func ContextWithSpan(ctx context.Context, span Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the span in ctx, or a span that does nothing if
+// there is none.
+func SpanFromContext(ctx context.Context) Span {
+	if span, ok := ctx.Value(spanKey{}).(Span); ok {
+		return span
+	}
+	return noopSpan{}
+}
+
+// Start starts a span with t, or returns ctx and a span that does nothing if
+// t is nil.
+func Start(ctx context.Context, t Tracer, name string, attrs ...Attribute) (context.Context, Span) {
+	if t == nil {
+		return ctx, noopSpan{}
+	}
+	return t.Start(ctx, name, attrs...)
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(...Attribute) {}
+func (noopSpan) RecordError(error)          {}
+func (noopSpan) End()                       {}
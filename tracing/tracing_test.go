@@ -0,0 +1,143 @@
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/shasderias/modbus"
+	"github.com/shasderias/modbus/mbtest"
+	"github.com/shasderias/modbus/simulator"
+	"github.com/shasderias/modbus/tracing"
+	"github.com/shasderias/modbus/transport/rtu"
+	"github.com/shasderias/modbus/transport/tcp"
+)
+
+var testMap = simulator.Map{Units: []simulator.Unit{{ID: 1, Size: map[string]int{"holding": 8}}}}
+
+// request sends a read that succeeds and one that fails with an exception
+// within a parent span, and returns the spans recorded.
+func request(t *testing.T, recorder *tracing.Recorder, transport modbus.ClientTransport) []tracing.RecordedSpan {
+	t.Helper()
+
+	client, err := modbus.NewClient(1, transport, func(c *modbus.ClientConfig) {
+		c.Tracer = recorder
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, parent := recorder.Start(context.Background(), "poll")
+	if _, err := client.WithContext(ctx).ReadHoldingRegisters(2, 3); err != nil {
+		t.Fatal(err)
+	}
+	var exception *modbus.ExceptionResponse
+	if _, err := client.WithContext(ctx).ReadHoldingRegisters(6, 4); !errors.As(err, &exception) {
+		t.Fatalf("got %v; want exception", err)
+	}
+	parent.End()
+
+	return recorder.Spans()
+}
+
+type span struct {
+	Name     string
+	ParentID uint64
+	Failed   bool
+}
+
+func summarize(spans []tracing.RecordedSpan) []span {
+	var s []span
+	for _, sp := range spans {
+		s = append(s, span{sp.Name, sp.ParentID, sp.Err != nil})
+	}
+	return s
+}
+
+func TestTCP(t *testing.T) {
+	_, conn, stop := mbtest.StartSimulatorTCP(t, testMap)
+	defer stop()
+
+	recorder := tracing.NewRecorder()
+	transport, err := tcp.NewClient(conn, func(c *tcp.ClientConfig) {
+		c.Tracer = recorder
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spans := request(t, recorder, transport)
+
+	// IDs are assigned in the order spans start: the parent, then the
+	// request, queue and wire spans of each request
+	want := []span{
+		{"modbus/tcp queue", 2, false},
+		{"modbus/tcp wire", 2, false},
+		{"modbus Read Holding Registers", 1, false},
+		{"modbus/tcp queue", 5, false},
+		{"modbus/tcp wire", 5, false},
+		{"modbus Read Holding Registers", 1, true},
+		{"poll", 0, false},
+	}
+	if diff := cmp.Diff(summarize(spans), want); diff != "" {
+		t.Fatal(diff)
+	}
+
+	attrs := spans[2].Attributes
+	if remote := attrs[tracing.KeyRemote]; remote != conn.RemoteAddr().String() {
+		t.Fatalf("got remote %v; want %v", remote, conn.RemoteAddr())
+	}
+	delete(attrs, tracing.KeyRemote)
+	if diff := cmp.Diff(attrs, map[string]any{
+		tracing.KeyTransport:    "tcp",
+		tracing.KeyTxID:         1,
+		tracing.KeyUnitID:       1,
+		tracing.KeyFunctionCode: modbus.FuncCodeReadHoldingRegisters,
+		tracing.KeyAddress:      2,
+		tracing.KeyCount:        3,
+	}); diff != "" {
+		t.Fatal(diff)
+	}
+
+	if got, want := spans[5].Attributes[tracing.KeyExceptionCode], modbus.ExceptionCodeIllegalDataAddress; got != want {
+		t.Fatalf("got exception code %v; want %v", got, want)
+	}
+}
+
+func TestRTU(t *testing.T) {
+	_, port := mbtest.StartSimulatorRTU(t, testMap)
+
+	recorder := tracing.NewRecorder()
+	transport := rtu.NewClient(port, func(c *rtu.ClientConfig) {
+		c.Tracer = recorder
+	})
+
+	spans := request(t, recorder, transport)
+
+	want := []span{
+		{"rtu/client wire", 2, false},
+		{"modbus Read Holding Registers", 1, false},
+		{"rtu/client wire", 4, false},
+		{"modbus Read Holding Registers", 1, true},
+		{"poll", 0, false},
+	}
+	if diff := cmp.Diff(summarize(spans), want); diff != "" {
+		t.Fatal(diff)
+	}
+	if got, want := spans[1].Attributes[tracing.KeyTransport], "rtu"; got != want {
+		t.Fatalf("got transport %v; want %v", got, want)
+	}
+}
+
+func TestNoTracer(t *testing.T) {
+	ctx, span := tracing.Start(context.Background(), nil, "request")
+	span.SetAttributes(tracing.Int(tracing.KeyUnitID, 1))
+	span.RecordError(errors.New("error"))
+	span.End()
+
+	if ctx != context.Background() {
+		t.Fatal("got new context; want ctx")
+	}
+}
@@ -1,25 +1,47 @@
 package rtu
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"log/slog"
 	"time"
 
 	"github.com/shasderias/modbus"
+	"github.com/shasderias/modbus/internal/logging"
+	"github.com/shasderias/modbus/tracing"
 )
 
 type Client struct {
-	conf *ClientConfig
-	port Port
+	conf     *ClientConfig
+	port     Port
+	logger   *slog.Logger
+	observer modbus.Observer
+	tracer   tracing.Tracer
 }
 
 type ClientConfig struct {
 	RequestTimeout time.Duration
 
-	ResponseHandlers map[byte]responseHandler
-}
+	// InterFrameDelay is the silent interval that marks the end of a response
+	// whose length cannot be determined from its contents, such as the
+	// response to a user-defined function code. Defaults to
+	// InterFrameDelay(19200). Adapters that buffer received bytes, such as
+	// USB to serial converters, may require a longer interval.
+	InterFrameDelay time.Duration
+
+	// Logger receives failed requests and, at debug level, every frame sent
+	// and received. Defaults to discarding.
+	Logger *slog.Logger
+
+	// Observer, if set, is notified of every request, with the lengths of
+	// the frames sent and received, its outcome and latency.
+	Observer modbus.Observer
 
-type responseHandler func(request modbus.PDU, buf []byte, port io.Reader) ([]byte, error)
+	// Tracer, if set, starts a child span of the span of every request for
+	// the time it is on the wire. See package tracing.
+	Tracer tracing.Tracer
+}
 
 type Port interface {
 	io.ReadWriteCloser
@@ -29,47 +51,86 @@ type Port interface {
 
 func NewClient(port Port, cFns ...func(config *ClientConfig)) *Client {
 	conf := &ClientConfig{
-		RequestTimeout: 300 * time.Millisecond,
-
-		ResponseHandlers: map[byte]responseHandler{
-			modbus.FuncCodeReadHoldingRegisters: readResponseHandler,
-			modbus.FuncCodeReadInputRegisters:   readResponseHandler,
-			modbus.FuncCodeReadCoils:            readResponseHandler,
-			modbus.FuncCodeReadDiscreteInputs:   readResponseHandler,
-
-			modbus.FuncCodeWriteSingleRegister:    writeResponseHandler,
-			modbus.FuncCodeWriteMultipleRegisters: writeResponseHandler,
-			modbus.FuncCodeWriteSingleCoil:        writeResponseHandler,
-			modbus.FuncCodeWriteMultipleCoils:     writeResponseHandler,
-
-			0x80 | modbus.FuncCodeReadHoldingRegisters: exceptionResponseHandler,
-			0x80 | modbus.FuncCodeReadInputRegisters:   exceptionResponseHandler,
-			0x80 | modbus.FuncCodeReadCoils:            exceptionResponseHandler,
-			0x80 | modbus.FuncCodeReadDiscreteInputs:   exceptionResponseHandler,
-
-			0x80 | modbus.FuncCodeWriteSingleRegister:    exceptionResponseHandler,
-			0x80 | modbus.FuncCodeWriteMultipleRegisters: exceptionResponseHandler,
-			0x80 | modbus.FuncCodeWriteSingleCoil:        exceptionResponseHandler,
-			0x80 | modbus.FuncCodeWriteMultipleCoils:     exceptionResponseHandler,
-		},
+		RequestTimeout:  300 * time.Millisecond,
+		InterFrameDelay: InterFrameDelay(19200),
 	}
 	for _, cFn := range cFns {
 		cFn(conf)
 	}
 	return &Client{
-		conf: conf,
-		port: port,
+		conf:     conf,
+		port:     port,
+		logger:   logging.OrDiscard(conf.Logger),
+		observer: conf.Observer,
+		tracer:   conf.Tracer,
 	}
 }
 
 func (c *Client) WriteRequest(slaveAddress byte, r modbus.PDU) (modbus.PDU, error) {
+	return c.WriteRequestContext(context.Background(), slaveAddress, r)
+}
+
+// WriteRequestContext is like WriteRequest, but does not send the request if
+// ctx is already done, and adds the transport to the span in ctx. A request
+// that has been sent is not abandoned when ctx is done, as the line is busy
+// until the response arrives or times out.
+func (c *Client) WriteRequestContext(ctx context.Context, slaveAddress byte, r modbus.PDU) (modbus.PDU, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	tracing.SpanFromContext(ctx).SetAttributes(tracing.String(tracing.KeyTransport, "rtu"))
+
+	_, span := tracing.Start(ctx, c.tracer, "rtu/client wire")
+	start := time.Now()
+
+	resp, err := c.writeRequest(ctx, slaveAddress, r, start)
+	latency := time.Since(start)
+
+	span.RecordError(err)
+	span.End()
+
+	if err != nil {
+		c.logger.LogAttrs(ctx, slog.LevelWarn, "rtu/client: request failed",
+			logging.UnitID(slaveAddress), logging.FunctionCode(r.FunctionCode()),
+			logging.Latency(latency), logging.Err(err))
+	}
+	if c.observer != nil {
+		c.observe(slaveAddress, r, resp, err, latency)
+	}
+
+	return resp, err
+}
+
+func (c *Client) observe(slaveAddress byte, req, resp modbus.PDU, err error, latency time.Duration) {
+	// slave address and CRC
+	const overhead = 3
+
+	e := modbus.RequestEvent{
+		UnitID:       slaveAddress,
+		FunctionCode: req.FunctionCode(),
+		Latency:      latency,
+		Err:          err,
+	}
+	e.Outcome, e.ExceptionCode = modbus.ClassifyOutcome(resp, err)
+	if b, err := req.MarshalBinary(); err == nil {
+		e.RequestBytes = overhead + len(b)
+	}
+	if resp != nil {
+		if b, err := resp.MarshalBinary(); err == nil {
+			e.ResponseBytes = overhead + len(b)
+		}
+	}
+	c.observer.ObserveRequest(e)
+}
+
+func (c *Client) writeRequest(ctx context.Context, slaveAddress byte, r modbus.PDU, start time.Time) (modbus.PDU, error) {
 	reqFrame := assembleFrame(slaveAddress, r)
 
 	if err := c.port.SetWriteDeadline(time.Now().Add(c.conf.RequestTimeout)); err != nil {
 		return nil, err
 	}
 
-	n, err := c.port.Write(assembleFrame(slaveAddress, r))
+	n, err := c.port.Write(reqFrame)
 	if err != nil {
 		return nil, fmt.Errorf("rtu/client: error writing request: %w", err)
 	}
@@ -77,6 +138,9 @@ func (c *Client) WriteRequest(slaveAddress byte, r modbus.PDU) (modbus.PDU, erro
 		return nil, fmt.Errorf("rtu/client: short write: %d/%d", n, len(reqFrame))
 	}
 
+	c.logger.LogAttrs(ctx, slog.LevelDebug, "rtu/client: sent request",
+		logging.UnitID(slaveAddress), logging.FunctionCode(r.FunctionCode()), logging.Frame(reqFrame))
+
 	if slaveAddress == 0 {
 		return nil, nil
 	}
@@ -89,76 +153,48 @@ func (c *Client) WriteRequest(slaveAddress byte, r modbus.PDU) (modbus.PDU, erro
 
 	// read slave address and function code
 	if _, err = io.ReadFull(c.port, respFrame[0:2]); err != nil {
-		return nil, fmt.Errorf("rtu/client: error reading response [0:3]: %w", err)
+		return nil, readError("error reading response [0:2]", err)
 	}
 
-	respSlaveAddress, respFuncCode := respFrame[0], respFrame[1]
+	respSlaveAddress := respFrame[0]
 
 	if respSlaveAddress != slaveAddress {
-		return nil, fmt.Errorf("rtu/client: unexpected slave address, sent: %d, recv: %d", slaveAddress, respSlaveAddress)
-	}
-
-	respHandler, ok := c.conf.ResponseHandlers[respFuncCode]
-	if !ok {
-		return nil, fmt.Errorf("rtu/client: unsupported response function code: %d", respFuncCode)
+		return nil, fmt.Errorf("rtu/client: %w: slave address, sent: %d, recv: %d", modbus.ErrUnexpectedResponse, slaveAddress, respSlaveAddress)
 	}
 
-	frame, err := respHandler(r, respFrame, c.port)
+	reqPDU, err := r.MarshalBinary()
 	if err != nil {
 		return nil, err
 	}
 
-	return decodeFrame(frame)
-}
-
-func (c *Client) Close() error {
-	return c.port.Close()
-}
-
-func writeResponseHandler(request modbus.PDU, buf []byte, port io.Reader) ([]byte, error) {
-	n, err := io.ReadFull(port, buf[2:8])
+	frame, err := readFrame(c.port, respFrame, func(pdu []byte) int {
+		return responseLength(reqPDU, pdu)
+	}, c.conf.InterFrameDelay)
 	if err != nil {
-		return nil, fmt.Errorf("rtu/client: error reading response[2:8]: %w", err)
-	}
-	if n < 6 {
-		return nil, fmt.Errorf("rtu/client: short read: %d/6", n)
+		return nil, readError("error reading response", err)
 	}
 
-	return buf[:8], nil
-}
+	c.logger.LogAttrs(ctx, slog.LevelDebug, "rtu/client: received response",
+		logging.UnitID(slaveAddress), logging.FunctionCode(frame[1]),
+		logging.Latency(time.Since(start)), logging.Frame(frame))
 
-func readResponseHandler(request modbus.PDU, buf []byte, port io.Reader) ([]byte, error) {
-	n, err := io.ReadFull(port, buf[2:3])
+	pdu, err := decodeFrame(frame)
 	if err != nil {
-		return nil, fmt.Errorf("rtu/client: error reading response[2:3]: %w", err)
-	}
-	if n < 1 {
-		return nil, fmt.Errorf("rtu/client: short read: %d/1", n)
-	}
-
-	remainderLength := int(buf[2])
-	if 3+remainderLength+2 > maxFrameLength {
-		return nil, fmt.Errorf("rtu/client: response length exceeds maximum RTU frame length")
+		// not a nil *modbus.RawPDU, which is a non-nil modbus.PDU
+		return nil, err
 	}
+	return pdu, nil
+}
 
-	n, err = io.ReadFull(port, buf[3:3+remainderLength+2])
-	if err != nil {
-		return nil, fmt.Errorf("rtu/client: error reading response[3:%d]: %w", 3+remainderLength+2, err)
-	}
-	if n < remainderLength+2 {
-		return nil, fmt.Errorf("rtu/client: short read[3:%d]: %d/%d", 3+remainderLength+2, n, remainderLength+2)
+// readError wraps err, an error reading a response. Timeouts also wrap
+// modbus.ErrTimeout.
+func readError(msg string, err error) error {
+	if modbus.IsTimeout(err) {
+		return fmt.Errorf("rtu/client: %w: %s: %w", modbus.ErrTimeout, msg, err)
 	}
-
-	return buf[:3+remainderLength+2], nil
+	return fmt.Errorf("rtu/client: %s: %w", msg, err)
 }
 
-func exceptionResponseHandler(request modbus.PDU, buf []byte, port io.Reader) ([]byte, error) {
-	n, err := io.ReadFull(port, buf[2:5])
-	if err != nil {
-		return nil, fmt.Errorf("rtu/client: error reading response[2:3]: %w", err)
-	}
-	if n < 1 {
-		return nil, fmt.Errorf("rtu/client: short read: %d/1", n)
-	}
-	return buf[:5], nil
+func (c *Client) Close() error {
+	return c.port.Close()
 }
@@ -0,0 +1,209 @@
+package rtu
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/shasderias/modbus"
+)
+
+// unknownLength is returned by the length functions when the length of a PDU
+// cannot be determined from its contents. The end of such a PDU can only be
+// found by waiting for the line to go silent.
+const unknownLength = modbus.UnknownLength
+
+const (
+	meiTypeReadDeviceIdentification = 0x0e
+
+	diagnosticReturnQueryData = 0x0000
+)
+
+// requestLength returns the length of the request PDU that begins with pdu.
+//
+// If the returned length exceeds len(pdu), more bytes are required; read
+// until len(pdu) reaches the returned length and call requestLength again.
+// The length of the PDU has been determined when the returned length equals
+// len(pdu). pdu must be at least 1 byte long.
+func requestLength(pdu []byte) int {
+	switch pdu[0] {
+	case
+		modbus.FuncCodeReadCoils,
+		modbus.FuncCodeReadDiscreteInputs,
+		modbus.FuncCodeReadHoldingRegisters,
+		modbus.FuncCodeReadInputRegisters,
+		modbus.FuncCodeWriteSingleCoil,
+		modbus.FuncCodeWriteSingleRegister:
+		// function code, address, quantity or value
+		return 5
+	case
+		modbus.FuncCodeReadExceptionStatus,
+		modbus.FuncCodeGetCommEventCounter,
+		modbus.FuncCodeGetCommEventLog,
+		modbus.FuncCodeReportServerID:
+		return 1
+	case modbus.FuncCodeDiagnostic:
+		// function code, sub-function, data
+		if len(pdu) < 3 {
+			return 3
+		}
+		if binary.BigEndian.Uint16(pdu[1:3]) == diagnosticReturnQueryData {
+			// the query data may be of any length
+			return unknownLength
+		}
+		return 5
+	case
+		modbus.FuncCodeWriteMultipleCoils,
+		modbus.FuncCodeWriteMultipleRegisters:
+		// function code, address, quantity, byte count, values
+		if len(pdu) < 6 {
+			return 6
+		}
+		return 6 + int(pdu[5])
+	case
+		modbus.FuncCodeReadFileRecord,
+		modbus.FuncCodeWriteFileRecord:
+		return byteCountLength(pdu)
+	case modbus.FuncCodeMaskWriteRegister:
+		// function code, address, and mask, or mask
+		return 7
+	case modbus.FuncCodeReadWriteMultipleRegisters:
+		// function code, read address, read quantity, write address,
+		// write quantity, byte count, values
+		if len(pdu) < 10 {
+			return 10
+		}
+		return 10 + int(pdu[9])
+	case modbus.FuncCodeReadFIFOQueue:
+		// function code, FIFO pointer address
+		return 3
+	case modbus.FuncCodeEncapsulatedInterfaceTransport:
+		if len(pdu) < 2 {
+			return 2
+		}
+		if pdu[1] == meiTypeReadDeviceIdentification {
+			// function code, MEI type, read device ID code, object ID
+			return 4
+		}
+		return unknownLength
+	default:
+		if f, ok := modbus.LookupFunction(pdu[0]); ok && f.RequestLength != nil {
+			return f.RequestLength(pdu)
+		}
+		return unknownLength
+	}
+}
+
+// responseLength returns the length of the response PDU that begins with pdu.
+// req is the request PDU the response answers. See requestLength for how the
+// returned length is to be interpreted.
+func responseLength(req, pdu []byte) int {
+	if pdu[0]&0x80 != 0 {
+		// error code, exception code
+		return 2
+	}
+
+	switch pdu[0] {
+	case
+		modbus.FuncCodeReadCoils,
+		modbus.FuncCodeReadDiscreteInputs,
+		modbus.FuncCodeReadHoldingRegisters,
+		modbus.FuncCodeReadInputRegisters,
+		modbus.FuncCodeGetCommEventLog,
+		modbus.FuncCodeReportServerID,
+		modbus.FuncCodeReadFileRecord,
+		modbus.FuncCodeWriteFileRecord,
+		modbus.FuncCodeReadWriteMultipleRegisters:
+		return byteCountLength(pdu)
+	case
+		modbus.FuncCodeWriteSingleCoil,
+		modbus.FuncCodeWriteSingleRegister,
+		modbus.FuncCodeWriteMultipleCoils,
+		modbus.FuncCodeWriteMultipleRegisters:
+		// function code, address, quantity or value
+		return 5
+	case modbus.FuncCodeReadExceptionStatus:
+		// function code, output data
+		return 2
+	case modbus.FuncCodeDiagnostic:
+		if len(pdu) < 3 {
+			return 3
+		}
+		if binary.BigEndian.Uint16(pdu[1:3]) == diagnosticReturnQueryData {
+			// the request is echoed
+			if len(req) >= 3 && req[0] == modbus.FuncCodeDiagnostic {
+				return len(req)
+			}
+			return unknownLength
+		}
+		return 5
+	case modbus.FuncCodeGetCommEventCounter:
+		// function code, status, event count
+		return 5
+	case modbus.FuncCodeMaskWriteRegister:
+		return 7
+	case modbus.FuncCodeReadFIFOQueue:
+		// function code, byte count (2 bytes), FIFO count, values
+		if len(pdu) < 3 {
+			return 3
+		}
+		return 3 + int(binary.BigEndian.Uint16(pdu[1:3]))
+	case modbus.FuncCodeEncapsulatedInterfaceTransport:
+		if len(pdu) < 2 {
+			return 2
+		}
+		if pdu[1] == meiTypeReadDeviceIdentification {
+			return readDeviceIdentificationLength(pdu)
+		}
+		return unknownLength
+	default:
+		if f, ok := modbus.LookupFunction(pdu[0]); ok && f.ResponseLength != nil {
+			return f.ResponseLength(req, pdu)
+		}
+		return unknownLength
+	}
+}
+
+// byteCountLength returns the length of a PDU consisting of a function code,
+// a byte count, and that many bytes of data.
+func byteCountLength(pdu []byte) int {
+	if len(pdu) < 2 {
+		return 2
+	}
+	return 2 + int(pdu[1])
+}
+
+func readDeviceIdentificationLength(pdu []byte) int {
+	// function code, MEI type, read device ID code, conformity level,
+	// more follows, next object ID, number of objects
+	const headerLength = 7
+
+	if len(pdu) < headerLength {
+		return headerLength
+	}
+
+	n := headerLength
+	for i := 0; i < int(pdu[6]); i++ {
+		// object ID, object length
+		if len(pdu) < n+2 {
+			return n + 2
+		}
+		n += 2 + int(pdu[n+1])
+	}
+
+	return n
+}
+
+// InterFrameDelay returns the minimum silent interval (t3.5) that separates
+// two frames on a line running at baudRate.
+//
+// Modbus over Serial Line V1.02, 2.5.1.1: 3.5 character times, fixed at
+// 1.75 ms for baud rates greater than 19200.
+func InterFrameDelay(baudRate int) time.Duration {
+	if baudRate <= 0 || baudRate > 19200 {
+		return 1750 * time.Microsecond
+	}
+
+	// 11 bits per character: start bit, 8 data bits, parity or second stop
+	// bit, stop bit
+	return time.Duration(3.5 * 11 * float64(time.Second) / float64(baudRate))
+}
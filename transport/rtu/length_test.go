@@ -0,0 +1,179 @@
+package rtu
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/shasderias/modbus"
+	"github.com/shasderias/modbus/internal/databuilder"
+)
+
+// bufferPort is a Port that reads from a buffer. Reading past the end of the
+// buffer times out.
+type bufferPort struct {
+	bytes.Buffer
+}
+
+func (p *bufferPort) Read(b []byte) (int, error) {
+	if p.Len() == 0 {
+		return 0, os.ErrDeadlineExceeded
+	}
+	return p.Buffer.Read(b)
+}
+
+func (p *bufferPort) Close() error                     { return nil }
+func (p *bufferPort) SetReadDeadline(time.Time) error  { return nil }
+func (p *bufferPort) SetWriteDeadline(time.Time) error { return nil }
+
+func TestFrameLength(t *testing.T) {
+	testCases := []struct {
+		name     string
+		request  bool
+		req, pdu []byte
+	}{
+		{"ReadHoldingRegistersRequest", true, nil, []byte{0x03, 0x00, 0x6b, 0x00, 0x03}},
+		{"ReadHoldingRegistersResponse", false, nil, []byte{0x03, 0x06, 0x02, 0x2b, 0x00, 0x00, 0x00, 0x64}},
+		{"ReadCoilsResponse", false, nil, []byte{0x01, 0x03, 0xcd, 0x6b, 0x05}},
+		{"WriteSingleCoilResponse", false, nil, []byte{0x05, 0x00, 0xac, 0xff, 0x00}},
+		{"ReadExceptionStatusRequest", true, nil, []byte{0x07}},
+		{"ReadExceptionStatusResponse", false, nil, []byte{0x07, 0x6d}},
+		{"DiagnosticRequest", true, nil, []byte{0x08, 0x00, 0x0a, 0x00, 0x00}},
+		{"DiagnosticResponse", false, nil, []byte{0x08, 0x00, 0x0a, 0x00, 0x00}},
+		{"DiagnosticReturnQueryDataRequest", true, nil, []byte{0x08, 0x00, 0x00, 0xa5, 0x37, 0x01}},
+		{"DiagnosticReturnQueryDataResponse", false,
+			[]byte{0x08, 0x00, 0x00, 0xa5, 0x37, 0x01},
+			[]byte{0x08, 0x00, 0x00, 0xa5, 0x37, 0x01}},
+		{"GetCommEventCounterRequest", true, nil, []byte{0x0b}},
+		{"GetCommEventCounterResponse", false, nil, []byte{0x0b, 0xff, 0xff, 0x01, 0x08}},
+		{"GetCommEventLogResponse", false, nil, []byte{0x0c, 0x08, 0x00, 0x00, 0x01, 0x08, 0x01, 0x21, 0x20, 0x00}},
+		{"WriteMultipleCoilsRequest", true, nil, []byte{0x0f, 0x00, 0x13, 0x00, 0x0a, 0x02, 0xcd, 0x01}},
+		{"WriteMultipleRegistersRequest", true, nil, []byte{0x10, 0x00, 0x01, 0x00, 0x02, 0x04, 0x00, 0x0a, 0x01, 0x02}},
+		{"WriteMultipleRegistersResponse", false, nil, []byte{0x10, 0x00, 0x01, 0x00, 0x02}},
+		{"ReportServerIDResponse", false, nil, []byte{0x11, 0x03, 0x01, 0xff, 0x42}},
+		{"ReadFileRecordRequest", true, nil, []byte{0x14, 0x07, 0x06, 0x00, 0x04, 0x00, 0x01, 0x00, 0x02}},
+		{"MaskWriteRegisterRequest", true, nil, []byte{0x16, 0x00, 0x04, 0x00, 0xf2, 0x00, 0x25}},
+		{"MaskWriteRegisterResponse", false, nil, []byte{0x16, 0x00, 0x04, 0x00, 0xf2, 0x00, 0x25}},
+		{"ReadWriteMultipleRegistersRequest", true, nil,
+			[]byte{0x17, 0x00, 0x03, 0x00, 0x06, 0x00, 0x0e, 0x00, 0x03, 0x06, 0x00, 0xff, 0x00, 0xff, 0x00, 0xff}},
+		{"ReadFIFOQueueRequest", true, nil, []byte{0x18, 0x04, 0xde}},
+		{"ReadFIFOQueueResponse", false, nil, []byte{0x18, 0x00, 0x06, 0x00, 0x02, 0x01, 0xb8, 0x12, 0x84}},
+		{"ReadDeviceIdentificationRequest", true, nil, []byte{0x2b, 0x0e, 0x01, 0x00}},
+		{"ReadDeviceIdentificationResponse", false, nil, []byte{
+			0x2b, 0x0e, 0x01, 0x01, 0x00, 0x00, 0x03,
+			0x00, 0x03, 'A', 'B', 'C',
+			0x01, 0x02, 'P', 'N',
+			0x02, 0x00,
+		}},
+		{"ExceptionResponse", false, nil, []byte{0x83, 0x02}},
+		{"UserDefinedExceptionResponse", false, nil, []byte{0xc1, 0x01}},
+		{"UserDefinedRequest", true, nil, []byte{0x41, 0x01, 0x02, 0x03}},
+		{"UserDefinedResponse", false, nil, []byte{0x41, 0x01, 0x02, 0x03, 0x04, 0x05}},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			frame := databuilder.New(len(tt.pdu) + 3).WriteBytes(0x01).WriteBytes(tt.pdu...).BytesWithCRC()
+
+			var port bufferPort
+			port.Write(frame[2:])
+			// trailing bytes of a following frame must not be consumed
+			port.Write([]byte{0x01, 0x03})
+
+			buf := make([]byte, maxFrameLength)
+			copy(buf, frame[:2])
+
+			pduLength := func(pdu []byte) int { return responseLength(tt.req, pdu) }
+			if tt.request {
+				pduLength = requestLength
+			}
+
+			if requestLength(tt.pdu) == unknownLength && tt.request ||
+				responseLength(tt.req, tt.pdu) == unknownLength && !tt.request {
+				// silence detection consumes everything available
+				port.Truncate(len(frame) - 2)
+			}
+
+			got, err := readFrame(&port, buf, pduLength, time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(got, frame); diff != "" {
+				t.Fatal(diff)
+			}
+
+			if _, err := decodeFrame(got); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestFrameTooLong(t *testing.T) {
+	var port bufferPort
+	port.Write(make([]byte, maxFrameLength))
+
+	buf := make([]byte, maxFrameLength)
+	copy(buf, []byte{0x01, 0x41})
+
+	if _, err := readFrame(&port, buf, requestLength, time.Millisecond); err == nil {
+		t.Fatal("got nil error; want error reading frame that exceeds maximum length")
+	}
+}
+
+func TestInterFrameDelay(t *testing.T) {
+	testCases := []struct {
+		baudRate int
+		want     time.Duration
+	}{
+		{9600, 4010416 * time.Nanosecond},
+		{19200, 2005208 * time.Nanosecond},
+		{38400, 1750 * time.Microsecond},
+		{115200, 1750 * time.Microsecond},
+	}
+
+	for _, tt := range testCases {
+		if got := InterFrameDelay(tt.baudRate); got != tt.want {
+			t.Errorf("InterFrameDelay(%d) = %v; want %v", tt.baudRate, got, tt.want)
+		}
+	}
+}
+
+func TestRegisteredFunctionLength(t *testing.T) {
+	const funcCode = 0x64
+
+	err := modbus.RegisterFunction(modbus.Function{
+		Code: funcCode,
+		// function code, byte count, data
+		ResponseLength: func(req, pdu []byte) int {
+			if len(pdu) < 2 {
+				return 2
+			}
+			return 2 + int(pdu[1])
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { modbus.UnregisterFunction(funcCode) })
+
+	frame := databuilder.New(8).WriteBytes(0x01, funcCode, 0x03, 0x0a, 0x0b, 0x0c).BytesWithCRC()
+
+	var port bufferPort
+	port.Write(frame[2:])
+	port.Write([]byte{0x01, 0x03})
+
+	buf := make([]byte, maxFrameLength)
+	copy(buf, frame[:2])
+
+	got, err := readFrame(&port, buf, func(pdu []byte) int { return responseLength(nil, pdu) }, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, frame); diff != "" {
+		t.Fatal(diff)
+	}
+}
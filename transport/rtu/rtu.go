@@ -2,6 +2,9 @@ package rtu
 
 import (
 	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
 
 	"github.com/shasderias/modbus"
 	"github.com/shasderias/modbus/internal/crc"
@@ -26,7 +29,79 @@ func assembleFrame(slaveAddress byte, pdu modbus.PDU) []byte {
 	return buf.BytesWithCRC()
 }
 
+// readFrame reads the remainder of a frame from port into buf. The slave
+// address and function code must already have been read into buf[0:2].
+//
+// pduLength is called with the PDU read so far to determine how many more
+// bytes to read (see requestLength). If it returns unknownLength, bytes are
+// read until the line has been silent for interFrameDelay.
+func readFrame(port Port, buf []byte, pduLength func(pdu []byte) int, interFrameDelay time.Duration) ([]byte, error) {
+	n := 1 // bytes of the PDU that have been read
+
+	for {
+		want := pduLength(buf[1 : 1+n])
+		if want == unknownLength {
+			return readUntilSilent(port, buf, 1+n, interFrameDelay)
+		}
+		if want <= n {
+			break
+		}
+		// slave address + PDU + CRC
+		if 1+want+2 > len(buf) {
+			return nil, fmt.Errorf("%w: length %d exceeds maximum RTU frame length", modbus.ErrFrameTooLong, 1+want+2)
+		}
+
+		if _, err := io.ReadFull(port, buf[1+n:1+want]); err != nil {
+			return nil, fmt.Errorf("error reading [%d:%d]: %w", 1+n, 1+want, err)
+		}
+		n = want
+	}
+
+	if _, err := io.ReadFull(port, buf[1+n:1+n+2]); err != nil {
+		return nil, fmt.Errorf("error reading CRC [%d:%d]: %w", 1+n, 1+n+2, err)
+	}
+
+	return buf[:1+n+2], nil
+}
+
+// readUntilSilent reads into buf[n:] until no bytes have been received for
+// interFrameDelay.
+func readUntilSilent(port Port, buf []byte, n int, interFrameDelay time.Duration) ([]byte, error) {
+	for {
+		if err := port.SetReadDeadline(time.Now().Add(interFrameDelay)); err != nil {
+			return nil, err
+		}
+
+		if n == len(buf) {
+			// the frame must end here, any further bytes mean it is too long
+			var b [1]byte
+			m, err := port.Read(b[:])
+			if m == 0 && (err == nil || modbus.IsTimeout(err)) {
+				return buf, nil
+			}
+			if err != nil {
+				return nil, fmt.Errorf("error reading [%d:]: %w", n, err)
+			}
+			return nil, fmt.Errorf("%w: exceeds maximum RTU frame length", modbus.ErrFrameTooLong)
+		}
+
+		m, err := port.Read(buf[n:])
+		n += m
+		switch {
+		case m == 0 && (err == nil || modbus.IsTimeout(err)):
+			return buf[:n], nil
+		case err != nil && !modbus.IsTimeout(err):
+			return nil, fmt.Errorf("error reading [%d:]: %w", n, err)
+		}
+	}
+}
+
 func decodeFrame(b []byte) (*modbus.RawPDU, error) {
+	// slave address, function code, CRC
+	if len(b) < 4 {
+		return nil, fmt.Errorf("rtu: %w: %x", modbus.ErrFrameTooShort, b)
+	}
+
 	var (
 		_        = b[0] // slave address
 		pduBytes = b[1 : len(b)-2]
@@ -6,6 +6,7 @@ import (
 
 	"github.com/shasderias/modbus"
 	"github.com/shasderias/modbus/mbtest"
+	"github.com/shasderias/modbus/simulator"
 	"github.com/shasderias/modbus/transport/rtu"
 )
 
@@ -300,9 +301,8 @@ func logfAsErr(t *testing.T, format string, args ...any) error {
 //			})
 //		}
 //	}
-func TestDiagSlave(t *testing.T) {
-	port := mbtest.StartDiagSlaveRTU(t)
-	defer port.Close()
+func TestSimulator(t *testing.T) {
+	_, port := mbtest.StartSimulatorRTU(t, simulator.DefaultMap())
 
 	transport := rtu.NewClient(port)
 
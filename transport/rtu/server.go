@@ -1,69 +1,259 @@
 package rtu
 
-//func (t *Client) WriteResponse(slaveAddress byte, r modbus.PDU) error {
-//	respFrame := assembleFrame(slaveAddress, r)
-//	n, err := t.port.Write(respFrame)
-//	if err != nil {
-//		return fmt.Errorf("error writing response: %w", err)
-//	}
-//	if n != len(respFrame) {
-//		return fmt.Errorf("short write: %d/%d", n, len(respFrame))
-//	}
-//	return nil
-//}
-//
-//func (t *Client) ReadRequest() (byte, modbus.PDU, error) {
-//	buf := make([]byte, maxFrameLength)
-//
-//	if _, err := io.ReadFull(t.port, buf[0:2]); err != nil {
-//		return 0, nil, fmt.Errorf("error reading request: %w", err)
-//	}
-//
-//	switch buf[1] {
-//	case
-//		modbus.FuncCodeReadCoils,
-//		modbus.FuncCodeReadDiscreteInputs,
-//		modbus.FuncCodeReadHoldingRegisters,
-//		modbus.FuncCodeReadInputRegisters,
-//
-//		modbus.FuncCodeWriteSingleCoil,
-//		modbus.FuncCodeWriteSingleRegister:
-//		// need to read another 2 (startAddress) + 2 (quantity) + 2 (crc) bytes
-//		if _, err := io.ReadFull(t.port, buf[2:8]); err != nil {
-//			return 0, nil, fmt.Errorf("error reading request: %w", err)
-//		}
-//
-//		buf = buf[:8]
-//	case
-//		modbus.FuncCodeWriteMultipleCoils,
-//		modbus.FuncCodeWriteMultipleRegisters:
-//		// need to read another 2 (startAddress) + 2 (quantity) + 1 (byteCount) + byteCount + 2 (crc) bytes
-//		// read 5 bytes first to determine how many more bytes to read
-//		if _, err := io.ReadFull(t.port, buf[2:7]); err != nil {
-//			return 0, nil, fmt.Errorf("error reading request: %w", err)
-//		}
-//
-//		// 1 (slaveAddress) + 1 (functionCode) + 2 (startAddress) + 2 (quantity) + 1 (byteCount) + byteCount (data) + 2 (crc)
-//		frameLen := 1 + 1 + 2 + 2 + 1 + int(buf[6]) + 2
-//
-//		if frameLen > maxFrameLength {
-//			return 0, nil, fmt.Errorf("expected frame %d exceeds maximum RTU frame length", frameLen)
-//		}
-//
-//		// read remaining byteCount + 2 bytes
-//		if _, err := io.ReadFull(t.port, buf[7:frameLen]); err != nil {
-//			return 0, nil, fmt.Errorf("error reading request: %w", err)
-//		}
-//
-//		buf = buf[:frameLen]
-//	default:
-//		return 0, nil, fmt.Errorf("unsupported function code: %v", buf[0])
-//	}
-//
-//	pdu, err := decodeFrame(buf)
-//	if err != nil {
-//		return 0, nil, err
-//	}
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/shasderias/modbus"
+	"github.com/shasderias/modbus/internal/logging"
+)
+
+type ServerConfig struct {
+	// InterFrameDelay is the silent interval that marks the end of a request
+	// whose length cannot be determined from its contents. Defaults to
+	// InterFrameDelay(19200).
+	InterFrameDelay time.Duration
+
+	// RequestTimeout is how long the server waits for the remainder of a
+	// request once its first bytes have been received.
+	RequestTimeout time.Duration
+
+	// Logger receives errors reading requests, handling them and writing
+	// responses, and, at debug level, every frame received and sent.
+	// Defaults to discarding.
+	Logger *slog.Logger
+}
+
+// Server serves requests received on a serial line.
 //
-//	return buf[0], pdu, nil
-//}
+// Every request is passed to the server's handler with its slave address as
+// the unit ID, so a single server can act as several slaves. The handler
+// returns a nil PDU and a nil error for slave addresses it does not serve, and
+// the server stays silent. Responses to broadcast requests (slave address 0)
+// are never sent. Requests are handled one at a time, in the order they are
+// received.
+type Server struct {
+	conf   ServerConfig
+	port   Port
+	h      modbus.Handler
+	logger *slog.Logger
+
+	mut    sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+	// err is the error that stopped the server, if reading from its port
+	// failed
+	err error
+}
+
+func NewServer(port Port, h modbus.Handler, fns ...func(c *ServerConfig)) (*Server, error) {
+	if h == nil {
+		return nil, fmt.Errorf("rtu/server: nil handler")
+	}
+
+	conf := ServerConfig{
+		InterFrameDelay: InterFrameDelay(19200),
+		RequestTimeout:  300 * time.Millisecond,
+	}
+	for _, fn := range fns {
+		fn(&conf)
+	}
+
+	return &Server{
+		conf:   conf,
+		port:   port,
+		h:      h,
+		logger: logging.OrDiscard(conf.Logger),
+	}, nil
+}
+
+// Start starts serving requests in a new goroutine.
+func (s *Server) Start() error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if s.done != nil {
+		return fmt.Errorf("rtu/server: server already started")
+	}
+
+	var ctx context.Context
+	ctx, s.cancel = context.WithCancel(context.Background())
+	s.done = make(chan struct{})
+	s.err = nil
+
+	go s.serve(ctx, s.done)
+
+	return nil
+}
+
+// Stop stops serving requests and waits for the request being handled, if
+// any, to return. The port is not closed.
+func (s *Server) Stop() error {
+	s.mut.Lock()
+	if s.done == nil {
+		s.mut.Unlock()
+		return nil
+	}
+	s.cancel()
+	done := s.done
+	s.done = nil
+	s.mut.Unlock()
+
+	<-done
+
+	return nil
+}
+
+// Done returns a channel that is closed when the server stops serving,
+// because Stop was called or because reading from its port failed, as when a
+// USB adapter is unplugged; see Err. The channel is closed if the server is
+// not started.
+func (s *Server) Done() <-chan struct{} {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if s.done == nil {
+		done := make(chan struct{})
+		close(done)
+		return done
+	}
+	return s.done
+}
+
+// Err returns the error reading from the port that stopped the server, or nil
+// if the server is serving or was stopped with Stop.
+func (s *Server) Err() error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.err
+}
+
+func (s *Server) serve(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	buf := make([]byte, maxFrameLength)
+
+	for {
+		slaveAddress, req, err := s.readRequest(ctx, buf)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			s.logger.LogAttrs(ctx, slog.LevelWarn, "rtu/server: error reading request", logging.Err(err))
+			// discard the rest of the frame so that the next request is read
+			// from its start
+			if err := s.discard(buf); err != nil {
+				s.logger.LogAttrs(ctx, slog.LevelError, "rtu/server: error reading, stopping server", logging.Err(err))
+
+				s.mut.Lock()
+				s.err = fmt.Errorf("rtu/server: error reading: %w", err)
+				s.mut.Unlock()
+				return
+			}
+			continue
+		}
+
+		received := time.Now()
+		attrs := []slog.Attr{logging.UnitID(slaveAddress), logging.FunctionCode(req.FunctionCode())}
+		if s.logger.Enabled(ctx, slog.LevelDebug) {
+			s.logger.LogAttrs(ctx, slog.LevelDebug, "rtu/server: received request",
+				append(attrs, logging.Frame(assembleFrame(slaveAddress, req)))...)
+		}
+
+		resp, err := modbus.Respond(ctx, s.h, slaveAddress, req)
+		if err != nil {
+			s.logger.LogAttrs(ctx, slog.LevelError, "rtu/server: error handling request",
+				append(attrs, logging.Err(err))...)
+		}
+		if resp == nil || slaveAddress == 0 {
+			continue
+		}
+
+		frame := assembleFrame(slaveAddress, resp)
+		if err := s.port.SetWriteDeadline(time.Now().Add(s.conf.RequestTimeout)); err != nil {
+			s.logger.LogAttrs(ctx, slog.LevelWarn, "rtu/server: error setting write deadline",
+				append(attrs, logging.Err(err))...)
+			continue
+		}
+		if _, err := s.port.Write(frame); err != nil {
+			s.logger.LogAttrs(ctx, slog.LevelWarn, "rtu/server: error writing response",
+				append(attrs, logging.Err(err))...)
+			continue
+		}
+
+		s.logger.LogAttrs(ctx, slog.LevelDebug, "rtu/server: sent response",
+			append(attrs, logging.Latency(time.Since(received)), logging.Frame(frame))...)
+	}
+}
+
+// readRequest waits for a request until ctx is done.
+func (s *Server) readRequest(ctx context.Context, buf []byte) (byte, modbus.PDU, error) {
+	n := 0
+	for n < 2 {
+		if err := ctx.Err(); err != nil {
+			return 0, nil, err
+		}
+
+		timeout := idlePollInterval
+		if n > 0 {
+			timeout = s.conf.RequestTimeout
+		}
+		if err := s.port.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			return 0, nil, fmt.Errorf("rtu/server: error setting read deadline: %w", err)
+		}
+
+		m, err := s.port.Read(buf[n:2])
+		n += m
+		if err != nil && !modbus.IsTimeout(err) {
+			return 0, nil, fmt.Errorf("rtu/server: error reading request: %w", err)
+		}
+		if err != nil && n == 1 {
+			return 0, nil, fmt.Errorf("rtu/server: %w: incomplete request: %x", modbus.ErrFrameTooShort, buf[:n])
+		}
+	}
+
+	if err := s.port.SetReadDeadline(time.Now().Add(s.conf.RequestTimeout)); err != nil {
+		return 0, nil, fmt.Errorf("rtu/server: error setting read deadline: %w", err)
+	}
+
+	frame, err := readFrame(s.port, buf, requestLength, s.conf.InterFrameDelay)
+	if err != nil {
+		return 0, nil, fmt.Errorf("rtu/server: error reading request: %w", err)
+	}
+
+	pdu, err := decodeFrame(frame)
+	if err != nil {
+		return 0, nil, fmt.Errorf("rtu/server: %w", err)
+	}
+
+	// buf is reused for the next request
+	b, _ := pdu.MarshalBinary()
+	pdu, err = modbus.NewRawPDU(append([]byte(nil), b...))
+	if err != nil {
+		return 0, nil, fmt.Errorf("rtu/server: %w", err)
+	}
+
+	return frame[0], pdu, nil
+}
+
+// discard reads from the port until the line is silent. It returns an error
+// only if reading from the port fails.
+func (s *Server) discard(buf []byte) error {
+	for {
+		if err := s.port.SetReadDeadline(time.Now().Add(s.conf.InterFrameDelay)); err != nil {
+			return err
+		}
+
+		n, err := s.port.Read(buf)
+		switch {
+		case n == 0 && (err == nil || modbus.IsTimeout(err)):
+			return nil
+		case err != nil && !modbus.IsTimeout(err):
+			return err
+		}
+	}
+}
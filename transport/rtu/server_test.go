@@ -0,0 +1,246 @@
+package rtu
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/shasderias/modbus"
+)
+
+func TestServer(t *testing.T) {
+	var (
+		mut     sync.Mutex
+		written []uint16
+	)
+
+	h := modbus.HandlerFunc(func(ctx context.Context, unitID byte, req modbus.PDU) (modbus.PDU, error) {
+		if unitID != 1 && unitID != 0 {
+			return nil, nil
+		}
+
+		switch r, _ := modbus.DecodeRequest(req); r := r.(type) {
+		case *modbus.ReadRegisterRequest:
+			return modbus.NewReadRegisterResponseFromUint16s(int(r.FunctionCode()), []uint16{0x1234, 0x5678})
+		case *modbus.WriteSingleRegisterRequest:
+			mut.Lock()
+			written = append(written, uint16(r.Value()[0])<<8|uint16(r.Value()[1]))
+			mut.Unlock()
+			return &modbus.WriteSingleRegisterResponse{WriteSingleRegisterRequest: *r}, nil
+		default:
+			return nil, modbus.NewExceptionResponseTo(req, modbus.ExceptionCodeIllegalFunction)
+		}
+	})
+
+	masterPort, slavePort := net.Pipe()
+	defer masterPort.Close()
+	defer slavePort.Close()
+
+	server, err := NewServer(slavePort, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	transport := NewClient(masterPort, func(c *ClientConfig) {
+		c.RequestTimeout = 100 * time.Millisecond
+	})
+
+	client, err := modbus.NewClient(1, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := client.ReadHoldingRegisters(0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(resp.Uint16(), []uint16{0x1234, 0x5678}); diff != "" {
+		t.Fatal(diff)
+	}
+
+	var exception *modbus.ExceptionResponse
+	if _, err := client.ReadCoils(0, 1); !errors.As(err, &exception) || exception.ExceptionCode() != modbus.ExceptionCodeIllegalFunction {
+		t.Fatalf("got %v; want illegal function exception", err)
+	}
+
+	other, err := modbus.NewClient(2, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.ReadHoldingRegisters(0, 2); err == nil {
+		t.Fatal("got response from unserved slave address")
+	}
+
+	broadcast, err := modbus.NewClient(0, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := broadcast.WriteSingleRegister(0, 7); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := broadcast.ReadHoldingRegisters(0, 1); !errors.Is(err, modbus.ErrBroadcastRead) {
+		t.Fatalf("got %v; want %v", err, modbus.ErrBroadcastRead)
+	}
+	if _, err := broadcast.ReadCoils(0, 1); !errors.Is(err, modbus.ErrBroadcastRead) {
+		t.Fatalf("got %v; want %v", err, modbus.ErrBroadcastRead)
+	}
+
+	// the server must not have answered the broadcast
+	if _, err := client.WriteSingleRegister(0, 8); err != nil {
+		t.Fatal(err)
+	}
+
+	mut.Lock()
+	defer mut.Unlock()
+	if diff := cmp.Diff(written, []uint16{7, 8}); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestServerDiscardsCorruptRequest(t *testing.T) {
+	h := modbus.HandlerFunc(func(ctx context.Context, unitID byte, req modbus.PDU) (modbus.PDU, error) {
+		return modbus.NewReadRegisterResponseFromUint16s(modbus.FuncCodeReadHoldingRegisters, []uint16{1})
+	})
+
+	masterPort, slavePort := net.Pipe()
+	defer masterPort.Close()
+	defer slavePort.Close()
+
+	server, err := NewServer(slavePort, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	corrupt := frame(0x01, 0x03, 0x00, 0x00, 0x00, 0x01)
+	corrupt[len(corrupt)-1] ^= 0xff
+	if _, err := masterPort.Write(corrupt); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	client, err := modbus.NewClient(1, NewClient(masterPort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ReadHoldingRegisters(0, 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerPortFailure(t *testing.T) {
+	h := modbus.HandlerFunc(func(ctx context.Context, unitID byte, req modbus.PDU) (modbus.PDU, error) {
+		return nil, nil
+	})
+
+	masterPort, slavePort := net.Pipe()
+	defer slavePort.Close()
+
+	server, err := NewServer(slavePort, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	select {
+	case <-server.Done():
+		t.Fatal("server stopped before its port failed")
+	default:
+	}
+
+	masterPort.Close()
+
+	select {
+	case <-server.Done():
+	case <-time.After(time.Second):
+		t.Fatal("server still serving after its port failed")
+	}
+	if err := server.Err(); err == nil {
+		t.Fatal("got nil; want error reading from the port")
+	}
+}
+
+func TestLogging(t *testing.T) {
+	h := modbus.HandlerFunc(func(ctx context.Context, unitID byte, req modbus.PDU) (modbus.PDU, error) {
+		return modbus.NewReadRegisterResponseFromUint16s(int(req.FunctionCode()), []uint16{0x1234})
+	})
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	masterPort, slavePort := net.Pipe()
+	defer masterPort.Close()
+	defer slavePort.Close()
+
+	server, err := NewServer(slavePort, h, func(c *ServerConfig) {
+		c.Logger = logger
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := modbus.NewClient(1, NewClient(masterPort, func(c *ClientConfig) {
+		c.RequestTimeout = 100 * time.Millisecond
+		c.Logger = logger
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ReadHoldingRegisters(0, 1); err != nil {
+		t.Fatal(err)
+	}
+	server.Stop()
+
+	type record struct {
+		Msg      string `json:"msg"`
+		Unit     int    `json:"unit"`
+		Function int    `json:"function"`
+		Frame    string `json:"frame"`
+	}
+	var got []record
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var r record
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, r)
+	}
+
+	// the client's and the server's records interleave
+	want := map[string]record{
+		"rtu/client: sent request":      {"rtu/client: sent request", 1, 3, "010300000001840a"},
+		"rtu/server: received request":  {"rtu/server: received request", 1, 3, "010300000001840a"},
+		"rtu/server: sent response":     {"rtu/server: sent response", 1, 3, "0103021234b533"},
+		"rtu/client: received response": {"rtu/client: received response", 1, 3, "0103021234b533"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d records; want %d: %+v", len(got), len(want), got)
+	}
+	for _, r := range got {
+		if diff := cmp.Diff(r, want[r.Msg]); diff != "" {
+			t.Fatal(diff)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package rtu
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/shasderias/modbus"
+	"github.com/shasderias/modbus/internal/crc"
+)
+
+type Direction int
+
+const (
+	DirectionUnknown Direction = iota
+	DirectionRequest
+	DirectionResponse
+)
+
+func (d Direction) String() string {
+	switch d {
+	case DirectionRequest:
+		return "request"
+	case DirectionResponse:
+		return "response"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describes a frame observed by a Sniffer.
+type Event struct {
+	// Time is when the first byte of the frame was received.
+	Time      time.Time
+	Direction Direction
+
+	SlaveAddress byte
+	FunctionCode byte
+
+	// PDU is the frame's PDU, decoded with modbus.DecodeRequest or
+	// modbus.DecodeResponse. It is nil if the frame is corrupt, and a
+	// *modbus.RawPDU if it could not be decoded.
+	PDU modbus.PDU
+
+	// Frame is the frame as received, including the slave address and CRC.
+	Frame []byte
+
+	// Request is the request a response answers. It is nil for requests and
+	// for responses that could not be paired with a request.
+	Request *Event
+
+	// Err is set if the frame is corrupt, for example because its CRC does
+	// not match (modbus.ErrBadCRC), or if its PDU could not be decoded.
+	Err error
+}
+
+// String describes the frame, such as
+//
+//	slave 1: Read Holding Registers (0x03) request: address 0, count 3
+func (e Event) String() string {
+	s := fmt.Sprintf("slave %d: ", e.SlaveAddress)
+	if e.PDU != nil {
+		s += modbus.Describe(e.PDU)
+	} else {
+		s += fmt.Sprintf("%s frame % x", e.Direction, e.Frame)
+	}
+	if e.Err != nil {
+		s += fmt.Sprintf(" (%v)", e.Err)
+	}
+	return s
+}
+
+// SnifferPort is the part of Port a Sniffer requires. A Sniffer never writes
+// to its port.
+type SnifferPort interface {
+	io.Reader
+	SetReadDeadline(time.Time) error
+}
+
+type SnifferConfig struct {
+	// InterFrameDelay is the silent interval that separates frames. Defaults
+	// to InterFrameDelay(19200).
+	InterFrameDelay time.Duration
+
+	// ResponseTimeout is how long after a request a frame from the same
+	// slave is considered to be the response to the request.
+	ResponseTimeout time.Duration
+}
+
+// Sniffer passively monitors a serial line.
+//
+// The byte stream is split into frames at silent intervals. As operating
+// system and adapter buffering may coalesce frames that were sent in quick
+// succession, frames are further split by length and validated by CRC.
+// Requests are paired with the response that follows them.
+type Sniffer struct {
+	conf SnifferConfig
+	port SnifferPort
+
+	pending *Event
+	partial []byte
+}
+
+func NewSniffer(port SnifferPort, fns ...func(c *SnifferConfig)) *Sniffer {
+	conf := SnifferConfig{
+		InterFrameDelay: InterFrameDelay(19200),
+		ResponseTimeout: 1 * time.Second,
+	}
+	for _, fn := range fns {
+		fn(&conf)
+	}
+	return &Sniffer{
+		conf: conf,
+		port: port,
+	}
+}
+
+const (
+	// maxChunkLength bounds how many bytes are buffered while waiting for the
+	// line to go silent.
+	maxChunkLength = 4 * maxFrameLength
+
+	// idlePollInterval is how often ctx is checked while the line is idle.
+	idlePollInterval = 100 * time.Millisecond
+)
+
+// Run sends an Event for every frame observed to events until ctx is done or
+// reading from the port fails.
+func (s *Sniffer) Run(ctx context.Context, events chan<- Event) error {
+	var (
+		buf       = make([]byte, maxFrameLength)
+		chunk     = make([]byte, 0, maxChunkLength)
+		chunkTime time.Time
+	)
+
+	emit := func(ev Event) bool {
+		select {
+		case events <- ev:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		timeout := idlePollInterval
+		if len(chunk) > 0 {
+			timeout = s.conf.InterFrameDelay
+		}
+		if err := s.port.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			return fmt.Errorf("rtu/sniffer: error setting read deadline: %w", err)
+		}
+
+		n, err := s.port.Read(buf)
+		if n > 0 {
+			if len(chunk) == 0 {
+				chunkTime = time.Now()
+			}
+			chunk = append(chunk, buf[:n]...)
+		}
+
+		silent := n == 0 && (err == nil || modbus.IsTimeout(err))
+		if len(chunk) > 0 && (silent || len(chunk) >= maxChunkLength-maxFrameLength || err != nil && !modbus.IsTimeout(err)) {
+			if !s.process(chunk, chunkTime, emit) {
+				return ctx.Err()
+			}
+			chunk = chunk[:0]
+		}
+
+		if err != nil && !modbus.IsTimeout(err) {
+			if s.partial != nil {
+				emit(s.event(s.partial, DirectionUnknown, chunkTime))
+				s.partial = nil
+			}
+			return fmt.Errorf("rtu/sniffer: error reading: %w", err)
+		}
+	}
+}
+
+type frameStatus int
+
+const (
+	frameOK frameStatus = iota
+	frameIncomplete
+	frameCorrupt
+)
+
+// process splits a chunk of bytes received without a silent interval into
+// frames. It returns false if emit does.
+func (s *Sniffer) process(chunk []byte, t time.Time, emit func(Event) bool) bool {
+	if s.partial != nil {
+		// the previous chunk ended with an incomplete frame, the line may
+		// have gone silent in the middle of a frame
+		joined := append(s.partial, chunk...)
+		partialLength := len(s.partial)
+		s.partial = nil
+
+		if n, dir, status := s.nextFrame(joined, t); status == frameOK && n > partialLength {
+			if !emit(s.event(joined[:n], dir, t)) {
+				return false
+			}
+			chunk = joined[n:]
+		} else if !emit(s.event(joined[:partialLength], DirectionUnknown, t)) {
+			return false
+		}
+	}
+
+	for len(chunk) > 0 {
+		n, dir, status := s.nextFrame(chunk, t)
+		switch status {
+		case frameIncomplete:
+			s.partial = append([]byte(nil), chunk...)
+			return true
+		case frameCorrupt:
+			return emit(s.event(chunk, DirectionUnknown, t))
+		}
+
+		if !emit(s.event(chunk[:n], dir, t)) {
+			return false
+		}
+		chunk = chunk[n:]
+	}
+
+	return true
+}
+
+// nextFrame returns the length and direction of the frame at the start of b.
+func (s *Sniffer) nextFrame(b []byte, t time.Time) (int, Direction, frameStatus) {
+	if len(b) < 4 {
+		return 0, DirectionUnknown, frameIncomplete
+	}
+
+	var reqPDU []byte
+	expectResponse := false
+	if p := s.pending; p != nil && t.Sub(p.Time) <= s.conf.ResponseTimeout &&
+		b[0] == p.SlaveAddress && b[1]&0x7f == p.FunctionCode {
+
+		expectResponse = true
+		reqPDU = p.Frame[1 : len(p.Frame)-2]
+	}
+
+	type candidate struct {
+		dir    Direction
+		length func(pdu []byte) int
+	}
+
+	candidates := []candidate{
+		{DirectionRequest, requestLength},
+		{DirectionResponse, func(pdu []byte) int { return responseLength(reqPDU, pdu) }},
+	}
+	if expectResponse {
+		candidates[0], candidates[1] = candidates[1], candidates[0]
+	}
+
+	incomplete := false
+	for _, c := range candidates {
+		pduLength := resolveLength(b[1:], c.length)
+		if pduLength == unknownLength {
+			continue
+		}
+
+		n := 1 + pduLength + 2
+		if n > len(b) {
+			incomplete = true
+			continue
+		}
+		if validCRC(b[:n]) {
+			return n, c.dir, frameOK
+		}
+	}
+
+	// the length of the frame could not be determined, or the frame does not
+	// have the length expected of its function code
+	for n := 4; n <= len(b) && n <= maxFrameLength; n++ {
+		if validCRC(b[:n]) {
+			return n, candidates[0].dir, frameOK
+		}
+	}
+
+	if incomplete {
+		return 0, DirectionUnknown, frameIncomplete
+	}
+
+	return 0, DirectionUnknown, frameCorrupt
+}
+
+// resolveLength calls pduLength until the length of pdu has been determined,
+// and returns unknownLength or a length greater than len(pdu) if it cannot be.
+func resolveLength(pdu []byte, pduLength func(pdu []byte) int) int {
+	n := 1
+	for {
+		want := pduLength(pdu[:n])
+		if want == unknownLength || want > len(pdu) {
+			return want
+		}
+		if want <= n {
+			return n
+		}
+		n = want
+	}
+}
+
+func validCRC(frame []byte) bool {
+	return binary.LittleEndian.Uint16(frame[len(frame)-2:]) == crc.Checksum(frame[:len(frame)-2])
+}
+
+func (s *Sniffer) event(frame []byte, dir Direction, t time.Time) Event {
+	ev := Event{
+		Time:      t,
+		Direction: dir,
+		Frame:     append([]byte(nil), frame...),
+	}
+
+	if len(frame) > 0 {
+		ev.SlaveAddress = frame[0]
+	}
+	if len(frame) > 1 {
+		ev.FunctionCode = frame[1]
+	}
+
+	raw, err := decodeFrame(ev.Frame)
+	if err != nil {
+		ev.Err = err
+		return ev
+	}
+
+	switch dir {
+	case DirectionRequest:
+		ev.PDU, ev.Err = modbus.DecodeRequest(raw)
+		if ev.SlaveAddress != 0 {
+			s.pending = &ev
+		}
+	case DirectionResponse:
+		ev.PDU, ev.Err = modbus.DecodeResponse(raw)
+		if s.pending != nil && s.pending.SlaveAddress == ev.SlaveAddress {
+			ev.Request = s.pending
+			s.pending = nil
+		}
+	}
+	if ev.Err != nil {
+		ev.PDU = raw
+	}
+
+	return ev
+}
@@ -0,0 +1,228 @@
+package rtu
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/shasderias/modbus"
+	"github.com/shasderias/modbus/internal/databuilder"
+)
+
+// scriptPort returns one chunk per read. An empty chunk times out, as if the
+// line had gone silent. io.EOF is returned once the chunks are exhausted.
+type scriptPort struct {
+	chunks [][]byte
+}
+
+func (p *scriptPort) Read(b []byte) (int, error) {
+	if len(p.chunks) == 0 {
+		return 0, io.EOF
+	}
+	chunk := p.chunks[0]
+	p.chunks = p.chunks[1:]
+	if len(chunk) == 0 {
+		return 0, os.ErrDeadlineExceeded
+	}
+	return copy(b, chunk), nil
+}
+
+func (p *scriptPort) SetReadDeadline(time.Time) error { return nil }
+
+func frame(slaveAddress byte, pdu ...byte) []byte {
+	return databuilder.New(len(pdu) + 3).WriteBytes(slaveAddress).WriteBytes(pdu...).BytesWithCRC()
+}
+
+func concat(bs ...[]byte) []byte {
+	var b []byte
+	for _, s := range bs {
+		b = append(b, s...)
+	}
+	return b
+}
+
+func sniff(t *testing.T, chunks ...[]byte) []Event {
+	t.Helper()
+
+	var (
+		sniffer = NewSniffer(&scriptPort{chunks})
+		events  = make(chan Event, 100)
+	)
+
+	if err := sniffer.Run(context.Background(), events); !errors.Is(err, io.EOF) {
+		t.Fatalf("got %v; want io.EOF", err)
+	}
+	close(events)
+
+	var got []Event
+	for ev := range events {
+		got = append(got, ev)
+	}
+	return got
+}
+
+type eventSummary struct {
+	Direction    Direction
+	SlaveAddress byte
+	FunctionCode byte
+	Paired       bool
+	Corrupt      bool
+}
+
+func summarize(events []Event) []eventSummary {
+	var s []eventSummary
+	for _, ev := range events {
+		s = append(s, eventSummary{ev.Direction, ev.SlaveAddress, ev.FunctionCode, ev.Request != nil, ev.Err != nil})
+	}
+	return s
+}
+
+var (
+	readRequest  = frame(0x01, 0x03, 0x00, 0x6b, 0x00, 0x03)
+	readResponse = frame(0x01, 0x03, 0x06, 0x02, 0x2b, 0x00, 0x00, 0x00, 0x64)
+	writeRequest = frame(0x02, 0x06, 0x00, 0x01, 0x00, 0x03)
+	writeEcho    = frame(0x02, 0x06, 0x00, 0x01, 0x00, 0x03)
+	exception    = frame(0x02, 0x86, 0x02)
+	userRequest  = frame(0x03, 0x41, 0x01, 0x02, 0x03)
+	userResponse = frame(0x03, 0x41, 0x04, 0x05)
+)
+
+func TestSniffer(t *testing.T) {
+	testCases := []struct {
+		name   string
+		chunks [][]byte
+		want   []eventSummary
+	}{
+		{
+			"Separate",
+			[][]byte{readRequest, nil, readResponse, nil},
+			[]eventSummary{
+				{DirectionRequest, 1, 0x03, false, false},
+				{DirectionResponse, 1, 0x03, true, false},
+			},
+		},
+		{
+			"Coalesced",
+			[][]byte{concat(readRequest, readResponse, writeRequest, writeEcho), nil},
+			[]eventSummary{
+				{DirectionRequest, 1, 0x03, false, false},
+				{DirectionResponse, 1, 0x03, true, false},
+				{DirectionRequest, 2, 0x06, false, false},
+				{DirectionResponse, 2, 0x06, true, false},
+			},
+		},
+		{
+			"SplitByGap",
+			[][]byte{readRequest, nil, readResponse[:4], nil, readResponse[4:], nil},
+			[]eventSummary{
+				{DirectionRequest, 1, 0x03, false, false},
+				{DirectionResponse, 1, 0x03, true, false},
+			},
+		},
+		{
+			"Exception",
+			[][]byte{writeRequest, nil, exception, nil},
+			[]eventSummary{
+				{DirectionRequest, 2, 0x06, false, false},
+				{DirectionResponse, 2, 0x86, true, false},
+			},
+		},
+		{
+			"BadCRC",
+			[][]byte{readRequest, nil, concat(readResponse[:len(readResponse)-1], []byte{0x00}), nil, writeRequest, nil},
+			[]eventSummary{
+				{DirectionRequest, 1, 0x03, false, false},
+				{DirectionUnknown, 1, 0x03, false, true},
+				{DirectionRequest, 2, 0x06, false, false},
+			},
+		},
+		{
+			"UserDefined",
+			[][]byte{concat(userRequest, userResponse), nil},
+			[]eventSummary{
+				{DirectionRequest, 3, 0x41, false, false},
+				{DirectionResponse, 3, 0x41, true, false},
+			},
+		},
+		{
+			"Unanswered",
+			[][]byte{readRequest, nil, writeRequest, nil, writeEcho, nil},
+			[]eventSummary{
+				{DirectionRequest, 1, 0x03, false, false},
+				{DirectionRequest, 2, 0x06, false, false},
+				{DirectionResponse, 2, 0x06, true, false},
+			},
+		},
+		{
+			"TrailingGarbage",
+			[][]byte{readRequest, nil, []byte{0x01, 0x03}},
+			[]eventSummary{
+				{DirectionRequest, 1, 0x03, false, false},
+				{DirectionUnknown, 1, 0x03, false, true},
+			},
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			got := summarize(sniff(t, tt.chunks...))
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestSnifferDecodesPDUs(t *testing.T) {
+	events := sniff(t, readRequest, nil, readResponse, nil)
+	if len(events) != 2 {
+		t.Fatalf("got %d events; want 2", len(events))
+	}
+
+	req, ok := events[0].PDU.(*modbus.ReadRegisterRequest)
+	if !ok {
+		t.Fatalf("got %T; want *modbus.ReadRegisterRequest", events[0].PDU)
+	}
+	if req.StartAddress() != 0x6b || req.RegisterCount() != 3 {
+		t.Fatalf("got start address %d, count %d; want 107, 3", req.StartAddress(), req.RegisterCount())
+	}
+
+	resp, ok := events[1].PDU.(*modbus.ReadRegisterResponse)
+	if !ok {
+		t.Fatalf("got %T; want *modbus.ReadRegisterResponse", events[1].PDU)
+	}
+	if diff := cmp.Diff(resp.Uint16(), []uint16{555, 0, 100}); diff != "" {
+		t.Fatal(diff)
+	}
+	if events[1].Request.PDU != events[0].PDU {
+		t.Fatal("response not paired with request")
+	}
+
+	var crcErr modbus.ErrBadCRC
+	events = sniff(t, concat(readRequest[:len(readRequest)-1], []byte{0x00}), nil)
+	if len(events) != 1 || !errors.As(events[0].Err, &crcErr) {
+		t.Fatalf("got %v; want a single event with a CRC error", events)
+	}
+}
+
+func TestEventString(t *testing.T) {
+	events := sniff(t, readRequest, nil, readResponse, nil, exception, nil)
+
+	var got []string
+	for _, ev := range events {
+		got = append(got, ev.String())
+	}
+	want := []string{
+		"slave 1: Read Holding Registers (0x03) request: address 107, count 3",
+		"slave 1: Read Holding Registers (0x03) response: values [555 0 100]",
+		"slave 2: Write Single Register (0x86) exception: Illegal Data Address (0x02)",
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Fatal(diff)
+	}
+}
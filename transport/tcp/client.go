@@ -1,44 +1,143 @@
 package tcp
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/shasderias/modbus"
+	"github.com/shasderias/modbus/internal/logging"
+	"github.com/shasderias/modbus/tracing"
 )
 
 type request struct {
+	ctx       context.Context
 	txID      uint16
 	unitID    byte
 	req, resp modbus.PDU
 	err       error
 	done      chan *request
+
+	// sent is when the request was queued
+	sent time.Time
+
+	// queueSpan spans the time the request waits to be written, wireSpan
+	// the time from when it is written to its completion
+	spanMut             sync.Mutex
+	queueSpan, wireSpan tracing.Span
+	spansEnded          bool
+}
+
+// written ends the queue span of r and starts its wire span.
+func (r *request) written(tracer tracing.Tracer) {
+	r.spanMut.Lock()
+	defer r.spanMut.Unlock()
+
+	if r.spansEnded {
+		return
+	}
+	r.queueSpan.End()
+	_, r.wireSpan = tracing.Start(r.ctx, tracer, "modbus/tcp wire")
+}
+
+// endSpans ends the span r is in, recording err.
+func (r *request) endSpans(err error) {
+	r.spanMut.Lock()
+	defer r.spanMut.Unlock()
+
+	span := r.queueSpan
+	if r.wireSpan != nil {
+		span = r.wireSpan
+	}
+	span.RecordError(err)
+	span.End()
+	r.spansEnded = true
 }
 
 type Client struct {
 	c Conn
 
-	closed    bool
-	closedMut sync.Mutex
+	// mut guards closing and err
+	mut sync.Mutex
+	// closing is set by Shutdown and Close, after which no request is
+	// accepted
+	closing bool
+	// err is why the client died, see Err
+	err error
+	// done is closed when the client dies, see Done
+	done chan struct{}
+
+	// pending counts the requests in progress, loops the read and write
+	// loops running
+	pending sync.WaitGroup
+	loops   sync.WaitGroup
 
 	requestTimeout time.Duration
+	logger         *slog.Logger
+	observer       modbus.Observer
+	tracer         tracing.Tracer
+
+	requestQueue chan *request
+
+	// window holds a value for every request in flight, up to MaxInFlight
+	window chan struct{}
 
-	writeLoopDone       chan struct{}
-	requestQueue        chan *request
 	inflightRequestsMut sync.Mutex
 	inflightRequests    map[uint16]*request
+	// txID is the last transaction ID assigned, guarded by
+	// inflightRequestsMut
+	txID uint16
 
-	txID      uint16
-	txIDMutex sync.Mutex
+	discarded atomic.Uint64
 }
 
+// DefaultMaxInFlight is the MaxInFlight of clients that are not configured
+// otherwise.
+const DefaultMaxInFlight = 16
+
+// maxMaxInFlight bounds MaxInFlight below the 65536 transaction IDs, so that
+// one is always free for the next request.
+const maxMaxInFlight = 0xffff
+
 type ClientConfig struct {
 	RequestTimeout time.Duration
+
+	// MaxInFlight is the maximum number of requests sent and not yet
+	// answered. Further requests wait for one of them to complete. 1 sends
+	// one request at a time, as devices that do not support pipelining
+	// require. A request that timed out no longer counts, although the
+	// device may still be processing it. Defaults to the entry of
+	// MaxInFlightByRemote for the connection, or DefaultMaxInFlight. Values
+	// above 65535, the number of transaction IDs less one, are taken as
+	// 65535.
+	MaxInFlight int
+
+	// MaxInFlightByRemote holds the MaxInFlight of connections to specific
+	// devices, keyed by the remote host and port, such as "10.0.0.5:502",
+	// or host, such as "10.0.0.5". It is consulted if MaxInFlight is 0 and
+	// the connection has a RemoteAddr method, as a net.Conn does, so that a
+	// single configuration function can describe a whole plant.
+	MaxInFlightByRemote map[string]int
+
+	// Logger receives errors that close the client, timeouts, and, at debug
+	// level, every frame sent and received. Defaults to discarding.
+	Logger *slog.Logger
+
+	// Observer, if set, is notified of every request, with the lengths of
+	// the frames sent and received, its outcome and latency.
+	Observer modbus.Observer
+
+	// Tracer, if set, starts child spans of the span of every request for
+	// the time it is queued and the time it is on the wire. See package
+	// tracing.
+	Tracer tracing.Tracer
 }
 
 func NewClient(c Conn, fns ...func(c *ClientConfig)) (*Client, error) {
@@ -54,93 +153,153 @@ func NewClient(c Conn, fns ...func(c *ClientConfig)) (*Client, error) {
 		c: c,
 
 		requestTimeout: config.RequestTimeout,
+		logger:         logging.OrDiscard(config.Logger),
+		observer:       config.Observer,
+		tracer:         config.Tracer,
 
-		writeLoopDone:    make(chan struct{}),
+		done:             make(chan struct{}),
 		requestQueue:     make(chan *request),
+		window:           make(chan struct{}, maxInFlight(c, config)),
 		inflightRequests: make(map[uint16]*request),
 	}
 
+	client.loops.Add(2)
 	go client.readLoop()
 	go client.writeLoop()
 
 	return client, nil
 }
 
-func (c *Client) readLoop() {
-	for {
-		err := func() (err error) {
-			var req *request
-			defer func() {
-				if err != nil && req != nil {
-					req.err = err
-					req.done <- req
-				}
-			}()
-			buf := make([]byte, maxFrameSize)
+// maxInFlight returns the MaxInFlight of a client of conn configured with
+// conf.
+func maxInFlight(conn Conn, conf ClientConfig) int {
+	return min(configuredMaxInFlight(conn, conf), maxMaxInFlight)
+}
 
-			n, err := io.ReadFull(c.c, buf[:7])
-			if err != nil {
-				return fmt.Errorf("modbus/tcp: error reading [:7]: %w", err)
-			}
-			if n < 7 {
-				return fmt.Errorf("modbus/tcp: short read [:7]: %d/7", n)
+func configuredMaxInFlight(conn Conn, conf ClientConfig) int {
+	if conf.MaxInFlight > 0 {
+		return conf.MaxInFlight
+	}
+
+	if remote, ok := conn.(interface{ RemoteAddr() net.Addr }); ok && conf.MaxInFlightByRemote != nil {
+		addr := remote.RemoteAddr().String()
+		if n, ok := conf.MaxInFlightByRemote[addr]; ok && n > 0 {
+			return n
+		}
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			if n, ok := conf.MaxInFlightByRemote[host]; ok && n > 0 {
+				return n
 			}
+		}
+	}
 
-			txID := binary.BigEndian.Uint16(buf[:2])
+	return DefaultMaxInFlight
+}
 
-			c.inflightRequestsMut.Lock()
-			req = c.inflightRequests[txID]
-			c.inflightRequestsMut.Unlock()
+func (c *Client) readLoop() {
+	defer c.loops.Done()
 
-			if req == nil {
-				return fmt.Errorf("modbus/tcp: unexpected transaction ID: %d", txID)
-			}
-			if protocolID := binary.BigEndian.Uint16(buf[2:4]); protocolID != 0 {
-				return fmt.Errorf("modbus/tcp: unexpected protocol ID: %d", protocolID)
-			}
-			if unitID := buf[6]; unitID != req.unitID {
-				return fmt.Errorf("modbus/tcp: unexpected unit ID: %d", unitID)
+	buf := make([]byte, maxFrameSize)
+
+	for {
+		frame, err := c.readFrame(buf)
+		if err != nil {
+			if !c.isClosed() {
+				c.logger.LogAttrs(context.Background(), slog.LevelError, "modbus/tcp: closing client", logging.Err(err))
 			}
+			c.die(err)
+			c.failInflight(c.Err())
+			return
+		}
 
-			remainingBytes := binary.BigEndian.Uint16(buf[4:6])
+		c.dispatch(frame)
+	}
+}
 
-			if remainingBytes < 2 {
-				return fmt.Errorf("modbus/tcp: short frame, expected frame to be at least 9 bytes long: %d", 6+remainingBytes)
-			}
-			if remainingBytes > maxFrameSize-7 {
-				return fmt.Errorf("modbus/tcp: frame too long: %d", 6+remainingBytes)
-			}
+// readFrame reads the next frame into buf. Errors are fatal, as the frames
+// that follow cannot be found.
+func (c *Client) readFrame(buf []byte) ([]byte, error) {
+	if _, err := io.ReadFull(c.c, buf[:7]); err != nil {
+		return nil, fmt.Errorf("modbus/tcp: error reading [:7]: %w", err)
+	}
 
-			n, err = io.ReadFull(c.c, buf[7:6+remainingBytes])
-			if err != nil {
-				return fmt.Errorf("modbus/tcp: error reading [7:%d]: %w", 6+remainingBytes, err)
-			}
-			if n < int(remainingBytes)-1 {
-				return fmt.Errorf("modbus/tcp: short read [7:%d]: %d/%d", 6+remainingBytes, n, remainingBytes)
-			}
+	if protocolID := binary.BigEndian.Uint16(buf[2:4]); protocolID != 0 {
+		return nil, fmt.Errorf("modbus/tcp: %w: protocol ID: %d", modbus.ErrInvalidFrame, protocolID)
+	}
 
-			req.resp, err = modbus.NewRawPDU(buf[7 : 6+remainingBytes])
-			if err != nil {
-				return fmt.Errorf("modbus/tcp: error parsing PDU: %w", err)
-			}
+	remainingBytes := binary.BigEndian.Uint16(buf[4:6])
 
-			req.done <- req
+	if remainingBytes < 2 {
+		return nil, fmt.Errorf("modbus/tcp: %w: expected frame to be at least 9 bytes long: %d", modbus.ErrFrameTooShort, 6+remainingBytes)
+	}
+	if remainingBytes > maxFrameSize-7 {
+		return nil, fmt.Errorf("modbus/tcp: %w: %d", modbus.ErrFrameTooLong, 6+remainingBytes)
+	}
 
-			return nil
-		}()
-		if err != nil {
-			c.log(err.Error())
-			c.Close()
-			return
+	if _, err := io.ReadFull(c.c, buf[7:6+remainingBytes]); err != nil {
+		return nil, fmt.Errorf("modbus/tcp: error reading [7:%d]: %w", 6+remainingBytes, err)
+	}
+
+	return buf[:6+remainingBytes], nil
+}
+
+// dispatch completes the request frame is the response to. Responses to no
+// request in flight, such as those arriving after their request timed out or
+// duplicates, are discarded. A response from another unit fails its request.
+func (c *Client) dispatch(frame []byte) {
+	var (
+		txID   = binary.BigEndian.Uint16(frame[:2])
+		unitID = frame[6]
+	)
+
+	c.inflightRequestsMut.Lock()
+	req := c.inflightRequests[txID]
+	delete(c.inflightRequests, txID)
+	c.inflightRequestsMut.Unlock()
+
+	if req == nil {
+		c.discarded.Add(1)
+		ctx := context.Background()
+		attrs := []slog.Attr{logging.TxID(txID), logging.UnitID(unitID)}
+		if c.logger.Enabled(ctx, slog.LevelDebug) {
+			attrs = append(attrs, logging.Frame(frame))
 		}
+		c.logger.LogAttrs(ctx, slog.LevelWarn, "modbus/tcp: discarded response to no request in flight", attrs...)
+		return
 	}
+
+	if unitID != req.unitID {
+		req.err = fmt.Errorf("modbus/tcp: %w: unit ID, sent: %d, recv: %d", modbus.ErrUnexpectedResponse, req.unitID, unitID)
+		req.done <- req
+		return
+	}
+
+	// frame is overwritten by the next frame
+	req.resp, req.err = modbus.NewRawPDU(append([]byte(nil), frame[7:]...))
+
+	c.logger.LogAttrs(req.ctx, slog.LevelDebug, "modbus/tcp: received response",
+		logging.TxID(txID), logging.UnitID(req.unitID), logging.FunctionCode(frame[7]),
+		logging.Latency(time.Since(req.sent)), logging.Frame(frame))
+
+	req.done <- req
 }
 
-func (c *Client) log(msg string) {
-	fmt.Println(msg)
+// failInflight fails every request in flight with err. c must be dead, so
+// that no request is added after.
+func (c *Client) failInflight(err error) {
+	c.inflightRequestsMut.Lock()
+	defer c.inflightRequestsMut.Unlock()
+
+	for txID, req := range c.inflightRequests {
+		req.err = err
+		req.done <- req
+		delete(c.inflightRequests, txID)
+	}
 }
 
 func (c *Client) writeLoop() {
+	defer c.loops.Done()
+
 	for {
 		select {
 		case r := <-c.requestQueue:
@@ -148,8 +307,11 @@ func (c *Client) writeLoop() {
 
 			n, err := c.c.Write(frame)
 			if err != nil {
-				r.err = fmt.Errorf("modbus/tcp: error writing request: %w", err)
-				r.done <- r
+				// the request may have been failed by the readLoop
+				if c.abandon(r) {
+					r.err = fmt.Errorf("modbus/tcp: error writing request: %w", err)
+					r.done <- r
+				}
 
 				if errors.Is(err, net.ErrClosed) {
 					return
@@ -157,72 +319,276 @@ func (c *Client) writeLoop() {
 				continue
 			}
 			if n != len(frame) {
-				r.err = fmt.Errorf("modbus/tcp: short write: %d/%d", n, len(frame))
-				r.done <- r
+				if c.abandon(r) {
+					r.err = fmt.Errorf("modbus/tcp: short write: %d/%d", n, len(frame))
+					r.done <- r
+				}
 				continue
 			}
-		case <-c.writeLoopDone:
-			break
+
+			r.written(c.tracer)
+
+			c.logger.LogAttrs(r.ctx, slog.LevelDebug, "modbus/tcp: sent request",
+				logging.TxID(r.txID), logging.UnitID(r.unitID), logging.FunctionCode(r.req.FunctionCode()),
+				logging.Frame(frame))
+		case <-c.done:
+			return
 		}
 	}
 }
 
-func (c *Client) queueRequest(unitID byte, requestPDU modbus.PDU) *request {
-	txID := c.getTxID()
-
+// queueRequest queues a request for the writeLoop. queueSpan is the span of
+// the time the request has been waiting for a place in the window.
+func (c *Client) queueRequest(ctx context.Context, unitID byte, requestPDU modbus.PDU, queueSpan tracing.Span) (*request, error) {
 	r := request{
-		txID:   txID,
+		ctx:    ctx,
 		unitID: unitID,
 		req:    requestPDU,
-		done:   make(chan *request),
+		// buffered, so that a request is completed even if its caller
+		// stopped waiting
+		done:      make(chan *request, 1),
+		sent:      time.Now(),
+		queueSpan: queueSpan,
 	}
 
 	c.inflightRequestsMut.Lock()
-	c.inflightRequests[txID] = &r
+	if c.isClosed() {
+		c.inflightRequestsMut.Unlock()
+		return nil, closedError(c.Err())
+	}
+	r.txID = c.nextTxID()
+	c.inflightRequests[r.txID] = &r
 	c.inflightRequestsMut.Unlock()
 
-	c.requestQueue <- &r
+	queueSpan.SetAttributes(tracing.Int(tracing.KeyTxID, int(r.txID)))
+
+	select {
+	case c.requestQueue <- &r:
+	case <-c.done:
+		// r is failed by the readLoop
+	}
 
-	return &r
+	return &r, nil
 }
 
 func (c *Client) WriteRequest(unitID byte, r modbus.PDU) (modbus.PDU, error) {
-	if c.isClosed() {
-		return nil, fmt.Errorf("modbus/tcp: client closed")
+	return c.WriteRequestContext(context.Background(), unitID, r)
+}
+
+// WriteRequestContext is like WriteRequest, but abandons the request when ctx
+// is done, and adds the transport, remote address and transaction ID to the
+// span in ctx.
+//
+// If MaxInFlight requests are in flight, WriteRequestContext waits for one of
+// them to complete, or for ctx to be done, before sending the request. The
+// request timeout starts when the request is sent.
+func (c *Client) WriteRequestContext(ctx context.Context, unitID byte, r modbus.PDU) (modbus.PDU, error) {
+	if err := c.admit(); err != nil {
+		return nil, err
 	}
-	result := c.queueRequest(unitID, r)
+	defer c.pending.Done()
 
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	_, queueSpan := tracing.Start(ctx, c.tracer, "modbus/tcp queue")
+	select {
+	case c.window <- struct{}{}:
+	case <-ctx.Done():
+		queueSpan.RecordError(ctx.Err())
+		queueSpan.End()
+		return nil, ctx.Err()
+	case <-c.done:
+		err := closedError(c.Err())
+		queueSpan.RecordError(err)
+		queueSpan.End()
+		return nil, err
+	}
+	defer func() { <-c.window }()
+
+	result, err := c.queueRequest(ctx, unitID, r, queueSpan)
+	if err != nil {
+		queueSpan.RecordError(err)
+		queueSpan.End()
+		return nil, err
+	}
+
+	span := tracing.SpanFromContext(ctx)
+	span.SetAttributes(tracing.String(tracing.KeyTransport, "tcp"), tracing.Int(tracing.KeyTxID, int(result.txID)))
+	if conn, ok := c.c.(interface{ RemoteAddr() net.Addr }); ok {
+		span.SetAttributes(tracing.String(tracing.KeyRemote, conn.RemoteAddr().String()))
+	}
+
+	var resp modbus.PDU
 	select {
 	case <-result.done:
-		return result.resp, result.err
+		resp, err = result.resp, result.err
 	case <-time.After(c.requestTimeout):
-		c.inflightRequestsMut.Lock()
-		delete(c.inflightRequests, result.txID)
-		c.inflightRequestsMut.Unlock()
-		return nil, fmt.Errorf("modbus/tcp: timeout waiting for response")
+		c.abandon(result)
+
+		c.logger.LogAttrs(ctx, slog.LevelWarn, "modbus/tcp: request timed out",
+			logging.TxID(result.txID), logging.UnitID(unitID), logging.FunctionCode(r.FunctionCode()),
+			slog.Duration("timeout", c.requestTimeout))
+		err = fmt.Errorf("modbus/tcp: %w", modbus.ErrTimeout)
+	case <-ctx.Done():
+		c.abandon(result)
+		err = ctx.Err()
+	}
+
+	result.endSpans(err)
+	c.observe(result, resp, err)
+
+	return resp, err
+}
+
+// abandon forgets r, so that its response is not waited for, and reports
+// whether r was in flight.
+func (c *Client) abandon(r *request) bool {
+	c.inflightRequestsMut.Lock()
+	defer c.inflightRequestsMut.Unlock()
+
+	if c.inflightRequests[r.txID] != r {
+		return false
 	}
+	delete(c.inflightRequests, r.txID)
+	return true
 }
 
+// observe notifies the observer of r, which completed with resp and err.
+func (c *Client) observe(r *request, resp modbus.PDU, err error) {
+	if c.observer == nil {
+		return
+	}
+
+	e := modbus.RequestEvent{
+		UnitID:       r.unitID,
+		FunctionCode: r.req.FunctionCode(),
+		Latency:      time.Since(r.sent),
+		Err:          err,
+	}
+	e.Outcome, e.ExceptionCode = modbus.ClassifyOutcome(resp, err)
+	if b, err := r.req.MarshalBinary(); err == nil {
+		e.RequestBytes = 7 + len(b)
+	}
+	if resp != nil {
+		if b, err := resp.MarshalBinary(); err == nil {
+			e.ResponseBytes = 7 + len(b)
+		}
+	}
+	c.observer.ObserveRequest(e)
+}
+
+// Close closes c and its connection, failing the requests in progress, and
+// returns once the goroutines of c have exited.
 func (c *Client) Close() error {
-	c.closedMut.Lock()
-	defer c.closedMut.Unlock()
-	if !c.closed {
-		close(c.writeLoopDone)
+	err := c.die(fmt.Errorf("modbus/tcp: %w", modbus.ErrClosed))
+	c.loops.Wait()
+	return err
+}
+
+// Shutdown stops c accepting requests, waits for the requests in progress,
+// including those waiting for a place in the window, to complete, and closes
+// c. If ctx is done first, c is closed, failing the requests still in
+// progress, and Shutdown returns ctx.Err().
+func (c *Client) Shutdown(ctx context.Context) error {
+	c.mut.Lock()
+	c.closing = true
+	c.mut.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		c.pending.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return c.Close()
+	case <-ctx.Done():
+		c.Close()
+		return ctx.Err()
 	}
-	c.closed = true
+}
+
+// Done returns a channel that is closed when c dies, because it was closed or
+// its connection failed.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns nil until Done is closed, and then why c died: an error
+// wrapping modbus.ErrClosed if it was closed, or the error its connection
+// failed with. The requests in flight when c died fail with the same error.
+func (c *Client) Err() error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.err
+}
+
+// die records err as the reason c died, if it has not died already, and
+// closes its connection, returning the error of closing it.
+func (c *Client) die(err error) error {
+	c.mut.Lock()
+	if c.err != nil {
+		c.mut.Unlock()
+		return nil
+	}
+	c.closing = true
+	c.err = err
+	close(c.done)
+	c.mut.Unlock()
+
 	return c.c.Close()
 }
 
+// admit counts a request as in progress, unless c is shutting down or dead.
+func (c *Client) admit() error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	switch {
+	case c.err != nil:
+		return closedError(c.err)
+	case c.closing:
+		return fmt.Errorf("modbus/tcp: %w: shutting down", modbus.ErrClosed)
+	}
+	c.pending.Add(1)
+	return nil
+}
+
 func (c *Client) isClosed() bool {
-	c.closedMut.Lock()
-	defer c.closedMut.Unlock()
-	return c.closed
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// closedError returns the error of requests made after a client died because
+// of cause.
+func closedError(cause error) error {
+	if errors.Is(cause, modbus.ErrClosed) {
+		return cause
+	}
+	return fmt.Errorf("modbus/tcp: %w: %w", modbus.ErrClosed, cause)
 }
 
-func (c *Client) getTxID() uint16 {
-	c.txIDMutex.Lock()
-	defer c.txIDMutex.Unlock()
+// nextTxID returns the next transaction ID that is not in flight.
+// inflightRequestsMut must be held.
+func (c *Client) nextTxID() uint16 {
+	for {
+		c.txID++
+		if _, ok := c.inflightRequests[c.txID]; !ok {
+			return c.txID
+		}
+	}
+}
 
-	c.txID++
-	return c.txID
+// DiscardedResponses returns the number of responses discarded because no
+// request was in flight with their transaction ID, such as responses that
+// arrived after their request timed out, or duplicates.
+func (c *Client) DiscardedResponses() uint64 {
+	return c.discarded.Load()
 }
@@ -0,0 +1,368 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shasderias/modbus"
+)
+
+func TestNextTxIDSkipsInflight(t *testing.T) {
+	c := &Client{
+		txID: 0xfffe,
+		inflightRequests: map[uint16]*request{
+			0xffff: {},
+			0x0001: {},
+		},
+	}
+
+	for _, want := range []uint16{0x0000, 0x0002, 0x0003} {
+		if got := c.nextTxID(); got != want {
+			t.Fatalf("got 0x%04x; want 0x%04x", got, want)
+		}
+	}
+}
+
+func TestDiscardedResponseLog(t *testing.T) {
+	frame := []byte{0x00, 0x07, 0x00, 0x00, 0x00, 0x05, 0x01, 0x03, 0x02, 0x00, 0x2a}
+
+	testCases := []struct {
+		name      string
+		level     slog.Level
+		wantFrame bool
+	}{
+		{"Warn", slog.LevelWarn, false},
+		{"Debug", slog.LevelDebug, true},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			c := &Client{logger: slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: tt.level}))}
+			c.dispatch(frame)
+
+			log := buf.String()
+			if !strings.Contains(log, "tx_id=7 unit=1") {
+				t.Fatalf("got %q; want tx_id and unit", log)
+			}
+			if got := strings.Contains(log, "frame="); got != tt.wantFrame {
+				t.Fatalf("got frame logged %v; want %v: %q", got, tt.wantFrame, log)
+			}
+			if got := c.DiscardedResponses(); got != 1 {
+				t.Fatalf("got %d discarded responses; want 1", got)
+			}
+		})
+	}
+}
+
+type fakeConn struct {
+	Conn
+	remote string
+}
+
+func (c fakeConn) RemoteAddr() net.Addr {
+	addr, _ := net.ResolveTCPAddr("tcp", c.remote)
+	return addr
+}
+
+func TestMaxInFlight(t *testing.T) {
+	byRemote := map[string]int{
+		"10.0.0.5":      1,
+		"10.0.0.6:1502": 4,
+	}
+
+	testCases := []struct {
+		name   string
+		conn   Conn
+		config ClientConfig
+		want   int
+	}{
+		{"Default", fakeConn{remote: "10.0.0.7:502"}, ClientConfig{MaxInFlightByRemote: byRemote}, DefaultMaxInFlight},
+		{"Host", fakeConn{remote: "10.0.0.5:502"}, ClientConfig{MaxInFlightByRemote: byRemote}, 1},
+		{"HostPort", fakeConn{remote: "10.0.0.6:1502"}, ClientConfig{MaxInFlightByRemote: byRemote}, 4},
+		{"OtherPort", fakeConn{remote: "10.0.0.6:502"}, ClientConfig{MaxInFlightByRemote: byRemote}, DefaultMaxInFlight},
+		{"Explicit", fakeConn{remote: "10.0.0.5:502"}, ClientConfig{MaxInFlight: 8, MaxInFlightByRemote: byRemote}, 8},
+		{"Clamped", fakeConn{remote: "10.0.0.7:502"}, ClientConfig{MaxInFlight: 100000}, 0xffff},
+		{"ClampedByRemote", fakeConn{remote: "10.0.0.7:502"}, ClientConfig{MaxInFlightByRemote: map[string]int{"10.0.0.7": 70000}}, 0xffff},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if got := maxInFlight(tt.conn, tt.config); got != tt.want {
+				t.Fatalf("got %d; want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// startServer starts a server whose requests take delay, and returns its
+// address and a function returning the highest number of requests handled at
+// once.
+func startServer(t *testing.T, delay time.Duration) (string, func() int32) {
+	t.Helper()
+
+	var active, peak atomic.Int32
+	h := modbus.HandlerFunc(func(ctx context.Context, unitID byte, req modbus.PDU) (modbus.PDU, error) {
+		n := active.Add(1)
+		defer active.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(delay)
+		return modbus.NewReadRegisterResponseFromUint16s(int(req.FunctionCode()), []uint16{0})
+	})
+
+	server, err := NewServer("127.0.0.1:0", h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Stop() })
+
+	return server.Addr().String(), peak.Load
+}
+
+func dial(t *testing.T, addr string) net.Conn {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+func TestWindow(t *testing.T) {
+	for _, maxInFlight := range []int{1, 3} {
+		maxInFlight := maxInFlight
+		t.Run(map[int]string{1: "Strict", 3: "Pipelined"}[maxInFlight], func(t *testing.T) {
+			addr, peak := startServer(t, 50*time.Millisecond)
+
+			client, err := NewClient(dial(t, addr), func(c *ClientConfig) {
+				c.MaxInFlight = maxInFlight
+				c.RequestTimeout = time.Second
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			req, err := modbus.NewReadRegisterRequest(modbus.FuncCodeReadHoldingRegisters, 0, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var wg sync.WaitGroup
+			for i := 0; i < 6; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if _, err := client.WriteRequest(1, req); err != nil {
+						t.Error(err)
+					}
+				}()
+			}
+			wg.Wait()
+
+			// the server may not read a request as soon as it is sent
+			if got := peak(); got > int32(maxInFlight) || maxInFlight > 1 && got < 2 {
+				t.Fatalf("got %d requests handled at once; want %d", got, maxInFlight)
+			}
+		})
+	}
+}
+
+func TestWindowCancel(t *testing.T) {
+	addr, _ := startServer(t, 200*time.Millisecond)
+
+	client, err := NewClient(dial(t, addr), func(c *ClientConfig) {
+		c.MaxInFlight = 1
+		c.RequestTimeout = time.Second
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	req, err := modbus.NewReadRegisterRequest(modbus.FuncCodeReadHoldingRegisters, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := make(chan error)
+	go func() {
+		_, err := client.WriteRequest(1, req)
+		first <- err
+	}()
+	// let the first request take the window
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := client.WriteRequestContext(ctx, 1, req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v; want context.DeadlineExceeded", err)
+	}
+
+	if err := <-first; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkGoroutines fails t if the number of goroutines does not fall back to
+// n, as goroutines that have been signalled may take a while to exit.
+func checkGoroutines(t *testing.T, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > n {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d goroutines; want %d", runtime.NumGoroutine(), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCloseLeak(t *testing.T) {
+	addr, _ := startServer(t, 0)
+
+	req, err := modbus.NewReadRegisterRequest(modbus.FuncCodeReadHoldingRegisters, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := runtime.NumGoroutine()
+	for i := 0; i < 20; i++ {
+		client, err := NewClient(dial(t, addr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.WriteRequest(1, req); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		<-client.Done()
+		if err := client.Err(); !errors.Is(err, modbus.ErrClosed) {
+			t.Fatalf("got %v; want ErrClosed", err)
+		}
+		if _, err := client.WriteRequest(1, req); !errors.Is(err, modbus.ErrClosed) {
+			t.Fatalf("got %v; want ErrClosed", err)
+		}
+	}
+	checkGoroutines(t, n)
+}
+
+func TestConnectionLost(t *testing.T) {
+	req, err := modbus.NewReadRegisterRequest(modbus.FuncCodeReadHoldingRegisters, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := runtime.NumGoroutine()
+
+	conn, server := net.Pipe()
+	client, err := NewClient(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Err() != nil {
+		t.Fatalf("got %v before the client died; want nil", client.Err())
+	}
+
+	// the server reads the request and hangs up
+	go func() {
+		io.ReadFull(server, make([]byte, 12))
+		server.Close()
+	}()
+
+	if _, err := client.WriteRequest(1, req); !errors.Is(err, io.EOF) {
+		t.Fatalf("got %v; want io.EOF", err)
+	}
+
+	select {
+	case <-client.Done():
+	case <-time.After(time.Second):
+		t.Fatal("got client alive after connection lost; want dead")
+	}
+	if err := client.Err(); !errors.Is(err, io.EOF) {
+		t.Fatalf("got %v; want io.EOF", err)
+	}
+	if _, err := client.WriteRequest(1, req); !errors.Is(err, modbus.ErrClosed) || !errors.Is(err, io.EOF) {
+		t.Fatalf("got %v; want ErrClosed and io.EOF", err)
+	}
+
+	// the client is not closed, and its goroutines still exit
+	checkGoroutines(t, n)
+}
+
+func TestShutdown(t *testing.T) {
+	addr, _ := startServer(t, 100*time.Millisecond)
+
+	req, err := modbus.NewReadRegisterRequest(modbus.FuncCodeReadHoldingRegisters, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name    string
+		timeout time.Duration
+		wantErr error
+		wantReq error
+	}{
+		{"Drained", time.Second, nil, nil},
+		{"Expired", 20 * time.Millisecond, context.DeadlineExceeded, modbus.ErrClosed},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewClient(dial(t, addr), func(c *ClientConfig) {
+				c.RequestTimeout = time.Second
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			inProgress := make(chan error)
+			go func() {
+				_, err := client.WriteRequest(1, req)
+				inProgress <- err
+			}()
+			// let the request be sent
+			time.Sleep(20 * time.Millisecond)
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			if err := client.Shutdown(ctx); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v; want %v", err, tt.wantErr)
+			}
+			if err := <-inProgress; !errors.Is(err, tt.wantReq) {
+				t.Fatalf("got %v from request in progress; want %v", err, tt.wantReq)
+			}
+			if _, err := client.WriteRequest(1, req); !errors.Is(err, modbus.ErrClosed) {
+				t.Fatalf("got %v; want ErrClosed", err)
+			}
+		})
+	}
+}
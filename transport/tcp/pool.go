@@ -0,0 +1,486 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/shasderias/modbus"
+	"github.com/shasderias/modbus/internal/logging"
+)
+
+// Pool is a ClientTransport that spreads requests across up to Size
+// connections to a server, for servers, typically gateways, that process the
+// requests of each connection one at a time.
+//
+// Connections are dialed lazily: a request is sent on the connection with the
+// fewest requests in flight, and a connection is dialed only if every
+// connection has requests in flight. Only requests that find no connection
+// open wait for the dial; others are sent on an open connection while it
+// proceeds in the background. A connection whose requests fail
+// MaxFailures times in a row, or that is closed by the server, is retired and
+// replaced by the next dial.
+type Pool struct {
+	address string
+	host    string
+
+	size        int
+	maxFailures int
+	retryDelay  time.Duration
+	dial        func(ctx context.Context, network, address string) (net.Conn, error)
+	hosts       *HostLimiter
+	clientFn    func(c *ClientConfig)
+	logger      *slog.Logger
+
+	// dialCtx is the context of background dials, canceled by Close
+	dialCtx     context.Context
+	cancelDials context.CancelFunc
+
+	mut    sync.Mutex
+	closed bool
+	conns  []*poolConn
+	// dialing is the number of dials in progress
+	dialing int
+	// dialErr is the error of the last dial, returned until retryAt
+	dialErr error
+	retryAt time.Time
+	// changed is closed, and replaced, when a connection is added or
+	// released
+	changed chan struct{}
+}
+
+type poolConn struct {
+	client *Client
+	remote string
+
+	// guarded by Pool.mut
+	inflight int
+	failures int
+	retired  bool
+}
+
+type PoolConfig struct {
+	// Size is the maximum number of connections. Defaults to 4.
+	Size int
+
+	// MaxFailures is the number of requests in a row that fail with a
+	// timeout or transport error after which a connection is retired.
+	// Exception responses are not failures. Defaults to 3.
+	MaxFailures int
+
+	// DialTimeout bounds every dial of the default Dial. Defaults to 5s.
+	DialTimeout time.Duration
+
+	// RetryDelay is how long requests fail with the error of a failed dial
+	// before another dial is attempted, if no connection is open. Defaults
+	// to 1s.
+	RetryDelay time.Duration
+
+	// Dial, if set, dials connections in place of a net.Dialer.
+	Dial func(ctx context.Context, network, address string) (net.Conn, error)
+
+	// Hosts, if set, limits the connections to each host across all the
+	// pools it is shared by.
+	Hosts *HostLimiter
+
+	// Client, if set, configures the Client of every connection, such as
+	// its RequestTimeout and MaxInFlight.
+	Client func(c *ClientConfig)
+
+	// Logger receives failed dials, connections retired and, at debug
+	// level, connections dialed. It is also the default Logger of the
+	// Client of every connection. Defaults to discarding.
+	Logger *slog.Logger
+}
+
+// NewPool returns a pool of connections to address, in the form "host:port".
+// No connection is dialed until the first request.
+func NewPool(address string, fns ...func(c *PoolConfig)) (*Pool, error) {
+	config := PoolConfig{
+		Size:        4,
+		MaxFailures: 3,
+		DialTimeout: 5 * time.Second,
+		RetryDelay:  time.Second,
+	}
+
+	for _, fn := range fns {
+		fn(&config)
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("modbus/tcp: pool: %w", err)
+	}
+	if config.Size < 1 {
+		return nil, fmt.Errorf("modbus/tcp: pool: size must be at least 1: %d", config.Size)
+	}
+	if config.MaxFailures < 1 {
+		return nil, fmt.Errorf("modbus/tcp: pool: max failures must be at least 1: %d", config.MaxFailures)
+	}
+
+	dial := config.Dial
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: config.DialTimeout}).DialContext
+	}
+
+	dialCtx, cancelDials := context.WithCancel(context.Background())
+
+	return &Pool{
+		address: address,
+		host:    host,
+
+		size:        config.Size,
+		maxFailures: config.MaxFailures,
+		retryDelay:  config.RetryDelay,
+		dial:        dial,
+		hosts:       config.Hosts,
+		clientFn:    config.Client,
+		logger:      logging.OrDiscard(config.Logger),
+
+		dialCtx:     dialCtx,
+		cancelDials: cancelDials,
+
+		changed: make(chan struct{}),
+	}, nil
+}
+
+func (p *Pool) WriteRequest(unitID byte, r modbus.PDU) (modbus.PDU, error) {
+	return p.WriteRequestContext(context.Background(), unitID, r)
+}
+
+// WriteRequestContext is like WriteRequest, but abandons the request, and
+// any dial it waits for, when ctx is done.
+func (p *Pool) WriteRequestContext(ctx context.Context, unitID byte, r modbus.PDU) (modbus.PDU, error) {
+	pc, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := pc.client.WriteRequestContext(ctx, unitID, r)
+	p.release(pc, err)
+
+	return resp, err
+}
+
+// acquire returns the connection to send a request on, dialing one if
+// needed, and counts the request as in flight on it.
+func (p *Pool) acquire(ctx context.Context) (*poolConn, error) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	for {
+		if p.closed {
+			return nil, fmt.Errorf("modbus/tcp: pool: %w", modbus.ErrClosed)
+		}
+		p.prune()
+
+		pc := p.leastLoaded()
+		switch {
+		case pc == nil && p.mayDial():
+			if _, err := p.dialLocked(ctx); err != nil {
+				return nil, err
+			}
+			// connections may have been added, retired or taken by other
+			// requests while p.mut was released
+			continue
+		case pc != nil && pc.inflight > 0 && p.mayDial():
+			go p.dialBackground()
+		}
+
+		if pc != nil {
+			pc.inflight++
+			return pc, nil
+		}
+
+		switch {
+		case p.dialing > 0:
+			// wait for the dials in progress
+		case p.dialErr != nil && time.Now().Before(p.retryAt):
+			return nil, p.dialErr
+		case len(p.conns) < p.size:
+			return nil, fmt.Errorf("modbus/tcp: pool: connection limit reached for host %s", p.host)
+		}
+
+		changed := p.changed
+		p.mut.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			p.mut.Lock()
+			return nil, ctx.Err()
+		}
+		p.mut.Lock()
+	}
+}
+
+// prune retires the connections whose client has been closed, such as by
+// the server closing the connection. p.mut must be held.
+func (p *Pool) prune() {
+	// retire removes from p.conns
+	for _, pc := range append([]*poolConn(nil), p.conns...) {
+		if pc.client.isClosed() {
+			p.retire(pc, "connection closed")
+		}
+	}
+}
+
+// leastLoaded returns the connection with the fewest requests in flight, or
+// nil if there is none. p.mut must be held.
+func (p *Pool) leastLoaded() *poolConn {
+	var best *poolConn
+	for _, pc := range p.conns {
+		if best == nil || pc.inflight < best.inflight {
+			best = pc
+		}
+	}
+	return best
+}
+
+// mayDial reports whether another connection may be dialed, and if so,
+// reserves it with the host limiter and counts it as dialing. p.mut must be
+// held.
+func (p *Pool) mayDial() bool {
+	if len(p.conns)+p.dialing >= p.size {
+		return false
+	}
+	if p.dialErr != nil && time.Now().Before(p.retryAt) {
+		return false
+	}
+	if !p.hosts.acquire(p.host) {
+		return false
+	}
+	p.dialing++
+	return true
+}
+
+// dialBackground dials the connection reserved by mayDial for the requests
+// to come, until p is closed.
+func (p *Pool) dialBackground() {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	p.dialLocked(p.dialCtx)
+}
+
+// dialLocked dials the connection reserved by mayDial, releasing p.mut while
+// it does, and adds it to the pool. p.mut must be held.
+func (p *Pool) dialLocked(ctx context.Context) (*poolConn, error) {
+	p.mut.Unlock()
+
+	conn, err := p.dial(ctx, "tcp", p.address)
+	var client *Client
+	if err == nil {
+		client, err = NewClient(conn, p.configureClient)
+		if err != nil {
+			conn.Close()
+		}
+	}
+
+	p.mut.Lock()
+	p.dialing--
+	defer p.broadcast()
+
+	if err != nil {
+		p.hosts.release(p.host)
+		if ctx.Err() == nil {
+			p.dialErr = fmt.Errorf("modbus/tcp: pool: error dialing %s: %w", p.address, err)
+			p.retryAt = time.Now().Add(p.retryDelay)
+			p.logger.LogAttrs(ctx, slog.LevelError, "modbus/tcp: pool: error dialing", logging.Remote(p.address), logging.Err(err))
+			return nil, p.dialErr
+		}
+		return nil, ctx.Err()
+	}
+	if p.closed {
+		p.hosts.release(p.host)
+		client.Close()
+		return nil, fmt.Errorf("modbus/tcp: pool: %w", modbus.ErrClosed)
+	}
+
+	pc := &poolConn{client: client, remote: conn.RemoteAddr().String()}
+	p.conns = append(p.conns, pc)
+	p.dialErr = nil
+
+	p.logger.LogAttrs(ctx, slog.LevelDebug, "modbus/tcp: pool: dialed connection",
+		logging.Remote(pc.remote), slog.Int("conns", len(p.conns)))
+
+	return pc, nil
+}
+
+func (p *Pool) configureClient(c *ClientConfig) {
+	c.Logger = p.logger
+	if p.clientFn != nil {
+		p.clientFn(c)
+	}
+}
+
+// release counts a request on pc, which completed with err, as no longer in
+// flight, and retires pc if it has failed too often.
+func (p *Pool) release(pc *poolConn, err error) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	defer p.broadcast()
+
+	pc.inflight--
+
+	switch {
+	case err == nil:
+		pc.failures = 0
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		// the caller gave up, which says nothing of the connection
+	default:
+		pc.failures++
+		if pc.failures >= p.maxFailures {
+			p.retire(pc, "too many failures")
+		}
+	}
+
+	if pc.retired && pc.inflight == 0 {
+		pc.client.Close()
+	}
+}
+
+// retire removes pc from the pool. Its client is closed once no request is
+// in flight on it. p.mut must be held.
+func (p *Pool) retire(pc *poolConn, reason string) {
+	if pc.retired {
+		return
+	}
+	pc.retired = true
+
+	for i := range p.conns {
+		if p.conns[i] == pc {
+			p.conns = append(p.conns[:i], p.conns[i+1:]...)
+			break
+		}
+	}
+	p.hosts.release(p.host)
+
+	if pc.inflight == 0 {
+		pc.client.Close()
+	}
+
+	p.logger.LogAttrs(context.Background(), slog.LevelWarn, "modbus/tcp: pool: retired connection",
+		logging.Remote(pc.remote), slog.String("reason", reason), slog.Int("failures", pc.failures))
+}
+
+// broadcast wakes the requests waiting for a connection. p.mut must be held.
+func (p *Pool) broadcast() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// PoolConnStats describes a connection of a Pool.
+type PoolConnStats struct {
+	Remote string
+	// InFlight is the number of requests in flight on the connection.
+	InFlight int
+	// Failures is the number of requests in a row that failed.
+	Failures int
+	// DiscardedResponses is the DiscardedResponses of the connection's
+	// Client.
+	DiscardedResponses uint64
+}
+
+// Stats describes the open connections of p.
+func (p *Pool) Stats() []PoolConnStats {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	stats := make([]PoolConnStats, 0, len(p.conns))
+	for _, pc := range p.conns {
+		stats = append(stats, PoolConnStats{
+			Remote:             pc.remote,
+			InFlight:           pc.inflight,
+			Failures:           pc.failures,
+			DiscardedResponses: pc.client.DiscardedResponses(),
+		})
+	}
+	return stats
+}
+
+// Close closes every connection of p. Requests in flight fail.
+func (p *Pool) Close() error {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	p.cancelDials()
+	p.broadcast()
+
+	var errs []error
+	for _, pc := range p.conns {
+		pc.retired = true
+		p.hosts.release(p.host)
+		if err := pc.client.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+	p.conns = nil
+
+	return errors.Join(errs...)
+}
+
+// HostLimiter limits the number of connections open to each host, across
+// the pools it is shared by, so that pools to different ports or with
+// different configurations of the same gateway do not exhaust its
+// connections. The zero value has no limit.
+type HostLimiter struct {
+	// Max is the maximum number of connections to a host, if not 0.
+	Max int
+
+	// ByHost overrides Max for specific hosts, such as "10.0.0.5". 0 is no
+	// limit.
+	ByHost map[string]int
+
+	mut  sync.Mutex
+	open map[string]int
+}
+
+// Open returns the number of connections open to host.
+func (l *HostLimiter) Open(host string) int {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	return l.open[host]
+}
+
+// acquire counts a connection to host, and reports whether it is within the
+// limit. A nil l has no limit.
+func (l *HostLimiter) acquire(host string) bool {
+	if l == nil {
+		return true
+	}
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	max := l.Max
+	if n, ok := l.ByHost[host]; ok {
+		max = n
+	}
+	if max > 0 && l.open[host] >= max {
+		return false
+	}
+
+	if l.open == nil {
+		l.open = make(map[string]int)
+	}
+	l.open[host]++
+	return true
+}
+
+func (l *HostLimiter) release(host string) {
+	if l == nil {
+		return
+	}
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	l.open[host]--
+}
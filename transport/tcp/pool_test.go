@@ -0,0 +1,257 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shasderias/modbus"
+)
+
+func TestPool(t *testing.T) {
+	addr, peak := startServer(t, 50*time.Millisecond)
+	hosts := &HostLimiter{}
+
+	pool, err := NewPool(addr, func(c *PoolConfig) {
+		c.Size = 3
+		c.Hosts = hosts
+		c.Client = func(c *ClientConfig) {
+			c.MaxInFlight = 1
+			c.RequestTimeout = time.Second
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := len(pool.Stats()); got != 0 {
+		t.Fatalf("got %d connections before the first request; want 0", got)
+	}
+
+	client, err := modbus.NewClient(1, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.ReadHoldingRegisters(0, 1); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := len(pool.Stats()); got != 3 {
+		t.Fatalf("got %d connections; want 3", got)
+	}
+	// the server may not read a request as soon as it is sent
+	if got := peak(); got > 3 || got < 2 {
+		t.Fatalf("got %d requests handled at once; want 3", got)
+	}
+
+	host, _, _ := net.SplitHostPort(addr)
+	if got := hosts.Open(host); got != 3 {
+		t.Fatalf("got %d connections open to host; want 3", got)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := hosts.Open(host); got != 0 {
+		t.Fatalf("got %d connections open to host after close; want 0", got)
+	}
+}
+
+// TestPoolBackgroundDial checks that a request is sent on a busy connection
+// while a dial for it proceeds, rather than waiting for the dial.
+func TestPoolBackgroundDial(t *testing.T) {
+	addr, _ := startServer(t, 50*time.Millisecond)
+
+	var (
+		dials   atomic.Int32
+		release = make(chan struct{})
+	)
+	pool, err := NewPool(addr, func(c *PoolConfig) {
+		c.Size = 2
+		c.Dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+			if dials.Add(1) > 1 {
+				select {
+				case <-release:
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+			}
+			return (&net.Dialer{}).DialContext(ctx, network, address)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	req, err := modbus.NewReadRegisterRequest(modbus.FuncCodeReadHoldingRegisters, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.WriteRequest(1, req); err != nil {
+		t.Fatal(err)
+	}
+
+	// keep the connection busy, so that the next request dials
+	busy := make(chan error)
+	go func() {
+		_, err := pool.WriteRequest(1, req)
+		busy <- err
+	}()
+	for pool.Stats()[0].InFlight == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := pool.WriteRequest(1, req); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-busy; err != nil {
+		t.Fatal(err)
+	}
+	if got := dials.Load(); got != 2 {
+		t.Fatalf("got %d dials; want 2", got)
+	}
+	if got := len(pool.Stats()); got != 1 {
+		t.Fatalf("got %d connections while the dial is blocked; want 1", got)
+	}
+
+	close(release)
+	for len(pool.Stats()) != 2 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPoolHostLimit(t *testing.T) {
+	addr, _ := startServer(t, 0)
+	hosts := &HostLimiter{Max: 1}
+
+	newPool := func() *Pool {
+		pool, err := NewPool(addr, func(c *PoolConfig) {
+			c.Size = 2
+			c.Hosts = hosts
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pool
+	}
+	pool1, pool2 := newPool(), newPool()
+	defer pool2.Close()
+
+	req, err := modbus.NewReadRegisterRequest(modbus.FuncCodeReadHoldingRegisters, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := pool1.WriteRequest(1, req); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool2.WriteRequest(1, req); err == nil {
+		t.Fatal("got nil error from pool over the host limit; want error")
+	}
+
+	pool1.Close()
+	if _, err := pool2.WriteRequest(1, req); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPoolRetire(t *testing.T) {
+	// a server that never responds
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	var accepted atomic.Int32
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			go io.Copy(io.Discard, conn)
+		}
+	}()
+
+	pool, err := NewPool(l.Addr().String(), func(c *PoolConfig) {
+		c.Size = 1
+		c.MaxFailures = 2
+		c.Client = func(c *ClientConfig) {
+			c.RequestTimeout = 20 * time.Millisecond
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	req, err := modbus.NewReadRegisterRequest(modbus.FuncCodeReadHoldingRegisters, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := pool.WriteRequest(1, req); !modbus.IsTimeout(err) {
+			t.Fatalf("got %v; want timeout", err)
+		}
+	}
+	if got := len(pool.Stats()); got != 0 {
+		t.Fatalf("got %d connections after %d failures; want 0", got, 2)
+	}
+
+	if _, err := pool.WriteRequest(1, req); !modbus.IsTimeout(err) {
+		t.Fatalf("got %v; want timeout", err)
+	}
+	if got := accepted.Load(); got != 2 {
+		t.Fatalf("got %d connections dialed; want 2", got)
+	}
+}
+
+func TestPoolDialError(t *testing.T) {
+	var (
+		errRefused = errors.New("connection refused")
+		dials      int
+	)
+
+	pool, err := NewPool("10.0.0.5:502", func(c *PoolConfig) {
+		c.RetryDelay = time.Hour
+		c.Dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+			dials++
+			return nil, errRefused
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	req, err := modbus.NewReadRegisterRequest(modbus.FuncCodeReadHoldingRegisters, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := pool.WriteRequest(1, req); !errors.Is(err, errRefused) {
+			t.Fatalf("got %v; want %v", err, errRefused)
+		}
+	}
+	if dials != 1 {
+		t.Fatalf("got %d dials within the retry delay; want 1", dials)
+	}
+}
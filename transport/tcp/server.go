@@ -1,140 +1,310 @@
 package tcp
 
-//import (
-//	"encoding/binary"
-//	"errors"
-//	"fmt"
-//	"io"
-//	"net"
-//
-//	"github.com/shasderias/modbus"
-//)
-//
-//type Server struct {
-//	address string
-//
-//	l net.Listener
-//
-//	h handlerFunc
-//}
-//
-//type ServerConfig struct {
-//}
-//
-//type handlerFunc func(request modbus.PDU) (modbus.PDU, error)
-//
-//func NewServer(address string) (*Server, error) {
-//	server := &Server{address: address}
-//
-//	return server, nil
-//}
-//
-//func (s *Server) Start() error {
-//	if s.l != nil {
-//		return fmt.Errorf("tcp/server: already started")
-//	}
-//
-//	listener, err := net.Listen("tcp", s.address)
-//	if err != nil {
-//		return fmt.Errorf("tcp/server: error listening: %w", err)
-//	}
-//
-//	s.l = listener
-//
-//	go s.acceptLoop()
-//
-//	return nil
-//}
-//
-//func (s *Server) RegisterHandler(h handlerFunc) {
-//	s.h = h
-//}
-//
-//func (s *Server) Stop() error {
-//	err := s.l.Close()
-//
-//	s.l = nil
-//
-//	return err
-//}
-//
-//func (s *Server) acceptLoop() {
-//	for {
-//		conn, err := s.l.Accept()
-//		if errors.Is(err, net.ErrClosed) {
-//			return
-//		} else if err != nil {
-//			s.log(err)
-//		}
-//		go s.handleConn(conn)
-//	}
-//}
-//
-//func (s *Server) handleConn(conn net.Conn) {
-//	defer conn.Close()
-//
-//	for {
-//		err := func() (err error) {
-//			buf := make([]byte, maxFrameSize)
-//
-//			n, err := io.ReadFull(conn, buf[:6])
-//			if err != nil {
-//				return fmt.Errorf("tcp/server: error reading [:6]: %w", err)
-//			}
-//			if n < 6 {
-//				return fmt.Errorf("tcp/server: short read [:6]: %d/6", n)
-//			}
-//
-//			protoID := binary.BigEndian.Uint16(buf[2:4])
-//			if protoID != 0 {
-//				return fmt.Errorf("tcp/server: unexpected protocol id: %d", protoID)
-//			}
-//
-//			remainderLen := binary.BigEndian.Uint16(buf[4:6])
-//
-//			if remainderLen < 3 {
-//				return fmt.Errorf("tcp/server: short frame, expected frame to be at least 9 bytes long: %d", 6+remainderLen)
-//			}
-//			if remainderLen > maxFrameSize-6 {
-//				return fmt.Errorf("tcp/server: frame too long: %d", 6+remainderLen)
-//			}
-//
-//			n, err = io.ReadFull(conn, buf[6:6+remainderLen])
-//			if err != nil {
-//				return fmt.Errorf("tcp/server: error reading [6:]: %w", err)
-//			}
-//			if n < int(remainderLen) {
-//				return fmt.Errorf("tcp/server: short read [6:]: %d/%d", n, remainderLen)
-//			}
-//
-//			txID := binary.BigEndian.Uint16(buf[0:2])
-//			unitID := buf[6]
-//
-//			request, err := modbus.NewRawPDU(buf[7 : 6+remainderLen])
-//			if err != nil {
-//				return fmt.Errorf("tcp/server: error decoding request PDU: %w", err)
-//			}
-//
-//			response, err := s.h(request)
-//			if err != nil {
-//				return fmt.Errorf("tcp/server: error handling request: %w", err)
-//			}
-//
-//			_, err = conn.Write(assembleFrame(txID, unitID, response))
-//			if err != nil {
-//				return fmt.Errorf("tcp/server: error writing response: %w", err)
-//			}
-//
-//			return nil
-//		}()
-//		if errors.Is(err, net.ErrClosed) {
-//			return
-//		} else if err != nil {
-//			s.log(err)
-//		}
-//	}
-//}
-//
-//func (*Server) log(a ...any) {
-//	fmt.Println(a...)
-//}
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/shasderias/modbus"
+	"github.com/shasderias/modbus/internal/logging"
+)
+
+type Server struct {
+	address     string
+	h           modbus.Handler
+	logger      *slog.Logger
+	maxInFlight int
+
+	mut   sync.Mutex
+	l     net.Listener
+	conns map[net.Conn]struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type ServerConfig struct {
+	// MaxInFlight is the maximum number of requests of a connection handled
+	// concurrently. Once it is reached, further requests are not read from
+	// the connection until one of them completes, so that a client that
+	// pipelines requests cannot start an unbounded number of handlers.
+	// Defaults to DefaultMaxInFlight.
+	MaxInFlight int
+
+	// Logger receives errors reading requests, handling them and writing
+	// responses, and, at debug level, connections opened and closed and
+	// every frame received and sent. Defaults to discarding.
+	Logger *slog.Logger
+}
+
+// NewServer returns a server that will listen on address and pass every
+// request it receives to h. Requests are handled concurrently, both across
+// and within connections, up to ServerConfig.MaxInFlight per connection.
+func NewServer(address string, h modbus.Handler, fns ...func(c *ServerConfig)) (*Server, error) {
+	if h == nil {
+		return nil, fmt.Errorf("modbus/tcp: nil handler")
+	}
+
+	config := ServerConfig{}
+
+	for _, fn := range fns {
+		fn(&config)
+	}
+
+	if config.MaxInFlight <= 0 {
+		config.MaxInFlight = DefaultMaxInFlight
+	}
+
+	return &Server{
+		address:     address,
+		h:           h,
+		logger:      logging.OrDiscard(config.Logger),
+		maxInFlight: config.MaxInFlight,
+		conns:       make(map[net.Conn]struct{}),
+	}, nil
+}
+
+func (s *Server) Start() error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if s.l != nil {
+		return fmt.Errorf("modbus/tcp: server already started")
+	}
+
+	listener, err := net.Listen("tcp", s.address)
+	if err != nil {
+		return fmt.Errorf("modbus/tcp: error listening: %w", err)
+	}
+
+	s.l = listener
+	if s.ctx == nil {
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+	}
+
+	s.wg.Add(1)
+	go s.acceptLoop(s.ctx, listener)
+
+	return nil
+}
+
+// Addr returns the address the server is listening on, or nil if the server
+// has not been started.
+func (s *Server) Addr() net.Addr {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if s.l == nil {
+		return nil
+	}
+	return s.l.Addr()
+}
+
+// Stop closes the listener and every open connection, and waits for
+// in-progress requests to return.
+func (s *Server) Stop() error {
+	s.mut.Lock()
+	if s.ctx == nil {
+		s.mut.Unlock()
+		return nil
+	}
+
+	var err error
+	if s.l != nil {
+		err = s.l.Close()
+		s.l = nil
+	}
+	s.cancel()
+	s.ctx = nil
+
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mut.Unlock()
+
+	s.wg.Wait()
+
+	return err
+}
+
+// ServeConn serves requests received on conn until conn is closed or the
+// server is stopped, and then closes conn. ServeConn can be used without
+// Start, for example to serve connections that were not accepted by the
+// server, such as either end of net.Pipe.
+func (s *Server) ServeConn(conn net.Conn) {
+	s.mut.Lock()
+	if s.ctx == nil {
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+	}
+	ctx := s.ctx
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	s.mut.Unlock()
+
+	s.handleConn(ctx, conn)
+}
+
+func (s *Server) acceptLoop(ctx context.Context, l net.Listener) {
+	defer s.wg.Done()
+
+	// delay after failed accepts, such as when the process runs out of file
+	// descriptors, as net/http does, so as not to spin
+	var delay time.Duration
+
+	for {
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			if delay == 0 {
+				delay = 5 * time.Millisecond
+			} else {
+				delay *= 2
+			}
+			if delay > time.Second {
+				delay = time.Second
+			}
+
+			s.logger.LogAttrs(ctx, slog.LevelWarn, "modbus/tcp: error accepting connection",
+				logging.Err(err), slog.Duration("retry_in", delay))
+
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		delay = 0
+
+		s.mut.Lock()
+		if s.l == nil {
+			s.mut.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.mut.Unlock()
+
+		s.wg.Add(1)
+		go s.handleConn(ctx, conn)
+	}
+}
+
+func (s *Server) handleConn(ctx context.Context, conn net.Conn) {
+	var (
+		writeMut sync.Mutex
+		requests sync.WaitGroup
+		inFlight = make(chan struct{}, s.maxInFlight)
+		remote   = logging.Remote(conn.RemoteAddr().String())
+	)
+
+	s.logger.LogAttrs(ctx, slog.LevelDebug, "modbus/tcp: connection opened", remote)
+
+	defer func() {
+		requests.Wait()
+
+		s.mut.Lock()
+		delete(s.conns, conn)
+		s.mut.Unlock()
+
+		conn.Close()
+		s.wg.Done()
+
+		s.logger.LogAttrs(ctx, slog.LevelDebug, "modbus/tcp: connection closed", remote)
+	}()
+
+	for {
+		txID, unitID, req, err := readRequest(conn)
+		if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			s.logger.LogAttrs(ctx, slog.LevelWarn, "modbus/tcp: error reading request, closing connection",
+				remote, logging.Err(err))
+			return
+		}
+
+		received := time.Now()
+		attrs := []slog.Attr{remote, logging.TxID(txID), logging.UnitID(unitID), logging.FunctionCode(req.FunctionCode())}
+		if s.logger.Enabled(ctx, slog.LevelDebug) {
+			s.logger.LogAttrs(ctx, slog.LevelDebug, "modbus/tcp: received request",
+				append(attrs, logging.Frame(assembleFrame(txID, unitID, req)))...)
+		}
+
+		select {
+		case inFlight <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+
+		requests.Add(1)
+		go func() {
+			defer func() {
+				<-inFlight
+				requests.Done()
+			}()
+
+			resp, err := modbus.Respond(ctx, s.h, unitID, req)
+			if err != nil {
+				s.logger.LogAttrs(ctx, slog.LevelError, "modbus/tcp: error handling request",
+					append(attrs, logging.Err(err))...)
+			}
+			if resp == nil {
+				return
+			}
+
+			frame := assembleFrame(txID, unitID, resp)
+
+			writeMut.Lock()
+			defer writeMut.Unlock()
+
+			if _, err := conn.Write(frame); err != nil {
+				s.logger.LogAttrs(ctx, slog.LevelWarn, "modbus/tcp: error writing response",
+					append(attrs, logging.Err(err))...)
+				return
+			}
+
+			s.logger.LogAttrs(ctx, slog.LevelDebug, "modbus/tcp: sent response",
+				append(attrs, logging.Latency(time.Since(received)), logging.Frame(frame))...)
+		}()
+	}
+}
+
+func readRequest(r io.Reader) (txID uint16, unitID byte, req modbus.PDU, err error) {
+	buf := make([]byte, maxFrameSize)
+
+	if _, err := io.ReadFull(r, buf[:7]); err != nil {
+		return 0, 0, nil, fmt.Errorf("modbus/tcp: error reading [:7]: %w", err)
+	}
+
+	if protocolID := binary.BigEndian.Uint16(buf[2:4]); protocolID != 0 {
+		return 0, 0, nil, fmt.Errorf("modbus/tcp: %w: protocol ID: %d", modbus.ErrInvalidFrame, protocolID)
+	}
+
+	remainingBytes := binary.BigEndian.Uint16(buf[4:6])
+
+	if remainingBytes < 2 {
+		return 0, 0, nil, fmt.Errorf("modbus/tcp: %w: expected frame to be at least 8 bytes long: %d", modbus.ErrFrameTooShort, 6+remainingBytes)
+	}
+	if remainingBytes > maxFrameSize-6 {
+		return 0, 0, nil, fmt.Errorf("modbus/tcp: %w: %d", modbus.ErrFrameTooLong, 6+remainingBytes)
+	}
+
+	if _, err := io.ReadFull(r, buf[7:6+remainingBytes]); err != nil {
+		return 0, 0, nil, fmt.Errorf("modbus/tcp: error reading [7:%d]: %w", 6+remainingBytes, err)
+	}
+
+	req, err = modbus.NewRawPDU(buf[7 : 6+remainingBytes])
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("modbus/tcp: error parsing PDU: %w", err)
+	}
+
+	return binary.BigEndian.Uint16(buf[0:2]), buf[6], req, nil
+}
@@ -0,0 +1,98 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shasderias/modbus"
+)
+
+func TestServerMaxInFlight(t *testing.T) {
+	var active, peak atomic.Int32
+	h := modbus.HandlerFunc(func(ctx context.Context, unitID byte, req modbus.PDU) (modbus.PDU, error) {
+		n := active.Add(1)
+		defer active.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		return modbus.NewReadRegisterResponseFromUint16s(int(req.FunctionCode()), []uint16{0})
+	})
+
+	server, err := NewServer("", h, func(c *ServerConfig) {
+		c.MaxInFlight = 2
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	go server.ServeConn(serverConn)
+
+	const n = 10
+	req, err := modbus.NewReadRegisterRequest(modbus.FuncCodeReadHoldingRegisters, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for i := 0; i < n; i++ {
+			if _, err := clientConn.Write(assembleFrame(uint16(i), 1, req)); err != nil {
+				return
+			}
+		}
+	}()
+
+	// 7 byte MBAP header, function code, byte count and a register
+	buf := make([]byte, 11)
+	for i := 0; i < n; i++ {
+		if _, err := io.ReadFull(clientConn, buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := peak.Load(); got != 2 {
+		t.Fatalf("got %d requests handled at once; want 2", got)
+	}
+}
+
+// failingListener fails to accept n times, and then reports it is closed.
+type failingListener struct {
+	net.Listener
+	n int
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	if l.n == 0 {
+		return nil, net.ErrClosed
+	}
+	l.n--
+	return nil, errors.New("accept: too many open files")
+}
+
+func TestServerAcceptBackoff(t *testing.T) {
+	server, err := NewServer("", modbus.HandlerFunc(func(context.Context, byte, modbus.PDU) (modbus.PDU, error) {
+		return nil, nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	server.wg.Add(1)
+	server.acceptLoop(context.Background(), &failingListener{n: 4})
+
+	// 5, 10, 20 and 40ms
+	if elapsed := time.Since(start); elapsed < 75*time.Millisecond {
+		t.Fatalf("got %s retrying failed accepts; want at least 75ms", elapsed)
+	}
+}